@@ -8,4 +8,7 @@ const AddressChecksumLen = 4
 
 const CurrencyPaymentForBlockMade = 10
 
+// Pseudo address of a receiver. Amount is paid to a minter of a block where a TX is added
+const CurrencyReceiverMinter = "MINTER"
+
 const CurrencySmallestUnit = 0.00000001
@@ -12,9 +12,35 @@ const (
 	responseLocalinfile = 0xfb
 
 	// MySQL field types constants
-	fieldTypeString   = 0xfd
-	fieldTypeLongLong = 0x08
-	fieldTypeDouble   = 0x05
+	fieldTypeDecimal    = 0x00
+	fieldTypeTiny       = 0x01
+	fieldTypeShort      = 0x02
+	fieldTypeLong       = 0x03
+	fieldTypeFloat      = 0x04
+	fieldTypeDouble     = 0x05
+	fieldTypeNull       = 0x06
+	fieldTypeTimestamp  = 0x07
+	fieldTypeLongLong   = 0x08
+	fieldTypeInt24      = 0x09
+	fieldTypeDate       = 0x0a
+	fieldTypeTime       = 0x0b
+	fieldTypeDateTime   = 0x0c
+	fieldTypeYear       = 0x0d
+	fieldTypeVarChar    = 0x0f
+	fieldTypeBit        = 0x10
+	fieldTypeJSON       = 0xf5
+	fieldTypeNewDecimal = 0xf6
+	fieldTypeEnum       = 0xf7
+	fieldTypeSet        = 0xf8
+	fieldTypeTinyBlob   = 0xf9
+	fieldTypeMediumBlob = 0xfa
+	fieldTypeLongBlob   = 0xfb
+	fieldTypeBlob       = 0xfc
+	fieldTypeString     = 0xfd
+	fieldTypeFixString  = 0xfe
+
+	// Parameter flag set for unsigned integers
+	paramFlagUnsigned = 0x80
 
 	// There is no code for Resultset in MySQL internal protocol
 	// so it's defined here for convenience
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"strconv"
@@ -16,6 +17,7 @@ import (
 var errInvalidPacketLength = errors.New("protocol: Invalid packet length")
 var errInvalidPacketType = errors.New("protocol: Invalid packet type")
 var errFieldTypeNotImplementedYet = errors.New("protocol: Required field type not implemented yet")
+var errParamTypesUnknown = errors.New("protocol: Types of prepared parameters are not known")
 
 func getPacketType(packet []byte) byte {
 	return packet[4]
@@ -327,7 +329,8 @@ type comStmtExecuteRequest struct {
 // PreparedParameter structure represents single prepared parameter structure for COM_STMT_EXECUTE request.
 type preparedParameter struct {
 	FieldType byte   // Type of prepared parameter. See https://mariadb.com/kb/en/mariadb/resultset/#field-types
-	Flag      byte   // Unused
+	Flag      byte   // Unsigned flag for integer types
+	IsNull    bool   // Parameter is marked in NullBitmap
 	Value     string // String value of any prepared parameter passed with COM_STMT_EXECUTE request
 }
 
@@ -358,7 +361,12 @@ type preparedParameter struct {
 // 			byte<n> BinaryParameterValue
 //		}
 // }
-func decodeComStmtExecuteRequest(packet []byte, paramsCount uint16) (*comStmtExecuteRequest, error) {
+//
+// Client sends types only on first execute (or when types change). knownTypes are types
+// remembered from previous execute of same statement.
+// longData are values sent before with COM_STMT_SEND_LONG_DATA. Such values are not present in the packet
+func decodeComStmtExecuteRequest(packet []byte, paramsCount uint16,
+	knownTypes []preparedParameter, longData map[uint16]string) (*comStmtExecuteRequest, error) {
 
 	// Min packet length = header(4 bytes) + command(1 byte) + statementID(4 bytes)
 	// + flags(1 byte) + iteration count(4 bytes)
@@ -399,10 +407,11 @@ func decodeComStmtExecuteRequest(packet []byte, paramsCount uint16) (*comStmtExe
 	parameters := make([]preparedParameter, paramsCount)
 
 	if paramsCount > 0 {
-		nullBitmapLength := int64((paramsCount + 7) / 8)
+		nullBitmapLength := int((paramsCount + 7) / 8)
 
-		// Skip to SendTypeToServer position
-		if _, err := r.Seek(nullBitmapLength, io.SeekCurrent); err != nil {
+		// Read NullBitmap
+		nullBitmap := make([]byte, nullBitmapLength)
+		if _, err := io.ReadFull(r, nullBitmap); err != nil {
 			return nil, err
 		}
 
@@ -417,33 +426,85 @@ func decodeComStmtExecuteRequest(packet []byte, paramsCount uint16) (*comStmtExe
 
 				// Read parameter FieldType and ParameterFlag
 				parameterMeta := make([]byte, 2)
-				if _, err := r.Read(parameterMeta); err != nil {
+				if _, err := io.ReadFull(r, parameterMeta); err != nil {
 					return nil, err
 				}
 
 				parameters[index].FieldType = parameterMeta[0]
 				parameters[index].Flag = parameterMeta[1]
 			}
+		} else {
+			if len(knownTypes) != int(paramsCount) {
+				// types were never sent for this statement
+				return nil, errParamTypesUnknown
+			}
+			for index := range parameters {
+				parameters[index].FieldType = knownTypes[index].FieldType
+				parameters[index].Flag = knownTypes[index].Flag
+			}
 		}
 
 		var fieldDecoderError error
 		var fieldValue string
 
 		for index, parameter := range parameters {
+
+			if nullBitmap[index/8]&(1<<uint(index%8)) > 0 {
+				parameters[index].IsNull = true
+				continue
+			}
+
+			if value, ok := longData[uint16(index)]; ok {
+				// value was sent before and is not part of this packet
+				parameters[index].Value = value
+				continue
+			}
+
+			unsigned := parameter.Flag&paramFlagUnsigned > 0
+
 			switch parameter.FieldType {
 
-			// MYSQL_TYPE_VAR_STRING (length encoded string)
-			case fieldTypeString:
+			case fieldTypeNull:
+				parameters[index].IsNull = true
+				continue
+
+			// Length encoded strings
+			case fieldTypeString, fieldTypeFixString, fieldTypeVarChar,
+				fieldTypeDecimal, fieldTypeNewDecimal, fieldTypeJSON,
+				fieldTypeEnum, fieldTypeSet, fieldTypeBit,
+				fieldTypeTinyBlob, fieldTypeMediumBlob, fieldTypeLongBlob, fieldTypeBlob:
 				fieldValue, fieldDecoderError = decodeFieldTypeString(r)
 
+			case fieldTypeTiny:
+				fieldValue, fieldDecoderError = decodeFieldTypeInteger(r, 1, unsigned)
+
+			case fieldTypeShort, fieldTypeYear:
+				fieldValue, fieldDecoderError = decodeFieldTypeInteger(r, 2, unsigned)
+
+			case fieldTypeLong, fieldTypeInt24:
+				fieldValue, fieldDecoderError = decodeFieldTypeInteger(r, 4, unsigned)
+
 			// MYSQL_TYPE_LONGLONG
 			case fieldTypeLongLong:
-				fieldValue, fieldDecoderError = decodeFieldTypeLongLong(r)
+				if unsigned {
+					fieldValue, fieldDecoderError = decodeFieldTypeInteger(r, 8, true)
+				} else {
+					fieldValue, fieldDecoderError = decodeFieldTypeLongLong(r)
+				}
+
+			case fieldTypeFloat:
+				fieldValue, fieldDecoderError = decodeFieldTypeFloat(r)
 
 			// MYSQL_TYPE_DOUBLE
 			case fieldTypeDouble:
 				fieldValue, fieldDecoderError = decodeFieldTypeDouble(r)
 
+			case fieldTypeDate, fieldTypeDateTime, fieldTypeTimestamp:
+				fieldValue, fieldDecoderError = decodeFieldTypeDateTime(r)
+
+			case fieldTypeTime:
+				fieldValue, fieldDecoderError = decodeFieldTypeTime(r)
+
 			// Field with missing decoder
 			default:
 				return nil, errFieldTypeNotImplementedYet
@@ -462,6 +523,55 @@ func decodeComStmtExecuteRequest(packet []byte, paramsCount uint16) (*comStmtExe
 	return &comStmtExecuteRequest{StatementID: statementID, PreparedParameters: parameters}, nil
 }
 
+// ComStmtSendLongDataRequest represents COM_STMT_SEND_LONG_DATA request structure.
+type comStmtSendLongDataRequest struct {
+	StatementID uint32
+	ParamID     uint16
+	Data        string
+}
+
+// DecodeComStmtSendLongDataRequest decodes COM_STMT_SEND_LONG_DATA packet sent by MySQL client.
+// See https://mariadb.com/kb/en/library/com_stmt_send_long_data/
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_STMT_SEND_LONG_DATA (0x18)
+// int<4> StatementID
+// int<2> ParameterID
+// byte<EOF> Data
+func decodeComStmtSendLongDataRequest(packet []byte) (*comStmtSendLongDataRequest, error) {
+	if err := checkPacketLength(11, packet); err != nil {
+		return nil, err
+	}
+
+	if packet[4] != comStmtSendLongData {
+		return nil, errInvalidPacketType
+	}
+
+	statementID := binary.LittleEndian.Uint32(packet[5:9])
+	paramID := binary.LittleEndian.Uint16(packet[9:11])
+
+	return &comStmtSendLongDataRequest{statementID, paramID, readEOFLengthString(packet[11:])}, nil
+}
+
+// DecodeComStmtCloseRequest returns statement ID from COM_STMT_CLOSE or COM_STMT_RESET packet.
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> COM_STMT_CLOSE (0x19) or COM_STMT_RESET (0x1a)
+// int<4> StatementID
+func decodeComStmtCloseRequest(packet []byte) (uint32, error) {
+	if err := checkPacketLength(9, packet); err != nil {
+		return 0, err
+	}
+
+	if packet[4] != comStmtClose && packet[4] != comStmtReset {
+		return 0, errInvalidPacketType
+	}
+
+	return binary.LittleEndian.Uint32(packet[5:9]), nil
+}
+
 // DecodeFieldTypeString decodes MYSQL_TYPE_VAR_STRING field (length-encoded string)
 // See https://mariadb.com/kb/en/mariadb/resultset/#field-types
 func decodeFieldTypeString(r *bytes.Reader) (string, error) {
@@ -481,18 +591,51 @@ func decodeFieldTypeLongLong(r *bytes.Reader) (string, error) {
 	var bigIntValue int64
 
 	if err := binary.Read(r, binary.LittleEndian, &bigIntValue); err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return strconv.FormatInt(bigIntValue, 10), nil
 }
 
+// DecodeFieldTypeInteger decodes MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_LONG fields
+// size is number of bytes used by the type
+func decodeFieldTypeInteger(r *bytes.Reader, size int, unsigned bool) (string, error) {
+	buf := make([]byte, 8)
+
+	if _, err := io.ReadFull(r, buf[:size]); err != nil {
+		return "", err
+	}
+
+	value := binary.LittleEndian.Uint64(buf)
+
+	if unsigned {
+		return strconv.FormatUint(value, 10), nil
+	}
+
+	// extend sign bit
+	shift := uint(64 - size*8)
+
+	return strconv.FormatInt(int64(value<<shift)>>shift, 10), nil
+}
+
+// DecodeFieldTypeFloat decodes MYSQL_TYPE_FLOAT field
+func decodeFieldTypeFloat(r *bytes.Reader) (string, error) {
+	floatBuf := make([]byte, 4)
+	if _, err := io.ReadFull(r, floatBuf); err != nil {
+		return "", err
+	}
+
+	floatValue := math.Float32frombits(binary.LittleEndian.Uint32(floatBuf))
+
+	return strconv.FormatFloat(float64(floatValue), 'f', -1, 32), nil
+}
+
 // DecodeFieldTypeDouble decodes MYSQL_TYPE_DOUBLE field
 // See https://mariadb.com/kb/en/mariadb/resultset/#field-types
 func decodeFieldTypeDouble(r *bytes.Reader) (string, error) {
 	// Read 8 bytes required for float64
 	doubleLengthBuf := make([]byte, 8)
-	if _, err := r.Read(doubleLengthBuf); err != nil {
+	if _, err := io.ReadFull(r, doubleLengthBuf); err != nil {
 		return "", err
 	}
 
@@ -502,6 +645,85 @@ func decodeFieldTypeDouble(r *bytes.Reader) (string, error) {
 	return strconv.FormatFloat(doubleValue, 'f', doubleDecodePrecision, 64), nil
 }
 
+// DecodeFieldTypeDateTime decodes MYSQL_TYPE_DATE, MYSQL_TYPE_DATETIME and MYSQL_TYPE_TIMESTAMP fields
+// See https://mariadb.com/kb/en/library/resultset-row/#timestamp-binary-encoding
+//
+// int<1> Length (0, 4, 7 or 11)
+// int<2> Year
+// int<1> Month
+// int<1> Day
+// int<1> Hour
+// int<1> Minute
+// int<1> Second
+// int<4> Microseconds
+func decodeFieldTypeDateTime(r *bytes.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	if length > 11 {
+		return "", errInvalidPacketLength
+	}
+
+	data := make([]byte, 11)
+	if _, err := io.ReadFull(r, data[:length]); err != nil {
+		return "", err
+	}
+
+	year := binary.LittleEndian.Uint16(data[0:2])
+	value := fmt.Sprintf("%04d-%02d-%02d", year, data[2], data[3])
+
+	if length > 4 {
+		value += fmt.Sprintf(" %02d:%02d:%02d", data[4], data[5], data[6])
+	}
+	if length > 7 {
+		value += fmt.Sprintf(".%06d", binary.LittleEndian.Uint32(data[7:11]))
+	}
+
+	return value, nil
+}
+
+// DecodeFieldTypeTime decodes MYSQL_TYPE_TIME field
+// See https://mariadb.com/kb/en/library/resultset-row/#time-binary-encoding
+//
+// int<1> Length (0, 8 or 12)
+// int<1> IsNegative
+// int<4> Days
+// int<1> Hour
+// int<1> Minute
+// int<1> Second
+// int<4> Microseconds
+func decodeFieldTypeTime(r *bytes.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	if length > 12 {
+		return "", errInvalidPacketLength
+	}
+
+	data := make([]byte, 12)
+	if _, err := io.ReadFull(r, data[:length]); err != nil {
+		return "", err
+	}
+
+	sign := ""
+	if data[0] == 1 {
+		sign = "-"
+	}
+	hours := binary.LittleEndian.Uint32(data[1:5])*24 + uint32(data[5])
+
+	value := fmt.Sprintf("%s%02d:%02d:%02d", sign, hours, data[6], data[7])
+
+	if length > 8 {
+		value += fmt.Sprintf(".%06d", binary.LittleEndian.Uint32(data[8:12]))
+	}
+
+	return value, nil
+}
+
 // ReadLenEncodedInteger returns parsed length-encoded integer and it's offset.
 // See https://mariadb.com/kb/en/mariadb/protocol-data-types/#length-encoded-integers
 func readLenEncodedInteger(r *bytes.Reader) (value uint64, offset uint64) {
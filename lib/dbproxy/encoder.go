@@ -2,6 +2,7 @@ package dbproxy
 
 import (
 	"encoding/binary"
+	"errors"
 )
 
 // Encode custom responses by a proxy
@@ -37,3 +38,26 @@ func (e ResponseError) Error() string {
 
 	return e.Message
 }
+
+// Encode COM_QUERY request. It is used to send a query changed by a filter instead of original request
+//
+// int<3> PacketLength
+// int<1> PacketNumber
+// int<1> Command COM_QUERY (0x03)
+// string<EOF> SQLStatement
+func encodeQueryRequest(query string) ([]byte, error) {
+	payloadLen := len(query) + 1
+
+	if payloadLen >= 0xffffff {
+		// such query must be split to many packets
+		return nil, errors.New("Query is too long")
+	}
+
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(payloadLen))
+
+	res := []byte{length[0], length[1], length[2], 0, comQuery}
+
+	res = append(res, []byte(query)...)
+	return res, nil
+}
@@ -20,7 +20,16 @@ type ResponseFilterCallback func(sessionID string, err error)
 
 // Interface for a filter structure
 // It is alternative for callbacks and can keep some state inside
+// RequestCallback can return a query to send to a server instead of received one. Empty string keeps the query.
+// It gets a server connection of a session to read rows in a transaction of a client. It can be used only
+// inside of the callback
 type DBProxyFilter interface {
-	RequestCallback(query string, sessionID string) error
+	RequestCallback(query string, sessionID string, conn SessionConnection) (string, error)
 	ResponseCallback(sessionID string, err error)
+	SessionClosed(sessionID string)
+}
+
+// Server connection of a client session. Queries are executed in a transaction of the client
+type SessionConnection interface {
+	ExecuteSQLSelectRows(sqlcommand string) ([]map[string]string, error)
 }
@@ -3,6 +3,7 @@ package dbproxy
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -185,32 +186,44 @@ func (p *mysqlProxy) handleConnection(client net.Conn) {
 
 	sessionID := randString(10)
 
-	requestFilter := p.getRequestManager(server, client, sessionID)
+	// prepared statements are known only inside a connection
+	statements := newSessionStatements()
+
+	// a filter can read rows in a transaction of the client
+	conn := newSessionConnection(server)
+
+	requestFilter := p.getRequestManager(server, client, sessionID, statements, conn)
 
 	// read request in parallel routine
 	go io.Copy(requestFilter, client)
 
-	responseFilter := p.getResponseManager(client, sessionID)
+	responseFilter := p.getResponseManager(client, sessionID, statements)
 
 	// read response. Response will be first operation
-	io.Copy(responseFilter, server)
+	conn.readResponses(responseFilter)
 
+	if p.queryFilter != nil {
+		// client or server closed connection. mysql cancels not commited transaction
+		p.queryFilter.SessionClosed(sessionID)
+	}
 }
 
 // Build requestPacketParser object
-func (p *mysqlProxy) getRequestManager(server net.Conn, client net.Conn, sessID string) *requestPacketParser {
-	return &requestPacketParser{server, client, sessID, p.requestCallback, p.queryFilter, p.traceLog, p.errorLog}
+func (p *mysqlProxy) getRequestManager(server net.Conn, client net.Conn, sessID string, statements *sessionStatements, conn *sessionConnection) *requestPacketParser {
+	return &requestPacketParser{server, client, sessID, statements, conn, p.requestCallback, p.queryFilter, p.traceLog, p.errorLog}
 }
 
 // Build responsePacketParser object
-func (p *mysqlProxy) getResponseManager(client net.Conn, sessID string) *responsePacketParser {
-	return &responsePacketParser{client, sessID, p.responseCallback, p.queryFilter, p.traceLog, p.errorLog}
+func (p *mysqlProxy) getResponseManager(client net.Conn, sessID string, statements *sessionStatements) *responsePacketParser {
+	return &responsePacketParser{client, sessID, statements, p.responseCallback, p.queryFilter, p.traceLog, p.errorLog}
 }
 
 type requestPacketParser struct {
 	server          net.Conn
 	client          net.Conn
 	sessionID       string
+	statements      *sessionStatements
+	conn            *sessionConnection
 	requestCallback RequestQueryFilterCallback
 	queryFilter     DBProxyFilter
 	traceLog        *log.Logger
@@ -223,28 +236,79 @@ func (pp *requestPacketParser) Write(p []byte) (n int, err error) {
 	// pass request to server or return error response
 
 	var clientErr error
+	var serverQuery string // query to send instead of received one
+
+	request := p
 
 	switch getPacketType(p) {
 
 	case comStmtPrepare:
+		// query with placeholders. it goes to filters only on execute when all values are known
+		decoded, err := decodeQueryRequest(p)
+
+		if err == nil {
+			pp.statements.setPending(decoded.Query)
+
+			pp.traceLog.Printf("Prepare: %s", decoded)
+		}
+
+	case comStmtExecute:
+		query, err := pp.statements.getExecuteQuery(p)
+
+		if err != nil {
+			// we can not allow to execute a query without checking it
+			clientErr = NewMySQLError(fmt.Sprintf("Prepared statement error: %s", err.Error()), 3001)
+			break
+		}
+		serverQuery, clientErr = pp.filterQuery(query)
+
+		pp.traceLog.Printf("Execute: %s", query)
+
+	case comStmtSendLongData:
+		decoded, err := decodeComStmtSendLongDataRequest(p)
+
+		if err == nil {
+			pp.statements.addLongData(decoded)
+		}
+
+	case comStmtReset:
+		statementID, err := decodeComStmtCloseRequest(p)
+
+		if err == nil {
+			pp.statements.reset(statementID)
+		}
+
+	case comStmtClose:
+		statementID, err := decodeComStmtCloseRequest(p)
+
+		if err == nil {
+			pp.statements.close(statementID)
+		}
+
 	case comQuery:
 
 		decoded, err := decodeQueryRequest(p)
 
 		if err == nil {
-			// pass through filters
-			if pp.queryFilter != nil {
-				clientErr = pp.queryFilter.RequestCallback(decoded.Query, pp.sessionID)
-			}
-			if clientErr == nil && pp.requestCallback != nil {
-				clientErr = pp.requestCallback(decoded.Query, pp.sessionID)
-			}
+			serverQuery, clientErr = pp.filterQuery(decoded.Query)
 
 			pp.traceLog.Printf("Request: %s", decoded)
 		}
 	}
+	if clientErr == nil && serverQuery != "" {
+		// filter changed the query. it is sent as a simple query, also for prepared statement.
+		// only update queries are changed, a server responds same way for them
+		pp.traceLog.Printf("Query changed by a filter: %s", serverQuery)
+
+		var packet []byte
+		packet, clientErr = encodeQueryRequest(serverQuery)
+
+		if clientErr == nil {
+			request = packet
+		}
+	}
 	if clientErr == nil {
-		io.Copy(pp.server, bytes.NewReader(p))
+		io.Copy(pp.server, bytes.NewReader(request))
 	} else {
 		// send error response to client
 		pp.traceLog.Printf("Custom error response: %s", clientErr)
@@ -264,9 +328,21 @@ func (pp *requestPacketParser) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// pass a query through filters. Returns a query to send to a server if a filter changed it
+func (pp *requestPacketParser) filterQuery(query string) (serverQuery string, clientErr error) {
+	if pp.queryFilter != nil {
+		serverQuery, clientErr = pp.queryFilter.RequestCallback(query, pp.sessionID, pp.conn)
+	}
+	if clientErr == nil && pp.requestCallback != nil {
+		clientErr = pp.requestCallback(query, pp.sessionID)
+	}
+	return
+}
+
 type responsePacketParser struct {
 	client           net.Conn
 	sessionID        string
+	statements       *sessionStatements
 	responseCallback ResponseFilterCallback
 	queryFilter      DBProxyFilter
 	traceLog         *log.Logger
@@ -276,6 +352,15 @@ type responsePacketParser struct {
 func (pp *responsePacketParser) Write(p []byte) (n int, err error) {
 	pp.traceLog.Printf("Write to Response , bytes received %d\n", len(p))
 
+	// check if this is response on prepare request
+	isPrepare, err := pp.statements.completePending(p)
+
+	if err != nil {
+		pp.errorLog.Printf("Prepare response decode error %s", err.Error())
+	} else if isPrepare {
+		pp.traceLog.Printf("Prepare response")
+	}
+
 	switch getPacketType(p) {
 
 	case responseErr:
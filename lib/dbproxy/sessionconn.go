@@ -0,0 +1,286 @@
+package dbproxy
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+)
+
+var errConnectionClosed = errors.New("Server connection is closed")
+
+// Server connection of a client session. All responses of a server are read here, they go to a client.
+// A filter can execute own query on the connection while it checks a request of a client. The query is
+// executed in a transaction of the client, and its response doesn't go to the client.
+// A client waits for a response on its request, so a server doesn't send anything else at this time
+type sessionConnection struct {
+	server net.Conn
+	lock   sync.Mutex
+	query  *sessionQuery // query of a filter which waits for a response
+	closed bool
+}
+
+// response packets of a query of a filter
+type sessionQuery struct {
+	packets chan []byte
+	done    chan struct{} // closed when a filter doesn't read a response anymore
+}
+
+func newSessionConnection(server net.Conn) *sessionConnection {
+	return &sessionConnection{server: server}
+}
+
+// Read packets from a server until the connection is closed. Packets are passed to a client
+// through the response parser or to a filter query
+func (c *sessionConnection) readResponses(response *responsePacketParser) {
+	defer c.close()
+
+	for {
+		packet, err := readPacket(c.server)
+
+		if err != nil {
+			return
+		}
+
+		c.lock.Lock()
+		query := c.query
+		c.lock.Unlock()
+
+		if query != nil {
+			select {
+			case query.packets <- packet:
+				continue
+			case <-query.done:
+				// a filter has read its response, this is a response for a client
+			}
+		}
+		response.Write(packet)
+	}
+}
+
+func (c *sessionConnection) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.closed = true
+
+	if c.query != nil {
+		close(c.query.packets)
+		c.query = nil
+	}
+}
+
+// Execute SELECT query in the session. Values of columns are strings, NULL is empty string
+func (c *sessionConnection) ExecuteSQLSelectRows(sqlcommand string) ([]map[string]string, error) {
+	request, err := encodeQueryRequest(sqlcommand)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := &sessionQuery{make(chan []byte), make(chan struct{})}
+
+	c.lock.Lock()
+
+	if c.closed {
+		c.lock.Unlock()
+		return nil, errConnectionClosed
+	}
+	c.query = query
+
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+
+		if c.query == query {
+			c.query = nil
+		}
+		c.lock.Unlock()
+
+		close(query.done)
+	}()
+
+	_, err = c.server.Write(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := decodeResultRows(func() ([]byte, error) {
+		packet, ok := <-query.packets
+
+		if !ok {
+			return nil, errConnectionClosed
+		}
+		return packet, nil
+	})
+
+	if err != nil && err != errConnectionClosed {
+		if _, ok := err.(ResponseError); !ok {
+			// rest of a response is unknown, it must not go to a client
+			c.server.Close()
+		}
+	}
+	return rows, err
+}
+
+// Decode response on a text query. Packets are read one by one with a header. Returns rows of a result set,
+// values by column names. Error response of a server is returned as an error
+func decodeResultRows(next func() ([]byte, error)) ([]map[string]string, error) {
+	packet, err := next()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkPacketLength(5, packet); err != nil {
+		return nil, err
+	}
+
+	switch packet[4] {
+	case responseErr:
+		message, _ := decodeErrResponse(packet)
+		return nil, NewMySQLError(message, 3001)
+
+	case responseOk:
+		// not a SELECT
+		return []map[string]string{}, nil
+
+	case responseLocalinfile:
+		return nil, errInvalidPacketType
+	}
+
+	count, _ := readLenEncodedInteger(bytes.NewReader(packet[4:]))
+
+	columns := []string{}
+
+	for i := uint64(0); i < count; i++ {
+		packet, err = next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := decodeColumnName(packet)
+
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+
+	rows := []map[string]string{}
+	columnsEOF := false
+
+	for {
+		packet, err = next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = checkPacketLength(5, packet); err != nil {
+			return nil, err
+		}
+
+		if len(packet)-4 >= 0xffffff {
+			// a row is split to many packets
+			return nil, errors.New("Row is too long")
+		}
+
+		switch packet[4] {
+		case responseErr:
+			message, _ := decodeErrResponse(packet)
+			return nil, NewMySQLError(message, 3001)
+
+		case responseEof:
+			// EOF after columns has 5 bytes. Without it (CLIENT_DEPRECATE_EOF) rows end with OK packet which is longer
+			if len(packet)-4 == 5 && len(rows) == 0 && !columnsEOF {
+				columnsEOF = true
+				continue
+			}
+			return rows, nil
+		}
+
+		row, err := decodeTextRow(packet, columns)
+
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// Name of a column from column definition packet
+//
+// lenenc_str catalog
+// lenenc_str schema
+// lenenc_str table
+// lenenc_str org_table
+// lenenc_str name
+// ...
+func decodeColumnName(packet []byte) (string, error) {
+	if err := checkPacketLength(5, packet); err != nil {
+		return "", err
+	}
+
+	r := bytes.NewReader(packet[4:])
+
+	var name string
+
+	for i := 0; i < 5; i++ {
+		value, err := readTextValue(r)
+
+		if err != nil {
+			return "", err
+		}
+		name = value
+	}
+	return name, nil
+}
+
+// Row of a text result set. Each value is length encoded string, NULL is 0xfb
+func decodeTextRow(packet []byte, columns []string) (map[string]string, error) {
+	r := bytes.NewReader(packet[4:])
+
+	row := map[string]string{}
+
+	for _, column := range columns {
+		value, err := readTextValue(r)
+
+		if err != nil {
+			return nil, err
+		}
+		row[column] = value
+	}
+	return row, nil
+}
+
+// Length encoded string. NULL is returned as empty string
+func readTextValue(r *bytes.Reader) (value string, err error) {
+	b, err := r.ReadByte()
+
+	if err != nil {
+		err = errInvalidPacketLength
+		return
+	}
+
+	if b == 0xfb {
+		return
+	}
+	r.UnreadByte()
+
+	length, _ := readLenEncodedInteger(r)
+
+	if length > uint64(r.Len()) {
+		err = errInvalidPacketLength
+		return
+	}
+
+	data := make([]byte, length)
+	r.Read(data)
+
+	value = string(data)
+	return
+}
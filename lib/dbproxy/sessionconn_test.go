@@ -0,0 +1,127 @@
+package dbproxy
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// packet with a header
+func makePacket(seq byte, payload ...byte) []byte {
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}, payload...)
+}
+
+// column definition with a name. other strings are empty
+func makeColumnPacket(seq byte, name string) []byte {
+	payload := []byte{3, 'd', 'e', 'f', 0, 0, 0, byte(len(name))}
+	payload = append(payload, name...)
+	payload = append(payload, 0, 0x0c, 0x21, 0, 0, 0, 0, 0, fieldTypeVarChar, 0, 0, 0, 0, 0)
+
+	return makePacket(seq, payload...)
+}
+
+// result set with columns id and a, rows (1, 'x') and (2, NULL)
+func makeResultPackets(deprecateEOF bool) [][]byte {
+	packets := [][]byte{makePacket(1, 2), makeColumnPacket(2, "id"), makeColumnPacket(3, "a")}
+
+	if !deprecateEOF {
+		packets = append(packets, makePacket(4, responseEof, 0, 0, 2, 0))
+	}
+	packets = append(packets, makePacket(5, 1, '1', 1, 'x'), makePacket(6, 1, '2', 0xfb))
+
+	if deprecateEOF {
+		return append(packets, makePacket(7, responseEof, 0, 0, 2, 0, 0, 0))
+	}
+	return append(packets, makePacket(7, responseEof, 0, 0, 2, 0))
+}
+
+func readPackets(packets [][]byte) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		packet := packets[0]
+		packets = packets[1:]
+		return packet, nil
+	}
+}
+
+func TestDecodeResultRows(t *testing.T) {
+	expected := []map[string]string{{"id": "1", "a": "x"}, {"id": "2", "a": ""}}
+
+	for _, deprecateEOF := range []bool{false, true} {
+		rows, err := decodeResultRows(readPackets(makeResultPackets(deprecateEOF)))
+
+		if err != nil {
+			t.Fatalf("Decode error: %s", err.Error())
+		}
+
+		if !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("Wrong rows %v, deprecate EOF %t", rows, deprecateEOF)
+		}
+	}
+
+	// no rows
+	packets := [][]byte{makePacket(1, 1), makeColumnPacket(2, "id"), makePacket(3, responseEof, 0, 0, 2, 0, 0, 0)}
+
+	rows, err := decodeResultRows(readPackets(packets))
+
+	if err != nil || len(rows) != 0 {
+		t.Fatalf("Empty result is expected")
+	}
+
+	packets = [][]byte{makePacket(1, responseErr, 0x7a, 0x04, '#', '4', '0', '0', '0', '1', 'e', 'r', 'r')}
+
+	if _, err := decodeResultRows(readPackets(packets)); err == nil {
+		t.Fatalf("Server error must be returned")
+	}
+}
+
+func TestSessionConnectionQuery(t *testing.T) {
+	server, serverSide := net.Pipe()
+	client, clientSide := net.Pipe()
+
+	discard := log.New(ioutil.Discard, "", 0)
+	response := &responsePacketParser{client: clientSide, sessionID: "s", statements: newSessionStatements(),
+		traceLog: discard, errorLog: discard}
+
+	conn := newSessionConnection(server)
+
+	go conn.readResponses(response)
+
+	go func() {
+		request, err := readPacket(serverSide)
+
+		if err != nil || request[4] != comQuery || string(request[5:]) != "SELECT * FROM t" {
+			serverSide.Close()
+			return
+		}
+		for _, packet := range makeResultPackets(false) {
+			serverSide.Write(packet)
+		}
+		// response on a request of a client
+		serverSide.Write(makePacket(1, responseOk, 0, 0, 2, 0, 0, 0))
+	}()
+
+	rows, err := conn.ExecuteSQLSelectRows("SELECT * FROM t")
+
+	if err != nil {
+		t.Fatalf("Query error: %s", err.Error())
+	}
+
+	if len(rows) != 2 || rows[0]["a"] != "x" {
+		t.Fatalf("Wrong rows %v", rows)
+	}
+
+	// other responses go to a client
+	packet, err := readPacket(client)
+
+	if err != nil || packet[4] != responseOk {
+		t.Fatalf("Response must go to a client")
+	}
+
+	serverSide.Close()
+
+	if _, err := conn.ExecuteSQLSelectRows("SELECT 1"); err == nil {
+		t.Fatalf("Closed connection must return error")
+	}
+}
@@ -0,0 +1,253 @@
+package dbproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Prepared statement known for a session
+type preparedStatement struct {
+	Query       string
+	ParamsCount uint16
+	ParamsTypes []preparedParameter // types sent by a client on last execute
+	LongData    map[uint16]string   // data sent with COM_STMT_SEND_LONG_DATA before execute
+}
+
+// Prepared statements state of a session (connection)
+// It is shared between request and response parsers. They work in different routines
+type sessionStatements struct {
+	lock           sync.Mutex
+	pendingQueries []string // prepare requests waiting for responses from a server, in order of requests
+	statements     map[uint32]*preparedStatement
+}
+
+func newSessionStatements() *sessionStatements {
+	return &sessionStatements{statements: make(map[uint32]*preparedStatement)}
+}
+
+// Client sent COM_STMT_PREPARE. Remember the query till server responds with statement ID
+// A client can send few prepare requests before it reads responses. Server responds in same order
+func (s *sessionStatements) setPending(query string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pendingQueries = append(s.pendingQueries, query)
+}
+
+// Server response to a prepare request. If there is pending query, first one is bound to the statement ID
+// Returns true if the response was a response to prepare request
+func (s *sessionStatements) completePending(packet []byte) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.pendingQueries) == 0 {
+		return false, nil
+	}
+
+	if err := checkPacketLength(5, packet); err != nil {
+		return false, err
+	}
+
+	// only first packet of a response (sequence 1) is OK or error. Parameters and columns
+	// definitions following prepare OK packet are skipped
+	if packet[3] != 1 || (getPacketType(packet) != responsePrepareOk && getPacketType(packet) != responseErr) {
+		return false, nil
+	}
+
+	query := s.pendingQueries[0]
+	s.pendingQueries = s.pendingQueries[1:]
+
+	if getPacketType(packet) != responsePrepareOk {
+		// prepare failed on a server side
+		return true, nil
+	}
+
+	resp, err := decodeComStmtPrepareOkResponse(packet)
+
+	if err != nil {
+		return true, err
+	}
+
+	s.statements[resp.StatementID] = &preparedStatement{
+		Query:       query,
+		ParamsCount: resp.ParametersNum,
+		LongData:    make(map[uint16]string)}
+
+	return true, nil
+}
+
+// Append long data for a parameter
+func (s *sessionStatements) addLongData(req *comStmtSendLongDataRequest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stmt, ok := s.statements[req.StatementID]
+
+	if !ok {
+		return
+	}
+	stmt.LongData[req.ParamID] += req.Data
+}
+
+// Build final query from COM_STMT_EXECUTE packet
+func (s *sessionStatements) getExecuteQuery(packet []byte) (string, error) {
+	if err := checkPacketLength(9, packet); err != nil {
+		return "", err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statementID := uint32(packet[5]) | uint32(packet[6])<<8 | uint32(packet[7])<<16 | uint32(packet[8])<<24
+
+	stmt, ok := s.statements[statementID]
+
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Unknown prepared statement %d", statementID))
+	}
+
+	decoded, err := decodeComStmtExecuteRequest(packet, stmt.ParamsCount, stmt.ParamsTypes, stmt.LongData)
+
+	if err != nil {
+		return "", err
+	}
+	// long data is used only for one execute
+	stmt.LongData = make(map[uint16]string)
+	stmt.ParamsTypes = decoded.PreparedParameters
+
+	return interpolateStatementQuery(stmt.Query, decoded.PreparedParameters)
+}
+
+// Forget long data of a statement
+func (s *sessionStatements) reset(statementID uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if stmt, ok := s.statements[statementID]; ok {
+		stmt.LongData = make(map[uint16]string)
+	}
+}
+
+// Forget a statement
+func (s *sessionStatements) close(statementID uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.statements, statementID)
+}
+
+// Replace placeholders in a prepared query with parameters values
+// Placeholders inside of quoted strings, identifiers and comments are ignored
+func interpolateStatementQuery(query string, params []preparedParameter) (string, error) {
+	var result strings.Builder
+
+	paramIndex := 0
+
+	var quote byte
+	inLineComment := false
+	inBlockComment := false
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(query) {
+				result.WriteByte(c)
+				i++
+				c = query[i]
+			} else if c == quote {
+				quote = 0
+			}
+
+		case inLineComment:
+			if c == '\n' {
+				inLineComment = false
+			}
+
+		case inBlockComment:
+			if c == '*' && i+1 < len(query) && query[i+1] == '/' {
+				inBlockComment = false
+				result.WriteByte(c)
+				i++
+				c = query[i]
+			}
+
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+
+		case c == '#':
+			inLineComment = true
+
+		case c == '-' && strings.HasPrefix(query[i:], "-- "):
+			inLineComment = true
+
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			inBlockComment = true
+
+		case c == '?':
+			if paramIndex >= len(params) {
+				return "", errors.New("Number of placeholders is more than number of parameters")
+			}
+			result.WriteString(formatParameterValue(params[paramIndex]))
+			paramIndex++
+			continue
+		}
+		result.WriteByte(c)
+	}
+
+	if paramIndex != len(params) {
+		return "", errors.New(fmt.Sprintf("Prepared statement expects %d parameters, found %d placeholders", len(params), paramIndex))
+	}
+
+	return result.String(), nil
+}
+
+// Converts parameter to SQL literal
+func formatParameterValue(p preparedParameter) string {
+	if p.IsNull {
+		return "NULL"
+	}
+
+	switch p.FieldType {
+	case fieldTypeTiny, fieldTypeShort, fieldTypeLong, fieldTypeInt24,
+		fieldTypeLongLong, fieldTypeYear, fieldTypeFloat, fieldTypeDouble:
+		return p.Value
+	}
+
+	if !utf8.ValidString(p.Value) {
+		// binary data
+		return fmt.Sprintf("X'%x'", p.Value)
+	}
+
+	return "'" + escapeStringValue(p.Value) + "'"
+}
+
+// Escape special characters in a string to use inside single quotes
+func escapeStringValue(v string) string {
+	var result strings.Builder
+
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case 0:
+			result.WriteString("\\0")
+		case '\n':
+			result.WriteString("\\n")
+		case '\r':
+			result.WriteString("\\r")
+		case '\\':
+			result.WriteString("\\\\")
+		case '\'':
+			result.WriteString("\\'")
+		case '"':
+			result.WriteString("\\\"")
+		case '\032':
+			result.WriteString("\\Z")
+		default:
+			result.WriteByte(v[i])
+		}
+	}
+	return result.String()
+}
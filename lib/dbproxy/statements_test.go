@@ -0,0 +1,144 @@
+package dbproxy
+
+import (
+	"testing"
+)
+
+func TestFormatParameterValue(t *testing.T) {
+	tests := []struct {
+		param    preparedParameter
+		expected string
+	}{
+		{preparedParameter{FieldType: fieldTypeVarChar, IsNull: true, Value: "x"}, "NULL"},
+		{preparedParameter{FieldType: fieldTypeLong, IsNull: true}, "NULL"},
+		{preparedParameter{FieldType: fieldTypeLongLong, Value: "-12"}, "-12"},
+		{preparedParameter{FieldType: fieldTypeDouble, Value: "1.5"}, "1.5"},
+		{preparedParameter{FieldType: fieldTypeVarChar, Value: "simple"}, "'simple'"},
+		{preparedParameter{FieldType: fieldTypeVarChar, Value: ""}, "''"},
+		{preparedParameter{FieldType: fieldTypeVarChar, Value: "it's"}, "'it\\'s'"},
+		{preparedParameter{FieldType: fieldTypeVarChar, Value: "say \"hi\""}, "'say \\\"hi\\\"'"},
+		{preparedParameter{FieldType: fieldTypeVarChar, Value: "a\\' OR 1=1 -- "}, "'a\\\\\\' OR 1=1 -- '"},
+		{preparedParameter{FieldType: fieldTypeVarChar, Value: "line1\nline2\r\x1a\x00"}, "'line1\\nline2\\r\\Z\\0'"},
+		{preparedParameter{FieldType: fieldTypeBlob, Value: "\xff\x00'\\"}, "X'ff00275c'"},
+	}
+
+	for _, test := range tests {
+		got := formatParameterValue(test.param)
+
+		if got != test.expected {
+			t.Fatalf("Wrong value for %q: got %s, expected %s", test.param.Value, got, test.expected)
+		}
+	}
+}
+
+func TestInterpolateStatementQuery(t *testing.T) {
+	str := func(v string) preparedParameter {
+		return preparedParameter{FieldType: fieldTypeVarChar, Value: v}
+	}
+	num := func(v string) preparedParameter {
+		return preparedParameter{FieldType: fieldTypeLong, Value: v}
+	}
+	null := preparedParameter{FieldType: fieldTypeVarChar, IsNull: true}
+
+	tests := []struct {
+		query    string
+		params   []preparedParameter
+		expected string
+	}{
+		{"INSERT INTO t SET a=?, b=?", []preparedParameter{num("1"), str("x")},
+			"INSERT INTO t SET a=1, b='x'"},
+		{"UPDATE t SET a=? WHERE id=?", []preparedParameter{null, num("5")},
+			"UPDATE t SET a=NULL WHERE id=5"},
+		{"UPDATE t SET a=? WHERE b='?' AND `c?`=?", []preparedParameter{str("?"), str("'?'")},
+			"UPDATE t SET a='?' WHERE b='?' AND `c?`=?"[:0] + "UPDATE t SET a='?' WHERE b='?' AND `c?`='\\'?\\''"},
+		{"UPDATE t SET a='it\\'s ?' WHERE id=?", []preparedParameter{num("2")},
+			"UPDATE t SET a='it\\'s ?' WHERE id=2"},
+		{"DELETE FROM t /* ? */ WHERE id=? -- ?\n AND b=? # ?", []preparedParameter{num("3"), str("\\")},
+			"DELETE FROM t /* ? */ WHERE id=3 -- ?\n AND b='\\\\' # ?"},
+		{"INSERT INTO t VALUES (?)", []preparedParameter{preparedParameter{FieldType: fieldTypeBlob, Value: "\x00\xfe"}},
+			"INSERT INTO t VALUES (X'00fe')"},
+	}
+
+	for _, test := range tests {
+		got, err := interpolateStatementQuery(test.query, test.params)
+
+		if err != nil {
+			t.Fatalf("Error for %s: %s", test.query, err.Error())
+		}
+
+		if got != test.expected {
+			t.Fatalf("Wrong query for %s: got %s, expected %s", test.query, got, test.expected)
+		}
+	}
+
+	if _, err := interpolateStatementQuery("UPDATE t SET a=? WHERE id=?", []preparedParameter{num("1")}); err == nil {
+		t.Fatalf("Expected error when there are more placeholders than parameters")
+	}
+
+	if _, err := interpolateStatementQuery("UPDATE t SET a='?'", []preparedParameter{num("1")}); err == nil {
+		t.Fatalf("Expected error when there are more parameters than placeholders")
+	}
+}
+
+// Prepare OK response packet with sequence 1
+func makePrepareOkPacket(statementID uint32, paramsCount uint16) []byte {
+	return []byte{12, 0, 0, 1, responsePrepareOk,
+		byte(statementID), byte(statementID >> 8), byte(statementID >> 16), byte(statementID >> 24),
+		0, 0, byte(paramsCount), byte(paramsCount >> 8), 0, 0, 0}
+}
+
+func TestPipelinedPrepares(t *testing.T) {
+	s := newSessionStatements()
+
+	s.setPending("UPDATE t SET a=? WHERE id=1")
+	s.setPending("UPDATE t SET b=? WHERE id=2")
+
+	isPrepare, err := s.completePending(makePrepareOkPacket(7, 1))
+
+	if err != nil || !isPrepare {
+		t.Fatalf("First prepare response is not accepted")
+	}
+
+	// parameter definition packet of first response must not take second query
+	isPrepare, err = s.completePending([]byte{4, 0, 0, 2, 3, 'd', 'e', 'f'})
+
+	if err != nil || isPrepare {
+		t.Fatalf("Parameter definition must not be taken as a prepare response")
+	}
+
+	isPrepare, err = s.completePending(makePrepareOkPacket(8, 1))
+
+	if err != nil || !isPrepare {
+		t.Fatalf("Second prepare response is not accepted")
+	}
+
+	// long data for the second statement
+	s.addLongData(&comStmtSendLongDataRequest{StatementID: 8, ParamID: 0, Data: "long 'text"})
+	s.addLongData(&comStmtSendLongDataRequest{StatementID: 8, ParamID: 0, Data: " \\end"})
+
+	executes := map[uint32]string{
+		7: "UPDATE t SET a='' WHERE id=1",
+		8: "UPDATE t SET b='long \\'text \\\\end' WHERE id=2",
+	}
+
+	for statementID, expected := range executes {
+		// one string parameter, types are sent. value is empty, the second statement has long data instead
+		packet := []byte{0, 0, 0, 0, comStmtExecute,
+			byte(statementID), 0, 0, 0, 0, 1, 0, 0, 0,
+			0, 1, fieldTypeVarChar, 0}
+
+		if statementID == 7 {
+			packet = append(packet, 0)
+		}
+
+		query, err := s.getExecuteQuery(packet)
+
+		if err != nil {
+			t.Fatalf("Execute error for statement %d: %s", statementID, err.Error())
+		}
+
+		if query != expected {
+			t.Fatalf("Wrong query for statement %d: got %s, expected %s", statementID, query, expected)
+		}
+	}
+}
@@ -1,11 +1,24 @@
 package lib
 
 const (
-	QueryKindSelect = "select"
-	QueryKindUpdate = "update"
-	QueryKindInsert = "insert"
-	QueryKindDelete = "delete"
-	QueryKindCreate = "create"
-	QueryKindDrop   = "drop"
-	QueryKindOther  = "other"
+	QueryKindSelect   = "select"
+	QueryKindUpdate   = "update"
+	QueryKindInsert   = "insert"
+	QueryKindDelete   = "delete"
+	QueryKindCreate   = "create"
+	QueryKindDrop     = "drop"
+	QueryKindAlter    = "alter"
+	QueryKindTruncate = "truncate"
+	QueryKindOther    = "other"
+
+	// transaction control statements
+	QueryKindBegin    = "begin"
+	QueryKindCommit   = "commit"
+	QueryKindRollback = "rollback"
+
+	// table permissions statements
+	QueryKindGrant        = "grant"
+	QueryKindRevoke       = "revoke"
+	QueryKindRowOwnership = "rowownership" // enable or disable of row ownership mode of a table
+	QueryKindTransfer     = "transfer"     // transfer of a row ownership
 )
@@ -0,0 +1,207 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+)
+
+// Long-term key pair of a node. Node ID is a hash of a public key.
+// Connections between nodes are TLS with self-signed certificates made from this key,
+// so a node knows who is on other side. Certificates are not checked by CA, node IDs are pinned instead
+type NodeIdentity struct {
+	ID          string
+	Certificate tls.Certificate
+}
+
+const nodeIDLength = 20     // bytes of a key hash
+const HandshakeTimeout = 10 // seconds. TLS handshake must be done in this time
+
+// Load a key from a file. New key is made and saved if there is no file
+func LoadNodeIdentity(filepath string) (*NodeIdentity, error) {
+	var key *ecdsa.PrivateKey
+
+	data, err := ioutil.ReadFile(filepath)
+
+	if err == nil {
+		block, _ := pem.Decode(data)
+
+		if block == nil {
+			return nil, errors.New(fmt.Sprintf("Node key file %s has wrong format", filepath))
+		}
+
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+
+		if err != nil {
+			return nil, err
+		}
+	} else if os.IsNotExist(err) {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := x509.MarshalECPrivateKey(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = ioutil.WriteFile(filepath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	return makeNodeIdentity(key)
+}
+
+// Make a self-signed certificate for a key
+func makeNodeIdentity(key *ecdsa.PrivateKey) (*NodeIdentity, error) {
+	id, err := GetNodeID(&key.PublicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: id},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	identity := NodeIdentity{}
+	identity.ID = id
+	identity.Certificate = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	return &identity, nil
+}
+
+// Node ID of a public key
+func GetNodeID(pubKey interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
+
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(der)
+
+	return hex.EncodeToString(hash[:nodeIDLength]), nil
+}
+
+// TLS settings of a node server. A client certificate is optional, wallets don't have it
+func (i *NodeIdentity) ServerTLSConfig() *tls.Config {
+	config := tls.Config{}
+	config.Certificates = []tls.Certificate{i.Certificate}
+	config.ClientAuth = tls.RequestClientCert
+	config.MinVersion = tls.VersionTLS12
+	config.VerifyPeerCertificate = verifyNodeCertificate("")
+
+	return &config
+}
+
+// TLS settings of a client. identity is nil for clients without a key.
+// If expectedID is not empty, a server must have this node ID
+func ClientTLSConfig(identity *NodeIdentity, expectedID string) *tls.Config {
+	config := tls.Config{}
+
+	if identity != nil {
+		config.Certificates = []tls.Certificate{identity.Certificate}
+	}
+	config.MinVersion = tls.VersionTLS12
+	// there is no CA. a certificate is checked by verifyNodeCertificate
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = verifyNodeCertificate(expectedID)
+
+	return &config
+}
+
+// Check that a certificate is signed by own key and the key is of expected node
+func verifyNodeCertificate(expectedID string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			if expectedID != "" {
+				return errors.New("Node certificate is missing")
+			}
+			return nil
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+
+		if err != nil {
+			return err
+		}
+
+		err = cert.CheckSignatureFrom(cert)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Node certificate is not self-signed: %s", err.Error()))
+		}
+
+		if expectedID == "" {
+			return nil
+		}
+
+		id, err := GetNodeID(cert.PublicKey)
+
+		if err != nil {
+			return err
+		}
+
+		if id != expectedID {
+			return errors.New(fmt.Sprintf("Node has ID %s, expected %s", id, expectedID))
+		}
+		return nil
+	}
+}
+
+// Node ID of other side of a connection. Empty if it has no certificate. A handshake must be done before
+func GetConnNodeID(conn *tls.Conn) string {
+	certs := conn.ConnectionState().PeerCertificates
+
+	if len(certs) == 0 {
+		return ""
+	}
+
+	id, err := GetNodeID(certs[0].PublicKey)
+
+	if err != nil {
+		return ""
+	}
+	return id
+}
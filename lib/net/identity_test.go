@@ -0,0 +1,135 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"testing"
+)
+
+func newTestIdentity(t *testing.T) *NodeIdentity {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Key error: %s", err.Error())
+	}
+
+	identity, err := makeNodeIdentity(key)
+
+	if err != nil {
+		t.Fatalf("Identity error: %s", err.Error())
+	}
+	return identity
+}
+
+// Connect to a server with given client settings. Returns node IDs seen by both sides
+func testHandshake(t *testing.T, server *NodeIdentity, config *tls.Config) (string, string, error) {
+	ln, err := tls.Listen(Protocol, "127.0.0.1:0", server.ServerTLSConfig())
+
+	if err != nil {
+		t.Fatalf("Listen error: %s", err.Error())
+	}
+	defer ln.Close()
+
+	seenByServer := make(chan string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+
+		if err != nil {
+			seenByServer <- ""
+			return
+		}
+		defer conn.Close()
+
+		tlsconn := conn.(*tls.Conn)
+
+		if tlsconn.Handshake() != nil {
+			seenByServer <- ""
+			return
+		}
+		seenByServer <- GetConnNodeID(tlsconn)
+	}()
+
+	conn, err := tls.Dial(Protocol, ln.Addr().String(), config)
+
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+
+	return GetConnNodeID(conn), <-seenByServer, nil
+}
+
+func TestNodeIdentityHandshake(t *testing.T) {
+	server := newTestIdentity(t)
+	client := newTestIdentity(t)
+
+	serverID, clientID, err := testHandshake(t, server, ClientTLSConfig(client, server.ID))
+
+	if err != nil {
+		t.Fatalf("Handshake with pinned ID failed: %s", err.Error())
+	}
+
+	if serverID != server.ID || clientID != client.ID {
+		t.Fatalf("Wrong node IDs after handshake: %s, %s", serverID, clientID)
+	}
+
+	// a wallet has no key, it is not known by a server
+	_, clientID, err = testHandshake(t, server, ClientTLSConfig(nil, ""))
+
+	if err != nil {
+		t.Fatalf("Handshake without a key failed: %s", err.Error())
+	}
+
+	if clientID != "" {
+		t.Fatalf("Expected empty ID of a client without a key, got %s", clientID)
+	}
+
+	// other node on same address
+	_, _, err = testHandshake(t, newTestIdentity(t), ClientTLSConfig(client, server.ID))
+
+	if err == nil {
+		t.Fatalf("Expected handshake error for not pinned key")
+	}
+}
+
+func TestNodeKeysPin(t *testing.T) {
+	keys, err := LoadNodeKeys(t.TempDir() + "/knownnodes.json")
+
+	if err != nil {
+		t.Fatalf("Load error: %s", err.Error())
+	}
+
+	addr := NodeAddr{Host: "localhost", Port: 8765}
+
+	if keys.CheckNodeID(addr, "aaa") != nil {
+		t.Fatalf("Any ID must be fine for not pinned address")
+	}
+
+	if keys.PinNodeID(addr, "aaa") != nil {
+		t.Fatalf("Pin error")
+	}
+
+	if keys.CheckNodeID(addr, "bbb") == nil || keys.PinNodeID(addr, "bbb") == nil {
+		t.Fatalf("Other ID must be refused for pinned address")
+	}
+
+	// load from the file again
+	keys, err = LoadNodeKeys(keys.filepath)
+
+	if err != nil {
+		t.Fatalf("Load error: %s", err.Error())
+	}
+
+	if keys.GetNodeID(addr) != "aaa" {
+		t.Fatalf("Pinned ID is not saved")
+	}
+
+	keys.ForgetNode(addr)
+
+	if keys.CheckNodeID(addr, "bbb") != nil {
+		t.Fatalf("Node must have new ID after it is removed")
+	}
+}
@@ -2,6 +2,7 @@ package net
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
 	"errors"
 	"fmt"
@@ -12,7 +13,8 @@ import (
 const Protocol = "tcp"
 const NodeVersion = 1
 const CommandLength = 12
-const AuthStringLength = 20
+const NetworkMagicLength = 4
+const MaxBodiesPerRequest = 100 // max number of full blocks a node returns for one request
 
 // Represents a node address
 type NodeAddr struct {
@@ -82,6 +84,24 @@ func BytesToCommand(bytes []byte) string {
 	return fmt.Sprintf("%s", command)
 }
 
+// Network magic is first bytes of every request. It is made from a genesis block hash,
+// so nodes of different blockchains refuse requests of each other before reading them
+func NetworkMagicFromGenesis(genesisHash []byte) []byte {
+	hash := sha256.Sum256(genesisHash)
+
+	return hash[:NetworkMagicLength]
+}
+
+// Check if a magic is empty. A client which doesn't know a blockchain sends zero bytes
+func IsEmptyNetworkMagic(magic []byte) bool {
+	for _, b := range magic {
+		if b != 0x0 {
+			return false
+		}
+	}
+	return true
+}
+
 // Get command part from request string
 func ExtractCommand(request []byte) []byte {
 	return request[:CommandLength]
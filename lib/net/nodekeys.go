@@ -0,0 +1,131 @@
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// IDs of other nodes. An ID is pinned for an address when we connect to the address first time,
+// after this a node on that address must have same key. IDs are saved to a file, like known_hosts of ssh
+type NodeKeys struct {
+	lock     sync.Mutex
+	filepath string
+	ids      map[string]string // address => node ID
+}
+
+// Load pinned IDs from a file. It is not an error if a file doesn't exist yet
+func LoadNodeKeys(filepath string) (*NodeKeys, error) {
+	k := NodeKeys{}
+	k.filepath = filepath
+	k.ids = make(map[string]string)
+
+	data, err := ioutil.ReadFile(filepath)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &k, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &k.ids)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Known nodes file %s has wrong format: %s", filepath, err.Error()))
+	}
+	return &k, nil
+}
+
+// Pinned ID of a node. Empty if a node was not connected yet
+func (k *NodeKeys) GetNodeID(addr NodeAddr) string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return k.ids[addr.NodeAddrToString()]
+}
+
+// Address where a node with the ID was connected. false if the ID is not pinned for any address
+func (k *NodeKeys) GetNodeAddr(id string) (NodeAddr, bool) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	for address, pinned := range k.ids {
+		if pinned == id {
+			addr := NodeAddr{}
+			addr.LoadFromString(address)
+
+			return addr, true
+		}
+	}
+	return NodeAddr{}, false
+}
+
+// Pin ID of a node on an address. Fails if other ID is pinned
+func (k *NodeKeys) PinNodeID(addr NodeAddr, id string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	err := k.checkNodeID(addr, id)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := k.ids[addr.NodeAddrToString()]; ok {
+		return nil
+	}
+
+	k.ids[addr.NodeAddrToString()] = id
+
+	return k.save()
+}
+
+// Check that a node on an address has pinned ID. Any ID is fine if nothing is pinned
+func (k *NodeKeys) CheckNodeID(addr NodeAddr, id string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return k.checkNodeID(addr, id)
+}
+
+// Forget ID of a node. It is done when a node is removed by a user, so it can have new key
+func (k *NodeKeys) ForgetNode(addr NodeAddr) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if _, ok := k.ids[addr.NodeAddrToString()]; !ok {
+		return nil
+	}
+
+	delete(k.ids, addr.NodeAddrToString())
+
+	return k.save()
+}
+
+// must be called when the lock is set
+func (k *NodeKeys) checkNodeID(addr NodeAddr, id string) error {
+	pinned, ok := k.ids[addr.NodeAddrToString()]
+
+	if ok && pinned != id {
+		return errors.New(fmt.Sprintf("Node %s has ID %s, but %s is pinned for it", addr.NodeAddrToString(), id, pinned))
+	}
+	return nil
+}
+
+// must be called when the lock is set
+func (k *NodeKeys) save() error {
+	if k.filepath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(k.ids, "", "  ")
+
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(k.filepath, data, 0600)
+}
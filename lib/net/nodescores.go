@@ -0,0 +1,197 @@
+package net
+
+import (
+	"sync"
+	"time"
+)
+
+// Penalty points for bad behaviour of a node. A node is banned when it has BanScore points
+const (
+	PenaltyInvalidBlock      = 50
+	PenaltyInvalidSignature  = 50
+	PenaltyOversizedMessage  = 20
+	PenaltyProtocolViolation = 10
+)
+
+const BanScore = 100
+
+// Time while a banned node is ignored. Seconds. Every next ban of same node is 2 times longer
+const BanTime = 3600
+const MaxBanTime = 7 * 24 * 3600
+
+// Seconds. Score of a node goes down by 1 point during this time
+const ScoreDecayTime = 60
+
+// Reputation of a node
+type NodeScore struct {
+	Score      int   // penalty points
+	Updated    int64 // time when the score was changed last time
+	BannedTill int64 // 0 if a node was never banned
+	Bans       int   // how many times a node was banned
+}
+
+// Scores of nodes. It is shared by all copies of nodes network object
+// Nodes which connect to us can be not known by address, they are scored by ID of a key
+type NodesScores struct {
+	lock   sync.Mutex
+	scores map[string]*NodeScore // address => score
+	peers  map[string]*NodeScore // node ID => score
+}
+
+func NewNodesScores() *NodesScores {
+	s := NodesScores{}
+	s.scores = make(map[string]*NodeScore)
+	s.peers = make(map[string]*NodeScore)
+
+	return &s
+}
+
+// Check if a node is banned now
+func (s NodeScore) IsBanned() bool {
+	return s.BannedTill > time.Now().Unix()
+}
+
+// Points go down with time
+func (s *NodeScore) decay(now int64) {
+	if s.Score <= 0 || s.Updated <= 0 {
+		return
+	}
+
+	drop := int((now - s.Updated) / ScoreDecayTime)
+
+	if drop <= 0 {
+		return
+	}
+
+	s.Score -= drop
+	s.Updated += int64(drop) * ScoreDecayTime
+
+	if s.Score < 0 {
+		s.Score = 0
+	}
+}
+
+// Add penalty points to a node. Returns new score and true if the node is banned now
+func (s *NodesScores) penalize(addr NodeAddr, penalty int) (NodeScore, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return penalizeScore(s.scores, addr.NodeAddrToString(), penalty)
+}
+
+// Add penalty points to a node by ID of its key
+func (s *NodesScores) penalizePeer(id string, penalty int) (NodeScore, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return penalizeScore(s.peers, id, penalty)
+}
+
+// must be called when the lock is set
+func penalizeScore(scores map[string]*NodeScore, key string, penalty int) (NodeScore, bool) {
+	now := time.Now().Unix()
+
+	score, ok := scores[key]
+
+	if !ok {
+		score = &NodeScore{}
+		scores[key] = score
+	}
+
+	score.decay(now)
+
+	score.Score += penalty
+	score.Updated = now
+
+	if score.Score < BanScore {
+		return *score, false
+	}
+
+	// points are spent on a ban
+	banTime := int64(BanTime) << uint(score.Bans)
+
+	if banTime > MaxBanTime || banTime <= 0 {
+		banTime = MaxBanTime
+	}
+
+	score.Bans++
+	score.Score = 0
+	score.BannedTill = now + banTime
+
+	return *score, true
+}
+
+// Score of a node. Empty if a node was not penalized
+func (s *NodesScores) get(addr NodeAddr) NodeScore {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return getScore(s.scores, addr.NodeAddrToString())
+}
+
+// Score of a node by ID of its key
+func (s *NodesScores) getPeer(id string) NodeScore {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return getScore(s.peers, id)
+}
+
+// must be called when the lock is set
+func getScore(scores map[string]*NodeScore, key string) NodeScore {
+	score, ok := scores[key]
+
+	if !ok {
+		return NodeScore{}
+	}
+
+	score.decay(time.Now().Unix())
+
+	return *score
+}
+
+// Scores of all nodes which were penalized
+func (s *NodesScores) getAll() map[string]NodeScore {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return getAllScores(s.scores)
+}
+
+// Scores of all nodes which were penalized by ID of a key
+func (s *NodesScores) getAllPeers() map[string]NodeScore {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return getAllScores(s.peers)
+}
+
+// must be called when the lock is set
+func getAllScores(scores map[string]*NodeScore) map[string]NodeScore {
+	now := time.Now().Unix()
+
+	list := make(map[string]NodeScore)
+
+	for key, score := range scores {
+		score.decay(now)
+
+		list[key] = *score
+	}
+	return list
+}
+
+// Set scores loaded from a storage
+func (s *NodesScores) set(scores map[string]NodeScore, peers map[string]NodeScore) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for address, score := range scores {
+		score := score
+		s.scores[address] = &score
+	}
+
+	for id, score := range peers {
+		score := score
+		s.peers[id] = &score
+	}
+}
@@ -0,0 +1,113 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNodePenaltiesAndBan(t *testing.T) {
+	n := NodeNetwork{}
+	n.Init()
+
+	addr := NodeAddr{Host: "localhost", Port: 8765}
+	n.SetNodes([]NodeAddr{addr}, true)
+
+	if n.PenalizeNode(addr, PenaltyInvalidBlock, "bad block") {
+		t.Fatalf("Node must not be banned after one penalty")
+	}
+
+	if n.GetNodeScore(addr).Score != PenaltyInvalidBlock {
+		t.Fatalf("Wrong score %d", n.GetNodeScore(addr).Score)
+	}
+
+	if !n.PenalizeNode(addr, PenaltyInvalidSignature, "bad signature") {
+		t.Fatalf("Node must be banned when the score reaches %d", BanScore)
+	}
+
+	if !n.CheckIsBanned(addr) || n.CheckIsKnown(addr) {
+		t.Fatalf("Banned node must be removed from known nodes")
+	}
+
+	if n.AddNodeToKnown(addr) {
+		t.Fatalf("Banned node must not be added back")
+	}
+
+	first := n.GetNodeScore(addr)
+
+	// second ban is longer
+	n.BanNode(addr, "deep reorganisation")
+
+	second := n.GetNodeScore(addr)
+
+	if second.Bans != 2 || second.BannedTill-time.Now().Unix() <= first.BannedTill-time.Now().Unix() {
+		t.Fatalf("Second ban must be longer than first")
+	}
+}
+
+func TestNodeScoreDecay(t *testing.T) {
+	now := time.Now().Unix()
+
+	score := NodeScore{Score: 10, Updated: now - 3*ScoreDecayTime - 1}
+	score.decay(now)
+
+	if score.Score != 7 {
+		t.Fatalf("Expected score 7 after decay, got %d", score.Score)
+	}
+
+	score = NodeScore{Score: 2, Updated: now - 10*ScoreDecayTime}
+	score.decay(now)
+
+	if score.Score != 0 {
+		t.Fatalf("Score must not be negative, got %d", score.Score)
+	}
+}
+
+// Storage keeps only saved peers scores
+type peersScoresStorage struct {
+	peers map[string]NodeScore
+}
+
+func (s *peersScoresStorage) GetNodes() ([]NodeAddr, error)                 { return nil, nil }
+func (s *peersScoresStorage) GetNodesScores() (map[string]NodeScore, error) { return nil, nil }
+func (s *peersScoresStorage) SaveNode(addr NodeAddr, score NodeScore)       {}
+func (s *peersScoresStorage) GetPeersScores() (map[string]NodeScore, error) { return s.peers, nil }
+func (s *peersScoresStorage) SavePeerScore(id string, score NodeScore)      { s.peers[id] = score }
+func (s *peersScoresStorage) RemoveNodeFromKnown(addr NodeAddr)             {}
+func (s *peersScoresStorage) GetCountOfKnownNodes() (int, error)            { return 0, nil }
+
+func TestPeerPenaltiesAndBan(t *testing.T) {
+	storage := &peersScoresStorage{make(map[string]NodeScore)}
+
+	n := NodeNetwork{}
+	n.Init()
+	n.SetExtraManager(storage)
+
+	if n.PenalizePeer("abcd", PenaltyOversizedMessage, "too long") {
+		t.Fatalf("Node must not be banned after one penalty")
+	}
+
+	if len(storage.peers) > 0 {
+		t.Fatalf("Score of not banned node must not be saved")
+	}
+
+	if !n.PenalizePeer("abcd", BanScore, "bad block") {
+		t.Fatalf("Node must be banned")
+	}
+
+	if !n.CheckPeerIsBanned("abcd") || n.CheckPeerIsBanned("other") {
+		t.Fatalf("Only penalized node must be banned")
+	}
+
+	// ban is kept after restart
+	n2 := NodeNetwork{}
+	n2.Init()
+	n2.SetExtraManager(storage)
+
+	if err := n2.LoadNodes(); err != nil {
+		t.Fatalf("Load error %s", err.Error())
+	}
+
+	if !n2.CheckPeerIsBanned("abcd") {
+		t.Fatalf("Ban must be loaded from a storage")
+	}
+}
@@ -2,16 +2,19 @@ package net
 
 import (
 	"sync"
+	"time"
 
 	"github.com/gelembjuk/oursql/lib/utils"
 )
 
 // INterface for extra storage for a nodes.
-// TODO
-// This is not used yet
+// Scores are saved with nodes, banned nodes stay in a storage while a ban is active
 type NodeNetworkStorage interface {
 	GetNodes() ([]NodeAddr, error)
-	AddNodeToKnown(addr NodeAddr)
+	GetNodesScores() (map[string]NodeScore, error)
+	SaveNode(addr NodeAddr, score NodeScore)
+	GetPeersScores() (map[string]NodeScore, error)
+	SavePeerScore(id string, score NodeScore)
 	RemoveNodeFromKnown(addr NodeAddr)
 	GetCountOfKnownNodes() (int, error)
 }
@@ -21,6 +24,7 @@ type NodeNetwork struct {
 	Logger  *utils.LoggerMan
 	Nodes   []NodeAddr
 	Storage NodeNetworkStorage
+	Scores  *NodesScores // set it before Init to share scores with other nodes network object
 	lock    *sync.Mutex
 }
 
@@ -32,6 +36,10 @@ type NodesListJSON struct {
 // Init nodes network object
 func (n *NodeNetwork) Init() {
 	n.lock = &sync.Mutex{}
+
+	if n.Scores == nil {
+		n.Scores = NewNodesScores()
+	}
 }
 
 // Set extra storage for a nodes
@@ -54,7 +62,24 @@ func (n *NodeNetwork) LoadNodes() error {
 		return err
 	}
 
+	scores, err := n.Storage.GetNodesScores()
+
+	if err != nil {
+		return err
+	}
+
+	peers, err := n.Storage.GetPeersScores()
+
+	if err != nil {
+		return err
+	}
+
+	n.Scores.set(scores, peers)
+
 	for _, node := range nodes {
+		if n.isBanned(node) {
+			continue
+		}
 		n.Nodes = append(n.Nodes, node)
 	}
 
@@ -75,7 +100,7 @@ func (n *NodeNetwork) SetNodes(nodes []NodeAddr, replace bool) {
 	if n.Storage != nil {
 		// remember what is not yet remembered
 		for _, node := range nodes {
-			n.Storage.AddNodeToKnown(node)
+			n.Storage.SaveNode(node, n.Scores.get(node))
 		}
 	}
 }
@@ -113,6 +138,10 @@ func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
 	n.lock.Lock()
 	defer n.lock.Unlock()
 
+	if n.isBanned(addr) {
+		return false
+	}
+
 	exists := false
 
 	for _, node := range n.Nodes {
@@ -126,7 +155,7 @@ func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
 	}
 
 	if n.Storage != nil {
-		n.Storage.AddNodeToKnown(addr)
+		n.Storage.SaveNode(addr, n.Scores.get(addr))
 	}
 
 	return !exists
@@ -137,6 +166,15 @@ func (n *NodeNetwork) RemoveNodeFromKnown(addr NodeAddr) {
 	n.lock.Lock()
 	defer n.lock.Unlock()
 
+	n.removeNode(addr)
+
+	if n.Storage != nil {
+		n.Storage.RemoveNodeFromKnown(addr)
+	}
+}
+
+// must be called when the lock is set
+func (n *NodeNetwork) removeNode(addr NodeAddr) {
 	updatedlist := []NodeAddr{}
 
 	for _, node := range n.Nodes {
@@ -146,8 +184,85 @@ func (n *NodeNetwork) RemoveNodeFromKnown(addr NodeAddr) {
 	}
 
 	n.Nodes = updatedlist
+}
 
-	if n.Storage != nil {
-		n.Storage.RemoveNodeFromKnown(addr)
+// Add penalty points to a node for bad behaviour. A node is banned when it has BanScore points.
+// Banned node is removed from known nodes and is not added back while a ban is active. Returns true if banned
+func (n *NodeNetwork) PenalizeNode(addr NodeAddr, penalty int, reason string) bool {
+	score, banned := n.Scores.penalize(addr, penalty)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.Logger != nil {
+		n.Logger.Warning.Printf("Node %s got %d penalty points for: %s. Score is %d", addr.NodeAddrToString(), penalty, reason, score.Score)
+	}
+
+	if banned {
+		if n.Logger != nil {
+			n.Logger.Warning.Printf("Node %s is banned till %s", addr.NodeAddrToString(), time.Unix(score.BannedTill, 0).Format(time.RFC3339))
+		}
+		n.removeNode(addr)
+	}
+
+	if n.Storage != nil && (banned || n.CheckIsKnown(addr)) {
+		// a ban must be kept after restart
+		n.Storage.SaveNode(addr, score)
+	}
+	return banned
+}
+
+// Add penalty points to a node by ID of its key. It is used for nodes which connect to us
+// and are not known by address. Returns true if banned
+func (n *NodeNetwork) PenalizePeer(id string, penalty int, reason string) bool {
+	score, banned := n.Scores.penalizePeer(id, penalty)
+
+	if n.Logger != nil {
+		n.Logger.Warning.Printf("Node with ID %s got %d penalty points for: %s. Score is %d", id, penalty, reason, score.Score)
+
+		if banned {
+			n.Logger.Warning.Printf("Node with ID %s is banned till %s", id, time.Unix(score.BannedTill, 0).Format(time.RFC3339))
+		}
+	}
+
+	if n.Storage != nil && banned {
+		// a ban must be kept after restart. scores of not banned nodes are not saved,
+		// keys are cheap and a storage would grow
+		n.Storage.SavePeerScore(id, score)
 	}
+	return banned
+}
+
+// Ban a node for bad behaviour at once
+func (n *NodeNetwork) BanNode(addr NodeAddr, reason string) {
+	n.PenalizeNode(addr, BanScore, reason)
+}
+
+// Score of a node
+func (n *NodeNetwork) GetNodeScore(addr NodeAddr) NodeScore {
+	return n.Scores.get(addr)
+}
+
+// Scores of all nodes which were penalized, including banned nodes
+func (n *NodeNetwork) GetNodesScores() map[string]NodeScore {
+	return n.Scores.getAll()
+}
+
+// Scores of nodes which were penalized by ID of a key
+func (n *NodeNetwork) GetPeersScores() map[string]NodeScore {
+	return n.Scores.getAllPeers()
+}
+
+// Check if a node is banned now
+func (n *NodeNetwork) CheckIsBanned(addr NodeAddr) bool {
+	return n.isBanned(addr)
+}
+
+// Check if a node with the key ID is banned now
+func (n *NodeNetwork) CheckPeerIsBanned(id string) bool {
+	return n.Scores.getPeer(id).IsBanned()
+}
+
+func (n *NodeNetwork) isBanned(addr NodeAddr) bool {
+	return n.Scores.get(addr).IsBanned()
 }
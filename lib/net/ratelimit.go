@@ -0,0 +1,80 @@
+package net
+
+import (
+	"sync"
+	"time"
+)
+
+// Max number of addresses a rate limiter remembers. Idle addresses are removed when there are more
+const rateLimiterMaxKeys = 10000
+
+// Token bucket rate limits per key (IP address). Every request takes a token, tokens are added
+// with given rate per second up to burst
+type RateLimiter struct {
+	lock    sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	l := RateLimiter{}
+	l.rate = rate
+	l.burst = float64(burst)
+	l.buckets = make(map[string]*tokenBucket)
+
+	return &l
+}
+
+// Take a token for a request. false if there are no tokens, a request must be refused
+func (l *RateLimiter) Allow(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+
+	b, ok := l.buckets[key]
+
+	if !ok {
+		if len(l.buckets) >= rateLimiterMaxKeys {
+			l.removeIdle(now)
+		}
+		b = &tokenBucket{l.burst, now}
+		l.buckets[key] = b
+	} else {
+		l.refill(b, now)
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// must be called when the lock is set
+func (l *RateLimiter) refill(b *tokenBucket, now time.Time) {
+	b.tokens += now.Sub(b.updated).Seconds() * l.rate
+	b.updated = now
+
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+}
+
+// Buckets which are full are same as new ones. must be called when the lock is set
+func (l *RateLimiter) removeIdle(now time.Time) {
+	for key, b := range l.buckets {
+		l.refill(b, now)
+
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
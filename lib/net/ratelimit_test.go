@@ -0,0 +1,32 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(10, 5)
+
+	for i := 0; i < 5; i++ {
+		if !l.Allow("10.0.0.1") {
+			t.Fatalf("Request %d must be allowed within burst", i)
+		}
+	}
+
+	if l.Allow("10.0.0.1") {
+		t.Fatalf("Request over burst must be refused")
+	}
+
+	// other address has own tokens
+	if !l.Allow("10.0.0.2") {
+		t.Fatalf("Request from other address must be allowed")
+	}
+
+	// 10 tokens per second
+	time.Sleep(150 * time.Millisecond)
+
+	if !l.Allow("10.0.0.1") {
+		t.Fatalf("Request must be allowed when tokens are added")
+	}
+}
@@ -0,0 +1,86 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Session is a long-lived connection between nodes. A client opens it with "session" command,
+// a server responds with one byte 1 and after this both sides exchange frames.
+// Frame is a kind (1 byte), a request ID (4 bytes), a length of data (4 bytes) and data.
+// Data of a request frame is a usual request, data of a response frame is a usual response.
+// A server responds to every request of a session, a response has ID of a request
+
+const (
+	FrameRequest  = 1
+	FrameResponse = 2
+	FramePing     = 3
+	FramePong     = 4
+)
+
+const SessionKeepAlive = 30                     // seconds. a client pings a server with this interval
+const SessionReadTimeout = 3 * SessionKeepAlive // seconds. a session is closed if nothing is received during this time
+const SessionIdleTimeout = 600                  // seconds. a session without requests is closed
+const SessionMaxFrameLength = 64 * 1024 * 1024  // bytes. max length of a response frame
+const ReconnectMinDelay = 1                     // seconds. delay after a failed connection, doubles after every next fail
+const ReconnectMaxDelay = 300                   // seconds
+
+// Frame is longer than allowed
+type FrameTooLongError struct {
+	Length uint32
+}
+
+func (e *FrameTooLongError) Error() string {
+	return fmt.Sprintf("Frame of %d bytes is too long", e.Length)
+}
+
+// Write a frame of a session
+func WriteFrame(w io.Writer, kind byte, id uint32, data []byte) error {
+	frame := make([]byte, 9, 9+len(data))
+
+	frame[0] = kind
+	binary.LittleEndian.PutUint32(frame[1:5], id)
+	binary.LittleEndian.PutUint32(frame[5:9], uint32(len(data)))
+
+	frame = append(frame, data...)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// Read a frame of a session. Returns kind, request ID and data. A frame longer than maxLength is refused.
+// Data is read by parts, so memory is allocated only for data really received
+func ReadFrame(r io.Reader, maxLength uint32) (byte, uint32, []byte, error) {
+	header := make([]byte, 9)
+
+	_, err := io.ReadFull(r, header)
+
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	kind := header[0]
+	id := binary.LittleEndian.Uint32(header[1:5])
+	length := binary.LittleEndian.Uint32(header[5:9])
+
+	if length > maxLength {
+		return 0, 0, nil, &FrameTooLongError{length}
+	}
+
+	data := bytes.Buffer{}
+
+	n, err := data.ReadFrom(io.LimitReader(r, int64(length)))
+
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	if n < int64(length) {
+		return 0, 0, nil, io.ErrUnexpectedEOF
+	}
+
+	return kind, id, data.Bytes(), nil
+}
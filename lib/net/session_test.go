@@ -0,0 +1,71 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// Reader which fails a test if data after a frame header is read
+type headerOnlyReader struct {
+	t      *testing.T
+	header []byte
+}
+
+func (r *headerOnlyReader) Read(p []byte) (int, error) {
+	if len(r.header) == 0 {
+		r.t.Fatalf("Data of a too long frame must not be read")
+	}
+	n := copy(p, r.header)
+	r.header = r.header[n:]
+
+	return n, nil
+}
+
+func TestReadFrame(t *testing.T) {
+	buf := bytes.Buffer{}
+
+	if err := WriteFrame(&buf, FrameRequest, 7, []byte("request data")); err != nil {
+		t.Fatalf("Write error: %s", err.Error())
+	}
+
+	kind, id, data, err := ReadFrame(&buf, 100)
+
+	if err != nil {
+		t.Fatalf("Read error: %s", err.Error())
+	}
+
+	if kind != FrameRequest || id != 7 || string(data) != "request data" {
+		t.Fatalf("Wrong frame %d %d %s", kind, id, string(data))
+	}
+
+	// a frame over the limit is refused after a header
+	buf.Reset()
+	WriteFrame(&buf, FrameRequest, 8, make([]byte, 101))
+
+	_, _, _, err = ReadFrame(&headerOnlyReader{t, buf.Bytes()[:9]}, 100)
+
+	if _, ok := err.(*FrameTooLongError); !ok {
+		t.Fatalf("Expected too long frame error, got %v", err)
+	}
+
+	// a frame says it is long but has few bytes. memory is not allocated for the declared length
+	buf.Reset()
+	WriteFrame(&buf, FrameRequest, 9, make([]byte, 32*1024*1024))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	_, _, _, err = ReadFrame(bytes.NewReader(buf.Bytes()[:9+100]), SessionMaxFrameLength)
+
+	runtime.ReadMemStats(&after)
+
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected unexpected EOF error, got %v", err)
+	}
+
+	if after.TotalAlloc-before.TotalAlloc > 1024*1024 {
+		t.Fatalf("Too much memory allocated for a short frame: %d bytes", after.TotalAlloc-before.TotalAlloc)
+	}
+}
@@ -5,6 +5,7 @@ package nodeclient
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"time"
 
@@ -20,12 +21,16 @@ import (
 )
 
 type NodeClient struct {
-	DataDir     string
-	NodeAddress netlib.NodeAddr
-	Address     string // wallet address
-	Logger      *utils.LoggerMan
-	NodeNet     *netlib.NodeNetwork
-	NodeAuthStr string
+	DataDir      string
+	NodeAddress  netlib.NodeAddr
+	Address      string // wallet address
+	Logger       *utils.LoggerMan
+	NodeNet      *netlib.NodeNetwork
+	Identity     *netlib.NodeIdentity // key of this node. nil for wallets
+	Keys         *netlib.NodeKeys     // pinned IDs of other nodes
+	ChainConfig  []byte               // hash of a chain config of this node. nodes with other config are not accepted
+	NetworkMagic []byte               // made from a genesis hash. empty if a client doesn't know a blockchain
+	Sessions     *SessionsPool        // persistent connections to nodes. if nil, new connection is opened for every request
 }
 
 type ComBlock struct {
@@ -46,6 +51,26 @@ type ComGetFirstBlocksData struct {
 	Height int
 }
 
+// Request of headers for blocks synchronisation. Headers start from the first hash of Locator
+// which is in the primary chain of a node
+type ComGetHeaders struct {
+	AddrFrom netlib.NodeAddr
+	Locator  [][]byte // hashes of our blocks. from top to down
+}
+
+// Response of GetHeaders request
+type ComHeaders struct {
+	Headers   [][]byte // serialised BlockShort structures. lowest block first, it is a block from a locator
+	Height    int      // height of the top of a node
+	ChainWork []byte   // work of the primary chain of a node. big integer
+}
+
+// Request of full blocks by hashes
+type ComGetBodies struct {
+	AddrFrom netlib.NodeAddr
+	Hashes   [][]byte
+}
+
 type ComGetData struct {
 	AddrFrom netlib.NodeAddr
 	Type     string
@@ -150,9 +175,12 @@ type ComTx struct {
 
 // Version mesage to other nodes
 type ComVersion struct {
-	Version    int
-	BestHeight int
-	AddrFrom   netlib.NodeAddr
+	Version     int
+	BestHeight  int
+	AddrFrom    netlib.NodeAddr
+	ChainConfig []byte
+	TopHash     []byte
+	ChainWork   []byte // cumulative work of the chain. big integer. a chain with more work is primary
 }
 
 // To send nodes manage command.
@@ -167,11 +195,30 @@ type ComGetNodeState struct {
 	ExpectingBlocksHeight int
 	TransactionsCached    int
 	UnspentOutputs        int
+	Sync                  ComSyncState
+	NodesScores           map[string]netlib.NodeScore // penalized nodes, including banned
+	PeersScores           map[string]netlib.NodeScore // penalized nodes by ID of a key
+	Violations            ComLimitsViolations
 }
 
-// Check if node address looks fine
-func (c *NodeClient) SetAuthStr(auth string) {
-	c.NodeAuthStr = auth
+// Requests refused by a node server because of limits
+type ComLimitsViolations struct {
+	OversizedRequests   int64
+	ReadTimeouts        int64
+	RejectedConnections int64
+	RateLimitedRequests int64
+	SessionOverloads    int64 // requests refused when a session executes too many requests
+}
+
+// Progress of blocks synchronisation
+type ComSyncState struct {
+	Active        bool
+	StartHeight   int // our height when synchronisation started
+	HeadersHeight int // height of the last loaded header
+	TargetHeight  int // height of a node where headers are loaded from
+	AppliedHeight int // height of the last added block
+	Peers         int // number of nodes blocks are loaded from
+	Retries       int // number of failed requests of blocks
 }
 
 // Check if node address looks fine
@@ -284,6 +331,46 @@ func (c *NodeClient) SendGetFirstBlocks(address netlib.NodeAddr) (*ComGetFirstBl
 	return &datapayload, nil
 }
 
+// Request for headers of blocks after our blocks. It is first step of blocks synchronisation
+func (c *NodeClient) SendGetHeaders(address netlib.NodeAddr, locator [][]byte, timeout time.Duration) (*ComHeaders, error) {
+	data := ComGetHeaders{c.NodeAddress, locator}
+
+	request, err := c.BuildCommandData("getheaders", &data)
+
+	if err != nil {
+		return nil, err
+	}
+	datapayload := ComHeaders{}
+
+	err = c.SendDataWaitResponseTimeout(address, request, &datapayload, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &datapayload, nil
+}
+
+// Request for full blocks by hashes. Blocks are returned in same order
+func (c *NodeClient) SendGetBodies(address netlib.NodeAddr, hashes [][]byte, timeout time.Duration) ([][]byte, error) {
+	data := ComGetBodies{c.NodeAddress, hashes}
+
+	request, err := c.BuildCommandData("getbodies", &data)
+
+	if err != nil {
+		return nil, err
+	}
+	datapayload := [][]byte{}
+
+	err = c.SendDataWaitResponseTimeout(address, request, &datapayload, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload, nil
+}
+
 // Request for a transaction or a block to get full info by ID or Hash
 func (c *NodeClient) SendGetData(address netlib.NodeAddr, kind string, id []byte) error {
 
@@ -310,9 +397,21 @@ func (c *NodeClient) SendTx(addr netlib.NodeAddr, tnxserialised []byte) error {
 	return c.SendData(addr, request)
 }
 
+// Send SQL Transaction which waits for signatures of more keys to other node
+func (c *NodeClient) SendMultiSigTx(addr netlib.NodeAddr, tnxserialised []byte) error {
+	data := ComTx{c.NodeAddress, tnxserialised}
+	request, err := c.BuildCommandData("txmultisig", &data)
+
+	if err != nil {
+		return err
+	}
+
+	return c.SendData(addr, request)
+}
+
 // Send own version and blockchain state to other node
-func (c *NodeClient) SendVersion(addr netlib.NodeAddr, bestHeight int) error {
-	data := ComVersion{netlib.NodeVersion, bestHeight, c.NodeAddress}
+func (c *NodeClient) SendVersion(addr netlib.NodeAddr, bestHeight int, topHash []byte, chainWork []byte) error {
+	data := ComVersion{netlib.NodeVersion, bestHeight, c.NodeAddress, c.ChainConfig, topHash, chainWork}
 
 	request, err := c.BuildCommandData("version", &data)
 
@@ -477,7 +576,7 @@ func (c *NodeClient) SendGetNodes() ([]netlib.NodeAddr, error) {
 // Request to add new node to contacts
 func (c *NodeClient) SendAddNode(node netlib.NodeAddr) error {
 	data := ComManageNode{node}
-	request, err := c.BuildCommandDataWithAuth("addnode", &data)
+	request, err := c.BuildCommandData("addnode", &data)
 
 	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
 
@@ -491,7 +590,7 @@ func (c *NodeClient) SendAddNode(node netlib.NodeAddr) error {
 // Request to remove a node from contacts
 func (c *NodeClient) SendRemoveNode(node netlib.NodeAddr) error {
 	data := ComManageNode{node}
-	request, err := c.BuildCommandDataWithAuth("removenode", &data)
+	request, err := c.BuildCommandData("removenode", &data)
 
 	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
 
@@ -504,7 +603,7 @@ func (c *NodeClient) SendRemoveNode(node netlib.NodeAddr) error {
 
 // Request to remove a node from contacts
 func (c *NodeClient) SendGetState() (ComGetNodeState, error) {
-	request, err := c.BuildCommandDataWithAuth("getstate", nil)
+	request, err := c.BuildCommandData("getstate", nil)
 
 	data := ComGetNodeState{}
 
@@ -517,12 +616,6 @@ func (c *NodeClient) SendGetState() (ComGetNodeState, error) {
 	return data, nil
 }
 
-// Builds a command data. It prepares a slice of bytes from given data
-func (c *NodeClient) BuildCommandDataWithAuth(command string, data interface{}) ([]byte, error) {
-	authbytes := netlib.CommandToBytes(c.NodeAuthStr)
-	return c.doBuildCommandData(command, data, authbytes)
-}
-
 // Builds a command data. It prepares a slice of bytes from given data
 func (c *NodeClient) BuildCommandData(command string, data interface{}) ([]byte, error) {
 	return c.doBuildCommandData(command, data, []byte{})
@@ -543,11 +636,18 @@ func (c *NodeClient) doBuildCommandData(command string, data interface{}, extra
 		payload = []byte{}
 	}
 	c.Logger.Trace.Printf("Build command %s", command)
+
+	// network magic first. zero bytes if we don't know a network
+	request := make([]byte, netlib.NetworkMagicLength)
+	copy(request, c.NetworkMagic)
+
+	request = append(request, netlib.CommandToBytes(command)...)
+
 	payloadlength := uint32(len(payload))
 	bs := make([]byte, 4)
 	binary.LittleEndian.PutUint32(bs, payloadlength) // convert int to []byte
 
-	request := append(netlib.CommandToBytes(command), bs...)
+	request = append(request, bs...)
 
 	// add length of extra data
 	payloadlength = uint32(len(extra))
@@ -573,8 +673,13 @@ func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
 		return err
 	}
 
+	if c.Sessions != nil {
+		_, err = c.sendInSession(addr, data, false, 0)
+		return err
+	}
+
 	c.Logger.Trace.Printf("Sending %d bytes to %s", len(data), addr.NodeAddrToString())
-	conn, err := net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), 1*time.Second)
+	conn, err := c.Dial(addr, 1*time.Second)
 
 	if err != nil {
 		c.Logger.Error.Println(err.Error())
@@ -602,6 +707,11 @@ func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
 
 // Send data to a node and wait for response
 func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, datapayload interface{}) error {
+	return c.SendDataWaitResponseTimeout(addr, data, datapayload, 0)
+}
+
+// Same as SendDataWaitResponse but fails if a node doesn't respond in time. 0 means no timeout
+func (c *NodeClient) SendDataWaitResponseTimeout(addr netlib.NodeAddr, data []byte, datapayload interface{}, timeout time.Duration) error {
 
 	err := c.CheckNodeAddress(addr)
 
@@ -612,41 +722,15 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 
 	c.Logger.Trace.Println("Sending data to " + addr.NodeAddrToString() + " and waiting response")
 
-	// connect
-	conn, err := net.Dial(netlib.Protocol, addr.NodeAddrToString())
-
-	if err != nil {
-		c.Logger.Error.Println(err.Error())
-		c.Logger.Trace.Println("Error: ", err.Error())
-
-		// we can not connect.
-		// we could remove this node from known
-		// but this is not always good. we need somethign more smart here
-		// TODO this needs analysis . if removing of a node is good idea
-		//c.NodeNet.RemoveNodeFromKnown(addr)
-
-		return errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
-	}
-	defer conn.Close()
-
-	c.Logger.Trace.Printf("Sending %d bytes ", len(data))
-	// send command bytes
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	var response []byte
 
-	if err != nil {
-		c.Logger.Error.Println(err.Error())
-		c.Logger.Trace.Println("Error: ", err.Error())
-		return err
+	if c.Sessions != nil {
+		response, err = c.sendInSession(addr, data, true, timeout)
+	} else {
+		response, err = c.sendInConnection(addr, data, timeout)
 	}
-	// read response
-	// read everything
-	c.Logger.Trace.Println("Start readin response")
-
-	response, err := ioutil.ReadAll(conn)
 
 	if err != nil {
-		c.Logger.Error.Println(err.Error())
-		c.Logger.Trace.Println("Response Read Error: ", err.Error())
 		return err
 	}
 
@@ -688,3 +772,94 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 
 	return nil
 }
+
+// Open a connection to a node. Traffic is encrypted. If a node ID is pinned for the address,
+// a node must have it. ID of a new node is pinned. 0 timeout means no timeout
+func (c *NodeClient) Dial(addr netlib.NodeAddr, timeout time.Duration) (net.Conn, error) {
+	expectedID := ""
+
+	if c.Keys != nil {
+		expectedID = c.Keys.GetNodeID(addr)
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+
+	conn, err := tls.DialWithDialer(&dialer, netlib.Protocol, addr.NodeAddrToString(), netlib.ClientTLSConfig(c.Identity, expectedID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Keys != nil && expectedID == "" {
+		err = c.Keys.PinNodeID(addr, netlib.GetConnNodeID(conn))
+
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Send a request in new connection and read a response until the connection is closed
+func (c *NodeClient) sendInConnection(addr netlib.NodeAddr, data []byte, timeout time.Duration) ([]byte, error) {
+	// connect
+	conn, err := c.Dial(addr, timeout)
+
+	if err != nil {
+		c.Logger.Error.Println(err.Error())
+		c.Logger.Trace.Println("Error: ", err.Error())
+
+		// we can not connect.
+		// we could remove this node from known
+		// but this is not always good. we need somethign more smart here
+		// TODO this needs analysis . if removing of a node is good idea
+		//c.NodeNet.RemoveNodeFromKnown(addr)
+
+		return nil, errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	c.Logger.Trace.Printf("Sending %d bytes ", len(data))
+	// send command bytes
+	_, err = io.Copy(conn, bytes.NewReader(data))
+
+	if err != nil {
+		c.Logger.Error.Println(err.Error())
+		c.Logger.Trace.Println("Error: ", err.Error())
+		return nil, err
+	}
+	// read response
+	// read everything
+	c.Logger.Trace.Println("Start readin response")
+
+	response, err := ioutil.ReadAll(conn)
+
+	if err != nil {
+		c.Logger.Error.Println(err.Error())
+		c.Logger.Trace.Println("Response Read Error: ", err.Error())
+		return nil, err
+	}
+	return response, nil
+}
+
+// Send a request in a session with a node. A response is returned if wait is true
+func (c *NodeClient) sendInSession(addr netlib.NodeAddr, data []byte, wait bool, timeout time.Duration) ([]byte, error) {
+	openRequest, err := c.BuildCommandData("session", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Sessions.Request(addr, openRequest, data, wait, timeout, c.Dial)
+
+	if err != nil {
+		c.Logger.Trace.Println("Session Error: ", err.Error())
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,381 @@
+package nodeclient
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+var errSessionClosed = errors.New("Session is closed")
+
+// Persistent connections to other nodes. There is one session per node, all requests to a node share it.
+// If a node can not be connected, next attempt is done after a delay. The delay doubles after every fail
+type SessionsPool struct {
+	Logger   *utils.LoggerMan
+	lock     sync.Mutex
+	sessions map[string]*session
+	failures map[string]*reconnectState
+	closed   bool
+}
+
+type reconnectState struct {
+	count   int
+	nextTry time.Time
+}
+
+// Connection to one node. Requests have IDs, responses are matched to waiting requests by ID
+type session struct {
+	pool        *SessionsPool
+	key         string
+	conn        net.Conn
+	lock        sync.Mutex
+	writeLock   sync.Mutex
+	nextID      uint32
+	waiting     map[uint32]chan []byte
+	closed      bool
+	lastRequest time.Time
+	done        chan struct{}
+}
+
+func NewSessionsPool(logger *utils.LoggerMan) *SessionsPool {
+	p := SessionsPool{}
+	p.Logger = logger
+	p.sessions = make(map[string]*session)
+	p.failures = make(map[string]*reconnectState)
+
+	return &p
+}
+
+// Close all sessions. New sessions are not opened after this
+func (p *SessionsPool) Close() {
+	p.lock.Lock()
+
+	p.closed = true
+
+	sessions := []*session{}
+
+	for _, s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.lock.Unlock()
+
+	for _, s := range sessions {
+		s.close()
+	}
+}
+
+// Function which opens a connection to a node
+type DialFunc func(addr netlib.NodeAddr, timeout time.Duration) (net.Conn, error)
+
+// Send a request to a node. Returns a response if wait is true. openRequest is "session" command
+// It is sent with a connection made by dial if there is no session with the node yet
+func (p *SessionsPool) Request(addr netlib.NodeAddr, openRequest []byte, data []byte, wait bool, timeout time.Duration, dial DialFunc) ([]byte, error) {
+	s, err := p.getSession(addr, openRequest, dial)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.request(data, wait, timeout)
+
+	if err == errSessionClosed {
+		// the session was closed by other side. try new one
+		s, err = p.getSession(addr, openRequest, dial)
+
+		if err != nil {
+			return nil, err
+		}
+		response, err = s.request(data, wait, timeout)
+	}
+	return response, err
+}
+
+// Session with a node. New connection is opened if there is no session
+func (p *SessionsPool) getSession(addr netlib.NodeAddr, openRequest []byte, dial DialFunc) (*session, error) {
+	key := addr.NodeAddrToString()
+
+	p.lock.Lock()
+
+	if p.closed {
+		p.lock.Unlock()
+		return nil, errSessionClosed
+	}
+
+	if s, ok := p.sessions[key]; ok {
+		p.lock.Unlock()
+		return s, nil
+	}
+
+	if f, ok := p.failures[key]; ok && time.Now().Before(f.nextTry) {
+		p.lock.Unlock()
+		return nil, errors.New(fmt.Sprintf("%s is not available. Next attempt in %d sec", key, int(time.Until(f.nextTry).Seconds())+1))
+	}
+	p.lock.Unlock()
+
+	// connect without a lock, it can take time
+	s, err := p.openSession(addr, openRequest, dial)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err != nil {
+		f, ok := p.failures[key]
+
+		if !ok {
+			f = &reconnectState{}
+			p.failures[key] = f
+		}
+
+		delay := netlib.ReconnectMinDelay << uint(f.count)
+
+		if delay > netlib.ReconnectMaxDelay || delay <= 0 {
+			delay = netlib.ReconnectMaxDelay
+		} else {
+			f.count++
+		}
+		f.nextTry = time.Now().Add(time.Duration(delay) * time.Second)
+
+		return nil, err
+	}
+	delete(p.failures, key)
+
+	if existing, ok := p.sessions[key]; ok {
+		// other thread connected at same time
+		s.close()
+		return existing, nil
+	}
+
+	if p.closed {
+		s.close()
+		return nil, errSessionClosed
+	}
+
+	p.sessions[key] = s
+
+	go s.readLoop()
+	go s.keepAlive()
+
+	return s, nil
+}
+
+// Connect to a node and open a session
+func (p *SessionsPool) openSession(addr netlib.NodeAddr, openRequest []byte, dial DialFunc) (*session, error) {
+	p.Logger.Trace.Printf("Open session with %s", addr.NodeAddrToString())
+
+	conn, err := dial(addr, 3*time.Second)
+
+	if err != nil {
+		p.Logger.Error.Println(err.Error())
+		return nil, errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
+	}
+
+	conn.SetDeadline(time.Now().Add(netlib.SessionReadTimeout * time.Second))
+
+	_, err = io.Copy(conn, bytes.NewReader(openRequest))
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	status := make([]byte, 1)
+
+	_, err = io.ReadFull(conn, status)
+
+	if err != nil {
+		conn.Close()
+		return nil, errors.New(fmt.Sprintf("Session with %s is not opened: %s", addr.NodeAddrToString(), err.Error()))
+	}
+
+	if status[0] != 1 {
+		// error message follows
+		response, _ := ioutil.ReadAll(conn)
+		conn.Close()
+
+		var message string
+
+		err = gob.NewDecoder(bytes.NewReader(response)).Decode(&message)
+
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New(message)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	s := session{}
+	s.pool = p
+	s.key = addr.NodeAddrToString()
+	s.conn = conn
+	s.waiting = make(map[uint32]chan []byte)
+	s.lastRequest = time.Now()
+	s.done = make(chan struct{})
+
+	return &s, nil
+}
+
+func (p *SessionsPool) remove(s *session) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.sessions[s.key] == s {
+		delete(p.sessions, s.key)
+	}
+}
+
+// Send a request. If wait is true, a response is returned. It fails if there is no response in timeout,
+// 0 means no timeout, but it fails anyway if the session is closed
+func (s *session) request(data []byte, wait bool, timeout time.Duration) ([]byte, error) {
+	s.lock.Lock()
+
+	if s.closed {
+		s.lock.Unlock()
+		return nil, errSessionClosed
+	}
+
+	s.nextID++
+	id := s.nextID
+
+	var ch chan []byte
+
+	if wait {
+		ch = make(chan []byte, 1)
+		s.waiting[id] = ch
+	}
+	s.lastRequest = time.Now()
+
+	s.lock.Unlock()
+
+	err := s.write(netlib.FrameRequest, id, data)
+
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	if !wait {
+		return nil, nil
+	}
+
+	var timer <-chan time.Time
+
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Session with %s closed before a response", s.key))
+		}
+		return response, nil
+
+	case <-timer:
+		s.lock.Lock()
+		delete(s.waiting, id)
+		s.lock.Unlock()
+
+		return nil, errors.New(fmt.Sprintf("No response from %s in %s", s.key, timeout))
+	}
+}
+
+func (s *session) write(kind byte, id uint32, data []byte) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(netlib.SessionReadTimeout * time.Second))
+
+	return netlib.WriteFrame(s.conn, kind, id, data)
+}
+
+// Read responses and pass them to waiting requests
+func (s *session) readLoop() {
+	defer s.pool.remove(s)
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(netlib.SessionReadTimeout * time.Second))
+
+		kind, id, data, err := netlib.ReadFrame(s.conn, netlib.SessionMaxFrameLength)
+
+		if err != nil {
+			s.pool.Logger.Trace.Printf("Session with %s is closed: %s", s.key, err.Error())
+			s.close()
+			return
+		}
+
+		if kind != netlib.FrameResponse {
+			// pongs only keep the session alive
+			continue
+		}
+
+		s.lock.Lock()
+		ch, ok := s.waiting[id]
+		delete(s.waiting, id)
+		s.lock.Unlock()
+
+		if ok {
+			ch <- data
+		}
+	}
+}
+
+// Ping a server while the session is used. Close it if there were no requests for long time
+func (s *session) keepAlive() {
+	ticker := time.NewTicker(netlib.SessionKeepAlive * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.lock.Lock()
+		idle := time.Since(s.lastRequest)
+		s.lock.Unlock()
+
+		if idle > netlib.SessionIdleTimeout*time.Second {
+			s.close()
+			return
+		}
+
+		err := s.write(netlib.FramePing, 0, nil)
+
+		if err != nil {
+			s.close()
+			return
+		}
+	}
+}
+
+// Close a connection. All waiting requests fail
+func (s *session) close() {
+	s.lock.Lock()
+
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+
+	for _, ch := range s.waiting {
+		close(ch)
+	}
+	s.waiting = make(map[uint32]chan []byte)
+
+	s.lock.Unlock()
+
+	close(s.done)
+	s.conn.Close()
+}
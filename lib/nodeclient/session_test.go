@@ -0,0 +1,155 @@
+package nodeclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Server which opens sessions and returns data of a request back. Responses are sent in random order
+func startEchoServer(t *testing.T) (net.Listener, netlib.NodeAddr) {
+	ln, err := net.Listen(netlib.Protocol, "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen error: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+			go serveEchoSession(conn)
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	return ln, netlib.NodeAddr{Host: "127.0.0.1", Port: port}
+}
+
+func dialPlain(addr netlib.NodeAddr, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), timeout)
+}
+
+func serveEchoSession(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, netlib.NetworkMagicLength+netlib.CommandLength+8)
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	length := binary.LittleEndian.Uint32(header[len(header)-8:]) + binary.LittleEndian.Uint32(header[len(header)-4:])
+
+	if _, err := io.ReadFull(conn, make([]byte, length)); err != nil {
+		return
+	}
+	conn.Write([]byte{1})
+
+	lock := sync.Mutex{}
+
+	for {
+		kind, id, data, err := netlib.ReadFrame(conn, netlib.SessionMaxFrameLength)
+
+		if err != nil {
+			return
+		}
+
+		go func() {
+			if kind == netlib.FrameRequest {
+				// later requests are answered first
+				time.Sleep(time.Duration(100/int(id)) * time.Millisecond)
+				kind = netlib.FrameResponse
+			} else {
+				kind = netlib.FramePong
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			netlib.WriteFrame(conn, kind, id, data)
+		}()
+	}
+}
+
+func TestSessionRequests(t *testing.T) {
+	ln, addr := startEchoServer(t)
+	defer ln.Close()
+
+	pool := NewSessionsPool(utils.CreateLogger())
+	defer pool.Close()
+
+	openRequest := make([]byte, netlib.NetworkMagicLength+netlib.CommandLength+8)
+
+	wg := sync.WaitGroup{}
+
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			data := []byte{byte(i)}
+
+			response, err := pool.Request(addr, openRequest, data, true, 5*time.Second, dialPlain)
+
+			if err != nil {
+				t.Errorf("Request %d failed: %s", i, err.Error())
+				return
+			}
+
+			if !bytes.Equal(response, data) {
+				t.Errorf("Request %d got response %x", i, response)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// all requests must share one connection
+	if len(pool.sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(pool.sessions))
+	}
+
+	_, err := pool.Request(addr, openRequest, []byte{10}, false, 0, dialPlain)
+
+	if err != nil {
+		t.Fatalf("Request without response failed: %s", err.Error())
+	}
+}
+
+func TestSessionReconnectDelay(t *testing.T) {
+	ln, addr := startEchoServer(t)
+	// nothing listens now
+	ln.Close()
+
+	pool := NewSessionsPool(utils.CreateLogger())
+	defer pool.Close()
+
+	openRequest := make([]byte, netlib.NetworkMagicLength+netlib.CommandLength+8)
+
+	for i := 0; i < 2; i++ {
+		_, err := pool.Request(addr, openRequest, []byte{1}, true, time.Second, dialPlain)
+
+		if err == nil {
+			t.Fatalf("Expected connection error")
+		}
+	}
+
+	f := pool.failures[addr.NodeAddrToString()]
+
+	if f == nil || f.count != 1 {
+		t.Fatalf("Expected one failed connection before a delay")
+	}
+
+	if time.Until(f.nextTry) <= 0 {
+		t.Fatalf("Expected a delay before next connection")
+	}
+}
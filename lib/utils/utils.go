@@ -251,3 +251,11 @@ func CopyBytes(source []byte) []byte {
 	}
 	return []byte{}
 }
+
+// Table name without a database name. Tables in configs can be written as db.table, queries are checked by a table only
+func TableNameWithoutSchema(table string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[i+1:]
+	}
+	return table
+}
@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Primary chain is a chain with most work, not a longest one. Work of a PoW block is 2^bits, it is a number
+// of hashes needed in average to find a hash below a target. PoA blocks have no work, each of them counts as 1,
+// so a longest chain wins there.
+// If chains have same work, a chain with lower top hash wins, so all nodes choose same chain
+
+// Work of one block
+func getBlockWork(block *structures.Block) *big.Int {
+	if len(block.Signer) > 0 {
+		return big.NewInt(1)
+	}
+
+	bits := block.Bits
+
+	if bits == 0 {
+		// blocks made before a difficulty was recorded. same rule as proof of work uses
+		bits = config.TargetBits
+
+		if block.Height >= 1000 {
+			bits = config.TargetBits_2
+		}
+	}
+
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits))
+}
+
+// Cumulative work of a chain ending with a block. Blocks added before work was recorded don't have it,
+// for them we go down till a block with work or till a genesis block
+func (bc *Blockchain) getChainWork(block *structures.Block) (*big.Int, error) {
+	work := big.NewInt(0)
+
+	for {
+		if len(block.ChainWork) > 0 {
+			return work.Add(work, new(big.Int).SetBytes(block.ChainWork)), nil
+		}
+
+		work.Add(work, getBlockWork(block))
+
+		if len(block.PrevBlockHash) == 0 {
+			return work, nil
+		}
+
+		prevBlock, err := bc.GetBlock(block.PrevBlockHash)
+
+		if err != nil {
+			return nil, err
+		}
+		block = &prevBlock
+	}
+}
+
+// Cumulative work of a chain ending with a block with a hash
+func (bc *Blockchain) GetChainWork(hash []byte) (*big.Int, error) {
+	block, err := bc.GetBlock(hash)
+
+	if err != nil {
+		return nil, err
+	}
+	return bc.getChainWork(&block)
+}
+
+// Top block of the primary chain and work of the chain. Nodes exchange it to know who has better chain
+func (bc *Blockchain) GetTopChainWork() ([]byte, int, *big.Int, error) {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	blockData, err := bcdb.GetTopBlock()
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	lastBlock, err := structures.NewBlockFromBytes(blockData)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	work, err := bc.getChainWork(lastBlock)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return lastBlock.Hash, lastBlock.Height, work, nil
+}
+
+// Check if a chain ending with a block is better than a chain ending with other block
+func IsHeavierChain(work *big.Int, hash []byte, otherWork *big.Int, otherHash []byte) bool {
+	c := work.Cmp(otherWork)
+
+	if c != 0 {
+		return c > 0
+	}
+	return bytes.Compare(hash, otherHash) < 0
+}
@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+func TestBlockWork(t *testing.T) {
+	tests := []struct {
+		block    structures.Block
+		expected int64
+	}{
+		{structures.Block{Bits: 20}, 1 << 20},
+		{structures.Block{Height: 5}, 1 << 16},
+		{structures.Block{Height: 1000}, 1 << 24},
+		{structures.Block{Bits: 20, Signer: []byte("authority")}, 1},
+	}
+
+	for i, test := range tests {
+		if w := getBlockWork(&test.block); w.Cmp(big.NewInt(test.expected)) != 0 {
+			t.Fatalf("Test %d: expected work %d, got %s", i, test.expected, w.String())
+		}
+	}
+}
+
+func TestHeavierChain(t *testing.T) {
+	// shorter chain with harder blocks wins
+	long := big.NewInt(9 * (1 << 16))
+	short := big.NewInt(8 * (1 << 18))
+
+	if !IsHeavierChain(short, []byte{2}, long, []byte{1}) {
+		t.Fatalf("Chain with more work must win")
+	}
+
+	if IsHeavierChain(long, []byte{1}, short, []byte{2}) {
+		t.Fatalf("Chain with less work must not win")
+	}
+
+	// same work. lower hash wins on any node
+	same := big.NewInt(1 << 16)
+
+	if !IsHeavierChain(same, []byte{1, 5}, same, []byte{2, 0}) {
+		t.Fatalf("Chain with lower hash must win if work is same")
+	}
+
+	if IsHeavierChain(same, []byte{2, 0}, same, []byte{1, 5}) {
+		t.Fatalf("Chain with higher hash must not win if work is same")
+	}
+}
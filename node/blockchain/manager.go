@@ -46,10 +46,10 @@ func NewBlockchainManager(DB database.DBManager, Logger *utils.LoggerMan) (*Bloc
 	BCBAddState_error              = 0 not added to the chain. Because of error
 	BCBAddState_addedToTop         = 1 added to the top of current chain
 	BCBAddState_addedToParallelTop = 2 added to the top, but on other branch. Other branch becomes primary now
-	BCBAddState_addedToParallel    = 3 added but not in main branch and heigh i lower then main branch
+	BCBAddState_addedToParallel    = 3 added but not in main branch and its branch has less work then main branch
 	BCBAddState_notAddedNoPrev     = 4 previous not found
 	BCBAddState_notAddedExists     = 5 already in blockchain
-*
+* Primary branch is a branch with most cumulative work, see chainwork.go
 */
 func (bc *Blockchain) AddBlock(block *structures.Block) (uint, error) {
 	bc.Logger.Trace.Printf("Adding new block to block chain %x", block.Hash)
@@ -81,6 +81,23 @@ func (bc *Blockchain) AddBlock(block *structures.Block) (uint, error) {
 		return BCBAddState_notAddedNoPrev, nil // means block is not added because previous is not in the DB
 	}
 
+	prevBlock, err := structures.NewBlockFromBytes(prevBlockData)
+
+	if err != nil {
+		return BCBAddState_error, err
+	}
+
+	// work of a chain is always calculated here. we don't trust a value received with a block
+	chainWork, err := bc.getChainWork(prevBlock)
+
+	if err != nil {
+		return BCBAddState_error, err
+	}
+
+	chainWork.Add(chainWork, getBlockWork(block))
+
+	block.ChainWork = chainWork.Bytes()
+
 	// add this block
 	blockData, err := block.Serialize()
 
@@ -112,11 +129,17 @@ func (bc *Blockchain) AddBlock(block *structures.Block) (uint, error) {
 		return BCBAddState_error, err
 	}
 
-	bc.Logger.Trace.Printf("Current BC state %d , %x\n", lastBlock.Height, lastHash)
-	bc.Logger.Trace.Printf("New block height %d\n", block.Height)
+	lastWork, err := bc.getChainWork(lastBlock)
+
+	if err != nil {
+		return BCBAddState_error, err
+	}
+
+	bc.Logger.Trace.Printf("Current BC state %d , %x , work %s\n", lastBlock.Height, lastHash, lastWork.String())
+	bc.Logger.Trace.Printf("New block height %d , work %s\n", block.Height, chainWork.String())
 
-	if block.Height > lastBlock.Height {
-		// the block becomes highest and is top of he blockchain
+	if IsHeavierChain(chainWork, block.Hash, lastWork, lastHash) {
+		// the block is top of the heaviest chain and is top of he blockchain
 		err = bcdb.SaveTopHash(block.Hash)
 
 		if err != nil {
@@ -155,7 +178,7 @@ func (bc *Blockchain) AddBlock(block *structures.Block) (uint, error) {
 //
 // NOTE . Operation is done in memory. On practice we don't expect to have million of
 // hashes in different branch. it can be 1-5 . Depends on consensus can be more. but not millions
-// top hash is already update when we execute this
+// top hash is already update when we execute this. It is a top of the heaviest branch, not the longest
 func (bc *Blockchain) UpdateChainOnNewBranch(prevTopHash []byte) error {
 	// go over blocks sttarting from top till bock is found in chain
 	newBlocks := []*structures.BlockShort{}
@@ -464,7 +487,7 @@ func (bc *Blockchain) GetBlocksShortInfo(startfrom []byte, maxcount int) []*stru
 	return blocks
 }
 
-// returns a list of hashes of all the blocks in the chain
+// returns headers of blocks of the chain starting from a block
 func (bc *Blockchain) GetNextBlocks(startfrom []byte) ([]*structures.BlockShort, error) {
 	localError := func(err error) ([]*structures.BlockShort, error) {
 		return nil, err
@@ -495,7 +518,13 @@ func (bc *Blockchain) GetNextBlocks(startfrom []byte) ([]*structures.BlockShort,
 			return localError(err)
 		}
 
-		blocks = append(blocks, block.GetShortCopy())
+		header, err := block.GetHeaderCopy()
+
+		if err != nil {
+			return localError(err)
+		}
+
+		blocks = append(blocks, header)
 
 		if len(blocks) >= maxcount {
 			break
@@ -681,6 +710,7 @@ func (bc *Blockchain) GetSideBranch(hash []byte, currentTip []byte) ([]*structur
 * Returns a chain of blocks starting from a hash and till
 * end of blockchain or block from main chain found
 * if already in main chain then returns empty list
+* Branches can have different length. The tip branch has more work but can be shorter
 *
 * The function load all hashes to the memory from "main" chain
 * TODO We need to use index of blocks
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gelembjuk/oursql/lib"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Settings of a blockchain. They must be same on all nodes of a network,
+// in other case nodes will not accept blocks of each other.
+// They are loaded from a genesis config when a blockchain is created. A hash of them is saved in a genesis block
+type ChainConfig struct {
+	NetworkID   string // name of a network
+	Consensus   ConsensusConfig
+	Difficulty  DifficultyConfig
+	Blocks      BlocksConfig
+	BlockReward float64 // amount a minter gets for a block. CurrencyPaymentForBlockMade if not set
+	Finality    FinalityConfig
+	Pricing     QueryPricing
+	MultiSig    map[string]MultiSigPolicy // tables where updates must be signed by M of N keys
+	ACL         map[string]TableACL       // permissions of tables set when a blockchain is created
+}
+
+const (
+	ConsensusProofOfWork      = "pow"
+	ConsensusProofOfAuthority = "poa"
+)
+
+// Engine which makes and verifies blocks. It is selected when a blockchain is created
+type ConsensusConfig struct {
+	Kind        string   // pow (default) or poa
+	Authorities []string // PoA. public keys in hex of authorities which sign blocks in turn
+	TurnTimeout int64    // PoA. seconds after which next authority can sign a block instead of one who missed a turn. 0 means never
+}
+
+// Check if blocks are signed by authorities instead of proof of work
+func (c ConsensusConfig) IsProofOfAuthority() bool {
+	return c.Kind == ConsensusProofOfAuthority
+}
+
+// Index of an authority. -1 if a key is not an authority
+func (c ConsensusConfig) GetAuthorityIndex(pubKey []byte) int {
+	for i, key := range c.Authorities {
+		if hex.EncodeToString(pubKey) == strings.ToLower(key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Proof of work difficulty. Empty values mean defaults from constants
+type DifficultyConfig struct {
+	InitialBits      int   // difficulty of first blocks
+	RetargetInterval int   // difficulty is changed every N blocks
+	BlockTime        int64 // seconds. difficulty is changed so blocks are made with this interval
+}
+
+func (d DifficultyConfig) GetInitialBits() int {
+	if d.InitialBits > 0 {
+		return d.InitialBits
+	}
+	return TargetBits
+}
+
+func (d DifficultyConfig) GetRetargetInterval() int {
+	if d.RetargetInterval > 0 {
+		return d.RetargetInterval
+	}
+	return RetargetInterval
+}
+
+func (d DifficultyConfig) GetBlockTime() int64 {
+	if d.BlockTime > 0 {
+		return d.BlockTime
+	}
+	return TargetBlockTime
+}
+
+// Limits of a block. Empty values mean defaults from constants
+type BlocksConfig struct {
+	MaxMinTransactions int   // minimum number of TXs in a block is a height of a block, but not more than this
+	MaxTransactions    int   // maximum number of TXs in a block
+	MinBuildingTime    int64 // seconds. a block is not made faster than this. -1 means no minimum
+}
+
+func (b BlocksConfig) GetMaxMinTransactions() int {
+	if b.MaxMinTransactions > 0 {
+		return b.MaxMinTransactions
+	}
+	return MaxMinNumberTransactionInBlock
+}
+
+func (b BlocksConfig) GetMaxTransactions() int {
+	if b.MaxTransactions > 0 {
+		return b.MaxTransactions
+	}
+	return MaxNumberTransactionInBlock
+}
+
+func (b BlocksConfig) GetMinBuildingTime() int64 {
+	if b.MinBuildingTime < 0 {
+		return 0
+	}
+	if b.MinBuildingTime > 0 {
+		return b.MinBuildingTime
+	}
+	return MinimumBlockBuildingTime
+}
+
+// Amount a minter gets for a block, without payments for queries
+func (c ChainConfig) GetBlockReward() float64 {
+	if c.BlockReward > 0 {
+		return c.BlockReward
+	}
+	return lib.CurrencyPaymentForBlockMade
+}
+
+// Initial permissions of a table. Same as permissions set by CREATE TABLE and GRANT queries
+type TableACL struct {
+	Owner        string              // address of the owner
+	Permissions  map[string][]string // permissions of other addresses. INSERT, UPDATE, DELETE, DDL
+	RowOwnership bool
+}
+
+// Blocks which can not be replaced by other branch. Replacing of a branch rollbacks SQL updates of its blocks,
+// so old data can not be rewritten by a long fork
+type FinalityConfig struct {
+	Depth          int          // blocks deeper than this under a top are final. 0 means no limit
+	CheckpointsKey string       // public key in hex which signs checkpoints
+	Checkpoints    []Checkpoint // blocks which are final on any node
+}
+
+// Hash of a block at a height. Signature is made by CheckpointsKey for data of GetSignData
+type Checkpoint struct {
+	Height    int
+	Hash      string // block hash in hex
+	Signature string // in hex
+}
+
+// Data signed for a checkpoint
+func (c Checkpoint) GetSignData() ([]byte, error) {
+	hash, err := hex.DecodeString(c.Hash)
+
+	if err != nil {
+		return nil, err
+	}
+	return append(utils.IntToHex(int64(c.Height)), hash...), nil
+}
+
+// Check that every checkpoint is signed by a checkpoints key. It is done once when a config is loaded
+func (f FinalityConfig) VerifyCheckpoints() error {
+	if len(f.Checkpoints) == 0 {
+		return nil
+	}
+
+	pubKey, err := hex.DecodeString(f.CheckpointsKey)
+
+	if err != nil || len(pubKey) == 0 {
+		return errors.New("Checkpoints key is not set or wrong")
+	}
+
+	for _, cp := range f.Checkpoints {
+		signdata, err := cp.GetSignData()
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Checkpoint at height %d has wrong hash: %s", cp.Height, err.Error()))
+		}
+
+		signature, err := hex.DecodeString(cp.Signature)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Checkpoint at height %d has wrong signature: %s", cp.Height, err.Error()))
+		}
+
+		v, err := utils.VerifySignature(signature, signdata, pubKey)
+
+		if err != nil {
+			return err
+		}
+
+		if !v {
+			return errors.New(fmt.Sprintf("Signature of checkpoint at height %d is not valid", cp.Height))
+		}
+	}
+	return nil
+}
+
+// Checkpoint at a height. nil if there is no
+func (f FinalityConfig) GetCheckpoint(height int) *Checkpoint {
+	for _, cp := range f.Checkpoints {
+		if cp.Height == height {
+			return &cp
+		}
+	}
+	return nil
+}
+
+// Keys which can sign updates of a table and number of signatures needed
+type MultiSigPolicy struct {
+	PubKeys   []string // public keys in hex
+	Threshold int
+}
+
+// Signing policy of a table. nil if a TX signed by one key is enough. A table in the config can be with a database name
+func (c ChainConfig) GetTableMultiSig(table string) *MultiSigPolicy {
+	for key, policy := range c.MultiSig {
+		if utils.TableNameWithoutSchema(key) == table {
+			return &policy
+		}
+	}
+	return nil
+}
+
+// Number of keys of a policy which signed a TX. Each key is counted once
+func (p MultiSigPolicy) CountSignatures(signers [][]byte) int {
+	count := 0
+
+	for _, key := range p.PubKeys {
+		for _, signer := range signers {
+			if hex.EncodeToString(signer) == strings.ToLower(key) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// Check if a key is one of policy keys
+func (p MultiSigPolicy) HasKey(pubKey []byte) bool {
+	return p.CountSignatures([][]byte{pubKey}) > 0
+}
+
+// Price of SQL queries. A signer of a TX pays it to a minter of a block where the TX is added
+// Price of an update is a sum of a price of a query kind, a price of a table and a price of bytes
+type QueryPricing struct {
+	Kinds   map[string]float64 // price per query kind (insert, update, delete, create ...)
+	Tables  map[string]float64 // price per table. Key "*" is used for all tables not listed
+	PerByte float64            // price per byte of a query and its rollback query
+}
+
+// Check if queries have no price
+func (p QueryPricing) IsFree() bool {
+	return len(p.Kinds) == 0 && len(p.Tables) == 0 && p.PerByte == 0
+}
+
+// Price of one update of a TX. size is a length of a query plus a length of a rollback query
+func (p QueryPricing) GetUpdatePrice(kind string, table string, size int) float64 {
+	price := p.Kinds[kind] + p.PerByte*float64(size)
+
+	tp := p.Tables["*"]
+
+	for key, tablePrice := range p.Tables {
+		if key != "*" && utils.TableNameWithoutSchema(key) == table {
+			tp = tablePrice
+			break
+		}
+	}
+	return RoundAmount(price + tp)
+}
+
+// Round an amount to the smallest currency unit (8 digits). Prices must be same on all nodes
+func RoundAmount(amount float64) float64 {
+	return math.Round(amount*1e8) / 1e8
+}
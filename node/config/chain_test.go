@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+func TestCheckpointsSignature(t *testing.T) {
+	w := remoteclient.Wallet{}
+	w.MakeWallet()
+
+	cp := Checkpoint{Height: 10, Hash: "0000a1b2c3"}
+
+	signdata, err := cp.GetSignData()
+
+	if err != nil {
+		t.Fatalf("Sign data error: %s", err.Error())
+	}
+
+	signature, _ := utils.SignDataByPubKey(w.GetPublicKey(), w.GetPrivateKey(), signdata)
+	cp.Signature = hex.EncodeToString(signature)
+
+	finality := FinalityConfig{
+		CheckpointsKey: hex.EncodeToString(w.GetPublicKey()),
+		Checkpoints:    []Checkpoint{cp}}
+
+	err = finality.VerifyCheckpoints()
+
+	if err != nil {
+		t.Fatalf("Checkpoint must be valid. Error: %s", err.Error())
+	}
+
+	// a chain config with wrong checkpoint is not valid
+	chain := ChainConfig{Finality: finality}
+
+	if err = chain.Validate(); err != nil {
+		t.Fatalf("Chain config must be valid. Error: %s", err.Error())
+	}
+
+	// signature doesn't match other height
+	finality.Checkpoints[0].Height = 11
+
+	if err = finality.VerifyCheckpoints(); err == nil {
+		t.Fatalf("Checkpoint with changed height must not be valid")
+	}
+
+	chain.Finality = finality
+
+	if err = chain.Validate(); err == nil {
+		t.Fatalf("Chain config with wrong checkpoint must not be valid")
+	}
+
+	// no key
+	finality.Checkpoints[0].Height = 10
+	finality.CheckpointsKey = ""
+
+	if err = finality.VerifyCheckpoints(); err == nil {
+		t.Fatalf("Checkpoints must not be accepted without a key")
+	}
+}
+
+func TestTablesWithDatabase(t *testing.T) {
+	w := remoteclient.Wallet{}
+	w.MakeWallet()
+
+	policy := MultiSigPolicy{Threshold: 1, PubKeys: []string{hex.EncodeToString(w.GetPublicKey())}}
+
+	chain := ChainConfig{MultiSig: map[string]MultiSigPolicy{"mydb.Items": policy}}
+
+	if err := chain.Validate(); err != nil {
+		t.Fatalf("Chain config must be valid. Error: %s", err.Error())
+	}
+
+	// queries use a table without a database
+	if chain.GetTableMultiSig("Items") == nil {
+		t.Fatalf("Multisig policy must be found for a table listed with a database")
+	}
+
+	if chain.GetTableMultiSig("items") != nil {
+		t.Fatalf("Multisig policy must not be found for other table")
+	}
+
+	// same table can not have 2 policies
+	chain.MultiSig["Items"] = policy
+
+	if err := chain.Validate(); err == nil {
+		t.Fatalf("Chain config with a table listed twice must not be valid")
+	}
+}
@@ -35,8 +35,11 @@ type AllPossibleArgs struct {
 	MySQLPassword  string
 	MySQLDBName    string
 	DBTablesPrefix string
+	MaxRowsPerSQL  int
 	DumpFile       string
 	SQL            string
+	Height         int
+	GenesisConfig  string
 }
 
 // Input summary
@@ -52,6 +55,7 @@ type AppInput struct {
 	Args           AllPossibleArgs
 	Database       database.DatabaseConfig
 	DBProxyAddress string
+	Chain          ChainConfig
 }
 
 type AppConfig struct {
@@ -63,6 +67,7 @@ type AppConfig struct {
 	Logs           []string
 	Database       database.DatabaseConfig
 	DBProxyAddress string
+	Chain          ChainConfig
 }
 
 // Parses input and config file. Command line arguments ovverride config file options
@@ -108,9 +113,12 @@ func parseConfig(dirpath string) (AppInput, error) {
 		cmd.StringVar(&input.Args.MySQLPassword, "mysqlpass", "", "MySQL password")
 		cmd.StringVar(&input.Args.MySQLDBName, "mysqldb", "", "MySQL database")
 		cmd.StringVar(&input.Args.DBTablesPrefix, "tablesprefix", "", "MySQL blockchain tables prefix")
+		cmd.IntVar(&input.Args.MaxRowsPerSQL, "maxrowsperquery", 0, "Max number of rows one UPDATE or DELETE query can affect")
 		cmd.StringVar(&input.DBProxyAddress, "dbproxyaddr", "", "MySQL DB proxy address host:port")
 		cmd.StringVar(&input.Args.DumpFile, "dumpfile", "", "File where to dump DB")
 		cmd.StringVar(&input.Args.SQL, "sql", "", "SQL command to execute")
+		cmd.IntVar(&input.Args.Height, "height", 0, "Block height")
+		cmd.StringVar(&input.Args.GenesisConfig, "genesisconfig", "", "Genesis config file with chain parameters")
 
 		configdirPtr := cmd.String("configdir", "", "Location of config files")
 		err := cmd.Parse(os.Args[2:])
@@ -188,6 +196,23 @@ func parseConfig(dirpath string) (AppInput, error) {
 		}
 
 		input.Database = config.Database
+		input.Chain = config.Chain
+
+		// checkpoints can be added to a config later. a node must not start with wrong checkpoints
+		err = input.Chain.Finality.VerifyCheckpoints()
+
+		if err != nil {
+			return input, err
+		}
+	}
+
+	if input.Args.GenesisConfig != "" {
+		// chain parameters for a new blockchain. they are saved to the config after this
+		input.Chain, err = LoadGenesisConfig(input.Args.GenesisConfig)
+
+		if err != nil {
+			return input, err
+		}
 	}
 	input.completeDBConfig()
 
@@ -232,6 +257,9 @@ func (c *AppInput) completeDBConfig() {
 	if c.Database.TablesPrefix == "" && c.Args.DBTablesPrefix != "" {
 		c.Database.TablesPrefix = c.Args.DBTablesPrefix
 	}
+	if c.Database.MaxRowsPerQuery == 0 && c.Args.MaxRowsPerSQL > 0 {
+		c.Database.MaxRowsPerQuery = c.Args.MaxRowsPerSQL
+	}
 }
 
 // check if this commands really needs a config file
@@ -366,6 +394,13 @@ func (c AppInput) UpdateConfig() error {
 	if c.Args.DBTablesPrefix != "" {
 		config.Database.TablesPrefix = c.Args.DBTablesPrefix
 	}
+	if c.Args.MaxRowsPerSQL > 0 {
+		config.Database.MaxRowsPerQuery = c.Args.MaxRowsPerSQL
+	}
+
+	if c.Args.GenesisConfig != "" {
+		config.Chain = c.Chain
+	}
 
 	// convert back to JSON and save to config file
 	file, errf := os.OpenFile(configfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
@@ -395,19 +430,22 @@ func (c AppInput) PrintUsage() {
 	fmt.Println("  listaddresses\n\t- Lists all addresses from the wallet file")
 
 	fmt.Println("=[Blockchain init operations]")
-	fmt.Println("  initblockchain [-minter ADDRESS] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX]\n\t- Create a blockchain and send genesis block reward to ADDRESS")
-	fmt.Println("  importblockchain [-nodehost HOST] [-nodeport PORT] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX]\n\t- Loads a blockchain from other node to init the DB.")
+	fmt.Println("  initblockchain [-minter ADDRESS] [-genesisconfig FILEPATH] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX]\n\t- Create a blockchain and send genesis block reward to ADDRESS. Chain parameters (network ID, consensus engine, block limits, rewards, pricing, tables ACL) are taken from the genesis config file and saved to Chain of the config file. Without it Chain of the config file is used. With poa ADDRESS must be an authority")
+	fmt.Println("  importblockchain [-nodehost HOST] [-nodeport PORT] [-genesisconfig FILEPATH] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX]\n\t- Loads a blockchain from other node to init the DB. The genesis config must be same as the blockchain was created with")
 	fmt.Println("  restoreblockchain -dumpfile FILEPATH [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX]\n\t- Loads a blockchain from dump file and restores it to given DB. A DB credentials can be optional if they are present in config file")
 	fmt.Println("  dumpblockchain -dumpfile FILEPATH\n\t- Dump blockchain DB to a file. This fle can be used to restore a BC")
-	fmt.Println("  updateconfig [-minter ADDRESS] [-proxykey ADDRESS] [-host HOST] [-port PORT] [-nodehost HOST] [-nodeport PORT] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX] [-dbproxyaddr ADDR]\n\t- Update config file. Allows to set this node minter address, host and port and remote node host and port")
+	fmt.Println("  updateconfig [-minter ADDRESS] [-proxykey ADDRESS] [-host HOST] [-port PORT] [-nodehost HOST] [-nodeport PORT] [-mysqlhost HOST] [-mysqlport PORT] [-mysqluser USER] [-mysqlpass PASSWORD] [-mysqldb DBNAME] [-tablesprefix PREFIX] [-dbproxyaddr ADDR] [-maxrowsperquery NUM]\n\t- Update config file. Allows to set this node minter address, host and port and remote node host and port")
 
 	fmt.Println("=[Blockchain manage operations]")
 	fmt.Println("  printchain [-view short|long]\n\t- Print all the blocks of the blockchain. Default view is long")
 	fmt.Println("  makeblock [-minter ADDRESS]\n\t- Try to mine new block if there are enough transactions")
 	fmt.Println("  dropblock\n\t- Delete last block fro the block chain. All transaction are returned back to unapproved state")
+	fmt.Println("  signcheckpoint -from FROM -height HEIGHT\n\t- Sign a hash of a block at HEIGHT by FROM address. FROM must be Chain.Finality.CheckpointsKey. Prints a checkpoint for Chain.Finality.Checkpoints of the config file")
 
 	fmt.Println("=[SQL operations]")
 	fmt.Println("  sql -from FROM -sql SQLCOMMAND\n\t- Execute SQL query signed by FROM address")
+	fmt.Println("  signtransaction -transaction TRANSACTIONID -from FROM\n\t- Sign SQL transaction for a multisig table by FROM address. The query is executed when the transaction has enough signatures")
+	fmt.Println("  multisigtransactions\n\t- Print the list of SQL transactions waiting for more signatures")
 
 	fmt.Println("=[Currency transactions and control operations]")
 	fmt.Println("  reindexcache\n\t- Rebuilds the database of unspent transactions outputs and transaction pointers")
@@ -428,7 +466,7 @@ func (c AppInput) PrintUsage() {
 	fmt.Println("  stopnode\n\t- Stop runnning node")
 	fmt.Println("  nodestate\n\t- Print state of the node process")
 
-	fmt.Println("  shownodes\n\t- Display list of nodes addresses, including inactive")
+	fmt.Println("  shownodes\n\t- Display list of nodes addresses, including inactive, with penalty scores and banned nodes")
 	fmt.Println("  addnode -nodehost HOST -nodeport PORT\n\t- Adds new node to list of connections")
 	fmt.Println("  removenode -nodehost HOST -nodeport PORT\n\t- Removes a node from list of connections")
 }
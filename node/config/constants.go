@@ -1,11 +1,22 @@
 package config
 
 // ==========================================================
-// this can be altered to experiment with blockchain
+// Defaults of chain parameters. They are used when a genesis config (ChainConfig) doesn't set a value.
+// Do not change them for existent blockchain, set values in a genesis config of a new one
 
 // this defines how strong miming is needed. 16 is simple mining less 5 sec in simple desktop
 // 24 will need 30 seconds in average
+// TargetBits is a difficulty of first blocks. Later it is changed every RetargetInterval blocks
+// so blocks are made every TargetBlockTime seconds. It can be changed in a chain config
 const TargetBits = 16
+const RetargetInterval = 100
+const TargetBlockTime = 60 // seconds
+
+// Limits of a difficulty
+const MinTargetBits = 8
+const MaxTargetBits = 64
+
+// Difficulty of blocks made before a difficulty was recorded in a block. It was 16 before height 1000 and 24 after
 const TargetBits_2 = 24
 
 // Max and Min number of transactions per block
@@ -21,11 +32,13 @@ const MaxNumberTransactionInBlock = 10000
 
 // File names
 const PidFileName = "server.pid"
+const NodeIdentityFileName = "nodekey.pem" // private key of a node. node ID is made from it
+const NodeKeysFileName = "knownnodes.json" // IDs of other nodes pinned by address
 
 // other internal constant
 const Daemonprocesscommandline = "daemonnode"
 
 // ==========================================================
 // Testing mode constants
-// we need this for testing purposes. can be set to 0 on production system
+// we need this for testing purposes. can be set to -1 in Blocks of a genesis config on production system
 const MinimumBlockBuildingTime = 3 // seconds
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Genesis config is a JSON file with a chain config. It is given to initblockchain and importblockchain
+// and is saved to the node config after this. Same file must be used on all nodes of a network
+
+// Load a chain config from a genesis file
+func LoadGenesisConfig(filepath string) (ChainConfig, error) {
+	chain := ChainConfig{}
+
+	file, err := os.Open(filepath)
+
+	if err != nil {
+		return chain, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(&chain)
+
+	if err != nil {
+		return chain, errors.New(fmt.Sprintf("Genesis config parse error: %s", err.Error()))
+	}
+
+	err = chain.Validate()
+
+	if err != nil {
+		return chain, err
+	}
+	return chain, nil
+}
+
+// Check if a chain config is correct
+func (c ChainConfig) Validate() error {
+	switch c.Consensus.Kind {
+	case "", ConsensusProofOfWork:
+	case ConsensusProofOfAuthority:
+		if len(c.Consensus.Authorities) == 0 {
+			return errors.New("Proof of authority needs a list of authorities")
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unknown consensus engine %s", c.Consensus.Kind))
+	}
+
+	if c.Blocks.MaxTransactions > 0 && c.Blocks.MaxTransactions < c.Blocks.GetMaxMinTransactions() {
+		return errors.New("Max number of transactions in a block is less than min number")
+	}
+
+	multiSigTables := []string{}
+
+	for table, policy := range c.MultiSig {
+		multiSigTables = append(multiSigTables, table)
+
+		if policy.Threshold < 1 || policy.Threshold > len(policy.PubKeys) {
+			return errors.New(fmt.Sprintf("Wrong threshold of multisig policy of table %s", table))
+		}
+	}
+
+	aclTables := []string{}
+
+	for table, acl := range c.ACL {
+		aclTables = append(aclTables, table)
+
+		if acl.Owner == "" {
+			return errors.New(fmt.Sprintf("Table %s has no owner in ACL", table))
+		}
+	}
+
+	pricingTables := []string{}
+
+	for table := range c.Pricing.Tables {
+		pricingTables = append(pricingTables, table)
+	}
+
+	for kind, tables := range map[string][]string{"MultiSig": multiSigTables, "ACL": aclTables, "Pricing": pricingTables} {
+		if err := checkTablesListedOnce(kind, tables); err != nil {
+			return err
+		}
+	}
+	return c.Finality.VerifyCheckpoints()
+}
+
+// Tables are found by a name without a database, so same table must not be listed twice
+func checkTablesListedOnce(kind string, tables []string) error {
+	found := map[string]bool{}
+
+	for _, table := range tables {
+		name := utils.TableNameWithoutSchema(table)
+
+		if found[name] {
+			return errors.New(fmt.Sprintf("Table %s is listed twice in %s of a chain config", name, kind))
+		}
+		found[name] = true
+	}
+	return nil
+}
+
+// Hash of a chain config. JSON encoding of same config is same on any node, maps keys are sorted
+func (c ChainConfig) GetHash() []byte {
+	data, err := json.Marshal(c)
+
+	if err != nil {
+		return nil
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
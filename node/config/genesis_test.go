@@ -0,0 +1,84 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeGenesisFile(t *testing.T, data string) string {
+	file, err := ioutil.TempFile("", "genesis")
+
+	if err != nil {
+		t.Fatalf("Temp file error: %s", err.Error())
+	}
+	defer file.Close()
+
+	file.WriteString(data)
+
+	return file.Name()
+}
+
+func TestLoadGenesisConfig(t *testing.T) {
+	// same config with other formatting and order of keys
+	files := []string{
+		`{"NetworkID": "test", "BlockReward": 5, "Blocks": {"MaxTransactions": 2000},
+			"ACL": {"users": {"Owner": "addr1", "Permissions": {"addr2": ["INSERT"]}}, "items": {"Owner": "addr1"}}}`,
+		`{"ACL":{"items":{"Owner":"addr1"},"users":{"Permissions":{"addr2":["INSERT"]},"Owner":"addr1"}},
+			"Blocks":{"MaxTransactions":2000},"BlockReward":5,"NetworkID":"test"}`,
+	}
+
+	hashes := [][]byte{}
+
+	for _, data := range files {
+		filepath := writeGenesisFile(t, data)
+		defer os.Remove(filepath)
+
+		chain, err := LoadGenesisConfig(filepath)
+
+		if err != nil {
+			t.Fatalf("Genesis config load error: %s", err.Error())
+		}
+
+		if chain.GetBlockReward() != 5 || chain.Blocks.GetMaxTransactions() != 2000 {
+			t.Fatalf("Genesis config values are wrong")
+		}
+
+		if chain.Blocks.GetMaxMinTransactions() != MaxMinNumberTransactionInBlock {
+			t.Fatalf("Default value is expected for a limit not set in genesis config")
+		}
+		hashes = append(hashes, chain.GetHash())
+	}
+
+	if bytes.Compare(hashes[0], hashes[1]) != 0 {
+		t.Fatalf("Hash of same config must be same")
+	}
+
+	other := ChainConfig{NetworkID: "other"}
+
+	if bytes.Compare(hashes[0], other.GetHash()) == 0 {
+		t.Fatalf("Hash of other config must be different")
+	}
+}
+
+func TestGenesisConfigValidate(t *testing.T) {
+	wrong := []string{
+		`{"Consensus": {"Kind": "unknown"}}`,
+		`{"Consensus": {"Kind": "poa"}}`,
+		`{"MultiSig": {"users": {"PubKeys": ["aa"], "Threshold": 2}}}`,
+		`{"ACL": {"users": {"Permissions": {"addr2": ["INSERT"]}}}}`,
+		`{"UnknownOption": 1}`,
+	}
+
+	for _, data := range wrong {
+		filepath := writeGenesisFile(t, data)
+		defer os.Remove(filepath)
+
+		_, err := LoadGenesisConfig(filepath)
+
+		if err == nil {
+			t.Fatalf("Genesis config must not be accepted: %s", data)
+		}
+	}
+}
@@ -1,14 +1,19 @@
 package consensus
 
 import (
+	"bytes"
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
+	"github.com/gelembjuk/oursql/lib"
 	"github.com/gelembjuk/oursql/lib/utils"
 	"github.com/gelembjuk/oursql/node/blockchain"
 	"github.com/gelembjuk/oursql/node/config"
 	"github.com/gelembjuk/oursql/node/database"
+	"github.com/gelembjuk/oursql/node/dbquery"
 	"github.com/gelembjuk/oursql/node/structures"
 	"github.com/gelembjuk/oursql/node/transactions"
 )
@@ -18,6 +23,7 @@ type NodeBlockMaker struct {
 	Logger        *utils.LoggerMan
 	MinterAddress string // this is the wallet that will receive for mining
 	PreparedBlock *structures.Block
+	Chain         config.ChainConfig
 }
 
 func (n *NodeBlockMaker) SetDBManager(DB database.DBManager) {
@@ -30,6 +36,10 @@ func (n *NodeBlockMaker) SetMinterAddress(minter string) {
 	n.MinterAddress = minter
 }
 
+// Proof of work doesn't sign blocks
+func (n *NodeBlockMaker) SetMinterKeys(pubKey []byte, privKey ecdsa.PrivateKey) {
+}
+
 func (n *NodeBlockMaker) PrepareNewBlock() (int, error) {
 
 	if n.PreparedBlock != nil {
@@ -142,6 +152,12 @@ func (n *NodeBlockMaker) doPrepareNewBlock() error {
 			return errors.New("No enought valid transactions! Waiting for new ones...")
 		}
 
+		err = n.checkTransactionsPayments(txs)
+
+		if err != nil {
+			return err
+		}
+
 		n.Logger.Trace.Printf("Minting: All good. New block assigned to address %s\n", n.MinterAddress)
 
 		newBlock, err := n.makeNewBlockFromTransactions(txs)
@@ -173,7 +189,27 @@ func (n *NodeBlockMaker) CompleteBlock() (*structures.Block, error) {
 	// it inputs  are not yet stent before
 	// if there is no 2 transaction with same input in one block
 
-	n.Logger.Trace.Printf("Minting: Start proof of work for the block\n")
+	bits, err := n.getNextBlockBits(b.PrevBlockHash, b.Height)
+
+	if err != nil {
+		return nil, err
+	}
+	b.Bits = bits
+
+	if len(b.PrevBlockHash) > 0 {
+		// blocks can be made faster than a second. a time must grow
+		medianTime, err := n.getMedianTimePast(b.PrevBlockHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if b.Timestamp <= medianTime {
+			b.Timestamp = medianTime + 1
+		}
+	}
+
+	n.Logger.Trace.Printf("Minting: Start proof of work for the block with difficulty %d\n", b.Bits)
 
 	starttime := time.Now()
 
@@ -188,8 +224,8 @@ func (n *NodeBlockMaker) CompleteBlock() (*structures.Block, error) {
 	b.Hash = hash[:]
 	b.Nonce = nonce
 
-	if config.MinimumBlockBuildingTime > 0 {
-		for t := time.Since(starttime).Seconds(); t < float64(config.MinimumBlockBuildingTime); t = time.Since(starttime).Seconds() {
+	if minTime := n.Chain.Blocks.GetMinBuildingTime(); minTime > 0 {
+		for t := time.Since(starttime).Seconds(); t < float64(minTime); t = time.Since(starttime).Seconds() {
 			time.Sleep(1 * time.Second)
 			n.Logger.Trace.Printf("Sleep")
 		}
@@ -210,8 +246,15 @@ func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []structures.
 		return nil, err
 	}
 
+	// payments for SQL queries go to a minter
+	payments, err := n.getMinterPayments(transactions, lastHash)
+
+	if err != nil {
+		return nil, err
+	}
+
 	// add transaction - prize for miner
-	cbTx, errc := structures.NewCoinbaseTransaction(n.MinterAddress, "")
+	cbTx, errc := structures.NewCoinbaseTransaction(n.MinterAddress, "", n.Chain.GetBlockReward()+payments)
 
 	if errc != nil {
 		return nil, errc
@@ -246,13 +289,37 @@ func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []structures.
 //   (output must be before input in same block)
 // 4. all inputs must be in blockchain (correct unspent inputs)
 // 5. Additionally verify each transaction agains signatures, total amount, balance etc
-// 6. Verify hash is correc agains rules
+// 6. Verify hash is correc agains rules. A block is made with expected difficulty
+// 7. SQL transactions pay a price of queries. coinbase gets a reward and all payments
+// 8. SQL transactions updating multisig tables have enough signatures
+// 9. A block doesn't replace final blocks. See finality.go
+// 10. A block time is after a median time of previous blocks and is not far in the future. See difficulty.go
 func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
+	//9.
+	err := n.checkFinality(block)
+
+	if err != nil {
+		return err
+	}
+
+	//10.
+	err = n.verifyBlockTime(block)
+
+	if err != nil {
+		return err
+	}
+
 	//6. Verify hash
 
+	bits, err := n.getExpectedBits(block)
+
+	if err != nil {
+		return err
+	}
+
 	pow := NewProofOfWork(block)
 
-	valid, err := pow.Validate()
+	valid, err := pow.Validate(bits)
 
 	if err != nil {
 		return err
@@ -262,6 +329,12 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 		return errors.New("Block hash is not valid")
 	}
 	n.Logger.Trace.Println("block hash verified")
+
+	return n.verifyBlockTransactions(block)
+}
+
+// Verify transactions of a block. Rules 1-5, 7, 8 of VerifyBlock. They are same for all consensus engines
+func (n *NodeBlockMaker) verifyBlockTransactions(block *structures.Block) error {
 	// 2. check number of TX
 	txnum := len(block.Transactions) - 1 /*minus coinbase TX*/
 
@@ -281,15 +354,31 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 
 	// 1
 	coinbaseused := false
+	var coinbaseTX *structures.Transaction
+	payments := float64(0)
 
 	prevTXs := []structures.Transaction{}
 
-	for _, tx := range block.Transactions {
+	// permissions as of the parent block. keeps permissions changes of previous TXs of the block
+	permissionsChecker, err := n.getPermissionsCheckerAt(block.PrevBlockHash)
+
+	if err != nil {
+		return err
+	}
+
+	multiSig, err := NewMultiSigManager(n.Chain, n.DB, n.Logger)
+
+	if err != nil {
+		return err
+	}
+
+	for i, tx := range block.Transactions {
 		if tx.IsCoinbaseTransfer() {
 			if coinbaseused {
 				return errors.New("2 coin base TX in the block")
 			}
 			coinbaseused = true
+			coinbaseTX = &block.Transactions[i]
 		}
 		vtx, err := n.getTransactionsManager().VerifyTransaction(&tx, prevTXs, block.PrevBlockHash)
 
@@ -300,6 +389,27 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 		if !vtx {
 			return errors.New(fmt.Sprintf("Transaction in a block is not valid: %x", tx.GetID()))
 		}
+
+		err = n.verifyTransactionPermissions(&tx, permissionsChecker)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Transaction %x in a block is not allowed: %s", tx.GetID(), err.Error()))
+		}
+
+		// 7.
+		payment, err := n.verifyTransactionPayment(&tx, prevTXs, block.PrevBlockHash)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Transaction %x in a block is not paid: %s", tx.GetID(), err.Error()))
+		}
+		payments += payment
+
+		// 8.
+		err = multiSig.CheckTransactionSigned(&tx)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Transaction %x in a block is not signed: %s", tx.GetID(), err.Error()))
+		}
 		n.Logger.Trace.Printf("checked %x . add it to previous list", tx.GetID())
 		prevTXs = append(prevTXs, tx)
 	}
@@ -307,9 +417,183 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 	if !coinbaseused {
 		return errors.New("No coinbase TX in the block")
 	}
+	// 7.
+	if math.Abs(coinbaseTX.Vout[0].Value-n.Chain.GetBlockReward()-payments) >= lib.CurrencySmallestUnit {
+		return errors.New(fmt.Sprintf("Value of coinbase transaction is wrong. Payments for queries are %.8f", payments))
+	}
 	return nil
 }
 
+// Check if SQL TX pays enough for its queries. Returns amount paid to a minter
+func (n *NodeBlockMaker) verifyTransactionPayment(tx *structures.Transaction, prevTXs []structures.Transaction, tip []byte) (float64, error) {
+	if !tx.IsSQLCommand() {
+		return 0, nil
+	}
+
+	payment, err := n.getTransactionsManager().GetMinterPayment(tx, prevTXs, tip)
+
+	if err != nil {
+		return 0, err
+	}
+
+	price, err := getTransactionPrice(n.Chain.Pricing, tx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if price-payment >= lib.CurrencySmallestUnit {
+		return 0, errors.New(fmt.Sprintf("Price of queries is %.8f but paid %.8f", price, payment))
+	}
+	return payment, nil
+}
+
+// Total amount paid to a minter by TXs of a new block
+func (n *NodeBlockMaker) getMinterPayments(txs []structures.Transaction, tip []byte) (float64, error) {
+	payments := float64(0)
+
+	for i, tx := range txs {
+		payment, err := n.getTransactionsManager().GetMinterPayment(&tx, txs[:i], tip)
+
+		if err != nil {
+			return 0, err
+		}
+		payments += payment
+	}
+	return config.RoundAmount(payments), nil
+}
+
+// Remove TXs which don't pay enough for queries from the pool. They can be received from nodes
+// with other pricing. A block with such TX would be rejected by other nodes
+func (n *NodeBlockMaker) checkTransactionsPayments(txs []structures.Transaction) error {
+	lastHash, _, err := n.getBlockchainManager().GetState()
+
+	if err != nil {
+		return err
+	}
+
+	for i, tx := range txs {
+		_, err := n.verifyTransactionPayment(&tx, txs[:i], lastHash)
+
+		if err == nil {
+			continue
+		}
+		n.Logger.Trace.Printf("Minting: Cancel TX %x. %s", tx.GetID(), err.Error())
+
+		cerr := n.getTransactionsManager().CancelTransaction(tx.GetID())
+
+		if cerr != nil {
+			return cerr
+		}
+		return errors.New(fmt.Sprintf("Transaction %x doesn't pay for queries. It was removed from the pool", tx.GetID()))
+	}
+	return nil
+}
+
+// Check if a signer of SQL TX has permissions for its queries. TXs from the pool are checked too,
+// permissions could be changed by other TXs of the block or on other branch
+func (n *NodeBlockMaker) verifyTransactionPermissions(tx *structures.Transaction, checker dbquery.PermissionsCheckerInterface) error {
+	if !tx.IsSQLCommand() {
+		return nil
+	}
+	return checker.CheckTransaction(tx)
+}
+
+// Permissions checker with permissions of tables as of a block. A DB has permissions of the top of the primary chain
+// and of TXs from the pool. Changes of the pool and of primary chain blocks above a fork are rolled back,
+// changes of blocks of a side branch are applied
+func (n *NodeBlockMaker) getPermissionsCheckerAt(blockHash []byte) (dbquery.PermissionsCheckerInterface, error) {
+	checker := dbquery.NewPermissionsChecker(n.DB, n.Logger)
+
+	pool, err := n.getTransactionsManager().GetUnapprovedTransactions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(pool) - 1; i >= 0; i-- {
+		if !pool[i].IsSQLCommand() {
+			continue
+		}
+		err = checker.RollbackTransaction(pool[i])
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bcm := n.getBlockchainManager()
+
+	topHash, _, err := bcm.GetState()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blockHash) == 0 || bytes.Compare(topHash, blockHash) == 0 {
+		return checker, nil
+	}
+
+	topBlock, err := bcm.GetBlock(topHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sideBlock, err := bcm.GetBlock(blockHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// go down on both branches till a common block
+	mainBlocks := []structures.Block{}
+	sideBlocks := []structures.Block{}
+
+	for bytes.Compare(topBlock.Hash, sideBlock.Hash) != 0 {
+		if topBlock.Height >= sideBlock.Height {
+			mainBlocks = append(mainBlocks, topBlock)
+
+			topBlock, err = bcm.GetBlock(topBlock.PrevBlockHash)
+		} else {
+			sideBlocks = append(sideBlocks, sideBlock)
+
+			sideBlock, err = bcm.GetBlock(sideBlock.PrevBlockHash)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, block := range mainBlocks {
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			if !block.Transactions[i].IsSQLCommand() {
+				continue
+			}
+			err = checker.RollbackTransaction(&block.Transactions[i])
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i := len(sideBlocks) - 1; i >= 0; i-- {
+		for _, tx := range sideBlocks[i].Transactions {
+			if !tx.IsSQLCommand() {
+				continue
+			}
+			err = checker.ApplyTransaction(&tx)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return checker, nil
+}
+
 //Get minimum and maximum number of transaction allowed in block for current chain
 func (n *NodeBlockMaker) getTransactionNumbersLimits(block *structures.Block) (int, int, error) {
 	var min int
@@ -327,11 +611,13 @@ func (n *NodeBlockMaker) getTransactionNumbersLimits(block *structures.Block) (i
 		min = block.Height
 	}
 
-	if min > config.MaxMinNumberTransactionInBlock {
-		min = config.MaxMinNumberTransactionInBlock
+	if min > n.Chain.Blocks.GetMaxMinTransactions() {
+		min = n.Chain.Blocks.GetMaxMinTransactions()
 	} else if min < 1 {
 		min = 1
 	}
-	n.Logger.Trace.Printf("TX count limits %d - %d", min, config.MaxNumberTransactionInBlock)
-	return min, config.MaxNumberTransactionInBlock, nil
+	max := n.Chain.Blocks.GetMaxTransactions()
+
+	n.Logger.Trace.Printf("TX count limits %d - %d", min, max)
+	return min, max, nil
 }
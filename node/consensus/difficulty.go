@@ -0,0 +1,189 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Difficulty of proof of work. First blocks are made with InitialBits. Every RetargetInterval blocks
+// a difficulty is changed by time spent on previous blocks. If blocks were made 2 times faster than BlockTime
+// one bit is added, if 2 times slower one bit is removed, and so on. It is changed by 2 bits at most at once
+// NOTE only integers are used, so all nodes get same result
+
+const maxRetargetStep = 2
+
+// Difficulty depends on times of blocks, so a miner can not set any time. A block time must be after
+// a median time of medianTimeBlocks previous blocks and not more than powMaxClockDrift seconds in the future
+const (
+	medianTimeBlocks = 11
+	powMaxClockDrift = 60
+)
+
+// Returns a block by a hash. Blocks are from a DB or from headers loaded from other node
+type blockGetter func(hash []byte) (structures.Block, error)
+
+// Difficulty a block at a height must be made with. It depends on previous blocks of a branch
+func (n *NodeBlockMaker) getNextBlockBits(prevHash []byte, height int) (int, error) {
+	return n.getNextBlockBitsFrom(n.getBlockchainManager().GetBlock, prevHash, height)
+}
+
+// Same as getNextBlockBits. Previous blocks are returned by getBlock
+func (n *NodeBlockMaker) getNextBlockBitsFrom(getBlock blockGetter, prevHash []byte, height int) (int, error) {
+	difficulty := n.Chain.Difficulty
+
+	if height == 0 {
+		return difficulty.GetInitialBits(), nil
+	}
+
+	prevBlock, err := getBlock(prevHash)
+
+	if err != nil {
+		return 0, err
+	}
+
+	interval := difficulty.GetRetargetInterval()
+
+	if height%interval != 0 {
+		return getTargetBits(&prevBlock), nil
+	}
+
+	// find first block of the interval
+	firstBlock := prevBlock
+
+	for i := 1; i < interval && firstBlock.Height > 0; i++ {
+		firstBlock, err = getBlock(firstBlock.PrevBlockHash)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	actualSpan := prevBlock.Timestamp - firstBlock.Timestamp
+	expectedSpan := int64(prevBlock.Height-firstBlock.Height) * difficulty.GetBlockTime()
+
+	bits := retargetBits(getTargetBits(&prevBlock), actualSpan, expectedSpan)
+
+	n.Logger.Trace.Printf("Difficulty retarget at height %d. Blocks took %d sec, expected %d sec. Bits %d", height, actualSpan, expectedSpan, bits)
+
+	return bits, nil
+}
+
+// Difficulty expected for a block. Blocks made before difficulty was recorded in a block are accepted only
+// on top of same old blocks. 0 is returned for them
+func (n *NodeBlockMaker) getExpectedBits(block *structures.Block) (int, error) {
+	if block.Bits == 0 && block.Height > 0 {
+		prevBlock, err := n.getBlockchainManager().GetBlock(block.PrevBlockHash)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if prevBlock.Bits == 0 {
+			return 0, nil
+		}
+	}
+	return n.getNextBlockBits(block.PrevBlockHash, block.Height)
+}
+
+// New difficulty after an interval of blocks
+func retargetBits(bits int, actualSpan int64, expectedSpan int64) int {
+	if actualSpan < 1 {
+		actualSpan = 1
+	}
+
+	for step := 0; step < maxRetargetStep && actualSpan*2 <= expectedSpan; step++ {
+		// blocks are made too fast
+		bits++
+		actualSpan *= 2
+	}
+
+	for step := 0; step < maxRetargetStep && expectedSpan*2 <= actualSpan; step++ {
+		// blocks are made too slow
+		bits--
+		expectedSpan *= 2
+	}
+
+	if bits < config.MinTargetBits {
+		bits = config.MinTargetBits
+	}
+
+	if bits > config.MaxTargetBits {
+		bits = config.MaxTargetBits
+	}
+	return bits
+}
+
+// Median time of previous blocks of a branch ending with a block. A time of next block must be after it
+func (n *NodeBlockMaker) getMedianTimePast(prevHash []byte) (int64, error) {
+	return getMedianTimePastFrom(n.getBlockchainManager().GetBlock, prevHash)
+}
+
+// Same as getMedianTimePast. Previous blocks are returned by getBlock
+func getMedianTimePastFrom(getBlock blockGetter, prevHash []byte) (int64, error) {
+	timestamps := []int64{}
+
+	hash := prevHash
+
+	for len(timestamps) < medianTimeBlocks && len(hash) > 0 {
+		block, err := getBlock(hash)
+
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, block.Timestamp)
+
+		hash = block.PrevBlockHash
+	}
+
+	return getMedianTime(timestamps), nil
+}
+
+// Median of times. 0 if there are no times
+func getMedianTime(timestamps []int64) int64 {
+	if len(timestamps) == 0 {
+		return 0
+	}
+
+	sorted := append([]int64{}, timestamps...)
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return sorted[len(sorted)/2]
+}
+
+// Check time of a block against a median time of previous blocks and a current time
+func checkBlockTime(timestamp int64, medianTime int64, now int64) error {
+	if timestamp > now+powMaxClockDrift {
+		return errors.New("Block time is in the future")
+	}
+
+	if timestamp <= medianTime {
+		return errors.New(fmt.Sprintf("Block time %d is not after median time %d of previous blocks", timestamp, medianTime))
+	}
+	return nil
+}
+
+// Verify time of a new block. Genesis block has no previous blocks. Blocks made before a difficulty
+// was recorded in a block are checked only to be not in the future, their times don't change a difficulty
+func (n *NodeBlockMaker) verifyBlockTime(block *structures.Block) error {
+	if len(block.PrevBlockHash) == 0 {
+		return nil
+	}
+
+	if block.Bits == 0 {
+		return checkBlockTime(block.Timestamp, 0, time.Now().Unix())
+	}
+
+	medianTime, err := n.getMedianTimePast(block.PrevBlockHash)
+
+	if err != nil {
+		return err
+	}
+
+	return checkBlockTime(block.Timestamp, medianTime, time.Now().Unix())
+}
@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/gelembjuk/oursql/node/config"
+)
+
+func TestRetargetBits(t *testing.T) {
+	tests := []struct {
+		bits     int
+		actual   int64
+		expected int64
+		result   int
+	}{
+		{16, 600, 600, 16},
+		{16, 400, 600, 16},  // less than 2 times faster
+		{16, 300, 600, 17},  // 2 times faster
+		{16, 100, 600, 18},  // 6 times faster
+		{16, 1, 600, 18},    // not more than 2 bits at once
+		{16, 0, 600, 18},    // same time for all blocks
+		{16, 1200, 600, 15}, // 2 times slower
+		{16, 9000, 600, 14},
+		{config.MinTargetBits, 9000, 600, config.MinTargetBits},
+		{config.MaxTargetBits, 1, 600, config.MaxTargetBits},
+	}
+
+	for _, test := range tests {
+		if r := retargetBits(test.bits, test.actual, test.expected); r != test.result {
+			t.Fatalf("Bits %d, time %d of %d: expected %d, got %d", test.bits, test.actual, test.expected, test.result, r)
+		}
+	}
+}
+
+func TestMedianTime(t *testing.T) {
+	if m := getMedianTime([]int64{}); m != 0 {
+		t.Fatalf("Expected 0 for no blocks, got %d", m)
+	}
+
+	if m := getMedianTime([]int64{50, 10, 40, 20, 30}); m != 30 {
+		t.Fatalf("Expected median 30, got %d", m)
+	}
+
+	if m := getMedianTime([]int64{10, 10, 10}); m != 10 {
+		t.Fatalf("Expected median 10, got %d", m)
+	}
+}
+
+func TestCheckBlockTime(t *testing.T) {
+	now := int64(100000)
+
+	tests := []struct {
+		timestamp  int64
+		medianTime int64
+		valid      bool
+	}{
+		{now, now - 100, true},
+		{now - 50, now - 100, true},
+		{now + powMaxClockDrift, now - 100, true},
+		{now + powMaxClockDrift + 1, now - 100, false}, // too far in the future
+		{now - 100, now - 100, false},                  // same as median
+		{now - 200, now - 100, false},                  // moved back
+	}
+
+	for _, test := range tests {
+		err := checkBlockTime(test.timestamp, test.medianTime, now)
+
+		if test.valid && err != nil {
+			t.Fatalf("Time %d must be valid. Error: %s", test.timestamp, err.Error())
+		}
+
+		if !test.valid && err == nil {
+			t.Fatalf("Time %d must not be valid with median %d", test.timestamp, test.medianTime)
+		}
+	}
+}
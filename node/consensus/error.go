@@ -0,0 +1,74 @@
+package consensus
+
+// Custom errors
+
+import (
+	"fmt"
+
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Error codes returned to a MySQL client by a proxy
+const (
+	ProxyErrorCodeNotEnoughFunds   = 5
+	ProxyErrorCodeMultiSigRequired = 6
+)
+
+type NotEnoughFundsError struct {
+	Price   float64
+	Balance float64
+}
+
+func (e *NotEnoughFundsError) Error() string {
+	return fmt.Sprintf("Error(%d): Not enough funds to pay for the query. Price is %.8f, balance is %.8f",
+		ProxyErrorCodeNotEnoughFunds, e.Price, e.Balance)
+}
+
+func NewNotEnoughFundsError(price float64, balance float64) error {
+	return &NotEnoughFundsError{price, balance}
+}
+
+// SQL TX updates a multisig table and needs signatures of more keys. The TX is kept until it gets them
+type MultiSigPendingError struct {
+	TX      *structures.Transaction
+	Missing int
+}
+
+func (e *MultiSigPendingError) Error() string {
+	return fmt.Sprintf("Error(%d): Transaction %x needs %d more signatures",
+		ProxyErrorCodeMultiSigRequired, e.TX.GetID(), e.Missing)
+}
+
+func NewMultiSigPendingError(tx *structures.Transaction, missing int) error {
+	return &MultiSigPendingError{tx, missing}
+}
+
+// Block is on a branch which forks below final blocks. Such branch can not become primary
+type ReorgTooDeepError struct {
+	Hash        []byte
+	ForkHeight  int
+	FinalHeight int
+}
+
+func (e *ReorgTooDeepError) Error() string {
+	return fmt.Sprintf("Block %x is on a branch which forks at height %d. Blocks up to height %d are final",
+		e.Hash, e.ForkHeight, e.FinalHeight)
+}
+
+func NewReorgTooDeepError(hash []byte, forkHeight int, finalHeight int) error {
+	return &ReorgTooDeepError{hash, forkHeight, finalHeight}
+}
+
+// Block from other node is not valid. Err is a reason
+type BlockVerifyError struct {
+	Hash []byte
+	Err  error
+}
+
+func (e *BlockVerifyError) Error() string {
+	return e.Err.Error()
+}
+
+func NewBlockVerifyError(hash []byte, err error) error {
+	return &BlockVerifyError{hash, err}
+}
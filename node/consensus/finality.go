@@ -0,0 +1,125 @@
+package consensus
+
+import (
+	"encoding/hex"
+
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Finality. A block deeper than Finality.Depth under a top of the primary chain is final. A block from a checkpoint
+// is final if it is in the primary chain. A block of a branch which forks below a final block is refused,
+// because switching to that branch would rollback SQL updates of final blocks.
+// Checkpoints are signed by Finality.CheckpointsKey, so they can be shipped with a config. Signatures are
+// verified when a config is loaded, here only heights and hashes are compared
+
+// Check that a block doesn't conflict with final blocks. Returns ReorgTooDeepError if it does
+func (n *NodeBlockMaker) checkFinality(block *structures.Block) error {
+	checkpoints := n.Chain.Finality.Checkpoints
+
+	for _, cp := range checkpoints {
+		if cp.Height == block.Height && cp.Hash != hex.EncodeToString(block.Hash) {
+			n.Logger.Error.Printf("Block %x conflicts with checkpoint at height %d", block.Hash, cp.Height)
+			return NewReorgTooDeepError(block.Hash, block.Height-1, cp.Height)
+		}
+	}
+
+	finalHeight, err := n.getFinalHeight(checkpoints)
+
+	if err != nil {
+		return err
+	}
+
+	if finalHeight <= 0 || block.Height == 0 {
+		return nil
+	}
+
+	forkHeight, err := n.getForkHeight(block.PrevBlockHash, finalHeight)
+
+	if err != nil {
+		return err
+	}
+
+	if forkHeight < finalHeight {
+		n.Logger.Error.Printf("Refused block %x. It forks at height %d, blocks up to height %d are final",
+			block.Hash, forkHeight, finalHeight)
+		return NewReorgTooDeepError(block.Hash, forkHeight, finalHeight)
+	}
+	return nil
+}
+
+// Height of the highest final block of the primary chain. 0 if only a genesis block is final
+func (n *NodeBlockMaker) getFinalHeight(checkpoints []config.Checkpoint) (int, error) {
+	_, topHeight, err := n.getBlockchainManager().GetState()
+
+	if err != nil {
+		return 0, err
+	}
+
+	finalHeight := 0
+
+	if n.Chain.Finality.Depth > 0 && topHeight > n.Chain.Finality.Depth {
+		finalHeight = topHeight - n.Chain.Finality.Depth
+	}
+
+	bcdb, err := n.DB.GetBlockchainObject()
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, cp := range checkpoints {
+		if cp.Height <= finalHeight || cp.Height > topHeight {
+			continue
+		}
+
+		hash, err := hex.DecodeString(cp.Hash)
+
+		if err != nil {
+			return 0, err
+		}
+
+		// if the primary chain doesn't have a checkpoint block, it must be possible to switch to a branch with it
+		inChain, err := bcdb.BlockInChain(hash)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if inChain {
+			finalHeight = cp.Height
+		}
+	}
+	return finalHeight, nil
+}
+
+// Height where a branch ending with a block joins the primary chain. We go down while a block is not in the chain,
+// but not lower than finalHeight, there is no sense to go deeper
+func (n *NodeBlockMaker) getForkHeight(hash []byte, finalHeight int) (int, error) {
+	bcdb, err := n.DB.GetBlockchainObject()
+
+	if err != nil {
+		return 0, err
+	}
+
+	bcm := n.getBlockchainManager()
+
+	for {
+		block, err := bcm.GetBlock(hash)
+
+		if err != nil {
+			return 0, err
+		}
+
+		inChain, err := bcdb.BlockInChain(block.Hash)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if inChain || block.Height < finalHeight || len(block.PrevBlockHash) == 0 {
+			return block.Height, nil
+		}
+		hash = block.PrevBlockHash
+	}
+}
@@ -0,0 +1,175 @@
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Check of headers loaded by headers first synchronisation. A header has a proof of a block, so a node
+// can't make us load blocks of a long chain of fake headers. Proof of work is checked with same difficulty
+// and time rules as for full blocks, proof of authority is checked by a signature of an authority in turn.
+// Previous blocks of a header are checked headers or blocks from a DB
+
+type headersChecker struct {
+	maker          *NodeBlockMaker
+	authority      *AuthorityBlockMaker        // nil for proof of work
+	headers        map[string]structures.Block // checked headers as blocks without transactions
+	last           *structures.Block
+	getDBBlock     blockGetter
+	getDBChainWork func(hash []byte) (*big.Int, error)
+}
+
+func (n *NodeBlockMaker) NewHeadersChecker() HeadersCheckerInterface {
+	return n.newHeadersChecker(nil)
+}
+
+func (n *AuthorityBlockMaker) NewHeadersChecker() HeadersCheckerInterface {
+	return n.NodeBlockMaker.newHeadersChecker(n)
+}
+
+func (n *NodeBlockMaker) newHeadersChecker(authority *AuthorityBlockMaker) *headersChecker {
+	bcm := n.getBlockchainManager()
+
+	return &headersChecker{n, authority, map[string]structures.Block{}, nil, bcm.GetBlock, bcm.GetChainWork}
+}
+
+// Previous block of a header. It is a checked header or a block from a DB
+func (c *headersChecker) getBlock(hash []byte) (structures.Block, error) {
+	if block, ok := c.headers[string(hash)]; ok {
+		return block, nil
+	}
+	return c.getDBBlock(hash)
+}
+
+// Check a proof of a header. The header is added to the chain, next headers can follow it
+func (c *headersChecker) CheckHeader(bs *structures.BlockShort) error {
+	prevBlock, err := c.getBlock(bs.PrevBlockHash)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Previous block of header %x is not found: %s", bs.Hash, err.Error()))
+	}
+
+	if bs.Height != prevBlock.Height+1 {
+		return errors.New(fmt.Sprintf("Header %x has wrong height %d", bs.Hash, bs.Height))
+	}
+
+	block := structures.Block{}
+	block.PrevBlockHash = bs.PrevBlockHash
+	block.Hash = bs.Hash
+	block.Height = bs.Height
+	block.Timestamp = bs.Timestamp
+	block.Nonce = bs.Nonce
+	block.Bits = bs.Bits
+	block.Signer = bs.Signer
+	block.Signature = bs.Signature
+
+	work := big.NewInt(1)
+
+	if c.authority != nil {
+		err = c.checkAuthorityHeader(&block, bs.TXsHash, &prevBlock)
+	} else {
+		err = c.checkWorkHeader(&block, bs.TXsHash, &prevBlock)
+		work.Lsh(work, uint(getTargetBits(&block)))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// work of a chain ending with the header
+	prevWork := new(big.Int).SetBytes(prevBlock.ChainWork)
+
+	if _, ok := c.headers[string(prevBlock.Hash)]; !ok {
+		prevWork, err = c.getDBChainWork(prevBlock.Hash)
+
+		if err != nil {
+			return err
+		}
+	}
+	block.ChainWork = work.Add(work, prevWork).Bytes()
+
+	c.headers[string(block.Hash)] = block
+	c.last = &block
+
+	return nil
+}
+
+// Cumulative work of a chain ending with last checked header. nil if nothing was checked
+func (c *headersChecker) GetChainWork() *big.Int {
+	if c.last == nil {
+		return nil
+	}
+	return new(big.Int).SetBytes(c.last.ChainWork)
+}
+
+// Proof of work of a header. Difficulty and time of a header are checked like for a full block
+func (c *headersChecker) checkWorkHeader(block *structures.Block, txsHash []byte, prevBlock *structures.Block) error {
+	bits := 0
+
+	if block.Bits > 0 || prevBlock.Bits > 0 {
+		var err error
+		bits, err = c.maker.getNextBlockBitsFrom(c.getBlock, block.PrevBlockHash, block.Height)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	pow := NewProofOfWork(block)
+	pow.txsHash = txsHash
+
+	valid, err := pow.Validate(bits)
+
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return errors.New(fmt.Sprintf("Proof of work of header %x is not valid", block.Hash))
+	}
+
+	medianTime := int64(0)
+
+	if block.Bits > 0 {
+		medianTime, err = getMedianTimePastFrom(c.getBlock, block.PrevBlockHash)
+
+		if err != nil {
+			return err
+		}
+	}
+	return checkBlockTime(block.Timestamp, medianTime, time.Now().Unix())
+}
+
+// Header must be signed by an authority in turn
+func (c *headersChecker) checkAuthorityHeader(block *structures.Block, txsHash []byte, prevBlock *structures.Block) error {
+	if block.Timestamp > time.Now().Unix()+poaMaxClockDrift {
+		return errors.New("Block time is in the future")
+	}
+
+	if !bytes.Equal(getAuthorityHeaderHash(block, txsHash), block.Hash) {
+		return errors.New(fmt.Sprintf("Hash of header %x is not valid", block.Hash))
+	}
+
+	v, err := utils.VerifySignature(block.Signature, block.Hash, block.Signer)
+
+	if err != nil {
+		return err
+	}
+
+	if !v {
+		return structures.NewSignatureError("block", block.Hash)
+	}
+
+	index := c.authority.Chain.Consensus.GetAuthorityIndex(block.Signer)
+
+	if index < 0 {
+		return errors.New(fmt.Sprintf("Key %x is not an authority of the chain", block.Signer))
+	}
+	return c.authority.checkTurnAfter(block, index, prevBlock)
+}
@@ -0,0 +1,149 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Checker with blocks of a DB in memory. The DB has only a genesis block with work 1
+func newTestHeadersChecker(n *NodeBlockMaker, authority *AuthorityBlockMaker, genesis structures.Block) *headersChecker {
+	getBlock := func(hash []byte) (structures.Block, error) {
+		if string(hash) == string(genesis.Hash) {
+			return genesis, nil
+		}
+		return structures.Block{}, errors.New("Block is not found")
+	}
+	getChainWork := func(hash []byte) (*big.Int, error) {
+		return big.NewInt(1), nil
+	}
+	return &headersChecker{n, authority, map[string]structures.Block{}, nil, getBlock, getChainWork}
+}
+
+// Header with a proof of work
+func makeWorkHeader(prev *structures.BlockShort, timestamp int64, bits int) *structures.BlockShort {
+	block := structures.Block{PrevBlockHash: prev.Hash, Height: prev.Height + 1, Timestamp: timestamp, Bits: bits}
+
+	pow := NewProofOfWork(&block)
+	pow.txsHash = []byte("transactions")
+
+	block.Nonce, block.Hash, _ = pow.Run()
+
+	bs := block.GetShortCopy()
+	bs.Timestamp = block.Timestamp
+	bs.Nonce = block.Nonce
+	bs.Bits = block.Bits
+	bs.TXsHash = pow.txsHash
+
+	return bs
+}
+
+func TestWorkHeaders(t *testing.T) {
+	n := &NodeBlockMaker{Logger: utils.CreateLogger()}
+	n.Chain.Difficulty = config.DifficultyConfig{InitialBits: config.MinTargetBits}
+
+	genesis := structures.Block{Hash: []byte("genesis"), PrevBlockHash: []byte{}, Timestamp: 1000, Bits: config.MinTargetBits}
+
+	checker := newTestHeadersChecker(n, nil, genesis)
+
+	prev := genesis.GetShortCopy()
+
+	for i := 1; i <= 3; i++ {
+		bs := makeWorkHeader(prev, 1000+int64(i)*10, config.MinTargetBits)
+
+		if err := checker.CheckHeader(bs); err != nil {
+			t.Fatalf("Header %d must be valid: %s", i, err.Error())
+		}
+		prev = bs
+	}
+
+	expected := big.NewInt(1 + 3<<config.MinTargetBits)
+
+	if checker.GetChainWork().Cmp(expected) != 0 {
+		t.Fatalf("Expected work %s, got %s", expected.String(), checker.GetChainWork().String())
+	}
+
+	// a hash without work
+	bs := makeWorkHeader(prev, 1100, config.MinTargetBits)
+	bs.Nonce++
+
+	if err := checker.CheckHeader(bs); err == nil {
+		t.Fatalf("Header with wrong nonce must not be valid")
+	}
+
+	// a proof with other difficulty
+	bs = makeWorkHeader(prev, 1100, config.MinTargetBits+1)
+
+	if err := checker.CheckHeader(bs); err == nil {
+		t.Fatalf("Header with wrong difficulty must not be valid")
+	}
+
+	// a time before a median time of previous blocks
+	bs = makeWorkHeader(prev, 1010, config.MinTargetBits)
+
+	if err := checker.CheckHeader(bs); err == nil {
+		t.Fatalf("Header with old time must not be valid")
+	}
+
+	// a header must follow a known block
+	bs = makeWorkHeader(&structures.BlockShort{Hash: []byte("unknown"), Height: 3}, 1100, config.MinTargetBits)
+
+	if err := checker.CheckHeader(bs); err == nil {
+		t.Fatalf("Header after unknown block must not be valid")
+	}
+}
+
+func TestAuthorityHeaders(t *testing.T) {
+	authority := remoteclient.Wallet{}
+	authority.MakeWallet()
+	other := remoteclient.Wallet{}
+	other.MakeWallet()
+
+	bm := &AuthorityBlockMaker{}
+	bm.Logger = utils.CreateLogger()
+	bm.Chain.Consensus = config.ConsensusConfig{Kind: config.ConsensusProofOfAuthority,
+		Authorities: []string{hex.EncodeToString(authority.GetPublicKey())}}
+
+	genesis := structures.Block{Hash: []byte("genesis"), PrevBlockHash: []byte{}, Timestamp: 1000}
+
+	makeHeader := func(w remoteclient.Wallet) *structures.BlockShort {
+		block := structures.Block{PrevBlockHash: genesis.Hash, Height: 1, Timestamp: 1010, Signer: w.GetPublicKey()}
+		block.Hash = getAuthorityHeaderHash(&block, []byte("transactions"))
+		block.Signature, _ = utils.SignDataByPubKey(w.GetPublicKey(), w.GetPrivateKey(), block.Hash)
+
+		bs := block.GetShortCopy()
+		bs.Timestamp = block.Timestamp
+		bs.Signer = block.Signer
+		bs.Signature = block.Signature
+		bs.TXsHash = []byte("transactions")
+
+		return bs
+	}
+
+	checker := newTestHeadersChecker(&bm.NodeBlockMaker, bm, genesis)
+
+	if err := checker.CheckHeader(makeHeader(other)); err == nil {
+		t.Fatalf("Header signed by other key must not be valid")
+	}
+
+	bs := makeHeader(authority)
+	bs.Signature = makeHeader(other).Signature
+
+	if err := checker.CheckHeader(bs); err == nil {
+		t.Fatalf("Header with wrong signature must not be valid")
+	}
+
+	if err := checker.CheckHeader(makeHeader(authority)); err != nil {
+		t.Fatalf("Header signed by an authority must be valid: %s", err.Error())
+	}
+
+	if checker.GetChainWork().Cmp(big.NewInt(2)) != 0 {
+		t.Fatalf("Expected work 2, got %s", checker.GetChainWork().String())
+	}
+}
@@ -2,8 +2,12 @@ package consensus
 
 import (
 	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
 
 	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
 	"github.com/gelembjuk/oursql/node/database"
 	"github.com/gelembjuk/oursql/node/structures"
 )
@@ -25,11 +29,19 @@ type BlockMakerInterface interface {
 	SetDBManager(DB database.DBManager)
 	SetLogManager(Logger *utils.LoggerMan)
 	SetMinterAddress(minter string)
+	SetMinterKeys(pubKey []byte, privKey ecdsa.PrivateKey)
 	PrepareNewBlock() (int, error)
 	SetPreparedBlock(block *structures.Block) error
 	IsBlockPrepared() bool
 	CompleteBlock() (*structures.Block, error)
 	VerifyBlock(block *structures.Block) error
+	NewHeadersChecker() HeadersCheckerInterface
+}
+
+// Checks a chain of block headers loaded from other node. Headers are checked in order of the chain
+type HeadersCheckerInterface interface {
+	CheckHeader(bs *structures.BlockShort) error
+	GetChainWork() *big.Int
 }
 
 type SQLTransactionsInterface interface {
@@ -37,22 +49,51 @@ type SQLTransactionsInterface interface {
 	NewQuerySigned(txEncoded []byte, signature []byte) (*structures.Transaction, error)
 	NewQueryByNode(sql string, pubKey []byte, privKey ecdsa.PrivateKey) (uint, *structures.Transaction, error)
 	NewQueryFromProxy(sql string) (*structures.Transaction, uint16, error)
+	NewQueryFromProxySession(sql string, session *SQLSession) (*structures.Transaction, string, uint16, error)
+}
+
+type MultiSigTransactionsInterface interface {
+	CheckTransactionSigned(tx *structures.Transaction) error
+	ReceivedPartialTransaction(tx *structures.Transaction) (*structures.Transaction, bool, error)
+	SignTransaction(txID []byte, pubKey []byte, privKey ecdsa.PrivateKey) (*structures.Transaction, bool, error)
+	GetTransactions() ([]*structures.Transaction, error)
 }
 
-func NewBlockMakerManager(minter string, DB database.DBManager, Logger *utils.LoggerMan) (BlockMakerInterface, error) {
+func NewBlockMakerManager(minter string, chain config.ChainConfig, DB database.DBManager, Logger *utils.LoggerMan) (BlockMakerInterface, error) {
 	bm := &NodeBlockMaker{}
 	bm.DB = DB
 	bm.Logger = Logger
 	bm.MinterAddress = minter
-	return bm, nil
+	bm.Chain = chain
+
+	switch chain.Consensus.Kind {
+	case "", config.ConsensusProofOfWork:
+		return bm, nil
+	case config.ConsensusProofOfAuthority:
+		if len(chain.Consensus.Authorities) == 0 {
+			return nil, errors.New("No authorities in the chain config")
+		}
+		return &AuthorityBlockMaker{NodeBlockMaker: *bm}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown consensus engine %s", chain.Consensus.Kind))
 }
 
-func NewSQLQueryManager(DB database.DBManager, Logger *utils.LoggerMan, pubKey []byte, privKey ecdsa.PrivateKey) (SQLTransactionsInterface, error) {
+func NewSQLQueryManager(chain config.ChainConfig, DB database.DBManager, Logger *utils.LoggerMan, pubKey []byte, privKey ecdsa.PrivateKey) (SQLTransactionsInterface, error) {
 	qm := &queryManager{}
 	qm.DB = DB
 	qm.Logger = Logger
+	qm.Chain = chain
 	qm.pubKey = pubKey
 	qm.privKey = privKey
 
 	return qm, nil
 }
+
+func NewMultiSigManager(chain config.ChainConfig, DB database.DBManager, Logger *utils.LoggerMan) (MultiSigTransactionsInterface, error) {
+	m := &multiSigManager{}
+	m.DB = DB
+	m.Logger = Logger
+	m.Chain = chain
+
+	return m, nil
+}
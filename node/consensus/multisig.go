@@ -0,0 +1,292 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/database"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
+	"github.com/gelembjuk/oursql/node/transactions"
+)
+
+// Updates of tables listed in a chain config MultiSig must be signed by M of N keys.
+// A TX is created and signed by one key as usual. Other keys add co-signatures. While signatures are collected
+// the TX is kept in a separate list (not in the pool) and is sent to other nodes. It goes to the pool
+// and its queries are executed when it has enough signatures
+// NOTE co-signatures are not part of a TX ID, so all copies of a TX have same ID
+
+type multiSigManager struct {
+	DB     database.DBManager
+	Logger *utils.LoggerMan
+	Chain  config.ChainConfig
+}
+
+func (m multiSigManager) getTransactionsManager() transactions.TransactionsManagerInterface {
+	return transactions.NewManager(m.DB, m.Logger)
+}
+
+// Number of signatures a TX still needs. It is a maximum for all tables updated by the TX
+func (m multiSigManager) getMissingSignatures(tx *structures.Transaction) (int, error) {
+	if !tx.IsSQLCommand() || len(m.Chain.MultiSig) == 0 {
+		return 0, nil
+	}
+
+	missing := 0
+	signers := tx.GetSignersPubKeys()
+
+	for _, sqlUpdate := range tx.GetSQLUpdates() {
+		parsed := sqlparser.NewSqlParser()
+
+		err := parsed.Parse(string(sqlUpdate.Query))
+
+		if err != nil {
+			return 0, err
+		}
+
+		policy := m.Chain.GetTableMultiSig(parsed.GetTable())
+
+		if policy == nil {
+			continue
+		}
+
+		if n := policy.Threshold - policy.CountSignatures(signers); n > missing {
+			missing = n
+		}
+	}
+	return missing, nil
+}
+
+// Check that every co-signer is a key of a policy of some table updated by a TX
+func (m multiSigManager) checkCoSigners(tx *structures.Transaction) error {
+	policies := []*config.MultiSigPolicy{}
+
+	for _, sqlUpdate := range tx.GetSQLUpdates() {
+		parsed := sqlparser.NewSqlParser()
+
+		err := parsed.Parse(string(sqlUpdate.Query))
+
+		if err != nil {
+			return err
+		}
+
+		if policy := m.Chain.GetTableMultiSig(parsed.GetTable()); policy != nil {
+			policies = append(policies, policy)
+		}
+	}
+
+	for _, cs := range tx.CoSignatures {
+		found := false
+
+		for _, policy := range policies {
+			if policy.HasKey(cs.PubKey) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return errors.New(fmt.Sprintf("Key %x can not sign transaction %x", cs.PubKey, tx.GetID()))
+		}
+	}
+	return nil
+}
+
+// load a TX waiting for signatures. nil if it is not in the list
+func (m multiSigManager) getTransaction(txID []byte) (*structures.Transaction, error) {
+	mtdb, err := m.DB.GetMultiSigTransactionsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	txData, err := mtdb.GetTransaction(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if txData == nil {
+		return nil, nil
+	}
+	return structures.DeserializeTransaction(txData)
+}
+
+func (m multiSigManager) putTransaction(tx *structures.Transaction) error {
+	mtdb, err := m.DB.GetMultiSigTransactionsObject()
+
+	if err != nil {
+		return err
+	}
+
+	txData, err := structures.SerializeTransaction(tx)
+
+	if err != nil {
+		return err
+	}
+	return mtdb.PutTransaction(tx.GetID(), txData)
+}
+
+// TX has all signatures. Move it from the list to the pool. Queries are executed
+func (m multiSigManager) completeTransaction(tx *structures.Transaction) error {
+	m.Logger.Trace.Printf("Multisig TX %x has all signatures. Add it to the pool", tx.GetID())
+
+	err := m.getTransactionsManager().ReceivedNewTransaction(tx, true)
+
+	if err != nil {
+		return err
+	}
+
+	mtdb, err := m.DB.GetMultiSigTransactionsObject()
+
+	if err != nil {
+		return err
+	}
+	return mtdb.DeleteTransaction(tx.GetID())
+}
+
+// Check if a TX has enough signatures for all tables it updates. Returns MultiSigPendingError if not
+func (m multiSigManager) CheckTransactionSigned(tx *structures.Transaction) error {
+	missing, err := m.getMissingSignatures(tx)
+
+	if err != nil {
+		return err
+	}
+
+	if missing > 0 {
+		return NewMultiSigPendingError(tx, missing)
+	}
+	return nil
+}
+
+// TX waiting for signatures is created on this node or received from other node.
+// Signatures are merged with a copy of the TX known before. Returns the merged TX or nil if nothing is new.
+// If the TX has enough signatures now, it is added to the pool and complete is true
+func (m multiSigManager) ReceivedPartialTransaction(tx *structures.Transaction) (merged *structures.Transaction, complete bool, err error) {
+	existsTX, err := m.getTransactionsManager().GetIfExists(tx.GetID())
+
+	if err != nil {
+		return
+	}
+
+	if existsTX != nil {
+		// already in the pool or in a block
+		return
+	}
+
+	err = tx.VerifySignatures()
+
+	if err != nil {
+		return
+	}
+
+	err = m.checkCoSigners(tx)
+
+	if err != nil {
+		return
+	}
+
+	knownTX, err := m.getTransaction(tx.GetID())
+
+	if err != nil {
+		return
+	}
+
+	if knownTX != nil {
+		var added int
+		added, err = knownTX.MergeCoSignatures(tx)
+
+		if err != nil || added == 0 {
+			return
+		}
+		tx = knownTX
+	}
+
+	missing, err := m.getMissingSignatures(tx)
+
+	if err != nil {
+		return
+	}
+
+	if missing > 0 {
+		m.Logger.Trace.Printf("Multisig TX %x needs %d more signatures", tx.GetID(), missing)
+		err = m.putTransaction(tx)
+
+		if err != nil {
+			return
+		}
+		merged = tx
+		return
+	}
+
+	err = m.completeTransaction(tx)
+
+	if err != nil {
+		return
+	}
+	merged = tx
+	complete = true
+	return
+}
+
+// Add a signature of a key to a TX waiting for signatures. Returns the TX and true if it is complete now
+func (m multiSigManager) SignTransaction(txID []byte, pubKey []byte, privKey ecdsa.PrivateKey) (*structures.Transaction, bool, error) {
+	tx, err := m.getTransaction(txID)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if tx == nil {
+		return nil, false, errors.New(fmt.Sprintf("Transaction %x is not waiting for signatures", txID))
+	}
+
+	signdata, err := tx.GetSignData()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	signature, err := utils.SignDataByPubKey(pubKey, privKey, signdata)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = tx.AddCoSignature(pubKey, signature)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return m.ReceivedPartialTransaction(tx)
+}
+
+// All TXs waiting for signatures
+func (m multiSigManager) GetTransactions() ([]*structures.Transaction, error) {
+	mtdb, err := m.DB.GetMultiSigTransactionsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*structures.Transaction{}
+
+	err = mtdb.ForEach(func(txID, txData []byte) error {
+		tx, err := structures.DeserializeTransaction(txData)
+
+		if err != nil {
+			return err
+		}
+		list = append(list, tx)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,40 @@
+package consensus
+
+import (
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Price of SQL updates. Same function is used when a TX is created and when a block is verified,
+// so a price is same on all nodes
+func getSQLUpdatesPrice(pricing config.QueryPricing, sqlUpdates []structures.SQLUpdate) (float64, error) {
+	price := float64(0)
+
+	if pricing.IsFree() {
+		return 0, nil
+	}
+
+	for _, sqlUpdate := range sqlUpdates {
+		parsed := sqlparser.NewSqlParser()
+
+		err := parsed.Parse(string(sqlUpdate.Query))
+
+		if err != nil {
+			return 0, err
+		}
+
+		size := len(sqlUpdate.Query) + len(sqlUpdate.RollbackQuery)
+
+		price += pricing.GetUpdatePrice(parsed.GetKind(), parsed.GetTable(), size)
+	}
+	return config.RoundAmount(price), nil
+}
+
+// Price of all SQL updates of a TX
+func getTransactionPrice(pricing config.QueryPricing, tx *structures.Transaction) (float64, error) {
+	if !tx.IsSQLCommand() {
+		return 0, nil
+	}
+	return getSQLUpdatesPrice(pricing, tx.GetSQLUpdates())
+}
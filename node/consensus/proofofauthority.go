@@ -0,0 +1,230 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Proof of authority. Blocks are signed by authorities listed in a chain config instead of proof of work.
+// Authorities sign blocks in turn, a block at height H is signed by the authority H mod N.
+// If TurnTimeout is set and an authority misses its turn, next one can sign a block when TurnTimeout seconds
+// passed after a previous block, the one after it when 2*TurnTimeout passed etc.
+// A block hash is a hash of block data and a signer key. An authority signs the hash
+
+// a block time can not be in a future more than this (seconds)
+const poaMaxClockDrift = 15
+
+type AuthorityBlockMaker struct {
+	NodeBlockMaker
+	MinterPubKey     []byte
+	MinterPrivateKey ecdsa.PrivateKey
+}
+
+func (n *AuthorityBlockMaker) SetMinterKeys(pubKey []byte, privKey ecdsa.PrivateKey) {
+	n.MinterPubKey = pubKey
+	n.MinterPrivateKey = privKey
+}
+
+// Makes a block only if it is a turn of this node authority now
+func (n *AuthorityBlockMaker) PrepareNewBlock() (int, error) {
+	myTurn, err := n.checkMyTurn()
+
+	if err != nil {
+		return BlockPrepare_Error, err
+	}
+
+	if !myTurn {
+		n.Logger.Trace.Printf("Minting: Not a turn of this authority")
+		return BlockPrepare_NotGoodTime, nil
+	}
+	return n.NodeBlockMaker.PrepareNewBlock()
+}
+
+// Sign a prepared block. There is no any work to do
+func (n *AuthorityBlockMaker) CompleteBlock() (*structures.Block, error) {
+	if n.PreparedBlock == nil {
+		return nil, errors.New("Block was not prepared")
+	}
+
+	if len(n.MinterPubKey) == 0 {
+		return nil, errors.New("Minter keys are not loaded. A block can not be signed")
+	}
+
+	b := n.PreparedBlock
+
+	if b.Height > 0 {
+		// time passed since the block was prepared, maybe a turn is changed
+		err := n.checkTurn(b, n.MinterPubKey)
+
+		if err != nil {
+			return nil, err
+		}
+	} else if n.Chain.Consensus.GetAuthorityIndex(n.MinterPubKey) < 0 {
+		// genesis block must be signed by any authority
+		return nil, errors.New("Minter is not an authority of the chain")
+	}
+
+	b.Signer = utils.CopyBytes(n.MinterPubKey)
+	b.Nonce = 0
+
+	hash, err := getAuthorityBlockHash(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := utils.SignDataByPubKey(n.MinterPubKey, n.MinterPrivateKey, hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b.Hash = hash
+	b.Signature = signature
+
+	n.Logger.Trace.Printf("Minting: New block is signed. Hash is %x\n", b.Hash)
+
+	return b, nil
+}
+
+// Verify the block. Same rules as for proof of work, except the rule 6
+// 6. Block hash is correct and is signed by an authority in turn
+func (n *AuthorityBlockMaker) VerifyBlock(block *structures.Block) error {
+	//9.
+	err := n.checkFinality(block)
+
+	if err != nil {
+		return err
+	}
+
+	//6.
+	if block.Timestamp > time.Now().Unix()+poaMaxClockDrift {
+		return errors.New("Block time is in the future")
+	}
+
+	hash, err := getAuthorityBlockHash(block)
+
+	if err != nil {
+		return err
+	}
+
+	if bytes.Compare(hash, block.Hash) != 0 {
+		return errors.New("Block hash is not valid")
+	}
+
+	v, err := utils.VerifySignature(block.Signature, block.Hash, block.Signer)
+
+	if err != nil {
+		return err
+	}
+
+	if !v {
+		return structures.NewSignatureError("block", block.Hash)
+	}
+
+	err = n.checkTurn(block, block.Signer)
+
+	if err != nil {
+		return err
+	}
+	n.Logger.Trace.Println("block signature verified")
+
+	return n.verifyBlockTransactions(block)
+}
+
+// Check if this node authority can sign next block now
+func (n *AuthorityBlockMaker) checkMyTurn() (bool, error) {
+	index := n.Chain.Consensus.GetAuthorityIndex(n.MinterPubKey)
+
+	if index < 0 {
+		return false, errors.New("Minter is not an authority of the chain")
+	}
+
+	topHash, topHeight, err := n.getBlockchainManager().GetState()
+
+	if err != nil {
+		return false, err
+	}
+
+	topBlock, err := n.getBlockchainManager().GetBlock(topHash)
+
+	if err != nil {
+		return false, err
+	}
+
+	return index == n.getAuthorityInTurn(topHeight+1, time.Now().Unix(), topBlock.Timestamp), nil
+}
+
+// Check if a key can sign a block. It must be an authority in turn for the block time
+func (n *AuthorityBlockMaker) checkTurn(block *structures.Block, signer []byte) error {
+	index := n.Chain.Consensus.GetAuthorityIndex(signer)
+
+	if index < 0 {
+		return errors.New(fmt.Sprintf("Key %x is not an authority of the chain", signer))
+	}
+
+	prevBlock, err := n.getBlockchainManager().GetBlock(block.PrevBlockHash)
+
+	if err != nil {
+		return err
+	}
+	return n.checkTurnAfter(block, index, &prevBlock)
+}
+
+// Check if an authority with an index is in turn for a block after a previous block
+func (n *AuthorityBlockMaker) checkTurnAfter(block *structures.Block, index int, prevBlock *structures.Block) error {
+	if block.Timestamp < prevBlock.Timestamp {
+		return errors.New("Block time is before a time of previous block")
+	}
+
+	if index != n.getAuthorityInTurn(block.Height, block.Timestamp, prevBlock.Timestamp) {
+		return errors.New(fmt.Sprintf("It is not a turn of authority %d to sign a block at height %d", index, block.Height))
+	}
+	return nil
+}
+
+// Index of an authority which can sign a block at height made at a time. prevTimestamp is a time of a previous block
+func (n *AuthorityBlockMaker) getAuthorityInTurn(height int, timestamp int64, prevTimestamp int64) int {
+	skipped := int64(0)
+
+	if n.Chain.Consensus.TurnTimeout > 0 && timestamp > prevTimestamp {
+		skipped = (timestamp - prevTimestamp) / n.Chain.Consensus.TurnTimeout
+	}
+	return int((int64(height) + skipped) % int64(len(n.Chain.Consensus.Authorities)))
+}
+
+// Hash of a block data. It is signed by an authority
+func getAuthorityBlockHash(block *structures.Block) ([]byte, error) {
+	txshash, err := block.HashTransactions()
+
+	if err != nil {
+		return nil, err
+	}
+	return getAuthorityHeaderHash(block, txshash), nil
+}
+
+// Hash of a block with a hash of its transactions. A header of a block has no transactions
+func getAuthorityHeaderHash(block *structures.Block, txshash []byte) []byte {
+	data := bytes.Join(
+		[][]byte{
+			block.PrevBlockHash,
+			txshash,
+			utils.IntToHex(block.Timestamp),
+			utils.IntToHex(int64(block.Height)),
+			block.Signer,
+			block.ChainConfig,
+		},
+		[]byte{},
+	)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
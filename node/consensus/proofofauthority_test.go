@@ -0,0 +1,91 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+func TestAuthorityInTurn(t *testing.T) {
+	bm := AuthorityBlockMaker{}
+	bm.Chain.Consensus = config.ConsensusConfig{Kind: config.ConsensusProofOfAuthority, Authorities: []string{"aa", "bb", "cc"}}
+
+	tests := []struct {
+		height    int
+		timestamp int64
+		expected  int
+	}{
+		{1, 100, 1},
+		{2, 1000, 2},
+		{3, 100, 0},
+	}
+
+	for _, test := range tests {
+		if i := bm.getAuthorityInTurn(test.height, test.timestamp, 100); i != test.expected {
+			t.Fatalf("Height %d: expected authority %d, got %d", test.height, test.expected, i)
+		}
+	}
+
+	// next authority can sign after a timeout
+	bm.Chain.Consensus.TurnTimeout = 10
+
+	tests = []struct {
+		height    int
+		timestamp int64
+		expected  int
+	}{
+		{1, 105, 1},
+		{1, 110, 2},
+		{1, 125, 0},
+		{2, 99, 2},
+	}
+
+	for _, test := range tests {
+		if i := bm.getAuthorityInTurn(test.height, test.timestamp, 100); i != test.expected {
+			t.Fatalf("Height %d time %d: expected authority %d, got %d", test.height, test.timestamp, test.expected, i)
+		}
+	}
+}
+
+func TestAuthorityBlockHash(t *testing.T) {
+	w := remoteclient.Wallet{}
+	w.MakeWallet()
+
+	cbtx, err := structures.NewCoinbaseTransaction(string(w.GetAddress()), "test", 0)
+
+	if err != nil {
+		t.Fatalf("Coinbase TX error: %s", err.Error())
+	}
+
+	block := structures.Block{}
+	block.PrepareNewBlock([]structures.Transaction{*cbtx}, []byte("prev"), 1)
+	block.Signer = w.GetPublicKey()
+
+	hash, err := getAuthorityBlockHash(&block)
+
+	if err != nil {
+		t.Fatalf("Hash error: %s", err.Error())
+	}
+
+	signature, _ := utils.SignDataByPubKey(w.GetPublicKey(), w.GetPrivateKey(), hash)
+
+	v, _ := utils.VerifySignature(signature, hash, w.GetPublicKey())
+
+	if !v {
+		t.Fatalf("Signature of block hash is not valid")
+	}
+
+	// other signer gives other hash
+	other := block.Copy()
+	other.Signer = []byte("other")
+
+	otherHash, _ := getAuthorityBlockHash(other)
+
+	if hex.EncodeToString(otherHash) == hex.EncodeToString(hash) {
+		t.Fatalf("Block hash doesn't depend on a signer")
+	}
+}
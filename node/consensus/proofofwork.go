@@ -19,37 +19,53 @@ var (
 
 // ProofOfWork represents a proof-of-work
 type ProofOfWork struct {
-	block  *structures.Block
-	target *big.Int
+	block   *structures.Block
+	target  *big.Int
+	txsHash []byte // hash of transactions. it is set when a block header is checked, a header has no transactions
 }
 
 // NewProofOfWork builds and returns a ProofOfWork object
-// The object can be used to find a hash for the block
+// The object can be used to find a hash for the block. Difficulty is a block Bits
 func NewProofOfWork(b *structures.Block) *ProofOfWork {
 	target := big.NewInt(1)
 
-	var tb int
+	target.Lsh(target, uint(256-getTargetBits(b)))
 
-	if b.Height >= 1000 {
-		tb = config.TargetBits_2
-	} else {
-		tb = config.TargetBits
-	}
-
-	target.Lsh(target, uint(256-tb))
-
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{b, target, nil}
 
 	return pow
 }
 
+// Difficulty of a block. Blocks made before Bits was added have a difficulty by a height
+func getTargetBits(b *structures.Block) int {
+	if b.Bits > 0 {
+		return b.Bits
+	}
+	if b.Height >= 1000 {
+		return config.TargetBits_2
+	}
+	return config.TargetBits
+}
+
 // Prepares data for next iteration of PoW
 // this will be hashed
 func (pow *ProofOfWork) prepareData() ([]byte, error) {
-	txshash, err := pow.block.HashTransactions()
+	txshash := pow.txsHash
 
-	if err != nil {
-		return nil, err
+	if txshash == nil {
+		var err error
+		txshash, err = pow.block.HashTransactions()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bits := pow.block.Bits
+
+	if bits == 0 {
+		// old blocks were hashed with this value
+		bits = config.TargetBits
 	}
 
 	data := bytes.Join(
@@ -57,7 +73,8 @@ func (pow *ProofOfWork) prepareData() ([]byte, error) {
 			pow.block.PrevBlockHash,
 			txshash,
 			utils.IntToHex(pow.block.Timestamp),
-			utils.IntToHex(int64(config.TargetBits)),
+			utils.IntToHex(int64(bits)),
+			pow.block.ChainConfig, // it is empty for all blocks except genesis
 		},
 		[]byte{},
 	)
@@ -103,10 +120,14 @@ func (pow *ProofOfWork) Run() (int, []byte, error) {
 }
 
 // Validate validates block's PoW
-// It calculates hash from same data and check if it is equal to block hash
-func (pow *ProofOfWork) Validate() (bool, error) {
+// It checks the block was made with expected difficulty. Then calculates hash from same data and check if it is equal to block hash
+func (pow *ProofOfWork) Validate(expectedBits int) (bool, error) {
 	var hashInt big.Int
 
+	if pow.block.Bits != expectedBits {
+		return false, nil
+	}
+
 	predata, err := pow.prepareData()
 
 	if err != nil {
@@ -117,7 +138,7 @@ func (pow *ProofOfWork) Validate() (bool, error) {
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
-	isValid := hashInt.Cmp(pow.target) == -1
+	isValid := hashInt.Cmp(pow.target) == -1 && bytes.Compare(hash[:], pow.block.Hash) == 0
 
 	return isValid, nil
 }
@@ -5,7 +5,9 @@ import (
 	"crypto/ecdsa"
 	"errors"
 
+	"github.com/gelembjuk/oursql/lib"
 	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
 	"github.com/gelembjuk/oursql/node/database"
 	"github.com/gelembjuk/oursql/node/dbquery"
 	"github.com/gelembjuk/oursql/node/structures"
@@ -19,6 +21,7 @@ import (
 type queryManager struct {
 	DB      database.DBManager
 	Logger  *utils.LoggerMan
+	Chain   config.ChainConfig
 	pubKey  []byte
 	privKey ecdsa.PrivateKey
 }
@@ -108,16 +111,154 @@ func (q queryManager) NewQueryByNode(sql string, pubKey []byte, privKey ecdsa.Pr
 // TODO replace error and code with custom errror structure containing a code
 func (q queryManager) NewQueryFromProxy(sql string) (tx *structures.Transaction, errCode uint16, err error) {
 	r, txdata, datatosign, tx, err := q.processQuery(sql, []byte{}, false)
-	// formate error message
+
+	return q.formatProxyResult(r, txdata, datatosign, tx, err)
+}
+
+// DB proxy received new query in a session (client connection).
+// Queries between BEGIN and COMMIT are collected in the session. On COMMIT one TX is created for all of them
+// On ROLLBACK collected queries are forgotten. Out of BEGIN/COMMIT it works same as NewQueryFromProxy
+// Returns a query to pass to a server. It is empty if the query is not changed. It is changed when
+// calls like NOW() are replaced with values, so the server executes same query as other nodes
+func (q queryManager) NewQueryFromProxySession(sql string, session *SQLSession) (tx *structures.Transaction, serverSQL string, errCode uint16, err error) {
+	ctx := session.getQueryContext()
+	// a query without a key in a comment is signed by keys of this node
+	ctx.PubKey = q.pubKey
+
+	qparsed, err := q.getQueryParser().ParseQueryInContext(sql, ctx)
+
 	if err != nil {
 		errCode = 4
 		return
 	}
+
+	serverSQL = qparsed.RewrittenSQL
+
+	if qparsed.IsPermissionsChange() || qparsed.IsRowOwnershipTransfer() {
+		// it is not MySQL GRANT. permissions and owners are changed by a TX, a server must do nothing
+		serverSQL = "DO 0"
+	}
+
+	// LAST_INSERT_ID() will return this ID if the query is successful
+	session.queryInsertID(qparsed.InsertID)
+
+	if qparsed.IsTransactionBegin() {
+		if session.IsActive() {
+			// mysql does implicit commit in this case. we don't support it
+			err = errors.New("Transaction is already started. Use COMMIT or ROLLBACK first")
+			errCode = 4
+			return
+		}
+		session.begin()
+		return
+	}
+
+	if qparsed.IsTransactionRollback() {
+		// nothing goes to the pool
+		session.reset()
+		return
+	}
+
+	if !session.IsActive() {
+		if qparsed.IsTransactionCommit() {
+			// nothing to commit
+			return
+		}
+		r, txdata, datatosign, qtx, qerr := q.processParsedQuery(qparsed, []byte{}, false)
+
+		tx, errCode, err = q.formatProxyResult(r, txdata, datatosign, qtx, qerr)
+
+		if err == nil && tx != nil && qparsed.RewrittenSQL != "" && len(qparsed.TransactionBytes) > 0 {
+			// values in the query are different now. server must execute the query signed in TX
+			serverSQL, err = q.getSignedServerSQL(tx, qparsed)
+
+			if err != nil {
+				errCode = 4
+				tx = nil
+			}
+		}
+		return
+	}
+
+	if qparsed.IsTransactionCommit() {
+		r, txdata, datatosign, qtx, qerr := q.processSessionCommit(qparsed, session)
+
+		tx, errCode, err = q.formatProxyResult(r, txdata, datatosign, qtx, qerr)
+		return
+	}
+
+	needsTX, err := q.checkQueryNeedsTransaction(qparsed)
+
+	if err != nil {
+		errCode = 4
+		return
+	}
+
+	if !needsTX {
+		return
+	}
+
+	if qparsed.IsTableManage() {
+		// mysql does implicit commit before such queries
+		err = errors.New("Table create, alter, truncate or drop is not allowed inside of a transaction")
+		errCode = 4
+		return
+	}
+
+	if qparsed.IsPermissionsChange() {
+		err = errors.New("Change of table permissions is not allowed inside of a transaction")
+		errCode = 4
+		return
+	}
+	// the query is executed by a server now, but TX will be created only on COMMIT
+	session.addQuery(qparsed)
+
+	return
+}
+
+// Query from a signed TX to execute on a server instead of a query received by a proxy.
+// Multi-row query is executed as it was changed now if it has same rows as the TX
+func (q queryManager) getSignedServerSQL(tx *structures.Transaction, qparsed dbquery.QueryParsed) (string, error) {
+	sqlUpdates := tx.GetSQLUpdates()
+
+	if len(sqlUpdates) == 1 {
+		return string(sqlUpdates[0].Query), nil
+	}
+
+	rows := qparsed.GetRowQueries()
+
+	differentErr := errors.New("Rows of the query are different from the signed transaction. " +
+		"Multi-row query with functions like NOW() or UUID() must be executed inside of BEGIN ... COMMIT")
+
+	if len(rows) != len(sqlUpdates) {
+		return "", differentErr
+	}
+
+	for i, sqlUpdate := range sqlUpdates {
+		if string(sqlUpdate.Query) != rows[i].SQL {
+			return "", differentErr
+		}
+	}
+	return qparsed.RewrittenSQL, nil
+}
+
+// Convert result of query processing to the format returned to a proxy
+func (q queryManager) formatProxyResult(r uint, txdata []byte, datatosign []byte, tx *structures.Transaction, err error) (*structures.Transaction, uint16, error) {
+	// formate error message
+	if err != nil {
+		if _, ok := err.(*NotEnoughFundsError); ok {
+			return nil, ProxyErrorCodeNotEnoughFunds, err
+		}
+		if _, ok := err.(*MultiSigPendingError); ok {
+			return nil, ProxyErrorCodeMultiSigRequired, err
+		}
+		return nil, 4, err
+	}
 	if r == SQLProcessingResultExecuted ||
 		r == SQLProcessingResultTranactionComplete ||
 		r == SQLProcessingResultTranactionCompleteInternally {
 
-		return // no anymore actions are needed. Query can be passed to mysql server
+		return tx, 0, nil // no anymore actions are needed. Query can be passed to mysql server
 	}
 	// it is needed to return error of  specific formate. it an include TX and data to sign
 	qp := q.getQueryParser()
@@ -125,26 +266,38 @@ func (q queryManager) NewQueryFromProxy(sql string) (tx *structures.Transaction,
 	errStr, errCode, err := qp.FormatSpecialErrorMessage(r, txdata, datatosign)
 
 	if err != nil {
-		return
+		return nil, errCode, err
 	}
-	err = errors.New(errStr)
-	return
+	return nil, errCode, errors.New(errStr)
 }
 
 // ========================================================================================
 // this does all work. It checks query, decides if ll data are present and creates transaction
 // it can return prepared transaction and data to sign or return complete transaction if keys are set in the object
 func (q queryManager) processQuery(sql string, pubKey []byte, executeifallowed bool) (uint, []byte, []byte, *structures.Transaction, error) {
-	localError := func(err error) (uint, []byte, []byte, *structures.Transaction, error) {
-		return SQLProcessingResultError, nil, nil, nil, err
-	}
 	qp := q.getQueryParser()
+
+	ctx := dbquery.NewQueryContext()
+	ctx.PubKey = pubKey
+
+	if len(pubKey) == 0 {
+		ctx.PubKey = q.pubKey
+	}
 	// this will get sql type and data from comments. data can be pubkey, txBytes, signature
-	qparsed, err := qp.ParseQuery(sql)
+	qparsed, err := qp.ParseQueryInContext(sql, ctx)
 
 	if err != nil {
-		return localError(err)
+		return SQLProcessingResultError, nil, nil, nil, err
+	}
+	return q.processParsedQuery(qparsed, pubKey, executeifallowed)
+}
+
+// same as processQuery but the query is already parsed
+func (q queryManager) processParsedQuery(qparsed dbquery.QueryParsed, pubKey []byte, executeifallowed bool) (uint, []byte, []byte, *structures.Transaction, error) {
+	localError := func(err error) (uint, []byte, []byte, *structures.Transaction, error) {
+		return SQLProcessingResultError, nil, nil, nil, err
 	}
+	qp := q.getQueryParser()
 
 	// maybe this query contains signature and txData from previous calls
 	if len(qparsed.Signature) > 0 && len(qparsed.TransactionBytes) > 0 {
@@ -193,33 +346,145 @@ func (q queryManager) processQuery(sql string, pubKey []byte, executeifallowed b
 		}
 	}
 
-	// check if the key has permissions to execute this query
-	hasPerm, err := q.checkExecutePermissions(qparsed, pubKey)
+	return q.makeSQLTransaction([]dbquery.QueryParsed{qparsed}, pubKey, executeifallowed)
+}
 
-	if err != nil {
-		return localError(err)
+// COMMIT received in a proxy session. Make one TX for all collected queries
+func (q queryManager) processSessionCommit(commitParsed dbquery.QueryParsed, session *SQLSession) (uint, []byte, []byte, *structures.Transaction, error) {
+	localError := func(err error) (uint, []byte, []byte, *structures.Transaction, error) {
+		return SQLProcessingResultError, nil, nil, nil, err
 	}
 
-	if !hasPerm {
-		return localError(errors.New("No permissions to execute this query"))
+	queries := session.getQueries()
+
+	if len(queries) == 0 {
+		// there were no updates
+		session.reset()
+		return SQLProcessingResultExecuted, nil, nil, nil, nil
 	}
 
-	amount, err := q.checkQueryNeedsPayment(qparsed)
+	// this is a case when signature and txdata were part of COMMIT comments.
+	if len(commitParsed.Signature) > 0 && len(commitParsed.TransactionBytes) > 0 {
+		// check this TX was prepared for same queries
+		tx, err := structures.DeserializeTransaction(commitParsed.TransactionBytes)
 
-	if err != nil {
-		return localError(err)
+		if err != nil {
+			return localError(err)
+		}
+
+		sqlUpdates := tx.GetSQLUpdates()
+
+		rows := []dbquery.QueryParsed{}
+
+		for _, qparsed := range queries {
+			rows = append(rows, qparsed.GetRowQueries()...)
+		}
+
+		if len(sqlUpdates) != len(rows) {
+			return localError(errors.New("Signed transaction doesn't match queries of the session"))
+		}
+
+		for i, sqlUpdate := range sqlUpdates {
+			if string(sqlUpdate.Query) != rows[i].SQL {
+				return localError(errors.New("Signed transaction doesn't match queries of the session"))
+			}
+		}
+
+		tx, err = q.processQueryWithSignature(commitParsed.TransactionBytes, commitParsed.Signature, false)
+
+		if err != nil {
+			return localError(err)
+		}
+		session.reset()
+
+		return SQLProcessingResultTranactionComplete, nil, nil, tx, nil
+	}
+
+	// pubkey from COMMIT comment has top priority, next is a key from any query of the session
+	pubKey := commitParsed.PubKey
+
+	for _, qparsed := range queries {
+		if len(pubKey) > 0 {
+			break
+		}
+		pubKey = qparsed.PubKey
+	}
+
+	if len(pubKey) == 0 {
+		if len(q.pubKey) == 0 {
+			// no pubkey to use. return notice about pubkey required
+			return SQLProcessingResultPubKeyRequired, nil, nil, nil, nil
+		}
+		pubKey = q.pubKey
 	}
-	// prepare SQL part of a TX
-	// this builds RefID for a TX update
-	sqlUpdate, err := qp.MakeSQLUpdateStructure(qparsed)
+
+	r, txBytes, datatosign, tx, err := q.makeSQLTransaction(queries, pubKey, false)
 
 	if err != nil {
 		return localError(err)
 	}
 
+	if r == SQLProcessingResultTranactionCompleteInternally {
+		session.reset()
+	}
+
+	return r, txBytes, datatosign, tx, nil
+}
+
+// Make new TX for list of queries. If a pubkey is internal, TX is signed and added to the pool
+func (q queryManager) makeSQLTransaction(queries []dbquery.QueryParsed, pubKey []byte, executeifallowed bool) (uint, []byte, []byte, *structures.Transaction, error) {
+	localError := func(err error) (uint, []byte, []byte, *structures.Transaction, error) {
+		return SQLProcessingResultError, nil, nil, nil, err
+	}
+	qp := q.getQueryParser()
+
+	sqlUpdates := []structures.SQLUpdate{}
+	amount := float64(0)
+
+	for _, qparsed := range queries {
+		// check if the key has permissions to execute this query
+		hasPerm, err := q.checkExecutePermissions(qparsed, pubKey)
+
+		if err != nil {
+			return localError(err)
+		}
+
+		if !hasPerm {
+			return localError(errors.New("No permissions to execute this query"))
+		}
+
+		// prepare SQL part of a TX
+		// this builds RefID for a TX update. multi-row insert gives update per row
+		querySQLUpdates, err := qp.MakeSQLUpdateStructures(qparsed)
+
+		if err != nil {
+			return localError(err)
+		}
+
+		queryAmount, err := q.checkQueryNeedsPayment(qparsed, querySQLUpdates)
+
+		if err != nil {
+			return localError(err)
+		}
+		amount += queryAmount
+
+		sqlUpdates = append(sqlUpdates, querySQLUpdates...)
+	}
+
+	if amount > 0 {
+		err := q.checkBalanceForPayment(pubKey, amount)
+
+		if err != nil {
+			return localError(err)
+		}
+	}
+
 	// prepare curency TX and add SQL part
 
-	txBytes, datatosign, err := q.getTransactionsManager().PrepareNewSQLTransaction(pubKey, sqlUpdate, amount, "MINTER")
+	// queries have values of NOW() etc for this time
+	txTime := queries[0].Time
+
+	txBytes, datatosign, err := q.getTransactionsManager().PrepareNewSQLGroupTransaction(pubKey, sqlUpdates, txTime, amount, lib.CurrencyReceiverMinter)
 
 	if err != nil {
 		return localError(err)
@@ -266,6 +531,12 @@ func (q queryManager) processQueryWithSignature(txEncoded []byte, signature []by
 	// verify
 	// TODO
 
+	err = q.checkMultiSignatures(tx)
+
+	if err != nil {
+		return nil, err
+	}
+
 	q.Logger.Trace.Printf("Adding TX to pool")
 	//return nil, errors.New("Temp err ")
 	// add to pool
@@ -279,14 +550,67 @@ func (q queryManager) processQueryWithSignature(txEncoded []byte, signature []by
 	return tx, nil
 }
 
+// check if a TX has all signatures needed for multisig tables. If it doesn't, the TX waits for other signers
+// and the query is not executed. MultiSigPendingError is returned in this case
+func (q queryManager) checkMultiSignatures(tx *structures.Transaction) error {
+	msm, err := NewMultiSigManager(q.Chain, q.DB, q.Logger)
+
+	if err != nil {
+		return err
+	}
+
+	err = msm.CheckTransactionSigned(tx)
+
+	if _, ok := err.(*MultiSigPendingError); !ok {
+		return err
+	}
+	q.Logger.Trace.Printf("TX %x waits for more signatures", tx.GetID())
+
+	_, _, perr := msm.ReceivedPartialTransaction(tx)
+
+	if perr != nil {
+		return perr
+	}
+	return err
+}
+
 // check if this pubkey can execute this query
 func (q queryManager) checkExecutePermissions(qp dbquery.QueryParsed, pubKey []byte) (bool, error) {
+	err := dbquery.NewPermissionsChecker(q.DB, q.Logger).CheckQuery(qp, pubKey)
+
+	if err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
 // check if this query requires payment for execution. return number
-func (q queryManager) checkQueryNeedsPayment(qp dbquery.QueryParsed) (float64, error) {
-	return 0, nil
+// Price is calculated for SQL updates of the query, it is what goes to a TX
+func (q queryManager) checkQueryNeedsPayment(qp dbquery.QueryParsed, sqlUpdates []structures.SQLUpdate) (float64, error) {
+	if !qp.IsUpdate() {
+		return 0, nil
+	}
+	return getSQLUpdatesPrice(q.Chain.Pricing, sqlUpdates)
+}
+
+// check if a pubkey has enough funds to pay for queries
+func (q queryManager) checkBalanceForPayment(pubKey []byte, amount float64) error {
+	address, err := utils.PubKeyToAddres(pubKey)
+
+	if err != nil {
+		return err
+	}
+
+	balance, err := q.getTransactionsManager().GetAddressBalance(address)
+
+	if err != nil {
+		return err
+	}
+
+	if balance.Total < amount {
+		return NewNotEnoughFundsError(amount, balance.Total)
+	}
+	return nil
 }
 
 // check if this query must be added to transaction. all SELECT queries must be ignored.
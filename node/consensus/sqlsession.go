@@ -0,0 +1,116 @@
+package consensus
+
+import (
+	"sync"
+
+	"github.com/gelembjuk/oursql/node/dbquery"
+)
+
+// State of SQL transaction started with BEGIN in a DB proxy session (client connection).
+// Update queries are collected here and are converted to one blockchain TX on COMMIT.
+// Request and response are processed in different routines, so all access is locked
+type SQLSession struct {
+	lock         sync.Mutex
+	active       bool
+	queries      []dbquery.QueryParsed
+	waitResponse bool   // last query was added to the list and waits for a response from a server
+	time         int64  // time of BEGIN. all queries of a transaction use it as NOW()
+	lastInsertID string // value of LAST_INSERT_ID() in the session
+	insertID     string // auto_increment ID of the last query. it becomes lastInsertID if the query is successful
+	conn         dbquery.SessionConnection
+}
+
+func NewSQLSession() *SQLSession {
+	return &SQLSession{}
+}
+
+// Check if there is a transaction started in the session
+func (s *SQLSession) IsActive() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.active
+}
+
+// Server responded on last query. If the query failed, it is not part of a transaction
+func (s *SQLSession) QueryResponse(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.waitResponse && err != nil && len(s.queries) > 0 {
+		s.queries = s.queries[:len(s.queries)-1]
+	}
+	s.waitResponse = false
+
+	if err == nil && s.insertID != "" {
+		s.lastInsertID = s.insertID
+	}
+	s.insertID = ""
+}
+
+func (s *SQLSession) begin() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.active = true
+	s.queries = []dbquery.QueryParsed{}
+	s.waitResponse = false
+	s.time = dbquery.NewQueryContext().Time
+}
+
+// Values for non-deterministic functions in a new query of the session
+func (s *SQLSession) getQueryContext() dbquery.QueryContext {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ctx := dbquery.NewQueryContext()
+
+	if s.active {
+		ctx.Time = s.time
+	}
+	ctx.LastInsertID = s.lastInsertID
+	ctx.Connection = s.conn
+
+	return ctx
+}
+
+// Server connection of the session. Rows before a query are read with it, inside of a transaction
+func (s *SQLSession) SetConnection(conn dbquery.SessionConnection) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.conn = conn
+}
+
+// New query is passed to a server. insertID is auto_increment value assigned by the query
+func (s *SQLSession) queryInsertID(insertID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.insertID = insertID
+}
+
+// Forget all collected queries. It is called on ROLLBACK or when TX is created
+func (s *SQLSession) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.active = false
+	s.queries = nil
+	s.waitResponse = false
+}
+
+func (s *SQLSession) addQuery(qparsed dbquery.QueryParsed) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queries = append(s.queries, qparsed)
+	s.waitResponse = true
+}
+
+func (s *SQLSession) getQueries() []dbquery.QueryParsed {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]dbquery.QueryParsed{}, s.queries...)
+}
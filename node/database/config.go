@@ -2,15 +2,36 @@ package database
 
 import (
 	"strconv"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Max number of rows one UPDATE or DELETE query can affect if it is not set in a config
+const DefaultMaxRowsPerQuery = 100
+
+// How a node assigns a key value to a row inserted without a key
+const (
+	KeyStrategyAutoIncrement = "autoincrement" // next auto_increment value. Two nodes can assign same value
+	KeyStrategyPartitioned   = "partitioned"   // auto_increment values where value % Step == Offset. Each node has own Offset
+	KeyStrategyHash          = "hash"          // hash of a query and its time
 )
 
+// Key assignment settings of a table. Key "*" in a config is used for all tables not listed
+type TableKeyConfig struct {
+	Strategy string
+	Step     int
+	Offset   int
+}
+
 type DatabaseConfig struct {
-	MysqlHost    string
-	MysqlPort    int
-	DatabaseName string
-	DbUser       string
-	DbPassword   string
-	TablesPrefix string
+	MysqlHost       string
+	MysqlPort       int
+	DatabaseName    string
+	DbUser          string
+	DbPassword      string
+	TablesPrefix    string
+	MaxRowsPerQuery int
+	TableKeys       map[string]TableKeyConfig
 }
 
 func (dbc *DatabaseConfig) HasMinimum() bool {
@@ -20,6 +41,27 @@ func (dbc *DatabaseConfig) HasMinimum() bool {
 	return true
 }
 
+// Max number of rows one UPDATE or DELETE query can affect. Each row is separate update in a TX
+func (dbc *DatabaseConfig) GetMaxRowsPerQuery() int {
+	if dbc.MaxRowsPerQuery > 0 {
+		return dbc.MaxRowsPerQuery
+	}
+	return DefaultMaxRowsPerQuery
+}
+
+// Key assignment settings of a table. Default is auto_increment. A table in the config can be with a database name
+func (dbc *DatabaseConfig) GetTableKeyConfig(table string) TableKeyConfig {
+	for key, c := range dbc.TableKeys {
+		if key != "*" && utils.TableNameWithoutSchema(key) == table {
+			return c
+		}
+	}
+	if c, ok := dbc.TableKeys["*"]; ok {
+		return c
+	}
+	return TableKeyConfig{Strategy: KeyStrategyAutoIncrement}
+}
+
 func (dbc *DatabaseConfig) GetServerAddress() string {
 	return dbc.MysqlHost + ":" + strconv.Itoa(dbc.MysqlPort)
 }
@@ -8,6 +8,7 @@ type DBManager interface {
 	QM() DBQueryManager // get QueryManager object
 
 	SetConfig(config DatabaseConfig) error
+	GetConfig() DatabaseConfig
 	SetLogger(logger *utils.LoggerMan) error
 	GetLockerObject() DatabaseLocker
 	SetLockerObject(lockerobj DatabaseLocker)
@@ -26,6 +27,8 @@ type DBManager interface {
 	GetUnspentOutputsObject() (UnspentOutputsInterface, error)
 	GetNodesObject() (NodesInterface, error)
 	GetDataReferencesObject() (DataReferencesaInterface, error)
+	GetTablesACLObject() (TablesACLInterface, error)
+	GetMultiSigTransactionsObject() (MultiSigTransactionsInterface, error)
 }
 
 type DBQueryManager interface {
@@ -33,9 +36,14 @@ type DBQueryManager interface {
 	Restore(file string) error
 	ExecuteSQL(sql string) error
 	ExecuteSQLExplain(sql string) (SQLExplainInfo, error)
-	ExecuteSQLPrimaryKey(table string) (string, error)
+	ExecuteSQLPrimaryKey(table string) ([]string, error)
 	ExecuteSQLNextKeyValue(table string) (string, error)
+	ExecuteSQLTableCreate(table string) (string, error)
+	ExecuteSQLTableColumns(table string) ([]string, error)
+	ExecuteSQLColumnType(table string, column string) (string, error)
+	ExecuteSQLTableDump(table string) (createSQL string, inserts []string, err error)
 	ExecuteSQLSelectRow(sqlcommand string) (data map[string]string, err error)
+	ExecuteSQLSelectRows(sqlcommand string) (data []map[string]string, err error)
 }
 
 type SQLExplainInfo struct {
@@ -103,6 +111,27 @@ type DataReferencesaInterface interface {
 	DeleteRefID(RefID []byte) error
 }
 
+// this is interface for DB of tables permissions. It keeps an owner of a table
+// and addresses allowed to update it
+type TablesACLInterface interface {
+	InitDB() error
+	TruncateDB() error
+	PutTableACL(table []byte, acl []byte) error
+	GetTableACL(table []byte) ([]byte, error)
+	DeleteTableACL(table []byte) error
+}
+
+// this is interface for DB of SQL transactions which are not yet signed by all required keys
+type MultiSigTransactionsInterface interface {
+	InitDB() error
+	TruncateDB() error
+	ForEach(callback ForEachKeyIteratorInterface) error
+
+	GetTransaction(txID []byte) ([]byte, error)
+	PutTransaction(txID []byte, txdata []byte) error
+	DeleteTransaction(txID []byte) error
+}
+
 type UnapprovedTransactionsInterface interface {
 	InitDB() error
 	TruncateDB() error
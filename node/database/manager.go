@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -23,6 +24,9 @@ const (
 	ClassNameUnspentOutputs         = "unspentoutputs"
 )
 
+// number of rows in one INSERT of a table dump
+const tableDumpRowsPerInsert = 100
+
 type MySQLDBManager struct {
 	Logger     *utils.LoggerMan
 	Config     DatabaseConfig
@@ -40,6 +44,9 @@ func (bdm *MySQLDBManager) SetConfig(config DatabaseConfig) error {
 
 	return nil
 }
+func (bdm *MySQLDBManager) GetConfig() DatabaseConfig {
+	return bdm.Config
+}
 func (bdm *MySQLDBManager) SetLogger(logger *utils.LoggerMan) error {
 	bdm.Logger = logger
 
@@ -171,6 +178,30 @@ func (bdm *MySQLDBManager) InitDatabase() error {
 
 	err = dr.InitDB()
 
+	if err != nil {
+		return err
+	}
+
+	ta, err := bdm.GetTablesACLObject()
+
+	if err != nil {
+		return err
+	}
+
+	err = ta.InitDB()
+
+	if err != nil {
+		return err
+	}
+
+	mt, err := bdm.GetMultiSigTransactionsObject()
+
+	if err != nil {
+		return err
+	}
+
+	err = mt.InitDB()
+
 	if err != nil {
 		return err
 	}
@@ -225,6 +256,32 @@ func (bdm *MySQLDBManager) GetDataReferencesObject() (DataReferencesaInterface,
 	return &dr, nil
 }
 
+func (bdm *MySQLDBManager) GetTablesACLObject() (TablesACLInterface, error) {
+	conn, err := bdm.getConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ta := tablesACL{}
+	ta.DB = &MySQLDB{conn, bdm.Config.TablesPrefix, bdm.Logger}
+
+	return &ta, nil
+}
+
+func (bdm *MySQLDBManager) GetMultiSigTransactionsObject() (MultiSigTransactionsInterface, error) {
+	conn, err := bdm.getConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	mt := multiSigTransactions{}
+	mt.DB = &MySQLDB{conn, bdm.Config.TablesPrefix, bdm.Logger}
+
+	return &mt, nil
+}
+
 // returns Transaction Index Database structure. does al init
 func (bdm *MySQLDBManager) GetTransactionsObject() (TranactionsInterface, error) {
 	conn, err := bdm.getConnection()
@@ -397,14 +454,30 @@ func (bdm MySQLDBManager) ExecuteSQLExplain(sql string) (r SQLExplainInfo, err e
 	return r, err
 }
 
-// get primary key column name for a table
-func (bdm MySQLDBManager) ExecuteSQLPrimaryKey(table string) (column string, err error) {
-	row, err := bdm.ExecuteSQLSelectRow("SHOW KEYS FROM " + table + " WHERE Key_name = 'PRIMARY'")
+// get primary key columns of a table. Composite key has many columns, they are in order of the key
+func (bdm MySQLDBManager) ExecuteSQLPrimaryKey(table string) (columns []string, err error) {
+	rows, err := bdm.ExecuteSQLSelectRows("SHOW KEYS FROM " + table + " WHERE Key_name = 'PRIMARY'")
 
 	if err != nil {
 		return
 	}
-	column = row["Column_name"]
+
+	if len(rows) == 0 {
+		err = errors.New(fmt.Sprintf("Primary key not found for table %s", table))
+		return
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		seqI, _ := strconv.Atoi(rows[i]["Seq_in_index"])
+		seqJ, _ := strconv.Atoi(rows[j]["Seq_in_index"])
+		return seqI < seqJ
+	})
+
+	columns = []string{}
+
+	for _, row := range rows {
+		columns = append(columns, row["Column_name"])
+	}
 	return
 }
 
@@ -463,6 +536,60 @@ func (bdm MySQLDBManager) ExecuteSQLSelectRow(sqlcommand string) (data map[strin
 	return
 }
 
+// get all rows as a list of maps
+func (bdm MySQLDBManager) ExecuteSQLSelectRows(sqlcommand string) (data []map[string]string, err error) {
+	db, err := bdm.getConnection()
+
+	if err != nil {
+		return
+	}
+
+	rows, err := db.Query(sqlcommand)
+
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+
+	if err != nil {
+		return
+	}
+
+	data = []map[string]string{}
+
+	for rows.Next() {
+		columns := make([]sql.NullString, len(cols))
+		columnPointers := make([]interface{}, len(cols))
+		for i, _ := range columns {
+			columnPointers[i] = &columns[i]
+		}
+
+		err = rows.Scan(columnPointers...)
+
+		if err != nil {
+			return
+		}
+		row := make(map[string]string)
+
+		for i, colName := range cols {
+			val := ""
+
+			if columns[i].Valid {
+				val = columns[i].String
+			}
+
+			row[colName] = val
+		}
+		data = append(data, row)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
 func (bdm MySQLDBManager) ExecuteSQLNextKeyValue(table string) (string, error) {
 	row, err := bdm.ExecuteSQLSelectRow("SHOW TABLE STATUS LIKE '" + table + "'")
 
@@ -471,3 +598,132 @@ func (bdm MySQLDBManager) ExecuteSQLNextKeyValue(table string) (string, error) {
 	}
 	return row["Auto_increment"], nil
 }
+
+// get CREATE TABLE statement for a table
+func (bdm MySQLDBManager) ExecuteSQLTableCreate(table string) (string, error) {
+	row, err := bdm.ExecuteSQLSelectRow("SHOW CREATE TABLE " + table)
+
+	if err != nil {
+		return "", err
+	}
+
+	createSQL, ok := row["Create Table"]
+
+	if !ok {
+		return "", errors.New(fmt.Sprintf("%s is not a table", table))
+	}
+	return createSQL, nil
+}
+
+// get list of columns of a table in order of a table structure
+func (bdm MySQLDBManager) ExecuteSQLTableColumns(table string) (columns []string, err error) {
+	rows, err := bdm.ExecuteSQLSelectRows("SHOW COLUMNS FROM " + table)
+
+	if err != nil {
+		return
+	}
+
+	columns = []string{}
+
+	for _, row := range rows {
+		columns = append(columns, row["Field"])
+	}
+	return
+}
+
+// get type of a column as it is in a table structure, like int(11) unsigned or varchar(64)
+func (bdm MySQLDBManager) ExecuteSQLColumnType(table string, column string) (string, error) {
+	rows, err := bdm.ExecuteSQLSelectRows("SHOW COLUMNS FROM " + table)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, row := range rows {
+		if row["Field"] == column {
+			return row["Type"], nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("Column %s not found in table %s", column, table))
+}
+
+// dump a table to CREATE TABLE statement and list of INSERT statements to restore all rows.
+// values are hex encoded, so any data is restored exactly
+func (bdm MySQLDBManager) ExecuteSQLTableDump(table string) (createSQL string, inserts []string, err error) {
+	createSQL, err = bdm.ExecuteSQLTableCreate(table)
+
+	if err != nil {
+		return
+	}
+
+	db, err := bdm.getConnection()
+
+	if err != nil {
+		return
+	}
+
+	rows, err := db.Query("SELECT * FROM " + table)
+
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+
+	if err != nil {
+		return
+	}
+
+	quotedCols := []string{}
+
+	for _, col := range cols {
+		quotedCols = append(quotedCols, QuoteIdentifier(col))
+	}
+
+	insertHead := "INSERT INTO " + table + " (" + strings.Join(quotedCols, ", ") + ") VALUES "
+
+	inserts = []string{}
+	values := []string{}
+
+	for rows.Next() {
+		columns := make([]sql.RawBytes, len(cols))
+		columnPointers := make([]interface{}, len(cols))
+		for i, _ := range columns {
+			columnPointers[i] = &columns[i]
+		}
+
+		err = rows.Scan(columnPointers...)
+
+		if err != nil {
+			return
+		}
+
+		rowValues := []string{}
+
+		for _, val := range columns {
+			if val == nil {
+				rowValues = append(rowValues, "NULL")
+			} else {
+				rowValues = append(rowValues, fmt.Sprintf("UNHEX('%x')", []byte(val)))
+			}
+		}
+		values = append(values, "("+strings.Join(rowValues, ", ")+")")
+
+		if len(values) == tableDumpRowsPerInsert {
+			inserts = append(inserts, insertHead+strings.Join(values, ", "))
+			values = []string{}
+		}
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return
+	}
+
+	if len(values) > 0 {
+		inserts = append(inserts, insertHead+strings.Join(values, ", "))
+	}
+	return
+}
@@ -5,8 +5,8 @@ import (
 )
 
 type mockMySQLDBManager struct {
-	ER        *SQLExplainInfo
-	KeyColumn string
+	ER         *SQLExplainInfo
+	KeyColumns []string
 }
 
 func GetDBManagerMock() mockMySQLDBManager {
@@ -18,6 +18,9 @@ func (bdm *mockMySQLDBManager) QM() DBQueryManager {
 func (bdm mockMySQLDBManager) SetConfig(config DatabaseConfig) error {
 	return nil
 }
+func (bdm mockMySQLDBManager) GetConfig() DatabaseConfig {
+	return DatabaseConfig{}
+}
 func (bdm mockMySQLDBManager) SetLogger(logger *utils.LoggerMan) error {
 	return nil
 }
@@ -60,6 +63,18 @@ func (bdm mockMySQLDBManager) GetNodesObject() (NodesInterface, error) {
 	ns := Nodes{}
 	return &ns, nil
 }
+func (bdm mockMySQLDBManager) GetDataReferencesObject() (DataReferencesaInterface, error) {
+	dr := dataReferences{}
+	return &dr, nil
+}
+func (bdm mockMySQLDBManager) GetTablesACLObject() (TablesACLInterface, error) {
+	ta := tablesACL{}
+	return &ta, nil
+}
+func (bdm mockMySQLDBManager) GetMultiSigTransactionsObject() (MultiSigTransactionsInterface, error) {
+	mt := multiSigTransactions{}
+	return &mt, nil
+}
 func (bdm mockMySQLDBManager) GetLockerObject() DatabaseLocker {
 	return nil
 }
@@ -85,15 +100,35 @@ func (bdm mockMySQLDBManager) ExecuteSQLExplain(sql string) (SQLExplainInfo, err
 	}
 	return SQLExplainInfo{}, nil
 }
-func (bdm mockMySQLDBManager) ExecuteSQLPrimaryKey(table string) (column string, err error) {
+func (bdm mockMySQLDBManager) ExecuteSQLPrimaryKey(table string) (columns []string, err error) {
 
-	return bdm.KeyColumn, nil
+	return bdm.KeyColumns, nil
 }
 
 func (bdm mockMySQLDBManager) ExecuteSQLSelectRow(sqlcommand string) (data map[string]string, err error) {
 	return
 }
 
+func (bdm mockMySQLDBManager) ExecuteSQLSelectRows(sqlcommand string) (data []map[string]string, err error) {
+	return
+}
+
 func (bdm mockMySQLDBManager) ExecuteSQLNextKeyValue(table string) (string, error) {
 	return "", nil
 }
+
+func (bdm mockMySQLDBManager) ExecuteSQLTableCreate(table string) (string, error) {
+	return "", nil
+}
+
+func (bdm mockMySQLDBManager) ExecuteSQLTableColumns(table string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (bdm mockMySQLDBManager) ExecuteSQLColumnType(table string, column string) (string, error) {
+	return "", nil
+}
+
+func (bdm mockMySQLDBManager) ExecuteSQLTableDump(table string) (string, []string, error) {
+	return "", []string{}, nil
+}
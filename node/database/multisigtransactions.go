@@ -0,0 +1,45 @@
+package database
+
+const multiSigTransactionsTable = "multisigtransactions"
+
+// SQL transactions which wait for more signatures before they go to the pool
+type multiSigTransactions struct {
+	DB        *MySQLDB
+	tableName string
+}
+
+func (mt *multiSigTransactions) getTableName() string {
+	if mt.tableName == "" {
+		mt.tableName = mt.DB.tablesPrefix + multiSigTransactionsTable
+	}
+	return mt.tableName
+}
+
+// Init DB. create table
+func (mt *multiSigTransactions) InitDB() error {
+	return mt.DB.CreateTable(mt.getTableName(), "VARBINARY(100)", "LONGBLOB")
+}
+
+func (mt *multiSigTransactions) TruncateDB() error {
+	return mt.DB.Truncate(mt.getTableName())
+}
+
+// execute functon for each key/value in the table
+func (mt *multiSigTransactions) ForEach(callback ForEachKeyIteratorInterface) error {
+	return mt.DB.forEachInTable(mt.getTableName(), callback)
+}
+
+// returns transaction by ID if it exists
+func (mt *multiSigTransactions) GetTransaction(txID []byte) ([]byte, error) {
+	return mt.DB.Get(mt.getTableName(), txID)
+}
+
+// Add or replace transaction record
+func (mt *multiSigTransactions) PutTransaction(txID []byte, txdata []byte) error {
+	return mt.DB.Put(mt.getTableName(), txID, txdata)
+}
+
+// delete transation from DB
+func (mt *multiSigTransactions) DeleteTransaction(txID []byte) error {
+	return mt.DB.Delete(mt.getTableName(), txID)
+}
@@ -0,0 +1,40 @@
+package database
+
+const tablesACLTable = "tablesacl"
+
+type tablesACL struct {
+	DB             *MySQLDB
+	tablesACLTable string
+}
+
+func (ta *tablesACL) getTablesACLTable() string {
+	if ta.tablesACLTable == "" {
+		ta.tablesACLTable = ta.DB.tablesPrefix + tablesACLTable
+	}
+	return ta.tablesACLTable
+}
+
+// Init database
+func (ta *tablesACL) InitDB() error {
+	return ta.DB.CreateTable(ta.getTablesACLTable(), "VARBINARY(300)", "BLOB")
+}
+
+// transacet tables
+func (ta *tablesACL) TruncateDB() error {
+	return ta.DB.Truncate(ta.getTablesACLTable())
+}
+
+// Save permissions of a table
+func (ta *tablesACL) PutTableACL(table []byte, acl []byte) error {
+	return ta.DB.Put(ta.getTablesACLTable(), table, acl)
+}
+
+// Get permissions of a table. nil if a table has no permissions
+func (ta *tablesACL) GetTableACL(table []byte) ([]byte, error) {
+	return ta.DB.Get(ta.getTablesACLTable(), table)
+}
+
+// Delete permissions of a table
+func (ta *tablesACL) DeleteTableACL(table []byte) error {
+	return ta.DB.Delete(ta.getTablesACLTable(), table)
+}
@@ -1,5 +1,12 @@
 package database
 
+import "strings"
+
 func Quote(s string) string {
 	return s
 }
+
+// Quote a table or a column name with backticks. Backticks inside a name are doubled
+func QuoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
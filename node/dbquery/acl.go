@@ -0,0 +1,451 @@
+package dbquery
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/oursql/lib"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/database"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Table permissions. A table gets an owner when it is created, it is an address of a TX signer.
+// The owner can do anything with a table and grants or revokes permissions of other addresses
+// with GRANT and REVOKE queries. These queries are part of TXs, they are not executed by a DB server
+// The owner can enable row ownership mode. In this mode only an owner of a row can update or delete it,
+// it is a signer of the row INSERT or an address the row was transferred to
+// NOTE tables without an owner (created before permissions were added) can be updated by anyone
+
+const (
+	PermissionInsert = "INSERT"
+	PermissionUpdate = "UPDATE"
+	PermissionDelete = "DELETE"
+	PermissionDDL    = "DDL" // create, alter, truncate and drop of a table
+)
+
+// all permissions in the order used in queries
+var allPermissions = []string{PermissionInsert, PermissionUpdate, PermissionDelete, PermissionDDL}
+
+type tableACL struct {
+	Owner        string              // address of the owner
+	OwnerTX      []byte              // TX where the table was created and got the owner
+	Permissions  map[string][]string // permissions of other addresses
+	RowOwnership bool                // rows can be updated only by their owners
+}
+
+func (acl tableACL) serialize() ([]byte, error) {
+	var encoded bytes.Buffer
+
+	enc := gob.NewEncoder(&encoded)
+	err := enc.Encode(acl)
+
+	if err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+func deserializeTableACL(data []byte) (*tableACL, error) {
+	acl := tableACL{}
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&acl)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Table permissions decode error: %s", err.Error()))
+	}
+
+	if acl.Permissions == nil {
+		acl.Permissions = map[string][]string{}
+	}
+	return &acl, nil
+}
+
+// Check if an address can do an operation with a table
+func (acl tableACL) allows(address string, permission string) bool {
+	return address == acl.Owner || containsPermission(acl.Permissions[address], permission)
+}
+
+// Permissions from a list which are granted to an address (or not granted if granted is false)
+func (acl tableACL) filterPermissions(address string, permissions []string, granted bool) []string {
+	result := []string{}
+
+	for _, p := range allPermissions {
+		if containsPermission(permissions, p) && containsPermission(acl.Permissions[address], p) == granted {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (acl *tableACL) grant(address string, permissions []string) {
+	granted := []string{}
+
+	// keep same order of permissions on all nodes
+	for _, p := range allPermissions {
+		if containsPermission(permissions, p) || containsPermission(acl.Permissions[address], p) {
+			granted = append(granted, p)
+		}
+	}
+	acl.Permissions[address] = granted
+}
+
+func (acl *tableACL) revoke(address string, permissions []string) {
+	left := []string{}
+
+	for _, p := range acl.Permissions[address] {
+		if !containsPermission(permissions, p) {
+			left = append(left, p)
+		}
+	}
+
+	if len(left) == 0 {
+		delete(acl.Permissions, address)
+		return
+	}
+	acl.Permissions[address] = left
+}
+
+func containsPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Queries that change permissions of a table. Only the owner can execute them
+func isPermissionsQuery(kind string) bool {
+	return kind == lib.QueryKindGrant || kind == lib.QueryKindRevoke || kind == lib.QueryKindRowOwnership
+}
+
+// Permission needed to execute a query of a kind. Empty if a query doesn't update a table
+func permissionForKind(kind string) string {
+	switch kind {
+	case lib.QueryKindInsert:
+		return PermissionInsert
+	case lib.QueryKindUpdate:
+		return PermissionUpdate
+	case lib.QueryKindDelete:
+		return PermissionDelete
+	case lib.QueryKindCreate, lib.QueryKindAlter, lib.QueryKindTruncate, lib.QueryKindDrop:
+		return PermissionDDL
+	}
+	return ""
+}
+
+// Permissions of tables stored in a DB.
+// If overlay is not nil, changes are kept in it and are not saved. It is used to verify TXs of a block
+// before they are executed. Each next TX is checked with changes done by previous TXs
+type tablesPermissions struct {
+	DB      database.DBManager
+	Logger  *utils.LoggerMan
+	overlay map[string]*tableACL
+}
+
+// permissions of a table. nil if a table has no owner
+func (tp tablesPermissions) getACL(table string) (*tableACL, error) {
+	if acl, ok := tp.overlay[table]; ok {
+		if acl == nil {
+			return nil, nil
+		}
+		// a copy, so a caller can change it
+		aclCopy := *acl
+		aclCopy.Permissions = map[string][]string{}
+
+		for address, permissions := range acl.Permissions {
+			aclCopy.Permissions[address] = permissions
+		}
+		return &aclCopy, nil
+	}
+
+	tadb, err := tp.DB.GetTablesACLObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := tadb.GetTableACL([]byte(table))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+	return deserializeTableACL(data)
+}
+
+// save permissions of a table. nil removes them
+func (tp tablesPermissions) putACL(table string, acl *tableACL) error {
+	if tp.overlay != nil {
+		tp.overlay[table] = acl
+		return nil
+	}
+
+	tadb, err := tp.DB.GetTablesACLObject()
+
+	if err != nil {
+		return err
+	}
+
+	if acl == nil {
+		return tadb.DeleteTableACL([]byte(table))
+	}
+
+	data, err := acl.serialize()
+
+	if err != nil {
+		return err
+	}
+	return tadb.PutTableACL([]byte(table), data)
+}
+
+// Check if an address can execute a query
+func (tp tablesPermissions) checkQuery(parsed sqlparser.SQLQueryParserInterface, address string) error {
+	acl, err := tp.getACL(parsed.GetTable())
+
+	if err != nil {
+		return err
+	}
+
+	if isPermissionsQuery(parsed.GetKind()) {
+		if acl == nil {
+			return errors.New(fmt.Sprintf("Table %s has no owner. Permissions can not be changed", parsed.GetTable()))
+		}
+		if acl.Owner != address {
+			return errors.New(fmt.Sprintf("Only the owner of table %s can change its permissions", parsed.GetTable()))
+		}
+		return nil
+	}
+
+	permission := permissionForKind(parsed.GetKind())
+
+	if permission == "" || acl == nil {
+		// first create of a table makes the owner
+		return nil
+	}
+
+	if !acl.allows(address, permission) {
+		return errors.New(fmt.Sprintf("Address %s has no %s permission on table %s", address, permission, parsed.GetTable()))
+	}
+	return nil
+}
+
+// Change permissions after a query from a TX. CREATE sets the owner if a table has no owner yet,
+// GRANT and REVOKE change permissions of an address, ENABLE and DISABLE change row ownership mode
+func (tp tablesPermissions) applyQuery(parsed sqlparser.SQLQueryParserInterface, tx *structures.Transaction) error {
+	switch parsed.GetKind() {
+	case lib.QueryKindCreate:
+		acl, err := tp.getACL(parsed.GetTable())
+
+		if err != nil {
+			return err
+		}
+
+		if acl != nil {
+			// the table was created before and dropped. the owner is same
+			return nil
+		}
+
+		owner, err := utils.PubKeyToAddres(tx.ByPubKey)
+
+		if err != nil {
+			return err
+		}
+
+		acl = &tableACL{Owner: owner, OwnerTX: utils.CopyBytes(tx.GetID()), Permissions: map[string][]string{}}
+
+		return tp.putACL(parsed.GetTable(), acl)
+
+	case lib.QueryKindGrant, lib.QueryKindRevoke, lib.QueryKindRowOwnership:
+		acl, err := tp.getACL(parsed.GetTable())
+
+		if err != nil {
+			return err
+		}
+
+		if acl == nil {
+			return errors.New(fmt.Sprintf("Table %s has no owner. Permissions can not be changed", parsed.GetTable()))
+		}
+
+		permissions, address := parsed.GetPermissionsChange()
+
+		switch parsed.GetKind() {
+		case lib.QueryKindGrant:
+			acl.grant(address, permissions)
+		case lib.QueryKindRevoke:
+			acl.revoke(address, permissions)
+		default:
+			acl.RowOwnership = parsed.GetRowOwnershipChange()
+		}
+		return tp.putACL(parsed.GetTable(), acl)
+	}
+	return nil
+}
+
+// Cancel permissions changes of a query from a TX. Rollback of GRANT, REVOKE, ENABLE and DISABLE is opposite query.
+// Rollback of CREATE removes the owner if it was set by this TX
+func (tp tablesPermissions) rollbackQuery(parsed sqlparser.SQLQueryParserInterface, rollbackSQL []byte, tx *structures.Transaction) error {
+	if parsed.GetKind() == lib.QueryKindCreate {
+		acl, err := tp.getACL(parsed.GetTable())
+
+		if err != nil {
+			return err
+		}
+
+		if acl == nil || bytes.Compare(acl.OwnerTX, tx.GetID()) != 0 {
+			return nil
+		}
+		return tp.putACL(parsed.GetTable(), nil)
+	}
+
+	rollbackParsed := sqlparser.NewSqlParser()
+
+	err := rollbackParsed.Parse(string(rollbackSQL))
+
+	if err != nil {
+		return err
+	}
+	return tp.applyQuery(rollbackParsed, tx)
+}
+
+// Set permissions of tables from a genesis config. It is done when a blockchain is created, before any TX.
+// The owner from a config is not removed by a rollback of CREATE TABLE
+func InitTablesPermissions(DB database.DBManager, Logger *utils.LoggerMan, acls map[string]config.TableACL) error {
+	tp := tablesPermissions{DB, Logger, nil}
+
+	for table, tableConfig := range acls {
+		acl := &tableACL{Owner: tableConfig.Owner, OwnerTX: []byte{}, Permissions: map[string][]string{}}
+
+		for address, permissions := range tableConfig.Permissions {
+			for _, p := range permissions {
+				if !containsPermission(allPermissions, p) {
+					return errors.New(fmt.Sprintf("Unknown permission %s of table %s", p, table))
+				}
+			}
+			acl.grant(address, permissions)
+		}
+		acl.RowOwnership = tableConfig.RowOwnership
+
+		Logger.Trace.Printf("Set owner %s of table %s from genesis config", acl.Owner, table)
+
+		// a table can be with a database name in a config
+		err := tp.putACL(utils.TableNameWithoutSchema(table), acl)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check if rows of a table can be updated only by their owners
+func (tp tablesPermissions) IsRowOwnershipEnabled(table string) (bool, error) {
+	acl, err := tp.getACL(table)
+
+	if err != nil || acl == nil {
+		return false, err
+	}
+	return acl.RowOwnership, nil
+}
+
+// Check if a query can be executed by a pubkey
+func (tp tablesPermissions) CheckQuery(parsed QueryParsed, pubKey []byte) error {
+	address, err := utils.PubKeyToAddres(pubKey)
+
+	if err != nil {
+		return err
+	}
+	return tp.checkQuery(parsed.Structure, address)
+}
+
+// Check if all queries of a TX can be executed by its signer.
+// Permissions changes of the TX are applied, so next TXs are checked with them
+func (tp tablesPermissions) CheckTransaction(tx *structures.Transaction) error {
+	if len(tx.ByPubKey) == 0 {
+		return errors.New("SQL transaction has no signer")
+	}
+
+	address, err := utils.PubKeyToAddres(tx.ByPubKey)
+
+	if err != nil {
+		return err
+	}
+
+	for _, sqlUpdate := range tx.GetSQLUpdates() {
+		parsed := sqlparser.NewSqlParser()
+
+		err = parsed.Parse(string(sqlUpdate.Query))
+
+		if err != nil {
+			return err
+		}
+
+		err = tp.checkQuery(parsed, address)
+
+		if err != nil {
+			return err
+		}
+
+		err = tp.applyQuery(parsed, tx)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply permissions changes of a TX without a check. It is used for TXs which were checked in a block before
+func (tp tablesPermissions) ApplyTransaction(tx *structures.Transaction) error {
+	for _, sqlUpdate := range tx.GetSQLUpdates() {
+		parsed := sqlparser.NewSqlParser()
+
+		err := parsed.Parse(string(sqlUpdate.Query))
+
+		if err != nil {
+			return err
+		}
+
+		err = tp.applyQuery(parsed, tx)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel permissions changes of a TX. Queries are rolled back in reverse order
+func (tp tablesPermissions) RollbackTransaction(tx *structures.Transaction) error {
+	sqlUpdates := tx.GetSQLUpdates()
+
+	for i := len(sqlUpdates) - 1; i >= 0; i-- {
+		parsed := sqlparser.NewSqlParser()
+
+		err := parsed.Parse(string(sqlUpdates[i].Query))
+
+		if err != nil {
+			return err
+		}
+
+		if !isPermissionsQuery(parsed.GetKind()) && parsed.GetKind() != lib.QueryKindCreate {
+			continue
+		}
+
+		err = tp.rollbackQuery(parsed, sqlUpdates[i].RollbackQuery, tx)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
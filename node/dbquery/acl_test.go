@@ -0,0 +1,167 @@
+package dbquery
+
+import (
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/config"
+	"github.com/gelembjuk/oursql/node/database"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Tables permissions in memory
+type tablesACLStorage struct {
+	data map[string][]byte
+}
+
+func (s *tablesACLStorage) InitDB() error     { return nil }
+func (s *tablesACLStorage) TruncateDB() error { return nil }
+func (s *tablesACLStorage) PutTableACL(table []byte, acl []byte) error {
+	s.data[string(table)] = acl
+	return nil
+}
+func (s *tablesACLStorage) GetTableACL(table []byte) ([]byte, error) {
+	return s.data[string(table)], nil
+}
+func (s *tablesACLStorage) DeleteTableACL(table []byte) error {
+	delete(s.data, string(table))
+	return nil
+}
+
+type aclDBManager struct {
+	database.DBManager
+	acl    *tablesACLStorage
+	config database.DatabaseConfig
+}
+
+func (bdm aclDBManager) GetTablesACLObject() (database.TablesACLInterface, error) {
+	return bdm.acl, nil
+}
+
+func (bdm aclDBManager) GetConfig() database.DatabaseConfig {
+	return bdm.config
+}
+
+func TestPermissionsOfTableWithDatabase(t *testing.T) {
+	owner := remoteclient.Wallet{}
+	owner.MakeWallet()
+	other := remoteclient.Wallet{}
+	other.MakeWallet()
+
+	ownerAddress, _ := utils.PubKeyToAddres(owner.GetPublicKey())
+
+	DBM := database.GetDBManagerMock()
+	db := aclDBManager{&DBM, &tablesACLStorage{map[string][]byte{}}, database.DatabaseConfig{DatabaseName: "mydb"}}
+
+	// a table in a config is with a database name
+	err := InitTablesPermissions(db, utils.CreateLogger(), map[string]config.TableACL{"mydb.Items": config.TableACL{Owner: ownerAddress, RowOwnership: true}})
+
+	if err != nil {
+		t.Fatalf("Permissions init error: %s", err.Error())
+	}
+
+	checker := NewPermissionsChecker(db, utils.CreateLogger())
+
+	// same table with a database or without it
+	for _, sql := range []string{"UPDATE mydb.Items SET a=1 WHERE id=1", "UPDATE `Items` SET a=1 WHERE id=1"} {
+		parsed := QueryParsed{Structure: sqlparser.NewSqlParser()}
+
+		if err := parsed.Structure.Parse(sql); err != nil {
+			t.Fatalf("Parse error: %s", err.Error())
+		}
+
+		if err := checker.CheckQuery(parsed, owner.GetPublicKey()); err != nil {
+			t.Fatalf("Owner must update the table: %s for %s", err.Error(), sql)
+		}
+
+		if err := checker.CheckQuery(parsed, other.GetPublicKey()); err == nil {
+			t.Fatalf("Other address must not update the table with %s", sql)
+		}
+
+		if enabled, _ := checker.IsRowOwnershipEnabled(parsed.Structure.GetTable()); !enabled {
+			t.Fatalf("Row ownership must be enabled for %s", sql)
+		}
+	}
+
+	// name of a table is case sensitive
+	if enabled, _ := checker.IsRowOwnershipEnabled("items"); enabled {
+		t.Fatalf("Other table must not have permissions")
+	}
+
+	// tables of other databases are not replicated
+	qp := NewQueryProcessor(db, utils.CreateLogger())
+
+	if _, err := qp.ParseQuery("UPDATE otherdb.Items SET a=1 WHERE id=1"); err == nil {
+		t.Fatalf("Query to other database must be refused")
+	}
+}
+
+func TestPermissionsRollbackOfTransaction(t *testing.T) {
+	owner := remoteclient.Wallet{}
+	owner.MakeWallet()
+
+	DBM := database.GetDBManagerMock()
+	db := aclDBManager{&DBM, &tablesACLStorage{map[string][]byte{}}, database.DatabaseConfig{}}
+
+	logger := utils.CreateLogger()
+
+	err := InitTablesPermissions(db, logger, map[string]config.TableACL{"t": config.TableACL{Owner: "1Owner"}})
+
+	if err != nil {
+		t.Fatalf("Permissions init error: %s", err.Error())
+	}
+
+	// TXs from a pool. their changes are saved in a DB
+	grantTX, _ := structures.NewSQLTransaction(structures.NewSQLUpdate("GRANT UPDATE ON t TO '1Abc'", "t:*", "REVOKE UPDATE ON t FROM '1Abc'"), nil, nil)
+	grantTX.ByPubKey = owner.GetPublicKey()
+
+	createTX, _ := structures.NewSQLTransaction(structures.NewSQLUpdate("CREATE TABLE n (id int)", "n:*", "DROP TABLE n"), nil, nil)
+	createTX.ByPubKey = owner.GetPublicKey()
+	createTX.ID = []byte("create")
+
+	for _, tx := range []*structures.Transaction{grantTX, createTX} {
+		if err := NewQueryProcessor(db, logger).ApplyPermissionsFromTX(tx); err != nil {
+			t.Fatalf("Permissions error: %s", err.Error())
+		}
+	}
+
+	update := sqlparser.NewSqlParser()
+	update.Parse("UPDATE t SET a=1 WHERE id=1")
+
+	checker := tablesPermissions{db, logger, map[string]*tableACL{}}
+
+	if err := checker.checkQuery(update, "1Abc"); err != nil {
+		t.Fatalf("Permission must be granted: %s", err.Error())
+	}
+
+	// permissions before the pool
+	for _, tx := range []*structures.Transaction{createTX, grantTX} {
+		if err := checker.RollbackTransaction(tx); err != nil {
+			t.Fatalf("Rollback error: %s", err.Error())
+		}
+	}
+
+	if err := checker.checkQuery(update, "1Abc"); err == nil {
+		t.Fatalf("Permission must be revoked by a rollback")
+	}
+
+	if acl, _ := checker.getACL("n"); acl != nil {
+		t.Fatalf("Table must have no owner after a rollback of CREATE")
+	}
+
+	// a DB is not changed
+	if err := NewPermissionsChecker(db, logger).(*tablesPermissions).checkQuery(update, "1Abc"); err != nil {
+		t.Fatalf("Permission must be kept in a DB: %s", err.Error())
+	}
+
+	// TX of other branch
+	if err := checker.ApplyTransaction(grantTX); err != nil {
+		t.Fatalf("Apply error: %s", err.Error())
+	}
+
+	if err := checker.checkQuery(update, "1Abc"); err != nil {
+		t.Fatalf("Permission must be granted again: %s", err.Error())
+	}
+}
@@ -8,12 +8,15 @@ import (
 
 type QueryProcessorInterface interface {
 	ParseQuery(sqlquery string) (QueryParsed, error)
+	ParseQueryInContext(sqlquery string, ctx QueryContext) (QueryParsed, error)
 	ExecuteQuery(sql string) (*structures.SQLUpdate, error)
 	ExecuteParsedQuery(qp QueryParsed) (*structures.SQLUpdate, error)
-	ExecuteQueryFromTX(sql structures.SQLUpdate) error
-	ExecuteRollbackQueryFromTX(sql structures.SQLUpdate) error
+	ExecuteQueryFromTX(sql structures.SQLUpdate, tx *structures.Transaction) error
+	ExecuteRollbackQueryFromTX(sql structures.SQLUpdate, tx *structures.Transaction) error
+	ApplyPermissionsFromTX(tx *structures.Transaction) error
 	FormatSpecialErrorMessage(errorKind uint, txdata []byte, datatosign []byte) (string, uint16, error)
 	MakeSQLUpdateStructure(parsed QueryParsed) (structures.SQLUpdate, error)
+	MakeSQLUpdateStructures(parsed QueryParsed) ([]structures.SQLUpdate, error)
 }
 
 type SQLUpdateInterface interface {
@@ -23,6 +26,22 @@ type SQLUpdateInterface interface {
 	RequiresBaseTransation() bool
 }
 
+// Checks if a signer can execute queries. One checker must be used to check TXs of a block in the order,
+// each TX is checked with permissions changes of previous TXs
+type PermissionsCheckerInterface interface {
+	CheckQuery(parsed QueryParsed, pubKey []byte) error
+	CheckTransaction(tx *structures.Transaction) error
+	ApplyTransaction(tx *structures.Transaction) error
+	RollbackTransaction(tx *structures.Transaction) error
+	IsRowOwnershipEnabled(table string) (bool, error)
+}
+
+// Server connection of a client session. Rows are read in a transaction of the client, so changes
+// of previous queries of the transaction are visible
+type SessionConnection interface {
+	ExecuteSQLSelectRows(sqlcommand string) ([]map[string]string, error)
+}
+
 func NewQueryProcessor(DB database.DBManager, Logger *utils.LoggerMan) QueryProcessorInterface {
 	return &queryProcessor{DB, Logger}
 }
@@ -38,3 +57,7 @@ func NewSQLUpdateManager(SQLUpdate structures.SQLUpdate) (SQLUpdateInterface, er
 
 	return &o, nil
 }
+
+func NewPermissionsChecker(DB database.DBManager, Logger *utils.LoggerMan) PermissionsCheckerInterface {
+	return &tablesPermissions{DB, Logger, map[string]*tableACL{}}
+}
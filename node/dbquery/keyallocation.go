@@ -0,0 +1,138 @@
+package dbquery
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gelembjuk/oursql/node/database"
+)
+
+// Assign key values to rows inserted without a key. Values are put to a query, so a TX
+// and a DB of this node get same values. Strategy is set per table in a config
+func (qp queryProcessor) allocateKeyValues(parsed *QueryParsed, column string, count int, ctx QueryContext) ([]string, error) {
+	table := parsed.Structure.GetTable()
+	dbconfig := qp.DB.GetConfig()
+	keyConfig := dbconfig.GetTableKeyConfig(table)
+
+	switch keyConfig.Strategy {
+	case database.KeyStrategyHash:
+		colType, err := qp.DB.QM().ExecuteSQLColumnType(parsed.Structure.GetQuotedTable(), column)
+
+		if err != nil {
+			return nil, err
+		}
+		if len(ctx.Nonce) == 0 {
+			return nil, errors.New("Random nonce for hash keys is not made")
+		}
+
+		// a key of a signer is from a query comment or from a context
+		pubKey := parsed.PubKey
+
+		if len(pubKey) == 0 {
+			pubKey = ctx.PubKey
+		}
+		return makeHashKeyValues(parsed.Structure.GetCanonicalQuery(), ctx.Time, pubKey, ctx.Nonce, colType, count)
+
+	case database.KeyStrategyPartitioned:
+		if keyConfig.Step < 2 || keyConfig.Offset < 0 || keyConfig.Offset >= keyConfig.Step {
+			return nil, errors.New(fmt.Sprintf("Wrong partitioned key config for table %s. Offset must be from 0 to Step-1", table))
+		}
+
+	case database.KeyStrategyAutoIncrement, "":
+		keyConfig.Step = 1
+		keyConfig.Offset = 0
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown key strategy %s for table %s", keyConfig.Strategy, table))
+	}
+
+	nextIDStr, err := qp.DB.QM().ExecuteSQLNextKeyValue(table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if nextIDStr == "" {
+		return nil, errors.New("Can not build reference ID for inserted row. Table has no auto_increment key")
+	}
+
+	nextID, err := strconv.ParseInt(nextIDStr, 10, 64)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return makeSequenceKeyValues(nextID, int64(keyConfig.Step), int64(keyConfig.Offset), count), nil
+}
+
+// count values of a sequence starting from next. All values have value % step == offset
+func makeSequenceKeyValues(next int64, step int64, offset int64, count int) []string {
+	if d := (offset - next%step + step) % step; d > 0 {
+		next = next + d
+	}
+
+	values := []string{}
+
+	for i := 0; i < count; i++ {
+		values = append(values, strconv.FormatInt(next, 10))
+		next = next + step
+	}
+	return values
+}
+
+var columnTypeRegexp = regexp.MustCompile(`^([a-z]+)(?:\((\d+)\))?`)
+
+// Key values from a hash of a query, its time, a key of a signer and a random nonce of a TX, so same query
+// from other signer or other node gets other keys. Value is a number or a hex string, it depends on column type
+func makeHashKeyValues(sqlquery string, time int64, pubKey []byte, nonce []byte, colType string, count int) ([]string, error) {
+	m := columnTypeRegexp.FindStringSubmatch(strings.ToLower(colType))
+
+	if m == nil {
+		return nil, errors.New(fmt.Sprintf("Unknown column type %s", colType))
+	}
+
+	// length of a hex string. 0 for bigint column
+	length := 0
+
+	switch m[1] {
+	case "char", "varchar", "binary", "varbinary":
+		length, _ = strconv.Atoi(m[2])
+
+		if length < 16 {
+			return nil, errors.New(fmt.Sprintf("Column type %s is too short for hash keys. At least 16 chars are needed", colType))
+		}
+		if length > sha256.Size*2 {
+			length = sha256.Size * 2
+		}
+	case "bigint":
+	default:
+		// smaller numbers have too big chance of collisions
+		return nil, errors.New(fmt.Sprintf("Hash keys are not supported for column type %s. Use bigint or char(16) and longer", colType))
+	}
+
+	values := []string{}
+
+	for i := 0; i < count; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%d:%x:%x:%s", time, i, pubKey, nonce, sqlquery)))
+
+		if length > 0 {
+			values = append(values, hex.EncodeToString(hash[:])[:length])
+			continue
+		}
+		// positive value of signed bigint
+		value := binary.BigEndian.Uint64(hash[:8]) >> 1
+
+		if value == 0 {
+			// 0 makes auto_increment column to generate a value
+			value = 1
+		}
+		values = append(values, strconv.FormatUint(value, 10))
+	}
+	return values, nil
+}
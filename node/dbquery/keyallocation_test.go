@@ -0,0 +1,50 @@
+package dbquery
+
+import (
+	"testing"
+)
+
+func TestHashKeyValues(t *testing.T) {
+	query := "INSERT INTO t (a) VALUES (1)"
+	nonce := []byte("nonce")
+
+	values1, err := makeHashKeyValues(query, 100, []byte("signer1"), nonce, "bigint(20)", 2)
+
+	if err != nil {
+		t.Fatalf("Hash keys error: %s", err.Error())
+	}
+
+	if len(values1) != 2 || values1[0] == values1[1] {
+		t.Fatalf("Expected 2 different keys, got %v", values1)
+	}
+
+	// same query at same time by other signer
+	values2, _ := makeHashKeyValues(query, 100, []byte("signer2"), nonce, "bigint(20)", 2)
+
+	if values1[0] == values2[0] || values1[1] == values2[1] {
+		t.Fatalf("Keys of other signer must be different, got %v and %v", values1, values2)
+	}
+
+	// same signer in other TX
+	values2, _ = makeHashKeyValues(query, 100, []byte("signer1"), []byte("nonce2"), "bigint(20)", 2)
+
+	if values1[0] == values2[0] {
+		t.Fatalf("Keys of other TX must be different, got %v and %v", values1, values2)
+	}
+
+	values2, _ = makeHashKeyValues(query, 100, []byte("signer1"), nonce, "bigint(20)", 2)
+
+	if values1[0] != values2[0] || values1[1] != values2[1] {
+		t.Fatalf("Same data must give same keys, got %v and %v", values1, values2)
+	}
+
+	hexValues, err := makeHashKeyValues(query, 100, []byte("signer1"), nonce, "char(16)", 1)
+
+	if err != nil || len(hexValues[0]) != 16 {
+		t.Fatalf("Expected hex key of 16 chars, got %v %v", hexValues, err)
+	}
+
+	if _, err := makeHashKeyValues(query, 100, []byte("signer1"), nonce, "int(11)", 1); err == nil {
+		t.Fatalf("Hash keys must not be allowed for int column")
+	}
+}
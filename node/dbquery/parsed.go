@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/gelembjuk/oursql/lib"
 	"github.com/gelembjuk/oursql/node/database"
@@ -16,21 +17,40 @@ type QueryParsed struct {
 	PubKey           []byte
 	Signature        []byte
 	TransactionBytes []byte
-	KeyCol           string
-	KeyVal           string
+	KeyCols          []string // primary key columns. many columns if a key is composite
+	KeyVals          []string // values of key columns in same order
 	RowBeforeQuery   map[string]string
+	TableBeforeQuery string    // CREATE TABLE statement of a table before ALTER
+	TableSnapshot    []byte    // compressed structure and rows of a table before DROP, TRUNCATE or ALTER which changes columns
+	ACLBeforeQuery   *tableACL // permissions of a table before GRANT or REVOKE
 	Structure        sqlparser.SQLQueryParserInterface
+	Rows             []QueryParsed // multi-row query split to single-row queries. Each has own RefID
+	Time             int64         // time used as a value of NOW() and similar functions
+	RewrittenSQL     string        // query changed by a node: function calls replaced by values, key values added, condition by keys of affected rows. empty if not changed
+	InsertID         string        // key value assigned to inserted row. ID of the first row for multi-row insert
 }
 
 func (qp QueryParsed) ReferenceID() string {
-	if qp.Structure.GetKind() == lib.QueryKindCreate ||
-		qp.Structure.GetKind() == lib.QueryKindDrop {
+	if qp.IsTableManage() {
 		return qp.Structure.GetTable() + ":*"
 	}
-	return qp.Structure.GetTable() + ":" + qp.KeyVal
+	if qp.IsPermissionsChange() {
+		return qp.Structure.GetTable() + ":*acl"
+	}
+	return qp.Structure.GetTable() + ":" + qp.GetKeyValue()
 }
+
+// Key value used in RefID. Values of a composite key are joined with comma
 func (qp QueryParsed) GetKeyValue() string {
-	return qp.KeyVal
+	return makeKeyValue(qp.KeyVals)
+}
+
+// Returns list of single-row queries. It is the query itself if this is not multi-row INSERT
+func (qp QueryParsed) GetRowQueries() []QueryParsed {
+	if len(qp.Rows) > 0 {
+		return qp.Rows
+	}
+	return []QueryParsed{qp}
 }
 
 // Info about a parsed query. Check if is select
@@ -38,22 +58,80 @@ func (qp QueryParsed) IsSelect() bool {
 	return qp.Structure.GetKind() == lib.QueryKindSelect
 }
 
-// Info about a parsed query. Check if is update (insert, update, delete, create table, drop table)
+// Info about a parsed query. Check if is update (insert, update, delete, create, alter, truncate and drop table,
+// permissions change and row ownership transfer)
 func (qp QueryParsed) IsUpdate() bool {
 	return qp.Structure.GetKind() == lib.QueryKindCreate ||
 		qp.Structure.GetKind() == lib.QueryKindDrop ||
+		qp.Structure.GetKind() == lib.QueryKindAlter ||
+		qp.Structure.GetKind() == lib.QueryKindTruncate ||
 		qp.Structure.GetKind() == lib.QueryKindDelete ||
 		qp.Structure.GetKind() == lib.QueryKindInsert ||
-		qp.Structure.GetKind() == lib.QueryKindUpdate
+		qp.Structure.GetKind() == lib.QueryKindUpdate ||
+		qp.IsPermissionsChange() ||
+		qp.IsRowOwnershipTransfer()
+}
+
+// Info about a parsed query. Check if it starts a transaction (BEGIN, START TRANSACTION)
+func (qp QueryParsed) IsTransactionBegin() bool {
+	return qp.Structure.GetKind() == lib.QueryKindBegin
+}
+
+// Info about a parsed query. Check if it is COMMIT
+func (qp QueryParsed) IsTransactionCommit() bool {
+	return qp.Structure.GetKind() == lib.QueryKindCommit
+}
+
+// Info about a parsed query. Check if it is ROLLBACK
+func (qp QueryParsed) IsTransactionRollback() bool {
+	return qp.Structure.GetKind() == lib.QueryKindRollback
+}
+
+// Info about a parsed query. Check if it is GRANT or REVOKE of table permissions or change of row ownership mode
+func (qp QueryParsed) IsPermissionsChange() bool {
+	return isPermissionsQuery(qp.Structure.GetKind())
+}
+
+// Info about a parsed query. Check if it is transfer of a row ownership
+func (qp QueryParsed) IsRowOwnershipTransfer() bool {
+	return qp.Structure.GetKind() == lib.QueryKindTransfer
+}
+
+// Info about a parsed query. Check if it works with a table as a whole (create, alter, truncate and drop table)
+func (qp QueryParsed) IsTableManage() bool {
+	return qp.Structure.GetKind() == lib.QueryKindCreate ||
+		qp.Structure.GetKind() == lib.QueryKindDrop ||
+		qp.Structure.GetKind() == lib.QueryKindAlter ||
+		qp.Structure.GetKind() == lib.QueryKindTruncate
 }
 
 // prepares rollback query
 func (qp QueryParsed) buildRollbackSQL() (string, error) {
 	if qp.Structure.GetKind() == lib.QueryKindCreate {
-		return "DROP TABLE " + qp.Structure.GetTable(), nil
+		return "DROP TABLE " + qp.Structure.GetQuotedTable(), nil
 	}
-	if qp.Structure.GetKind() == lib.QueryKindDrop {
-		// no rollback for this operation . this must be processed somehow differently
+	if qp.Structure.GetKind() == lib.QueryKindDrop ||
+		qp.Structure.GetKind() == lib.QueryKindTruncate ||
+		qp.Structure.IsDataLosingAlter() {
+		// rollback is a snapshot of a table. it is not SQL, it is restored in a special way
+		if len(qp.TableSnapshot) == 0 {
+			return "", errors.New("Table snapshot is not created")
+		}
+		return string(qp.TableSnapshot), nil
+	}
+	if qp.Structure.GetKind() == lib.QueryKindAlter {
+		// table structure before ALTER. rollback recreates a table with it and copies data back
+		if qp.TableBeforeQuery == "" {
+			return "", errors.New("Table structure before ALTER is not known")
+		}
+		return qp.TableBeforeQuery, nil
+	}
+	if qp.IsPermissionsChange() {
+
+		return qp.makePermissionsRollback()
+	}
+	if qp.IsRowOwnershipTransfer() {
+		// an owner of a row is found by a chain of TXs. nothing to restore
 		return "", nil
 	}
 	if qp.Structure.GetKind() == lib.QueryKindInsert {
@@ -141,12 +219,17 @@ func (qp QueryParsed) parseInfoFromComments() (PubKey []byte, Signature []byte,
 
 // Build Insert operation rollback
 func (qp QueryParsed) makeInsertRollback() (sql string, err error) {
-	return "DELETE FROM " + qp.Structure.GetTable() + " WHERE " + qp.KeyCol + "='" + database.Quote(qp.KeyVal) + "'", nil
+	condition, err := qp.makeKeyCondition()
+
+	if err != nil {
+		return
+	}
+	return "DELETE FROM " + qp.Structure.GetQuotedTable() + " WHERE " + condition, nil
 }
 
 // Build Update operation rollback
 func (qp QueryParsed) makeUpdateRollback() (sql string, err error) {
-	sql = "UPDATE " + qp.Structure.GetTable() + " SET "
+	sql = "UPDATE " + qp.Structure.GetQuotedTable() + " SET "
 
 	first := true
 
@@ -160,21 +243,27 @@ func (qp QueryParsed) makeUpdateRollback() (sql string, err error) {
 				first = false
 			}
 
-			sql = sql + " " + col + "='" + database.Quote(curVal) + "'"
+			sql = sql + " " + database.QuoteIdentifier(col) + "='" + database.Quote(curVal) + "'"
 		} else {
 			err = errors.New(fmt.Sprintf("Can not find current value for column %s", col))
 			return
 		}
 	}
 
-	sql = sql + " WHERE " + qp.KeyCol + "='" + database.Quote(qp.KeyVal) + "'"
+	condition, err := qp.makeKeyCondition()
+
+	if err != nil {
+		return
+	}
+
+	sql = sql + " WHERE " + condition
 
 	return
 }
 
 // Build Delete operation rollback
 func (qp QueryParsed) makeDeleteRollback() (sql string, err error) {
-	sql = "INSERT INTO " + qp.Structure.GetTable() + " SET "
+	sql = "INSERT INTO " + qp.Structure.GetQuotedTable() + " SET "
 
 	first := true
 
@@ -185,8 +274,88 @@ func (qp QueryParsed) makeDeleteRollback() (sql string, err error) {
 			first = false
 		}
 
-		sql = sql + " " + col + "='" + database.Quote(curVal) + "'"
+		sql = sql + " " + database.QuoteIdentifier(col) + "='" + database.Quote(curVal) + "'"
 	}
 
 	return
 }
+
+// Build GRANT or REVOKE rollback. It is opposite query for permissions which are really changed
+func (qp QueryParsed) makePermissionsRollback() (sql string, err error) {
+	if qp.ACLBeforeQuery == nil {
+		err = errors.New("Table permissions before the query are not known")
+		return
+	}
+
+	if qp.Structure.GetKind() == lib.QueryKindRowOwnership {
+		enable := qp.Structure.GetRowOwnershipChange()
+
+		if enable == qp.ACLBeforeQuery.RowOwnership {
+			err = errors.New(fmt.Sprintf("Row ownership mode of table %s is already set", qp.Structure.GetTable()))
+			return
+		}
+		if enable {
+			sql = "DISABLE ROW OWNERSHIP ON " + qp.Structure.GetQuotedTable()
+		} else {
+			sql = "ENABLE ROW OWNERSHIP ON " + qp.Structure.GetQuotedTable()
+		}
+		return
+	}
+
+	permissions, address := qp.Structure.GetPermissionsChange()
+
+	if address == qp.ACLBeforeQuery.Owner {
+		err = errors.New("The owner of a table has all permissions")
+		return
+	}
+
+	if qp.Structure.GetKind() == lib.QueryKindGrant {
+		granted := qp.ACLBeforeQuery.filterPermissions(address, permissions, false)
+
+		if len(granted) == 0 {
+			err = errors.New(fmt.Sprintf("Address %s already has these permissions", address))
+			return
+		}
+		sql = "REVOKE " + strings.Join(granted, ", ") + " ON " + qp.Structure.GetQuotedTable() + " FROM '" + address + "'"
+		return
+	}
+
+	revoked := qp.ACLBeforeQuery.filterPermissions(address, permissions, true)
+
+	if len(revoked) == 0 {
+		err = errors.New(fmt.Sprintf("Address %s has none of these permissions", address))
+		return
+	}
+	sql = "GRANT " + strings.Join(revoked, ", ") + " ON " + qp.Structure.GetQuotedTable() + " TO '" + address + "'"
+	return
+}
+
+// Build condition to find a row by a key. key1='val1' AND key2='val2' for a composite key
+func (qp QueryParsed) makeKeyCondition() (string, error) {
+	if len(qp.KeyCols) == 0 || len(qp.KeyCols) != len(qp.KeyVals) {
+		return "", errors.New("Primary key of a row is not known")
+	}
+
+	condition := []string{}
+
+	for i, col := range qp.KeyCols {
+		condition = append(condition, database.QuoteIdentifier(col)+"='"+database.Quote(qp.KeyVals[i])+"'")
+	}
+	return strings.Join(condition, " AND "), nil
+}
+
+// Join values of a key to one string. Single value is used as is.
+// For composite keys commas and slashes in values are escaped, so a RefID is unique
+func makeKeyValue(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	escaped := []string{}
+
+	for _, v := range values {
+		v = strings.Replace(v, "\\", "\\\\", -1)
+		escaped = append(escaped, strings.Replace(v, ",", "\\,", -1))
+	}
+	return strings.Join(escaped, ",")
+}
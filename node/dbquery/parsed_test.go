@@ -0,0 +1,88 @@
+package dbquery
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+)
+
+// parse a query as a node does it for a TX. Key and a row before the query are set like patchRowInfo does
+func makeParsedRow(t *testing.T, sql string, keyCols []string, keyVals []string, row map[string]string) QueryParsed {
+	parsed := QueryParsed{KeyCols: keyCols, KeyVals: keyVals, RowBeforeQuery: row}
+	parsed.Structure = sqlparser.NewSqlParser()
+
+	if err := parsed.Structure.Parse(sql); err != nil {
+		t.Fatalf("Parse error: %s for %s", err.Error(), sql)
+	}
+	return parsed
+}
+
+func TestRollbackQuotedColumns(t *testing.T) {
+	row := map[string]string{"id": "1", "order": "o1", "a b": "x"}
+
+	// query => kind of rollback and columns it sets
+	sqls := map[string][]string{
+		"UPDATE `my table` SET `order`='2', `a b`='y' WHERE `id`=1":          []string{"update", "order", "a b"},
+		"DELETE FROM `my table` WHERE `id`=1":                                []string{"insert", "id", "order", "a b"},
+		"INSERT INTO `my table` (`id`, `order`, `a b`) VALUES (1, '2', 'y')": []string{"delete"}}
+
+	for sql, res := range sqls {
+		parsed := makeParsedRow(t, sql, []string{"id"}, []string{"1"}, row)
+
+		rollback, err := parsed.buildRollbackSQL()
+
+		if err != nil {
+			t.Fatalf("Rollback error: %s for %s", err.Error(), sql)
+		}
+
+		// rollback must be a valid query for the same table and same row
+		p := sqlparser.NewSqlParser()
+
+		if err := p.Parse(rollback); err != nil {
+			t.Fatalf("Rollback %s is not valid: %s", rollback, err.Error())
+		}
+
+		if p.GetKind() != res[0] || p.GetQuotedTable() != "`my table`" {
+			t.Fatalf("Wrong rollback %s for %s", rollback, sql)
+		}
+
+		if res[0] != "insert" {
+			if vals, ok := p.GetKeyCondition([]string{"id"}); !ok || vals[0] != "1" {
+				t.Fatalf("Rollback %s must have key condition", rollback)
+			}
+		}
+
+		for _, col := range res[1:] {
+			if p.GetUpdateColumns()[col] != row[col] {
+				t.Fatalf("Rollback %s must set %s to %s", rollback, col, row[col])
+			}
+		}
+	}
+}
+
+func TestKeyConditionQuotedColumns(t *testing.T) {
+	parsed := makeParsedRow(t, "delete from t where `order`=1 and `a b`=2", []string{"order", "a b"}, []string{"1", "2"}, nil)
+
+	condition, err := parsed.makeKeyCondition()
+
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	if condition != "`order`='1' AND `a b`='2'" {
+		t.Fatalf("Unexpected condition %s", condition)
+	}
+
+	p := sqlparser.NewSqlParser()
+
+	if err := p.Parse("SELECT * FROM t WHERE " + condition); err != nil {
+		t.Fatalf("Condition %s is not valid: %s", condition, err.Error())
+	}
+
+	vals, ok := parsed.Structure.GetKeyCondition(parsed.KeyCols)
+
+	if !ok || !reflect.DeepEqual(vals, parsed.KeyVals) {
+		t.Fatalf("Key values are not found in %s", parsed.Structure.GetCanonicalQuery())
+	}
+}
@@ -0,0 +1,150 @@
+package dbquery
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+)
+
+// Values of functions which results depend on a moment of execution or a state of a server.
+// Calls of such functions are replaced with literals on a node where a query is received,
+// so all other nodes execute exactly same query
+// Rows before a query are read with a connection of a client session if a query is from a session
+type QueryContext struct {
+	Time         int64             // unix time in nanoseconds. It is used as a time of a TX too
+	LastInsertID string            // result of LAST_INSERT_ID() in a client session. empty if not known
+	Connection   SessionConnection // nil if a query is not from a client session
+	PubKey       []byte            // key of a TX signer if it is known before a TX is made
+	Nonce        []byte            // random bytes of a TX. hash keys of inserted rows depend on them
+}
+
+// Context of a query executed now
+func NewQueryContext() QueryContext {
+	ctx := QueryContext{Time: time.Now().UTC().UnixNano()}
+	// empty nonce is refused when it is used
+	ctx.Nonce, _ = randomBytes(16)
+
+	return ctx
+}
+
+// Replace non-deterministic function calls in a query with values.
+// Returns true if the query was changed
+func (c QueryContext) replaceFunctionCalls(structure sqlparser.SQLQueryParserInterface) (bool, error) {
+	return structure.ReplaceFunctionCalls(c.functionValue)
+}
+
+// Value of a function call as SQL literal. Empty string if a call doesn't need to be replaced
+// NOTE all time functions use UTC. RAND() gets one value for all rows of a query
+func (c QueryContext) functionValue(name string, args []string) (string, error) {
+	t := time.Unix(0, c.Time).UTC()
+
+	switch name {
+	case "NOW", "CURRENT_TIMESTAMP", "LOCALTIME", "LOCALTIMESTAMP", "SYSDATE", "UTC_TIMESTAMP":
+		return formatTimeValue(t, "2006-01-02 15:04:05", args)
+
+	case "CURDATE", "CURRENT_DATE", "UTC_DATE":
+		return "'" + t.Format("2006-01-02") + "'", nil
+
+	case "CURTIME", "CURRENT_TIME", "UTC_TIME":
+		return formatTimeValue(t, "15:04:05", args)
+
+	case "UNIX_TIMESTAMP":
+		if len(args) > 0 {
+			// time of a given date
+			return "", nil
+		}
+		return strconv.FormatInt(t.Unix(), 10), nil
+
+	case "UUID":
+		b, err := randomBytes(16)
+
+		if err != nil {
+			return "", err
+		}
+		// version 4, variant RFC 4122
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+
+		return fmt.Sprintf("'%x-%x-%x-%x-%x'", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+
+	case "UUID_SHORT":
+		b, err := randomBytes(8)
+
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatUint(binary.BigEndian.Uint64(b)>>1, 10), nil
+
+	case "RAND":
+		if len(args) > 0 {
+			// with a seed it returns same value everywhere
+			return "", nil
+		}
+		b, err := randomBytes(8)
+
+		if err != nil {
+			return "", err
+		}
+		// 53 bits is a precision of a double
+		return strconv.FormatFloat(float64(binary.BigEndian.Uint64(b)>>11)/float64(uint64(1)<<53), 'f', -1, 64), nil
+
+	case "LAST_INSERT_ID":
+		if len(args) > 0 {
+			// this sets a value, it is not a read of a server state
+			return "", nil
+		}
+		if c.LastInsertID == "" {
+			return "", errors.New("LAST_INSERT_ID() value is not known on this node. Use the key value explicitly")
+		}
+		return "'" + c.LastInsertID + "'", nil
+
+	case "CONNECTION_ID", "ROW_COUNT", "FOUND_ROWS", "SLEEP", "GET_LOCK", "RELEASE_LOCK":
+		return "", errors.New(fmt.Sprintf("Function %s() is not supported in update queries", name))
+	}
+	return "", nil
+}
+
+// Time literal with fractional seconds precision given as an argument
+func formatTimeValue(t time.Time, layout string, args []string) (string, error) {
+	if len(args) > 1 {
+		return "", errors.New("Wrong number of arguments for a time function")
+	}
+	if len(args) == 1 {
+		fsp, err := strconv.Atoi(args[0])
+
+		if err != nil || fsp < 0 || fsp > 6 {
+			return "", errors.New(fmt.Sprintf("Wrong fractional seconds precision %s", args[0]))
+		}
+		if fsp > 0 {
+			layout = layout + "." + "000000"[:fsp]
+		}
+	}
+	return "'" + t.Format(layout) + "'", nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Callback to find non-deterministic calls in a query from a TX. Such query must not be executed,
+// result would be different on each node
+func checkDeterministicCall(name string, args []string) (string, error) {
+	value, err := QueryContext{Time: 1, LastInsertID: "1"}.functionValue(name, args)
+
+	if err == nil && value != "" {
+		err = errors.New(fmt.Sprintf("Function %s() has different results on different nodes", name))
+	}
+	return "", err
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/gelembjuk/oursql/lib"
 	"github.com/gelembjuk/oursql/lib/utils"
@@ -19,6 +20,11 @@ type queryProcessor struct {
 
 // checks if this query is syntax correct , return altered query if needed
 func (qp queryProcessor) ParseQuery(sqlquery string) (r QueryParsed, err error) {
+	return qp.ParseQueryInContext(sqlquery, NewQueryContext())
+}
+
+// same as ParseQuery. Calls like NOW() or UUID() are replaced with values from the context
+func (qp queryProcessor) ParseQueryInContext(sqlquery string, ctx QueryContext) (r QueryParsed, err error) {
 	r.Structure = sqlparser.NewSqlParser()
 
 	err = r.Structure.Parse(sqlquery)
@@ -27,6 +33,28 @@ func (qp queryProcessor) ParseQuery(sqlquery string) (r QueryParsed, err error)
 		return
 	}
 
+	// permissions and references are by a table without a database. tables of other databases are not replicated
+	if r.Structure.GetSchema() != "" && r.Structure.GetSchema() != qp.DB.GetConfig().DatabaseName {
+		err = errors.New(fmt.Sprintf("Table of other database %s can not be used", r.Structure.GetSchema()))
+		return
+	}
+
+	r.Time = ctx.Time
+
+	if r.Structure.IsTableDataUpdate() {
+		// such calls give different results on other nodes. TX must contain values
+		var replaced bool
+		replaced, err = ctx.replaceFunctionCalls(r.Structure)
+
+		if err != nil {
+			return
+		}
+
+		if replaced {
+			r.RewrittenSQL = r.Structure.GetCanonicalQuery()
+		}
+	}
+
 	// check syntax
 	err = qp.checkQuerySyntax(r.Structure)
 
@@ -34,14 +62,35 @@ func (qp queryProcessor) ParseQuery(sqlquery string) (r QueryParsed, err error)
 		return
 	}
 
-	// this will extract key column, its value, check if it is present
-	err = qp.patchRowInfo(&r)
+	// a signer key is needed to make keys of inserted rows
+	r.PubKey, r.Signature, r.TransactionBytes, err = r.parseInfoFromComments()
 
 	if err != nil {
 		return
 	}
 
-	r.PubKey, r.Signature, r.TransactionBytes, err = r.parseInfoFromComments()
+	if r.Structure.GetKind() == lib.QueryKindInsert {
+		// rows without a key get key values now
+		err = qp.patchInsertKey(&r, ctx)
+
+		if err != nil {
+			return
+		}
+	}
+
+	rows, err := r.Structure.SplitInsertRows()
+
+	if err != nil {
+		return
+	}
+
+	if len(rows) > 1 {
+		// multi-row insert. each row will have own RefID
+		err = qp.patchInsertRowsInfo(&r, rows)
+	} else {
+		// this will extract key column, its value, check if it is present
+		err = qp.patchRowInfo(&r, ctx)
+	}
 
 	if err != nil {
 		return
@@ -70,89 +119,387 @@ func (qp queryProcessor) checkQuerySyntax(sqlparsed sqlparser.SQLQueryParserInte
 
 // return info for a row that will be affected by a query. If that is update or delete
 // return a row
-// if it is insert, try to get next autoincrement
-func (qp queryProcessor) patchRowInfo(parsed *QueryParsed) (err error) {
+// if it is insert, get key values of a row
+// if it is alter, get current table structure. if alter changes or drops columns, make a snapshot of a table
+// if it is drop or truncate, make a snapshot of a table
+// if it is grant or revoke, get current permissions of a table
+// if it is transfer of a row ownership, find a row by a key
+func (qp queryProcessor) patchRowInfo(parsed *QueryParsed, ctx QueryContext) (err error) {
+	if parsed.IsPermissionsChange() {
+		return qp.patchPermissionsInfo(parsed)
+	}
+
+	if parsed.IsRowOwnershipTransfer() {
+		return qp.patchTransferInfo(parsed, ctx)
+	}
+
+	if parsed.Structure.GetKind() == lib.QueryKindAlter && !parsed.Structure.IsDataLosingAlter() {
+		parsed.TableBeforeQuery, err = qp.DB.QM().ExecuteSQLTableCreate(parsed.Structure.GetQuotedTable())
+		return
+	}
+
+	if parsed.Structure.GetKind() == lib.QueryKindDrop ||
+		parsed.Structure.GetKind() == lib.QueryKindTruncate ||
+		parsed.Structure.GetKind() == lib.QueryKindAlter {
+
+		snapshot := tableSnapshot{}
+
+		snapshot.CreateSQL, snapshot.Inserts, err = qp.DB.QM().ExecuteSQLTableDump(parsed.Structure.GetQuotedTable())
+
+		if err != nil {
+			return
+		}
+
+		parsed.TableSnapshot, err = snapshot.serialize()
+		return
+	}
+
 	if parsed.Structure.GetKind() != lib.QueryKindUpdate &&
 		parsed.Structure.GetKind() != lib.QueryKindDelete &&
 		parsed.Structure.GetKind() != lib.QueryKindInsert {
 		return
 	}
 
-	keyCol, err := qp.DB.QM().ExecuteSQLPrimaryKey(parsed.Structure.GetTable())
+	keyCols, err := qp.DB.QM().ExecuteSQLPrimaryKey(parsed.Structure.GetQuotedTable())
 
 	if err != nil {
 		return
 	}
 
-	parsed.KeyCol = keyCol
+	parsed.KeyCols = keyCols
 
 	if parsed.Structure.GetKind() == lib.QueryKindUpdate ||
 		parsed.Structure.GetKind() == lib.QueryKindDelete {
 
-		cKey, cVal := parsed.Structure.GetOneColumnCondition()
+		keyVals, isKeyCondition := parsed.Structure.GetKeyCondition(keyCols)
 
-		if cKey != keyCol {
-			err = errors.New("Query condition has no a primary key")
-			return
+		if !isKeyCondition {
+			// condition is not by a primary key. query can affect many rows
+			err = qp.patchAffectedRowsInfo(parsed, ctx)
+
+			if err != nil {
+				return
+			}
+		} else {
+			err = qp.patchKeyRowInfo(parsed, keyVals, ctx)
+
+			if err != nil {
+				return
+			}
 		}
 
-		sqlquery := "SELECT * FROM " + parsed.Structure.GetTable() + " WHERE " + keyCol + "='" + database.Quote(cVal) + "'"
+	} else if parsed.Structure.GetKind() == lib.QueryKindInsert {
+		// key values are in a query already. they were added by patchInsertKey if not set
+		parsed.KeyVals, err = keyValuesFromRow(keyCols, parsed.Structure.GetUpdateColumns())
+
+		return
+	}
+	// do extra verification.
+	// we don't allow to change a key column value with UPDATE query. It can break the system
 
-		var currentRow map[string]string
+	if parsed.Structure.GetKind() == lib.QueryKindUpdate {
+		for _, keyCol := range keyCols {
+			if val, ok := parsed.Structure.GetUpdateColumns()[keyCol]; ok {
+				if val != keyCol {
+					err = errors.New("Update of primary key value is not allowed")
+					return
+				}
+			}
+		}
+	}
+	return
+}
 
-		currentRow, err = qp.DB.QM().ExecuteSQLSelectRow(sqlquery)
+// permissions of a table before GRANT or REVOKE to build rollback. Only a table with an owner can have permissions
+func (qp queryProcessor) patchPermissionsInfo(parsed *QueryParsed) (err error) {
+	if _, address := parsed.Structure.GetPermissionsChange(); address != "" {
+		_, err = utils.AddresToPubKeyHash(address)
 
 		if err != nil {
+			err = errors.New(fmt.Sprintf("Wrong address %s", address))
 			return
 		}
+	}
 
-		parsed.RowBeforeQuery = currentRow
-		parsed.KeyVal = cVal
+	parsed.ACLBeforeQuery, err = qp.getTablesPermissions().getACL(parsed.Structure.GetTable())
 
-	} else if parsed.Structure.GetKind() == lib.QueryKindInsert {
-		// there can be different primary key and it can be in list of insert columns
+	if err != nil {
+		return
+	}
+
+	if parsed.ACLBeforeQuery == nil {
+		err = errors.New(fmt.Sprintf("Table %s has no owner. Permissions can not be changed", parsed.Structure.GetTable()))
+	}
+	return
+}
+
+// transfer of a row ownership. Same as update of a row, it has RefID of a row
+func (qp queryProcessor) patchTransferInfo(parsed *QueryParsed, ctx QueryContext) (err error) {
+	address := parsed.Structure.GetTransferAddress()
+
+	_, err = utils.AddresToPubKeyHash(address)
+
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Wrong address %s", address))
+		return
+	}
+
+	parsed.KeyCols, err = qp.DB.QM().ExecuteSQLPrimaryKey(parsed.Structure.GetQuotedTable())
+
+	if err != nil {
+		return
+	}
+
+	keyVals, isKeyCondition := parsed.Structure.GetKeyCondition(parsed.KeyCols)
+
+	if !isKeyCondition {
+		err = errors.New("Transfer of a row ownership needs a condition by a primary key")
+		return
+	}
+
+	err = qp.patchKeyRowInfo(parsed, keyVals, ctx)
+
+	if err != nil {
+		return
+	}
+
+	if len(parsed.RowBeforeQuery) == 0 {
+		err = errors.New("Row not found")
+	}
+	return
+}
 
-		cols := parsed.Structure.GetUpdateColumns()
+// query condition is primary key value. get current row to build rollback
+// A row is read in a transaction of a client session, it can be changed by previous queries of the transaction
+func (qp queryProcessor) patchKeyRowInfo(parsed *QueryParsed, keyVals []string, ctx QueryContext) (err error) {
+	parsed.KeyVals = keyVals
+
+	condition, err := parsed.makeKeyCondition()
+
+	if err != nil {
+		return
+	}
+
+	sqlquery := "SELECT * FROM " + parsed.Structure.GetQuotedTable() + " WHERE " + condition
+
+	currentRow, err := qp.selectRow(sqlquery, ctx)
+
+	if err != nil {
+		return
+	}
+
+	parsed.RowBeforeQuery = currentRow
+	return
+}
+
+// Read rows with a connection of a client session if a query is from a session. Otherwise with own connection
+func (qp queryProcessor) selectRows(sqlquery string, ctx QueryContext) ([]map[string]string, error) {
+	if ctx.Connection == nil {
+		return qp.DB.QM().ExecuteSQLSelectRows(sqlquery)
+	}
+	return ctx.Connection.ExecuteSQLSelectRows(sqlquery)
+}
+
+// Read a row with a connection of a client session if a query is from a session. Otherwise with own connection
+func (qp queryProcessor) selectRow(sqlquery string, ctx QueryContext) (map[string]string, error) {
+	if ctx.Connection == nil {
+		return qp.DB.QM().ExecuteSQLSelectRow(sqlquery)
+	}
 
-		if val, ok := cols[keyCol]; ok {
-			parsed.KeyVal = val
+	rows, err := ctx.Connection.ExecuteSQLSelectRows(sqlquery)
 
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		// no row. same as a read with own connection
+		return map[string]string{}, nil
+	}
+	return rows[0], nil
+}
+
+// query condition can match many rows. Find all affected rows and make a query for each row by its key value.
+// Every row query has own RefID and rollback. A server executes the query only for these rows
+func (qp queryProcessor) patchAffectedRowsInfo(parsed *QueryParsed, ctx QueryContext) (err error) {
+	sqlquery, err := parsed.Structure.GetAffectedRowsSelect()
+
+	if err != nil {
+		return
+	}
+
+	// locking read returns latest versions of rows, not a snapshot. In a client session rows stay locked
+	// till the end of its transaction
+	currentRows, err := qp.selectRows(sqlquery+" FOR UPDATE", ctx)
+
+	if err != nil {
+		return
+	}
+
+	if len(currentRows) == 0 {
+		err = errors.New("No rows match the query condition")
+		return
+	}
+
+	dbconfig := qp.DB.GetConfig()
+
+	if len(currentRows) > dbconfig.GetMaxRowsPerQuery() {
+		err = errors.New(fmt.Sprintf("Query affects %d rows. Max allowed is %d", len(currentRows), dbconfig.GetMaxRowsPerQuery()))
+		return
+	}
+
+	parsed.Rows = []QueryParsed{}
+
+	rowsKeyVals := [][]string{}
+
+	for _, currentRow := range currentRows {
+		var keyVals []string
+		keyVals, err = keyValuesFromRow(parsed.KeyCols, currentRow)
+
+		if err != nil {
 			return
 		}
-		// try to predict key value
-		// try to get next auto_increment
-		var nextID string
-		nextID, err = qp.DB.QM().ExecuteSQLNextKeyValue(parsed.Structure.GetTable())
+		rowsKeyVals = append(rowsKeyVals, keyVals)
+
+		var rowSQL string
+		rowSQL, err = parsed.Structure.MakeRowQuery(parsed.KeyCols, keyVals)
 
 		if err != nil {
 			return
 		}
 
-		if nextID == "" {
-			err = errors.New("Can not build reference ID for inserted row. Table has no auto_increment key")
+		row := QueryParsed{KeyCols: parsed.KeyCols, KeyVals: keyVals, RowBeforeQuery: currentRow}
+		row.Structure = sqlparser.NewSqlParser()
+
+		err = row.Structure.Parse(rowSQL)
+
+		if err != nil {
+			return
+		}
+		row.SQL = row.Structure.GetCanonicalQuery()
+
+		parsed.Rows = append(parsed.Rows, row)
+	}
+
+	// other rows can match the condition when a server executes the query. They are not in a TX
+	parsed.RewrittenSQL, err = parsed.Structure.MakeRowsQuery(parsed.KeyCols, rowsKeyVals)
+
+	return
+}
+
+// split multi-row insert to single-row queries. Each row gets a key value.
+// Key values were added to rows without a key by patchInsertKey
+func (qp queryProcessor) patchInsertRowsInfo(parsed *QueryParsed, rows []string) (err error) {
+	keyCols, err := qp.DB.QM().ExecuteSQLPrimaryKey(parsed.Structure.GetQuotedTable())
+
+	if err != nil {
+		return
+	}
+
+	parsed.KeyCols = keyCols
+	parsed.Rows = []QueryParsed{}
+
+	keys := map[string]bool{}
+
+	for _, rowSQL := range rows {
+		row := QueryParsed{KeyCols: keyCols}
+		row.Structure = sqlparser.NewSqlParser()
+
+		err = row.Structure.Parse(rowSQL)
+
+		if err != nil {
 			return
 		}
 
-		err = parsed.Structure.ExtendInsert(keyCol, nextID, "string")
+		row.KeyVals, err = keyValuesFromRow(keyCols, row.Structure.GetUpdateColumns())
 
 		if err != nil {
 			return
 		}
 
-		parsed.KeyVal = nextID
-		parsed.SQL = parsed.Structure.GetCanonicalQuery()
+		if keys[row.GetKeyValue()] {
+			err = errors.New(fmt.Sprintf("Multi-row INSERT has duplicate primary key value %s", row.GetKeyValue()))
+			return
+		}
+		keys[row.GetKeyValue()] = true
+
+		row.SQL = row.Structure.GetCanonicalQuery()
 
+		parsed.Rows = append(parsed.Rows, row)
 	}
-	// do extra verification.
-	// we don't allow to change a key column value with UPDATE query. It can break the system
+	return
+}
 
-	if parsed.Structure.GetKind() == lib.QueryKindUpdate {
-		if val, ok := parsed.Structure.GetUpdateColumns()[keyCol]; ok {
-			if val != keyCol {
-				err = errors.New("Update of primary key value is not allowed")
-				return
-			}
+// Add key values to INSERT without a key. Values are assigned by a strategy set for a table.
+// Same query is executed on this node, so the DB has same keys as a TX
+func (qp queryProcessor) patchInsertKey(parsed *QueryParsed, ctx QueryContext) (err error) {
+	keyCols, err := qp.DB.QM().ExecuteSQLPrimaryKey(parsed.Structure.GetQuotedTable())
+
+	if err != nil {
+		return
+	}
+
+	// all rows have same list of columns
+	missing := missingKeyColumns(keyCols, parsed.Structure.GetUpdateColumns())
+
+	if len(missing) == 0 {
+		return
+	}
+
+	if len(missing) > 1 {
+		err = errors.New("Can not build reference ID for inserted row. Only one key column can be missed in a query")
+		return
+	}
+
+	rows, err := parsed.Structure.SplitInsertRows()
+
+	if err != nil {
+		return
+	}
+
+	values, err := qp.allocateKeyValues(parsed, missing[0], len(rows), ctx)
+
+	if err != nil {
+		return
+	}
+
+	err = parsed.Structure.ExtendInsertRows(missing[0], values, "string")
+
+	if err != nil {
+		return
+	}
+
+	// mysql returns ID of the first inserted row
+	parsed.InsertID = values[0]
+	parsed.RewrittenSQL = parsed.Structure.GetCanonicalQuery()
+
+	return
+}
+
+// key columns that are not set in a query
+func missingKeyColumns(keyCols []string, cols map[string]string) []string {
+	missing := []string{}
+
+	for _, keyCol := range keyCols {
+		if _, ok := cols[keyCol]; !ok {
+			missing = append(missing, keyCol)
+		}
+	}
+	return missing
+}
+
+// values of key columns in a row, in order of key columns
+func keyValuesFromRow(keyCols []string, row map[string]string) (keyVals []string, err error) {
+	keyVals = []string{}
+
+	for _, keyCol := range keyCols {
+		val, ok := row[keyCol]
+
+		if !ok {
+			err = errors.New(fmt.Sprintf("Primary key column %s not found in a row", keyCol))
+			return
 		}
+		keyVals = append(keyVals, val)
 	}
 	return
 }
@@ -169,6 +516,9 @@ func (qp queryProcessor) ExecuteQuery(sql string) (*structures.SQLUpdate, error)
 
 // execute query from QueryParsed data.
 func (qp queryProcessor) ExecuteParsedQuery(parsed QueryParsed) (*structures.SQLUpdate, error) {
+	if len(parsed.Rows) > 0 {
+		return nil, errors.New("Multi-row query must be executed as a transaction of single-row updates")
+	}
 	su, err := qp.MakeSQLUpdateStructure(parsed)
 
 	if err != nil {
@@ -183,14 +533,181 @@ func (qp queryProcessor) ExecuteParsedQuery(parsed QueryParsed) (*structures.SQL
 	return &su, err
 }
 
-// Execute query from TX
-func (qp queryProcessor) ExecuteQueryFromTX(sql structures.SQLUpdate) error {
-	return qp.DB.QM().ExecuteSQL(string(sql.Query))
+// Execute query from TX. GRANT, REVOKE and other permissions queries are not executed by a DB server
+func (qp queryProcessor) ExecuteQueryFromTX(sql structures.SQLUpdate, tx *structures.Transaction) error {
+	parsed := sqlparser.NewSqlParser()
+
+	err := parsed.Parse(string(sql.Query))
+
+	if err == nil && parsed.IsTableDataUpdate() {
+		// node that created TX had to replace such calls with values
+		_, err = parsed.ReplaceFunctionCalls(checkDeterministicCall)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err == nil && isPermissionsQuery(parsed.GetKind()) {
+		return qp.getTablesPermissions().applyQuery(parsed, tx)
+	}
+
+	if err == nil && parsed.GetKind() == lib.QueryKindTransfer {
+		// an owner of a row is found by a chain of TXs. nothing to do
+		return nil
+	}
+
+	err = qp.DB.QM().ExecuteSQL(string(sql.Query))
+
+	if err != nil || parsed.GetKind() != lib.QueryKindCreate {
+		return err
+	}
+	// new table gets the owner
+	return qp.getTablesPermissions().applyQuery(parsed, tx)
 }
 
 // Execute rollback query from TX
-func (qp queryProcessor) ExecuteRollbackQueryFromTX(sql structures.SQLUpdate) error {
-	return qp.DB.QM().ExecuteSQL(string(sql.RollbackQuery))
+func (qp queryProcessor) ExecuteRollbackQueryFromTX(sql structures.SQLUpdate, tx *structures.Transaction) error {
+	parsed := sqlparser.NewSqlParser()
+
+	err := parsed.Parse(string(sql.Query))
+
+	if err == nil && parsed.GetKind() == lib.QueryKindAlter && !parsed.IsDataLosingAlter() {
+		// rollback query is a table structure before ALTER
+		return qp.restoreTableStructure(parsed.GetQuotedTable(), string(sql.RollbackQuery))
+	}
+
+	if err == nil && (parsed.GetKind() == lib.QueryKindDrop || parsed.GetKind() == lib.QueryKindTruncate ||
+		parsed.GetKind() == lib.QueryKindAlter) {
+		// rollback query is a table snapshot
+		return qp.restoreTableSnapshot(parsed, sql.RollbackQuery)
+	}
+
+	if err == nil && isPermissionsQuery(parsed.GetKind()) {
+		// rollback query is opposite REVOKE or GRANT
+		return qp.getTablesPermissions().rollbackQuery(parsed, sql.RollbackQuery, tx)
+	}
+
+	if err == nil && parsed.GetKind() == lib.QueryKindTransfer {
+		return nil
+	}
+
+	err = qp.DB.QM().ExecuteSQL(string(sql.RollbackQuery))
+
+	if err != nil || parsed.GetKind() != lib.QueryKindCreate {
+		return err
+	}
+	return qp.getTablesPermissions().rollbackQuery(parsed, sql.RollbackQuery, tx)
+}
+
+// Change permissions by queries from TX. It is used when queries were executed by a DB server directly (from a proxy)
+func (qp queryProcessor) ApplyPermissionsFromTX(tx *structures.Transaction) error {
+	for _, sqlUpdate := range tx.GetSQLUpdates() {
+		parsed := sqlparser.NewSqlParser()
+
+		err := parsed.Parse(string(sqlUpdate.Query))
+
+		if err != nil {
+			return err
+		}
+
+		err = qp.getTablesPermissions().applyQuery(parsed, tx)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qp queryProcessor) getTablesPermissions() tablesPermissions {
+	return tablesPermissions{qp.DB, qp.Logger, nil}
+}
+
+// Restore a table from a snapshot. Table is created again after DROP. After TRUNCATE it exists, only rows are inserted.
+// After ALTER a table is dropped and created again with old structure
+func (qp queryProcessor) restoreTableSnapshot(parsed sqlparser.SQLQueryParserInterface, data []byte) error {
+	snapshot, err := deserializeTableSnapshot(data)
+
+	if err != nil {
+		return err
+	}
+
+	queries := snapshot.Inserts
+
+	switch parsed.GetKind() {
+	case lib.QueryKindDrop:
+		queries = append([]string{snapshot.CreateSQL}, queries...)
+	case lib.QueryKindAlter:
+		queries = append([]string{"DROP TABLE " + parsed.GetQuotedTable(), snapshot.CreateSQL}, queries...)
+	}
+
+	for _, sqlquery := range queries {
+		err = qp.DB.QM().ExecuteSQL(sqlquery)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Table snapshot restore error: %s", err.Error()))
+		}
+	}
+	return nil
+}
+
+// Recreate a table with given CREATE TABLE statement and keep its data.
+// Data are copied to a backup table, then the table is created again and data of common columns are copied back
+// table is a quoted name, a backup table gets a suffix inside quotes, so it is in same schema
+func (qp queryProcessor) restoreTableStructure(table string, createSQL string) error {
+	backupTable := strings.TrimSuffix(table, "`") + "_rollback_backup`"
+
+	queries := []string{
+		"DROP TABLE IF EXISTS " + backupTable,
+		"CREATE TABLE " + backupTable + " LIKE " + table,
+		"INSERT INTO " + backupTable + " SELECT * FROM " + table,
+		"DROP TABLE " + table,
+		createSQL}
+
+	for _, sqlquery := range queries {
+		err := qp.DB.QM().ExecuteSQL(sqlquery)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Table %s restore error: %s", table, err.Error()))
+		}
+	}
+
+	columns, err := qp.DB.QM().ExecuteSQLTableColumns(table)
+
+	if err != nil {
+		return err
+	}
+
+	backupColumns, err := qp.DB.QM().ExecuteSQLTableColumns(backupTable)
+
+	if err != nil {
+		return err
+	}
+
+	// columns added by ALTER are removed. ALTER which changes or drops columns has a snapshot rollback, it is not restored here
+	commonColumns := []string{}
+
+	for _, column := range columns {
+		for _, backupColumn := range backupColumns {
+			if column == backupColumn {
+				commonColumns = append(commonColumns, database.QuoteIdentifier(column))
+				break
+			}
+		}
+	}
+
+	if len(commonColumns) > 0 {
+		columnsList := strings.Join(commonColumns, ", ")
+
+		err = qp.DB.QM().ExecuteSQL("INSERT INTO " + table + " (" + columnsList + ") SELECT " + columnsList + " FROM " + backupTable)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Table %s restore error: %s", table, err.Error()))
+		}
+	}
+
+	return qp.DB.QM().ExecuteSQL("DROP TABLE " + backupTable)
 }
 
 // errorKind possible values: 2 - pubkey required, 3 - data sign required
@@ -219,3 +736,20 @@ func (qp queryProcessor) MakeSQLUpdateStructure(parsed QueryParsed) (sqlupdate s
 	qp.Logger.Trace.Printf("rollback for %s is %s and refID %s", parsed.SQL, rollSQL, parsed.ReferenceID())
 	return
 }
+
+// Builds SQL update structures for each row of a query. It is one update if the query is not multi-row INSERT
+func (qp queryProcessor) MakeSQLUpdateStructures(parsed QueryParsed) (sqlupdates []structures.SQLUpdate, err error) {
+	sqlupdates = []structures.SQLUpdate{}
+
+	for _, row := range parsed.GetRowQueries() {
+		var sqlupdate structures.SQLUpdate
+
+		sqlupdate, err = qp.MakeSQLUpdateStructure(row)
+
+		if err != nil {
+			return
+		}
+		sqlupdates = append(sqlupdates, sqlupdate)
+	}
+	return
+}
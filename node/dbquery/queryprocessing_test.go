@@ -1,10 +1,13 @@
 package dbquery
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/gelembjuk/oursql/lib/utils"
 	"github.com/gelembjuk/oursql/node/database"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
 )
 
 func TestParsingUpdate(t *testing.T) {
@@ -14,7 +17,7 @@ func TestParsingUpdate(t *testing.T) {
 	si.Table = "t"
 	DBM.SetSQLExplain(&si)
 
-	DBM.KeyColumn = "id"
+	DBM.KeyColumns = []string{"id"}
 
 	sqls := map[string][]string{
 		"UPDATE t SET a='b' WHERE id=1":                []string{"UPDATE t SET a='b' WHERE id=1", "update", "t:1", "", "", ""},
@@ -50,3 +53,222 @@ func TestParsingUpdate(t *testing.T) {
 	}
 
 }
+
+// Connection of a client session. It returns rows as a transaction of the client sees them
+type sessionConnectionMock struct {
+	rows    []map[string]string
+	queries []string
+}
+
+func (c *sessionConnectionMock) ExecuteSQLSelectRows(sqlcommand string) ([]map[string]string, error) {
+	c.queries = append(c.queries, sqlcommand)
+	return c.rows, nil
+}
+
+func TestInsertThenUpdateInSession(t *testing.T) {
+	DBM := database.GetDBManagerMock()
+	DBM.KeyColumns = []string{"id"}
+
+	qp := NewQueryProcessor(&DBM, utils.CreateLoggerStdout())
+
+	conn := &sessionConnectionMock{}
+	ctx := NewQueryContext()
+	ctx.Connection = conn
+
+	insert, err := qp.ParseQueryInContext("INSERT INTO t (id, a) VALUES (1, 'x')", ctx)
+
+	if err != nil {
+		t.Fatalf("Parse error: %s", err.Error())
+	}
+
+	// the row is not committed yet, it is visible only in the session
+	conn.rows = []map[string]string{{"id": "1", "a": "x"}}
+
+	update, err := qp.ParseQueryInContext("UPDATE t SET a='y' WHERE id=1", ctx)
+
+	if err != nil {
+		t.Fatalf("Parse error: %s", err.Error())
+	}
+
+	if len(conn.queries) != 1 || conn.queries[0] != "SELECT * FROM `t` WHERE `id`='1'" {
+		t.Fatalf("Row must be read in the session, got queries %q", conn.queries)
+	}
+
+	if insert.ReferenceID() != update.ReferenceID() {
+		t.Fatalf("Queries of same row must have same RefID")
+	}
+
+	sqlUpdate, err := qp.MakeSQLUpdateStructure(update)
+
+	if err != nil {
+		t.Fatalf("Rollback error: %s", err.Error())
+	}
+
+	// rollback of the update returns the inserted value
+	rollback := sqlparser.NewSqlParser()
+
+	if err := rollback.Parse(string(sqlUpdate.RollbackQuery)); err != nil {
+		t.Fatalf("Rollback parse error: %s", err.Error())
+	}
+
+	if rollback.GetUpdateColumns()["a"] != "x" {
+		t.Fatalf("Wrong rollback %s", string(sqlUpdate.RollbackQuery))
+	}
+}
+
+func TestUpdateNotFoundInSession(t *testing.T) {
+	DBM := database.GetDBManagerMock()
+	DBM.KeyColumns = []string{"id"}
+
+	qp := NewQueryProcessor(&DBM, utils.CreateLoggerStdout())
+
+	conn := &sessionConnectionMock{}
+	ctx := NewQueryContext()
+	ctx.Connection = conn
+
+	// there is no row with the key, same as a read without a session
+	update, err := qp.ParseQueryInContext("UPDATE t SET a='y' WHERE id=5", ctx)
+
+	if err != nil {
+		t.Fatalf("Parse error: %s", err.Error())
+	}
+
+	if len(conn.queries) != 1 || conn.queries[0] != "SELECT * FROM `t` WHERE `id`='5'" {
+		t.Fatalf("Row must be read in the session, got queries %q", conn.queries)
+	}
+
+	if update.RowBeforeQuery == nil || len(update.RowBeforeQuery) != 0 {
+		t.Fatalf("Empty row is expected, got %v", update.RowBeforeQuery)
+	}
+}
+
+func TestAffectedRowsInSession(t *testing.T) {
+	DBM := database.GetDBManagerMock()
+	DBM.KeyColumns = []string{"id"}
+
+	qp := NewQueryProcessor(&DBM, utils.CreateLoggerStdout())
+
+	conn := &sessionConnectionMock{rows: []map[string]string{{"id": "1", "a": "x"}, {"id": "2", "a": "x"}}}
+	ctx := NewQueryContext()
+	ctx.Connection = conn
+
+	parsed, err := qp.ParseQueryInContext("UPDATE t SET a='y' WHERE a='x'", ctx)
+
+	if err != nil {
+		t.Fatalf("Parse error: %s", err.Error())
+	}
+
+	if len(conn.queries) != 1 || conn.queries[0] != "SELECT * FROM t WHERE a='x' FOR UPDATE" {
+		t.Fatalf("Rows must be read in the session, got queries %q", conn.queries)
+	}
+
+	if len(parsed.Rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(parsed.Rows))
+	}
+
+	// a server must not update rows which are not in a TX
+	if parsed.RewrittenSQL != "UPDATE t SET a='y' WHERE (`id`='1') OR (`id`='2')" {
+		t.Fatalf("Unexpected query for a server: %s", parsed.RewrittenSQL)
+	}
+}
+
+// Query manager which keeps executed queries. Structure and rows of a table are set by a test
+type queryManagerRecorder struct {
+	database.DBQueryManager
+	createSQL string
+	columns   map[string][]string // quoted table => columns
+	inserts   []string
+	queries   []string
+}
+
+func (qm *queryManagerRecorder) ExecuteSQL(sql string) error {
+	qm.queries = append(qm.queries, sql)
+	return nil
+}
+
+func (qm *queryManagerRecorder) ExecuteSQLTableCreate(table string) (string, error) {
+	return qm.createSQL, nil
+}
+
+func (qm *queryManagerRecorder) ExecuteSQLTableColumns(table string) ([]string, error) {
+	return qm.columns[table], nil
+}
+
+func (qm *queryManagerRecorder) ExecuteSQLTableDump(table string) (string, []string, error) {
+	return qm.createSQL, qm.inserts, nil
+}
+
+type dbManagerRecorder struct {
+	database.DBManager
+	qm *queryManagerRecorder
+}
+
+func (bdm dbManagerRecorder) QM() database.DBQueryManager {
+	return bdm.qm
+}
+
+// parse queries and execute their rollbacks in reverse order, as a node does when a block is canceled
+func executeRollbacks(t *testing.T, qp QueryProcessorInterface, sqls []string) {
+	sqlUpdates := []structures.SQLUpdate{}
+
+	for _, sql := range sqls {
+		parsed, err := qp.ParseQuery(sql)
+
+		if err != nil {
+			t.Fatalf("Parse error: %s for %s", err.Error(), sql)
+		}
+
+		sqlUpdate, err := qp.MakeSQLUpdateStructure(parsed)
+
+		if err != nil {
+			t.Fatalf("Rollback error: %s for %s", err.Error(), sql)
+		}
+		sqlUpdates = append(sqlUpdates, sqlUpdate)
+	}
+
+	for i := len(sqlUpdates) - 1; i >= 0; i-- {
+		if err := qp.ExecuteRollbackQueryFromTX(sqlUpdates[i], nil); err != nil {
+			t.Fatalf("Rollback execute error: %s", err.Error())
+		}
+	}
+}
+
+func TestAlterRollback(t *testing.T) {
+	DBM := database.GetDBManagerMock()
+	DBM.KeyColumns = []string{"id"}
+
+	qm := &queryManagerRecorder{DBQueryManager: DBM.QM()}
+	qm.createSQL = "CREATE TABLE `t` (`id` int, `a` varchar(10), PRIMARY KEY (`id`))"
+	qm.columns = map[string][]string{"`t`": []string{"id", "a"}, "`t_rollback_backup`": []string{"id", "a", "c"}}
+	qm.inserts = []string{"INSERT INTO `t` (`id`, `a`) VALUES ('1', 'x')"}
+
+	qp := NewQueryProcessor(dbManagerRecorder{&DBM, qm}, utils.CreateLoggerStdout())
+
+	// new column is removed, rows inserted after ALTER are deleted before it and other rows keep their data
+	executeRollbacks(t, qp, []string{"ALTER TABLE t ADD COLUMN c int", "INSERT INTO t (id, a, c) VALUES (2, 'y', 7)"})
+
+	expected := []string{
+		"DELETE FROM `t` WHERE `id`='2'",
+		"DROP TABLE IF EXISTS `t_rollback_backup`",
+		"CREATE TABLE `t_rollback_backup` LIKE `t`",
+		"INSERT INTO `t_rollback_backup` SELECT * FROM `t`",
+		"DROP TABLE `t`",
+		qm.createSQL,
+		"INSERT INTO `t` (`id`, `a`) SELECT `id`, `a` FROM `t_rollback_backup`",
+		"DROP TABLE `t_rollback_backup`"}
+
+	if !reflect.DeepEqual(qm.queries, expected) {
+		t.Fatalf("Wrong rollback of ADD COLUMN, got queries %q", qm.queries)
+	}
+
+	// old values of a changed column are restored from a snapshot
+	qm.queries = nil
+
+	executeRollbacks(t, qp, []string{"ALTER TABLE t MODIFY a int"})
+
+	expected = []string{"DROP TABLE `t`", qm.createSQL, qm.inserts[0]}
+
+	if !reflect.DeepEqual(qm.queries, expected) {
+		t.Fatalf("Wrong rollback of MODIFY, got queries %q", qm.queries)
+	}
+}
@@ -0,0 +1,82 @@
+package dbquery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Max size of a compressed snapshot. It is stored in a TX, and a TX over 4MB is not accepted by other nodes
+const maxTableSnapshotSize = 3 * 1024 * 1024
+
+// Snapshot of a table. It is stored as a rollback of DROP TABLE, TRUNCATE and ALTER TABLE which changes columns
+// to restore a table structure and all rows
+type tableSnapshot struct {
+	CreateSQL string
+	Inserts   []string
+}
+
+// Serialize and compress a snapshot. Too big snapshot is an error, a TX with it can not be sent to other nodes
+func (ts tableSnapshot) serialize() ([]byte, error) {
+	var encoded bytes.Buffer
+
+	enc := gob.NewEncoder(&encoded)
+	err := enc.Encode(ts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var compressed bytes.Buffer
+
+	zw := gzip.NewWriter(&compressed)
+
+	_, err = zw.Write(encoded.Bytes())
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = zw.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compressed.Len() > maxTableSnapshotSize {
+		return nil, errors.New(fmt.Sprintf("Table is too big for this query. Snapshot of the table for a rollback is %d bytes, max is %d bytes. Delete rows of the table first",
+			compressed.Len(), maxTableSnapshotSize))
+	}
+
+	return compressed.Bytes(), nil
+}
+
+// Decompress and deserialize a snapshot
+func deserializeTableSnapshot(data []byte) (ts tableSnapshot, err error) {
+	if len(data) == 0 {
+		err = errors.New("Table snapshot is empty")
+		return
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Table snapshot decompress error: %s", err.Error()))
+		return
+	}
+
+	encoded, err := ioutil.ReadAll(zr)
+
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Table snapshot decompress error: %s", err.Error()))
+		return
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(encoded))
+	err = dec.Decode(&ts)
+
+	return
+}
@@ -0,0 +1,38 @@
+package dbquery
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestTableSnapshot(t *testing.T) {
+	snapshot := tableSnapshot{"CREATE TABLE `t` (`id` int)", []string{"INSERT INTO `t` (`id`) VALUES ('1')"}}
+
+	data, err := snapshot.serialize()
+
+	if err != nil {
+		t.Fatalf("Serialize error: %s", err.Error())
+	}
+
+	restored, err := deserializeTableSnapshot(data)
+
+	if err != nil {
+		t.Fatalf("Deserialize error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(restored, snapshot) {
+		t.Fatalf("Wrong snapshot %v", restored)
+	}
+
+	// random data are not compressed well
+	row := make([]byte, maxTableSnapshotSize)
+	rand.Read(row)
+
+	snapshot.Inserts = append(snapshot.Inserts, "INSERT INTO `t` (`id`) VALUES ('"+hex.EncodeToString(row)+"')")
+
+	if _, err := snapshot.serialize(); err == nil {
+		t.Fatalf("Too big snapshot must be refused")
+	}
+}
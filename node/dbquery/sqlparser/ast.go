@@ -0,0 +1,390 @@
+package sqlparser
+
+import (
+	"github.com/gelembjuk/oursql/lib"
+	"github.com/gelembjuk/oursql/node/database"
+)
+
+// ================== STATEMENTS =============================
+
+type statement interface {
+	// one of QueryKind* constants
+	kind() string
+	// main table of a query. empty if there is no table
+	mainTable() tableName
+}
+
+// Table name, possibly schema qualified
+type tableName struct {
+	schema string
+	name   string
+}
+
+func (t tableName) String() string {
+	if t.schema != "" {
+		return t.schema + "." + t.name
+	}
+	return t.name
+}
+
+// name to use in generated SQL. Both parts are quoted, original case is kept
+func (t tableName) quoted() string {
+	if t.name == "" {
+		return ""
+	}
+	if t.schema != "" {
+		return database.QuoteIdentifier(t.schema) + "." + database.QuoteIdentifier(t.name)
+	}
+	return database.QuoteIdentifier(t.name)
+}
+
+// SET column = value
+type assignment struct {
+	column *columnExpr
+	value  expression
+}
+
+type selectStatement struct {
+	table *tableName // first table in FROM. nil if there is no FROM or it is a subquery
+	where expression
+}
+
+// One row in VALUES list
+type valuesRow struct {
+	pos    int // position of opening bracket
+	end    int // position after closing bracket
+	values []expression
+}
+
+type insertStatement struct {
+	table       tableName
+	columns     []string
+	columnsPos  int // position of opening bracket of columns list. -1 if there is no list
+	rows        []valuesRow
+	set         []assignment
+	setEnd      int  // position after the last SET assignment
+	fromSelect  bool // INSERT ... SELECT
+	onDuplicate []assignment
+	ignore      bool
+}
+
+type updateStatement struct {
+	table     tableName
+	tablePos  int // table name with an alias is tablePos:tableEnd
+	tableEnd  int
+	set       []assignment
+	filterPos int // position of WHERE, ORDER BY, LIMIT part. It is end of a query if there is no such part
+	where     expression
+	limit     expression
+}
+
+type deleteStatement struct {
+	table     tableName
+	tablePos  int
+	tableEnd  int
+	filterPos int
+	where     expression
+	limit     expression
+}
+
+type createTableStatement struct {
+	table       tableName
+	ifNotExists bool
+}
+
+type dropTableStatement struct {
+	table    tableName
+	ifExists bool
+}
+
+type alterTableStatement struct {
+	table     tableName
+	losesData bool // existing columns are changed or dropped
+}
+
+type truncateTableStatement struct {
+	table tableName
+}
+
+// BEGIN, COMMIT, ROLLBACK
+type transactionStatement struct {
+	queryKind string
+}
+
+// GRANT or REVOKE of table permissions for an address. It is not MySQL privileges
+type permissionsStatement struct {
+	queryKind   string
+	table       tableName
+	permissions []string // in upper case
+	address     string
+}
+
+// ENABLE or DISABLE ROW OWNERSHIP of a table
+type rowOwnershipStatement struct {
+	table  tableName
+	enable bool
+}
+
+// transfer of a row ownership to an address. A row is found by a condition
+type transferStatement struct {
+	table   tableName
+	address string
+	where   expression
+}
+
+func (s *selectStatement) kind() string {
+	return lib.QueryKindSelect
+}
+func (s *selectStatement) mainTable() tableName {
+	if s.table == nil {
+		return tableName{}
+	}
+	return *s.table
+}
+func (s *insertStatement) kind() string {
+	return lib.QueryKindInsert
+}
+func (s *insertStatement) mainTable() tableName {
+	return s.table
+}
+func (s *updateStatement) kind() string {
+	return lib.QueryKindUpdate
+}
+func (s *updateStatement) mainTable() tableName {
+	return s.table
+}
+func (s *deleteStatement) kind() string {
+	return lib.QueryKindDelete
+}
+func (s *deleteStatement) mainTable() tableName {
+	return s.table
+}
+func (s *createTableStatement) kind() string {
+	return lib.QueryKindCreate
+}
+func (s *createTableStatement) mainTable() tableName {
+	return s.table
+}
+func (s *dropTableStatement) kind() string {
+	return lib.QueryKindDrop
+}
+func (s *dropTableStatement) mainTable() tableName {
+	return s.table
+}
+func (s *alterTableStatement) kind() string {
+	return lib.QueryKindAlter
+}
+func (s *alterTableStatement) mainTable() tableName {
+	return s.table
+}
+func (s *truncateTableStatement) kind() string {
+	return lib.QueryKindTruncate
+}
+func (s *truncateTableStatement) mainTable() tableName {
+	return s.table
+}
+func (s *transactionStatement) kind() string {
+	return s.queryKind
+}
+func (s *transactionStatement) mainTable() tableName {
+	return tableName{}
+}
+func (s *permissionsStatement) kind() string {
+	return s.queryKind
+}
+func (s *permissionsStatement) mainTable() tableName {
+	return s.table
+}
+func (s *rowOwnershipStatement) kind() string {
+	return lib.QueryKindRowOwnership
+}
+func (s *rowOwnershipStatement) mainTable() tableName {
+	return s.table
+}
+func (s *transferStatement) kind() string {
+	return lib.QueryKindTransfer
+}
+func (s *transferStatement) mainTable() tableName {
+	return s.table
+}
+
+// ================== EXPRESSIONS =============================
+
+// Any expression keeps its position in a query, so original text can be restored
+type expression interface {
+	position() (int, int)
+}
+
+type exprPosition struct {
+	pos int
+	end int
+}
+
+func (e exprPosition) position() (int, int) {
+	return e.pos, e.end
+}
+
+const (
+	literalString = iota
+	literalNumber
+	literalNull
+	literalBool
+	literalParam // ? in prepared statements
+)
+
+type literalExpr struct {
+	exprPosition
+	literalKind int
+	value       string // unescaped string or number as is
+}
+
+// column reference. table can be empty
+type columnExpr struct {
+	exprPosition
+	table string
+	name  string
+}
+
+type variableExpr struct {
+	exprPosition
+	name string
+}
+
+// binary operators including comparison, AND, OR, LIKE, IS etc. op is in upper case
+type binaryExpr struct {
+	exprPosition
+	op    string
+	left  expression
+	right expression
+}
+
+type unaryExpr struct {
+	exprPosition
+	op      string
+	operand expression
+}
+
+type parenExpr struct {
+	exprPosition
+	inner expression
+}
+
+// (a, b, c)
+type rowExpr struct {
+	exprPosition
+	items []expression
+}
+
+type funcExpr struct {
+	exprPosition
+	name string // in upper case
+	args []expression
+}
+
+type inExpr struct {
+	exprPosition
+	not     bool
+	operand expression
+	list    []expression // empty for a subquery
+}
+
+type betweenExpr struct {
+	exprPosition
+	not     bool
+	operand expression
+	low     expression
+	high    expression
+}
+
+// expression we don't need to understand. subqueries, CASE, INTERVAL etc
+type rawExpr struct {
+	exprPosition
+}
+
+// remove all brackets around an expression
+func unwrapParens(e expression) expression {
+	for {
+		p, ok := e.(*parenExpr)
+
+		if !ok {
+			return e
+		}
+		e = p.inner
+	}
+}
+
+// Check if this is comparison of a column with a literal. Literal can be on any side
+// Returns column, operator and a literal. operator is swapped if literal is on the left side
+func columnComparison(e expression) (*columnExpr, string, *literalExpr, bool) {
+	b, ok := unwrapParens(e).(*binaryExpr)
+
+	if !ok {
+		return nil, "", nil, false
+	}
+
+	switch b.op {
+	case "=", "<>", "!=", ">", "<", ">=", "<=":
+	default:
+		return nil, "", nil, false
+	}
+
+	left := unwrapParens(b.left)
+	right := unwrapParens(b.right)
+
+	if c, ok := left.(*columnExpr); ok {
+		if l, ok := right.(*literalExpr); ok && l.isValue() {
+			return c, b.op, l, true
+		}
+	}
+	if c, ok := right.(*columnExpr); ok {
+		if l, ok := left.(*literalExpr); ok && l.isValue() {
+			swap := map[string]string{">": "<", "<": ">", ">=": "<=", "<=": ">="}
+
+			op := b.op
+
+			if s, ok := swap[op]; ok {
+				op = s
+			}
+			return c, op, l, true
+		}
+	}
+	return nil, "", nil, false
+}
+
+// literal that can be compared with a column value
+func (l *literalExpr) isValue() bool {
+	return l.literalKind == literalString || l.literalKind == literalNumber
+}
+
+// list of expressions joined with AND on a top level. brackets around AND are removed too
+func collectAndTerms(e expression, terms []expression) []expression {
+	if e == nil {
+		return terms
+	}
+	e = unwrapParens(e)
+
+	if b, ok := e.(*binaryExpr); ok && (b.op == "AND" || b.op == "&&") {
+		terms = collectAndTerms(b.left, terms)
+		return collectAndTerms(b.right, terms)
+	}
+	return append(terms, e)
+}
+
+// collects comparisons of columns with literals joined with AND, OR, XOR
+// NOTE expressions in brackets are not checked. we don't need it at this place
+func collectConditionColumns(e expression, columns map[string][]string) {
+	if e == nil {
+		return
+	}
+	if b, ok := e.(*binaryExpr); ok && (b.op == "AND" || b.op == "OR" || b.op == "XOR") {
+		collectConditionColumns(b.left, columns)
+		collectConditionColumns(b.right, columns)
+		return
+	}
+	if _, ok := e.(*parenExpr); ok {
+		return
+	}
+	if c, op, l, ok := columnComparison(e); ok {
+		columns[c.name] = []string{l.value, op} // (VAUE, OPERATOR)
+	}
+}
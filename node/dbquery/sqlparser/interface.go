@@ -1,31 +1,55 @@
 package sqlparser
 
 const (
-	QueryKindSelect = "select"
-	QueryKindUpdate = "update"
-	QueryKindInsert = "insert"
-	QueryKindDelete = "delete"
-	QueryKindCreate = "create"
-	QueryKindDrop   = "drop"
-	QueryKindOther  = "other"
+	QueryKindSelect   = "select"
+	QueryKindUpdate   = "update"
+	QueryKindInsert   = "insert"
+	QueryKindDelete   = "delete"
+	QueryKindCreate   = "create"
+	QueryKindDrop     = "drop"
+	QueryKindAlter    = "alter"
+	QueryKindTruncate = "truncate"
+	QueryKindOther    = "other"
+
+	QueryKindBegin    = "begin"
+	QueryKindCommit   = "commit"
+	QueryKindRollback = "rollback"
+
+	QueryKindGrant        = "grant"
+	QueryKindRevoke       = "revoke"
+	QueryKindRowOwnership = "rowownership"
+	QueryKindTransfer     = "transfer"
 )
 
 type SQLQueryParserInterface interface {
 	Parse(sqlquery string) error
 	ExtendInsert(column string, value string, coltype string) error
+	ExtendInsertRows(column string, values []string, coltype string) error
+	SplitInsertRows() ([]string, error)
+	GetAffectedRowsSelect() (string, error)
+	MakeRowQuery(columns []string, values []string) (string, error)
+	MakeRowsQuery(columns []string, rows [][]string) (string, error)
+	ReplaceFunctionCalls(replace func(name string, args []string) (string, error)) (bool, error)
 	GetCanonicalQuery() string
 	GetKind() string
 	IsSingeTable() bool
 	IsRead() bool
 	IsModifyDB() bool
 	GetTable() string
+	GetQuotedTable() string
+	GetSchema() string
 	IsTableManage() bool
 	IsTableDataUpdate() bool
+	IsDataLosingAlter() bool
 	GetUpdateColumns() map[string]string
 	HasCondition() bool
 	IsOneColumnCondition() bool
 	GetOneColumnCondition() (string, string)
+	GetKeyCondition(columns []string) ([]string, bool)
 	GetComments() []string
+	GetPermissionsChange() ([]string, string)
+	GetRowOwnershipChange() bool
+	GetTransferAddress() string
 }
 
 func NewSqlParser() SQLQueryParserInterface {
@@ -0,0 +1,299 @@
+package sqlparser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF         tokenKind = iota
+	tokenIdent                 // unquoted word. keywords are idents too
+	tokenQuotedIdent           // `name`
+	tokenString                // 'text' or "text"
+	tokenNumber                // 12, 1.5e3, 0x1F, X'1F', b'101'
+	tokenVariable              // @var, @@session.var
+	tokenParam                 // ?
+	tokenOperator              // operators and punctuation
+	tokenComment               // /* */, -- and #
+)
+
+// operators longer than one char. longest first
+var multiCharOperators = []string{"<=>", "->>", "<=", ">=", "<>", "!=", ":=", "||", "&&", "<<", ">>", "->"}
+
+const singleCharOperators = "=<>!+-*/%(),.;&|^~:{}"
+
+type token struct {
+	kind  tokenKind
+	text  string // token as it is in a query
+	value string // unquoted and unescaped value of strings and quoted idents, text of comments
+	pos   int    // position of the first byte in a query
+	end   int    // position after the last byte
+}
+
+// Check if the token is a keyword (unquoted word) equal to one of the words. Case insensitive
+func (t token) isKeyword(words ...string) bool {
+	if t.kind != tokenIdent {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t token) isOperator(op string) bool {
+	return t.kind == tokenOperator && t.text == op
+}
+
+// Split a query to tokens. Comments are returned as tokens too. Last token is always EOF
+func tokenize(query string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+
+	for i < len(query) {
+		c := query[i]
+
+		if isSpaceChar(c) {
+			i++
+			continue
+		}
+		start := i
+
+		var t token
+		var err error
+
+		switch {
+		case c == '#':
+			t, i = readLineComment(query, i, 1)
+
+		case c == '-' && i+1 < len(query) && query[i+1] == '-' && (i+2 == len(query) || isSpaceChar(query[i+2])):
+			t, i = readLineComment(query, i, 2)
+
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			if i+2 < len(query) && query[i+2] == '!' {
+				// mysql executes contents of such comments
+				return nil, errors.New(fmt.Sprintf("Executable comments are not supported, position %d", i))
+			}
+			end := strings.Index(query[i+2:], "*/")
+
+			if end < 0 {
+				return nil, errors.New(fmt.Sprintf("Unterminated comment at position %d", i))
+			}
+			i = i + 2 + end + 2
+			t = token{kind: tokenComment, value: query[start+2 : i-2]}
+
+		case c == '\'' || c == '"':
+			t, i, err = readString(query, i)
+
+		case c == '`':
+			t, i, err = readQuotedIdent(query, i)
+
+		case (c == 'x' || c == 'X' || c == 'b' || c == 'B') && i+1 < len(query) && query[i+1] == '\'':
+			end := strings.IndexByte(query[i+2:], '\'')
+
+			if end < 0 {
+				return nil, errors.New(fmt.Sprintf("Unterminated string at position %d", i))
+			}
+			i = i + 2 + end + 1
+			t = token{kind: tokenNumber}
+
+		case isDigitChar(c) || (c == '.' && i+1 < len(query) && isDigitChar(query[i+1])):
+			t, i = readNumber(query, i)
+
+		case isIdentChar(c):
+			for i < len(query) && isIdentChar(query[i]) {
+				i++
+			}
+			t = token{kind: tokenIdent}
+
+		case c == '@':
+			i++
+			if i < len(query) && query[i] == '@' {
+				i++
+			}
+			if i < len(query) && (query[i] == '`' || query[i] == '\'' || query[i] == '"') {
+				var q token
+				q, i, err = readString(query, i)
+				t = token{kind: tokenVariable, value: q.value}
+			} else {
+				for i < len(query) && (isIdentChar(query[i]) || query[i] == '.') {
+					i++
+				}
+				t = token{kind: tokenVariable}
+			}
+
+		case c == '?':
+			i++
+			t = token{kind: tokenParam}
+
+		default:
+			op := ""
+
+			for _, o := range multiCharOperators {
+				if strings.HasPrefix(query[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" && strings.IndexByte(singleCharOperators, c) >= 0 {
+				op = string(c)
+			}
+			if op == "" {
+				return nil, errors.New(fmt.Sprintf("Unexpected character %q at position %d", c, i))
+			}
+			i = i + len(op)
+			t = token{kind: tokenOperator}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		t.pos = start
+		t.end = i
+		t.text = query[start:i]
+
+		if t.kind != tokenString && t.kind != tokenQuotedIdent && t.kind != tokenComment && t.value == "" {
+			t.value = t.text
+		}
+		tokens = append(tokens, t)
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(query), end: len(query)})
+
+	return tokens, nil
+}
+
+// read comment till the end of a line. prefixlen is length of a comment start (# or --)
+func readLineComment(query string, i int, prefixlen int) (token, int) {
+	start := i + prefixlen
+	end := strings.IndexByte(query[start:], '\n')
+
+	if end < 0 {
+		return token{kind: tokenComment, value: query[start:]}, len(query)
+	}
+	return token{kind: tokenComment, value: query[start : start+end]}, start + end
+}
+
+// read quoted string. both ' and " are strings in mysql (if ANSI_QUOTES is not on)
+func readString(query string, i int) (token, int, error) {
+	quote := query[i]
+	start := i
+	i++
+
+	var value strings.Builder
+
+	for i < len(query) {
+		c := query[i]
+
+		if c == '\\' && quote != '`' && i+1 < len(query) {
+			value.WriteString(unescapeChar(query[i+1]))
+			i = i + 2
+			continue
+		}
+		if c == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				// doubled quote
+				value.WriteByte(quote)
+				i = i + 2
+				continue
+			}
+			return token{kind: tokenString, value: value.String()}, i + 1, nil
+		}
+		value.WriteByte(c)
+		i++
+	}
+	return token{}, i, errors.New(fmt.Sprintf("Unterminated string at position %d", start))
+}
+
+// read `quoted` identifier. There are no escapes, only doubled backtick
+func readQuotedIdent(query string, i int) (token, int, error) {
+	t, end, err := readString(query, i)
+
+	if err != nil {
+		return t, end, errors.New(fmt.Sprintf("Unterminated quoted identifier at position %d", i))
+	}
+	t.kind = tokenQuotedIdent
+	return t, end, nil
+}
+
+// read number literal. if it is followed by letters then this is identifier like 1abc
+func readNumber(query string, i int) (token, int) {
+	start := i
+
+	if strings.HasPrefix(query[i:], "0x") || strings.HasPrefix(query[i:], "0b") {
+		i = i + 2
+		for i < len(query) && isIdentChar(query[i]) {
+			i++
+		}
+		return token{kind: tokenNumber}, i
+	}
+
+	for i < len(query) && isDigitChar(query[i]) {
+		i++
+	}
+	if i < len(query) && query[i] == '.' {
+		i++
+		for i < len(query) && isDigitChar(query[i]) {
+			i++
+		}
+	}
+	if i < len(query) && (query[i] == 'e' || query[i] == 'E') {
+		j := i + 1
+
+		if j < len(query) && (query[j] == '+' || query[j] == '-') {
+			j++
+		}
+		if j < len(query) && isDigitChar(query[j]) {
+			i = j
+			for i < len(query) && isDigitChar(query[i]) {
+				i++
+			}
+		}
+	}
+	if i < len(query) && isIdentChar(query[i]) && !strings.Contains(query[start:i], ".") {
+		for i < len(query) && isIdentChar(query[i]) {
+			i++
+		}
+		return token{kind: tokenIdent}, i
+	}
+	return token{kind: tokenNumber}, i
+}
+
+// mysql escape sequences in strings
+func unescapeChar(c byte) string {
+	switch c {
+	case '0':
+		return "\x00"
+	case 'b':
+		return "\b"
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case 'Z':
+		return "\x1a"
+	case '%', '_':
+		// these are kept with a slash. it is for LIKE patterns
+		return "\\" + string(c)
+	}
+	return string(c)
+}
+
+func isSpaceChar(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigitChar(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigitChar(c) || c == '_' || c == '$' || c >= 0x80
+}
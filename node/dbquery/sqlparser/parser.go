@@ -2,27 +2,27 @@ package sqlparser
 
 import (
 	"errors"
-	"regexp"
+	"fmt"
 	"strings"
 
 	"github.com/gelembjuk/oursql/lib"
 	"github.com/gelembjuk/oursql/node/database"
 )
 
-const (
-	querySubKindInsertSet    = "insertset"
-	querySubKindInsertValues = "insertvalues"
-)
+// functions which can be called without brackets
+var niladicFunctions = []string{"CURRENT_TIMESTAMP", "CURRENT_DATE", "CURRENT_TIME", "LOCALTIME", "LOCALTIMESTAMP",
+	"UTC_TIMESTAMP", "UTC_DATE", "UTC_TIME"}
 
 type sqlParser struct {
 	originalQuery    string
 	canonicalQuery   string
 	kind             string
-	subkind          string
 	table            string
+	quotedTable      string
+	schema           string
 	comments         []string
+	statement        statement
 	updateColumns    map[string]string
-	conditonText     string
 	conditionColumns map[string][]string
 }
 
@@ -30,11 +30,12 @@ func (q *sqlParser) Parse(sqlquery string) (err error) {
 	q.originalQuery = sqlquery
 	q.canonicalQuery = ""
 	q.kind = ""
-	q.subkind = ""
 	q.table = ""
+	q.quotedTable = ""
+	q.schema = ""
 	q.comments = []string{}
+	q.statement = nil
 	q.updateColumns = map[string]string{}
-	q.conditonText = ""
 	q.conditionColumns = map[string][]string{}
 
 	sqlquery = strings.TrimSpace(sqlquery)
@@ -46,33 +47,19 @@ func (q *sqlParser) Parse(sqlquery string) (err error) {
 
 	q.comments = comments
 
-	sqlquery, _ = q.normalizeQuery(sqlquery)
-
-	q.canonicalQuery = sqlquery
-
-	q.kind, q.table, err = q.parseKindAndTable(sqlquery)
-
-	if err != nil {
-		return
-	}
-
-	q.updateColumns, err = q.parseUpdateColumns(sqlquery, q.kind)
-
-	if err != nil {
-		return
-	}
-	q.conditonText, q.conditionColumns, err = q.parseCondition(sqlquery, q.kind)
-
-	if err != nil {
-		return
-	}
+	q.canonicalQuery, _ = q.normalizeQuery(sqlquery)
 
-	return
+	return q.parseCanonicalQuery()
 }
 
 // updates already parsed query if it is insert
 // adds one more column to a query
 func (q *sqlParser) ExtendInsert(column string, value string, coltype string) error {
+	return q.ExtendInsertRows(column, []string{value}, coltype)
+}
+
+// adds one more column to each row of insert query. values are in order of rows
+func (q *sqlParser) ExtendInsertRows(column string, values []string, coltype string) error {
 	if q.GetKind() != lib.QueryKindInsert {
 		return errors.New("Now insert query")
 	}
@@ -83,473 +70,436 @@ func (q *sqlParser) ExtendInsert(column string, value string, coltype string) er
 		return nil
 	}
 
-	if q.subkind == querySubKindInsertSet {
-		extraCond := column + "="
+	insert := q.statement.(*insertStatement)
 
-		if coltype == "int" {
-			extraCond = extraCond + database.Quote(value)
+	quoted := []string{}
+
+	for _, value := range values {
+		if coltype != "int" {
+			value = "'" + database.Quote(value) + "'"
 		} else {
-			extraCond = extraCond + "'" + database.Quote(value) + "'"
+			value = database.Quote(value)
 		}
-		q.canonicalQuery = q.canonicalQuery + ", " + extraCond
+		quoted = append(quoted, value)
+	}
 
-		return nil
+	sqlquery := q.canonicalQuery
+	// a key column can be named with a reserved word
+	quotedColumn := database.QuoteIdentifier(column)
 
-	}
-	if q.subkind == querySubKindInsertValues {
-		var extraCond string
-		if coltype == "int" {
-			extraCond = database.Quote(value)
-		} else {
-			extraCond = "'" + database.Quote(value) + "'"
-		}
-		// insert as a first column
-		re, err := regexp.Compile("(?i)^(.+into\\s+" + q.GetTable() + "\\s+\\()(.+\\)\\s+values\\s+\\()(.+)$")
+	if len(insert.set) > 0 && len(quoted) == 1 {
+		sqlquery = sqlquery[:insert.setEnd] + ", " + quotedColumn + "=" + quoted[0] + sqlquery[insert.setEnd:]
 
-		if err != nil {
-			return err
-		}
-		s := re.FindStringSubmatch(q.canonicalQuery)
+	} else if insert.columnsPos >= 0 && len(insert.rows) > 0 && len(insert.rows) == len(quoted) {
+		// insert as a first column. values lists are later in a query, so they go first, from the last row
+		sep := ", "
 
-		if len(s) < 3 {
-			return errors.New("Can not parse INSERT query")
+		if len(insert.columns) == 0 {
+			sep = ""
 		}
-		q.canonicalQuery = s[1] + column + ", " + s[2] + extraCond + ", " + s[3]
+		for i := len(insert.rows) - 1; i >= 0; i-- {
+			rowPos := insert.rows[i].pos + 1
+			sqlquery = sqlquery[:rowPos] + quoted[i] + sep + sqlquery[rowPos:]
+		}
+		sqlquery = sqlquery[:insert.columnsPos+1] + quotedColumn + sep + sqlquery[insert.columnsPos+1:]
 
-		return nil
+	} else if len(insert.rows) != len(quoted) {
+		return errors.New("Number of values must be same as number of inserted rows")
+
+	} else {
+		return errors.New("Can not parse INSERT query")
 	}
 
-	return errors.New("Unknown query type")
-}
+	q.canonicalQuery = sqlquery
 
-// ================== PARSERS =============================
-// extract comments from the query
-func (q *sqlParser) parseComments(originalsqlquery string) (sqlquery string, comments []string, err error) {
-	comments = []string{}
-	sqlquery = originalsqlquery
+	// positions of all parts are changed, parse again
+	return q.parseCanonicalQuery()
+}
 
-	r, err := regexp.Compile("/\\*(.*?)\\*/")
+// Split multi-row INSERT to list of single-row INSERT queries. Each row has same columns list and
+// same ON DUPLICATE KEY UPDATE part. For any other query it is a list with the query itself
+func (q *sqlParser) SplitInsertRows() ([]string, error) {
+	insert, ok := q.statement.(*insertStatement)
 
-	if err != nil {
-		return
+	if !ok || len(insert.rows) < 2 {
+		return []string{q.canonicalQuery}, nil
 	}
 
-	fs := r.FindAllStringSubmatch(sqlquery, -1)
-
-	for _, s := range fs {
-		comment := s[1]
-		sqlquery = strings.Replace(sqlquery, s[0], " ", 1)
-		comments = append(comments, comment)
-	}
+	head := q.canonicalQuery[:insert.rows[0].pos]
+	tail := q.canonicalQuery[insert.rows[len(insert.rows)-1].end:]
 
-	return
-}
+	queries := []string{}
 
-// normalize a query. remove extra spaces (but not from texts inside)
-// TODO
-// this muts do similar work like "github.com/percona/go-mysql/query"
-// but without replacing values with ?
-func (q *sqlParser) normalizeQuery(sqlquery string) (string, error) {
-	sqlquery = strings.Trim(sqlquery, ";")
-	sqlquery = strings.TrimSpace(sqlquery)
-	return sqlquery, nil
+	for _, row := range insert.rows {
+		queries = append(queries, head+q.canonicalQuery[row.pos:row.end]+tail)
+	}
+	return queries, nil
 }
 
-// get query kind and table name
-func (q *sqlParser) parseKindAndTable(sqlquery string) (kind string, table string, err error) {
-	lcase := strings.ToLower(sqlquery)
-
-	re := ""
+// Build SELECT query that returns all rows affected by UPDATE or DELETE query.
+// It has same table, WHERE, ORDER BY and LIMIT
+func (q *sqlParser) GetAffectedRowsSelect() (string, error) {
+	tablePos, tableEnd, filterPos, err := q.getFilterPositions()
 
-	if strings.HasPrefix(lcase, "select ") {
-		kind = lib.QueryKindSelect
-		re = "from\\s+([^ ]+)"
-
-	} else if strings.HasPrefix(lcase, "update ") {
-		kind = lib.QueryKindUpdate
-		re = "update\\s+([^ ]+)\\s"
+	if err != nil {
+		return "", err
+	}
+	sqlquery := "SELECT * FROM " + q.canonicalQuery[tablePos:tableEnd]
 
-	} else if strings.HasPrefix(lcase, "insert ") {
-		kind = lib.QueryKindInsert
-		re = "insert\\s+into\\s+([^ ]+)\\s"
+	if filterPos < len(q.canonicalQuery) {
+		sqlquery = sqlquery + " " + q.canonicalQuery[filterPos:]
+	}
+	return sqlquery, nil
+}
 
-	} else if strings.HasPrefix(lcase, "delete ") {
-		kind = lib.QueryKindDelete
-		re = "delete\\s+from\\s+([^ ]+)\\s"
+// Build UPDATE or DELETE query that affects only one row. Condition of the query is
+// replaced with column1=value1 AND column2=value2 ... , ORDER BY and LIMIT are removed
+func (q *sqlParser) MakeRowQuery(columns []string, values []string) (string, error) {
+	return q.MakeRowsQuery(columns, [][]string{values})
+}
 
-	} else if strings.HasPrefix(lcase, "create table ") {
-		kind = lib.QueryKindCreate
-		re = "create\\s+table\\s+([^ ]+)\\s"
+// Same as MakeRowQuery but for list of rows. Conditions of rows are joined with OR
+func (q *sqlParser) MakeRowsQuery(columns []string, rows [][]string) (string, error) {
+	_, _, filterPos, err := q.getFilterPositions()
 
-	} else if strings.HasPrefix(lcase, "drop table ") {
-		kind = lib.QueryKindDrop
-		re = "drop\\s+table\\s+([^ ]+)"
+	if err != nil {
+		return "", err
+	}
 
-	} else {
-		err = errors.New("Unknown query type")
+	if len(rows) == 0 {
+		return "", errors.New("No rows to build a query")
 	}
 
-	if re != "" {
-		var r *regexp.Regexp
-		r, err = regexp.Compile(re)
+	conditions := []string{}
 
-		if err != nil {
-			return
+	for _, values := range rows {
+		if len(columns) == 0 || len(columns) != len(values) {
+			return "", errors.New("Number of key columns and values must be same")
 		}
-		sr := r.FindStringSubmatch(lcase)
 
-		if len(sr) < 2 {
-			err = errors.New("Table name not found")
-			return
+		condition := []string{}
+
+		for i, column := range columns {
+			condition = append(condition, database.QuoteIdentifier(column)+"='"+database.Quote(values[i])+"'")
 		}
+		conditions = append(conditions, strings.Join(condition, " AND "))
+	}
 
-		table = sr[1]
+	if len(conditions) == 1 {
+		return strings.TrimSpace(q.canonicalQuery[:filterPos]) + " WHERE " + conditions[0], nil
 	}
+	return strings.TrimSpace(q.canonicalQuery[:filterPos]) + " WHERE (" + strings.Join(conditions, ") OR (") + ")", nil
+}
 
+// positions of a table reference and a filter part of UPDATE or DELETE query
+func (q *sqlParser) getFilterPositions() (tablePos int, tableEnd int, filterPos int, err error) {
+	switch s := q.statement.(type) {
+	case *updateStatement:
+		return s.tablePos, s.tableEnd, s.filterPos, nil
+	case *deleteStatement:
+		return s.tablePos, s.tableEnd, s.filterPos, nil
+	}
+	err = errors.New("Not UPDATE or DELETE query")
 	return
 }
 
-// parse update columns and values
-func (q *sqlParser) parseUpdateColumns(sqlquery string, kind string) (data map[string]string, err error) {
-	data = map[string]string{}
+// Replace function calls with values. The callback gets a function name in upper case and arguments
+// as they are in a query. It returns a value to put instead of a call or empty string to keep the call.
+// Functions called without brackets (CURRENT_TIMESTAMP etc) are passed with nil arguments.
+// Returns true if something was replaced
+func (q *sqlParser) ReplaceFunctionCalls(replace func(name string, args []string) (string, error)) (bool, error) {
+	tokens, err := tokenize(q.canonicalQuery)
 
-	if kind != lib.QueryKindInsert && kind != lib.QueryKindUpdate {
-		return
+	if err != nil {
+		return false, err
 	}
 
-	var r *regexp.Regexp
+	var sqlquery strings.Builder
 
-	if kind == lib.QueryKindInsert {
-		r, err = regexp.Compile("(?i)insert\\s+into\\s+[^ ]+\\s+set\\s+(.+)")
+	last := 0
+	replaced := false
 
-		if err != nil {
-			return
+	// last token is always EOF, so there is always next token for an ident
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+
+		if t.kind != tokenIdent || tokens[i+1].isOperator(".") {
+			continue
+		}
+		if i > 0 && (tokens[i-1].isOperator(".") || tokens[i-1].isKeyword("INTO", "TABLE", "UPDATE", "FROM", "JOIN")) {
+			// qualified name or a table name
+			continue
 		}
-		sr := r.FindStringSubmatch(sqlquery)
 
-		if len(sr) >= 2 {
-			q.subkind = querySubKindInsertSet
-			return q.parseKeyValueSet(sr[1])
+		end := i // last token of a call
+		var args []string
+
+		if tokens[i+1].isOperator("(") {
+			end, args, err = q.readCallArguments(tokens, i+1)
+
+			if err != nil {
+				return false, err
+			}
+		} else if !t.isKeyword(niladicFunctions...) {
+			continue
 		}
 
-		r, err = regexp.Compile("(?i)insert\\s+into\\s+[^ ]+\\s+\\((.*?)\\)\\s+values\\s+\\((.+)\\)")
+		value, err := replace(strings.ToUpper(t.text), args)
 
 		if err != nil {
-			return
+			return false, err
 		}
 
-		sr = r.FindStringSubmatch(sqlquery)
-
-		if len(sr) >= 3 {
-			q.subkind = querySubKindInsertValues
-			return q.parseValueList(sr[1], sr[2])
+		if value == "" {
+			// arguments are checked too, there can be other calls
+			continue
 		}
+		sqlquery.WriteString(q.canonicalQuery[last:t.pos])
+		sqlquery.WriteString(value)
 
-		err = errors.New("Can not parse keys/values from INSERT query")
-	} else if kind == lib.QueryKindUpdate {
+		last = tokens[end].end
+		i = end
+		replaced = true
+	}
 
-		r, err = regexp.Compile("(?i)update\\s+[^ ]+\\s+set\\s+(.+)\\swhere")
+	if !replaced {
+		return false, nil
+	}
+	sqlquery.WriteString(q.canonicalQuery[last:])
 
-		if err != nil {
-			return
-		}
-		sr := r.FindStringSubmatch(sqlquery)
+	q.canonicalQuery = sqlquery.String()
 
-		if len(sr) >= 2 {
-			return q.parseKeyValueSet(sr[1])
-		}
+	// positions of all parts are changed, parse again
+	return true, q.parseCanonicalQuery()
+}
 
-		r, err = regexp.Compile("(?i)update\\s+[^ ]+\\s+set\\s+(.+)")
+// arguments of a function call. open is index of opening bracket. Returns index of closing bracket
+func (q *sqlParser) readCallArguments(tokens []token, open int) (end int, args []string, err error) {
+	args = []string{}
 
-		if err != nil {
-			return
-		}
+	depth := 0
+	argPos := tokens[open].end
 
-		sr = r.FindStringSubmatch(sqlquery)
+	for i := open; i < len(tokens); i++ {
+		t := tokens[i]
 
-		if len(sr) >= 2 {
-			return q.parseKeyValueSet(sr[1])
-		}
+		switch {
+		case t.isOperator("("):
+			depth++
 
-		err = errors.New("Can not parse keys/values from UPDATE query")
+		case t.isOperator(")"):
+			depth--
 
-	}
+			if depth > 0 {
+				continue
+			}
+			arg := strings.TrimSpace(q.canonicalQuery[argPos:t.pos])
+
+			if arg != "" || len(args) > 0 {
+				args = append(args, arg)
+			}
+			return i, args, nil
 
+		case t.isOperator(",") && depth == 1:
+			args = append(args, strings.TrimSpace(q.canonicalQuery[argPos:t.pos]))
+			argPos = t.end
+		}
+	}
+	err = errors.New(fmt.Sprintf("Closing bracket not found for a bracket at position %d", tokens[open].pos))
 	return
 }
 
-// parse update columns and values
-func (q *sqlParser) parseKeyValueSet(command string) (map[string]string, error) {
-	data := map[string]string{}
-	r, err := regexp.Compile("((?:[^,\"']|\"[^\"]*\"|'[^']*')+)")
+// ================== PARSERS =============================
+// extract comments from the query
+func (q *sqlParser) parseComments(originalsqlquery string) (sqlquery string, comments []string, err error) {
+	comments = []string{}
+
+	tokens, err := tokenize(originalsqlquery)
 
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	st := r.FindAllString(command, -1)
+	var query strings.Builder
 
-	for _, s := range st {
-		s = strings.TrimSpace(s)
-		kv := strings.SplitN(s, "=", 2)
+	last := 0
 
-		if len(kv) != 2 {
+	for _, t := range tokens {
+		if t.kind != tokenComment {
 			continue
 		}
-		k := q.cleanSQLColumnName(kv[0])
-		v := q.cleanSQLValue(kv[1])
-
-		data[k] = v
+		query.WriteString(originalsqlquery[last:t.pos])
+		query.WriteString(" ")
+		last = t.end
 
+		comments = append(comments, t.value)
 	}
+	query.WriteString(originalsqlquery[last:])
 
-	return data, nil
+	sqlquery = query.String()
+
+	return
 }
 
-// parse names and values from insert lists
-func (q *sqlParser) parseValueList(nameslist, valueslist string) (map[string]string, error) {
-	data := map[string]string{}
+// normalize a query. remove extra spaces and ; from the end
+func (q *sqlParser) normalizeQuery(sqlquery string) (string, error) {
+	sqlquery = strings.TrimSpace(sqlquery)
 
-	nameslist = strings.TrimSpace(nameslist)
-	valueslist = strings.TrimSpace(valueslist)
+	for strings.HasSuffix(sqlquery, ";") {
+		sqlquery = strings.TrimSpace(strings.TrimSuffix(sqlquery, ";"))
+	}
+	return sqlquery, nil
+}
 
-	r, err := regexp.Compile("((?:[^,\"']|\"[^\"]*\"|'[^']*')+)")
+// build a statement from canonical query and extract all info needed for the interface
+func (q *sqlParser) parseCanonicalQuery() (err error) {
+	tokens, err := tokenize(q.canonicalQuery)
 
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	names := []string{}
-	values := []string{}
-
-	st := r.FindAllString(nameslist, -1)
-
-	for _, s := range st {
-		s = q.cleanSQLColumnName(s)
-		names = append(names, s)
+	for _, t := range tokens {
+		if t.isOperator(";") {
+			return errors.New("Multiple statements in one query are not supported")
+		}
 	}
-	st = r.FindAllString(valueslist, -1)
 
-	for _, s := range st {
-		s = q.cleanSQLValue(s)
-		values = append(values, s)
-	}
+	q.statement, err = newStatementParser(q.canonicalQuery, tokens).parseStatement()
 
-	if len(names) != len(values) {
-		return nil, errors.New("Can not parse names/values. Counts in lists are different")
-	}
-
-	for i, k := range names {
-		data[k] = values[i]
+	if err != nil {
+		return
 	}
 
-	return data, nil
-}
+	q.kind = q.statement.kind()
+	// references and permissions use a table name without a database, same table has same RefIDs in any query.
+	// SQL is built with a quoted name as it is in a query
+	q.table = q.statement.mainTable().name
+	q.schema = q.statement.mainTable().schema
+	q.quotedTable = q.statement.mainTable().quoted()
 
-// parse condition
-func (q *sqlParser) parseCondition(sqlquery string, kind string) (condtext string, columns map[string][]string, err error) {
-	columns = map[string][]string{}
+	q.updateColumns, err = q.parseUpdateColumns()
 
-	if kind != lib.QueryKindDelete && kind != lib.QueryKindUpdate {
+	if err != nil {
 		return
 	}
 
-	var r *regexp.Regexp
+	q.conditionColumns = map[string][]string{}
 
-	if kind == lib.QueryKindDelete {
-		r, err = regexp.Compile("(?i)delete\\s+from\\s+[^ ]+\\s+where\\s+(.+)")
+	collectConditionColumns(unwrapParens(q.getCondition()), q.conditionColumns)
 
-		if err != nil {
+	return
+}
+
+// parse update columns and values
+func (q *sqlParser) parseUpdateColumns() (data map[string]string, err error) {
+	data = map[string]string{}
+
+	switch s := q.statement.(type) {
+	case *insertStatement:
+		if s.fromSelect {
+			err = errors.New("INSERT ... SELECT is not supported")
 			return
 		}
-		sr := r.FindStringSubmatch(sqlquery)
-
-		if len(sr) >= 2 {
-			return q.parseConditionDetails(sr[1])
+		if len(s.set) > 0 {
+			return q.parseAssignments(s.set), nil
 		}
-
-	} else if kind == lib.QueryKindUpdate {
-
-		r, err = regexp.Compile("(?i)update\\s+[^ ]+\\s+set\\s+.+\\swhere\\s+(.+)")
-
-		if err != nil {
+		if s.columnsPos < 0 {
+			err = errors.New("Can not parse keys/values from INSERT query. List of columns is required")
 			return
 		}
-		sr := r.FindStringSubmatch(sqlquery)
-
-		if len(sr) >= 2 {
-			return q.parseConditionDetails(sr[1])
+		// for multi-row insert these are values of the first row
+		for _, row := range s.rows[1:] {
+			if len(row.values) != len(s.columns) {
+				err = errors.New("Can not parse names/values. Counts in lists are different")
+				return
+			}
 		}
+		return q.parseValueList(s.columns, s.rows[0].values)
 
+	case *updateStatement:
+		return q.parseAssignments(s.set), nil
 	}
-
 	return
 }
 
-// parse condition details
-func (q *sqlParser) parseConditionDetails(conditionstring string) (condtext string, columns map[string][]string, err error) {
-	columns = map[string][]string{}
-	conditionstring = strings.TrimSpace(conditionstring)
-	// remove LIMIT, ORDER BY , GROUP BY from the end
-	var r *regexp.Regexp
-
-	r, err = regexp.Compile("(?i)^(.+)\\slimit\\s+\\d+[^\"']+$")
-
-	if err != nil {
-		return
-	}
-	sr := r.FindStringSubmatch(conditionstring)
+// parse update columns and values
+func (q *sqlParser) parseAssignments(set []assignment) map[string]string {
+	data := map[string]string{}
 
-	if len(sr) >= 2 {
-		conditionstring = sr[1]
+	for _, a := range set {
+		data[a.column.name] = q.expressionValue(a.value)
 	}
+	return data
+}
 
-	r, err = regexp.Compile("(?i)^(.+)\\sorder\\s+by\\s+[^\"']+$")
+// parse names and values from insert lists
+func (q *sqlParser) parseValueList(names []string, values []expression) (map[string]string, error) {
+	data := map[string]string{}
 
-	if err != nil {
-		return
+	if len(names) != len(values) {
+		return nil, errors.New("Can not parse names/values. Counts in lists are different")
 	}
-	sr = r.FindStringSubmatch(conditionstring)
 
-	if len(sr) >= 2 {
-		conditionstring = sr[1]
+	for i, k := range names {
+		data[k] = q.expressionValue(values[i])
 	}
 
-	r, err = regexp.Compile("(?i)^(.+)\\sgroup\\s+by\\s+[^\"']+$")
-
-	if err != nil {
-		return
-	}
-	sr = r.FindStringSubmatch(conditionstring)
+	return data, nil
+}
 
-	if len(sr) >= 2 {
-		conditionstring = sr[1]
+// value to set to a column. It is unescaped value for string literals and expression text for all other
+func (q *sqlParser) expressionValue(e expression) string {
+	if l, ok := e.(*literalExpr); ok && l.literalKind == literalString {
+		return l.value
 	}
-	conditionstring = strings.TrimSpace(conditionstring)
+	pos, end := e.position()
 
-	// remove extra brekets
-	if strings.HasPrefix(conditionstring, "(") {
-		conditionstring = strings.TrimLeft(conditionstring, "(")
-		conditionstring = strings.TrimRight(conditionstring, ")")
+	if _, ok := e.(*columnExpr); ok {
+		// `quoted` value was always unescaped like a string
+		return q.cleanSQLValue(q.canonicalQuery[pos:end])
 	}
-	condtext = conditionstring
-	columns, err = q.parseConditionString(condtext)
+	return q.canonicalQuery[pos:end]
+}
 
-	return
+// WHERE of update, delete or transfer of a row ownership
+func (q *sqlParser) getCondition() expression {
+	switch s := q.statement.(type) {
+	case *updateStatement:
+		return s.where
+	case *deleteStatement:
+		return s.where
+	case *transferStatement:
+		return s.where
+	}
+	return nil
 }
 
 // parse condition details to columns.
 // NOTE we don't care about logic if there are AND,OR,NOT . We don't need it at this place
-// NOTE this function can not parse all condition operators, only basic >=|<=|<>|!=|=|>|<
-// all operators aka: LIKE, NOT LIKE, IS, NOT IS are not recognized here. maybe in the future
+// Only comparisons of a column with a value are returned, conditions in brackets are skipped
 func (q *sqlParser) parseConditionString(conditionstring string) (columns map[string][]string, err error) {
 	columns = map[string][]string{}
 
-	var r *regexp.Regexp
-
-	r, err = regexp.Compile("((?:[^ \"']|\"[^\"]*\"|'[^']*')+)")
-
-	if err != nil {
-		return
-	}
-
-	operators := ">=|<=|<>|!=|=|>|<"
-
-	rCOV, err := regexp.Compile("^`?([a-zA-Z0-9_]+)`?(" + operators + ")(.+)$")
+	tokens, err := tokenize(conditionstring)
 
 	if err != nil {
 		return
 	}
 
-	rCO, err := regexp.Compile("^`?([a-zA-Z0-9_]+)`?(" + operators + ")$")
+	p := newStatementParser(conditionstring, tokens)
 
-	if err != nil {
-		return
-	}
-
-	rOV, err := regexp.Compile("^(" + operators + ")(.+)$")
+	condition, err := p.parseExpression()
 
 	if err != nil {
 		return
 	}
-	rO, err := regexp.Compile("^(" + operators + ")$")
 
-	if err != nil {
+	if err = p.expectEnd(); err != nil {
 		return
 	}
 
-	st := r.FindAllString(conditionstring, -1)
-
-	column := ""
-	operator := ""
-	complicated := 0
-
-	for _, s := range st {
-
-		sl := strings.ToLower(s)
-
-		if sl == "and" || sl == "or" || sl == "not" {
-			continue
-		}
-		// s can be column name or value or operator or all together
-
-		if column == "" {
-			if strings.HasPrefix(s, "(") {
-				complicated = complicated + 1
-				// this is special case. we don't parse this yet.
-				continue
-			}
-			if complicated > 0 {
-				if strings.HasSuffix(s, ")") {
-					complicated = complicated - 1
-				}
-				continue
-			}
-			// test for ColumnOpValue, aka xxx!='ccc'
-			ss := rCOV.FindStringSubmatch(s)
-
-			if len(ss) > 2 {
-				columns[ss[1]] = []string{q.cleanSQLValue(ss[3]), ss[2]} // (VAUE, OPERATOR)
-				continue
-			}
-			// test for ColumnOp aka xxx<>
-			ss = rCO.FindStringSubmatch(s)
-
-			if len(ss) >= 2 {
-				column = ss[1]
-				operator = ss[2]
-				continue
-			}
-
-			column = q.cleanSQLColumnName(s)
-		} else if operator == "" {
-			// this is can be just operator. but we have to verify it is really operator
-			ss := rO.FindStringSubmatch(s)
-
-			if len(ss) >= 2 {
-				operator = ss[1]
-				continue
-			}
-			// test for ColumnOpValue, aka >20
-			ss = rOV.FindStringSubmatch(s)
-
-			if len(ss) >= 2 {
-				columns[column] = []string{q.cleanSQLValue(ss[2]), ss[1]} // (VAUE, OPERATOR)
-				column = ""
-				continue
-			}
-
-			// ELSE this is unknown operator. we can not parse this. we start all from scratch to find next "normal" pair
-			column = ""
-		} else {
-			// this is just a value
-			value := q.cleanSQLValue(s)
-			columns[column] = []string{value, operator}
-			column = ""
-			operator = ""
-		}
-
-	}
+	collectConditionColumns(unwrapParens(condition), columns)
 
 	return
 }
@@ -581,13 +531,6 @@ func (q *sqlParser) cleanSQLValue(value string) string {
 	return value
 }
 
-// Clean column name. remove quotes, trim spaces etc
-func (q *sqlParser) cleanSQLColumnName(name string) string {
-	name = strings.TrimSpace(name)
-	name = strings.Trim(name, "`")
-	return name
-}
-
 // ================== END PARSERS =============================
 func (q sqlParser) GetCanonicalQuery() string {
 	return q.canonicalQuery
@@ -608,8 +551,18 @@ func (q sqlParser) GetTable() string {
 	return q.table
 
 }
+
+// table name quoted to be used in generated SQL. GetTable is only for references and permissions
+func (q sqlParser) GetQuotedTable() string {
+	return q.quotedTable
+}
+
+// database of a table if it is in a query. empty if a table is in a current database
+func (q sqlParser) GetSchema() string {
+	return q.schema
+}
 func (q sqlParser) IsTableManage() bool {
-	return q.kind == QueryKindDrop || q.kind == QueryKindCreate
+	return q.kind == QueryKindDrop || q.kind == QueryKindCreate || q.kind == QueryKindAlter || q.kind == QueryKindTruncate
 }
 func (q sqlParser) IsTableDataUpdate() bool {
 	return q.kind == QueryKindDelete || q.kind == QueryKindInsert || q.kind == QueryKindUpdate
@@ -618,33 +571,96 @@ func (q sqlParser) GetUpdateColumns() map[string]string {
 	return q.updateColumns
 }
 func (q sqlParser) HasCondition() bool {
-	return len(q.conditonText) > 0
+	return q.getCondition() != nil
 }
+
+// condition is only one comparison of a column with a value with "=" operator
 func (q sqlParser) IsOneColumnCondition() bool {
-	if len(q.conditionColumns) != 1 {
-		return false
-	}
-	for _, v := range q.conditionColumns {
-		return v[1] == "="
-	}
-	return false
+	column, _ := q.GetOneColumnCondition()
+
+	return column != ""
 }
 
 // this returns data only if there is single condition and it is "=" operator
 func (q sqlParser) GetOneColumnCondition() (string, string) {
-	// if there are more 1 conditions, we don't know which to return. ordering is unknown, so it can be different each time
-	if len(q.conditionColumns) != 1 {
+	// if there are AND/OR, we don't know which to use
+	column, op, value, ok := columnComparison(q.getCondition())
+
+	if !ok || op != "=" {
 		return "", ""
 	}
+	return column.name, value.value
+}
+
+// Returns values of given columns if a condition is only "=" comparisons of these columns joined with AND.
+// It is used to find a row by a primary key, including composite keys. Values are in order of columns
+func (q sqlParser) GetKeyCondition(columns []string) ([]string, bool) {
+	terms := collectAndTerms(q.getCondition(), nil)
+
+	if len(terms) != len(columns) {
+		return nil, false
+	}
+
+	values := map[string]string{}
 
-	for k, v := range q.conditionColumns {
-		if v[1] == "=" {
-			return k, v[0]
+	for _, term := range terms {
+		column, op, value, ok := columnComparison(term)
+
+		if !ok || op != "=" {
+			return nil, false
+		}
+		if _, ok := values[column.name]; ok {
+			return nil, false
 		}
-		break
+		values[column.name] = value.value
 	}
-	return "", ""
+
+	result := []string{}
+
+	for _, column := range columns {
+		value, ok := values[column]
+
+		if !ok {
+			return nil, false
+		}
+		result = append(result, value)
+	}
+	return result, true
 }
 func (q sqlParser) GetComments() []string {
 	return q.comments
 }
+
+// permissions and an address of GRANT or REVOKE query. empty for other queries
+func (q sqlParser) GetPermissionsChange() ([]string, string) {
+	s, ok := q.statement.(*permissionsStatement)
+
+	if !ok {
+		return nil, ""
+	}
+	return s.permissions, s.address
+}
+
+// true if ENABLE ROW OWNERSHIP query, false for DISABLE and other queries
+func (q sqlParser) GetRowOwnershipChange() bool {
+	s, ok := q.statement.(*rowOwnershipStatement)
+
+	return ok && s.enable
+}
+
+// ALTER TABLE changes or drops existing columns. Its rollback must restore data, not only a structure
+func (q sqlParser) IsDataLosingAlter() bool {
+	s, ok := q.statement.(*alterTableStatement)
+
+	return ok && s.losesData
+}
+
+// new owner of a row in TRANSFER ROW OWNERSHIP query. empty for other queries
+func (q sqlParser) GetTransferAddress() string {
+	s, ok := q.statement.(*transferStatement)
+
+	if !ok {
+		return ""
+	}
+	return s.address
+}
@@ -1,8 +1,10 @@
 package sqlparser
 
 import (
+	"errors"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -21,6 +23,10 @@ func TestBasic(t *testing.T) {
 		"delete FROM t WHERE x=y":                                                             []string{"delete FROM t WHERE x=y", "delete", "t", "0"},
 		"create table ttt (a int, b varchar(10))":                                             []string{"create table ttt (a int, b varchar(10))", "create", "ttt", "0"},
 		" drop table ttt;":                                                                    []string{"drop table ttt", "drop", "ttt", "0"},
+		"BEGIN":                                                                               []string{"BEGIN", "begin", "", "0"},
+		" START TRANSACTION;":                                                                 []string{"START TRANSACTION", "begin", "", "0"},
+		"COMMIT /*SIGN:XXXXXX;DATA:YYYYYYYYYYYYY;*/":                                          []string{"COMMIT", "commit", "", "0"},
+		"rollback":                                                                            []string{"rollback", "rollback", "", "0"},
 		" UPDATE t SET a='b',c = 'X\\\"q', `d` = 2, `e`= \"3\\'33\",p = `oo\\r`": []string{"UPDATE t SET a='b',c = 'X\\\"q', `d` = 2, `e`= \"3\\'33\",p = `oo\\r`", "update", "t", "5"}}
 
 	for sql, res := range sqls {
@@ -138,9 +144,8 @@ func TestConditionString(t *testing.T) {
 		"id='2' and y!='x' and z = 2 OR p= 3 OR p <>3":                      map[string][]string{"id": []string{"2", "="}, "y": []string{"x", "!="}, "z": []string{"2", "="}, "p": []string{"3", "<>"}},
 		"id='2' and y = 'x' OR z=\"bbb\"":                                   map[string][]string{"id": []string{"2", "="}, "y": []string{"x", "="}, "z": []string{"bbb", "="}},
 		"id='2' and y='x' OR z=\"bbb\" AND (x=1 or y=2)":                    map[string][]string{"id": []string{"2", "="}, "y": []string{"x", "="}, "z": []string{"bbb", "="}},
-		"id='2' and y='x' OR z=\"bbb\" AND (x=1 or y=2 and (a=1 or b = 2))": map[string][]string{"id": []string{"2", "="}, "y": []string{"x", "="}, "z": []string{"bbb", "="}}}
-	// TODO . this test fails. Query parser needs improvement
-	//"id='2' and y='tt\\\"oo\\''":                                        map[string][]string{"id": []string{"2", "="}, "y": []string{"tt\"oo'", "="}},
+		"id='2' and y='x' OR z=\"bbb\" AND (x=1 or y=2 and (a=1 or b = 2))": map[string][]string{"id": []string{"2", "="}, "y": []string{"x", "="}, "z": []string{"bbb", "="}},
+		"id='2' and y='tt\\\"oo\\''":                                        map[string][]string{"id": []string{"2", "="}, "y": []string{"tt\"oo'", "="}}}
 
 	for s, res := range tests {
 		data, err := p.parseConditionString(s)
@@ -160,8 +165,8 @@ func TestInsert(t *testing.T) {
 	p := NewSqlParser()
 	sqls := map[string][]string{
 		"INSERT into t SET a='b',c = 'X', kc = 2":                      []string{"INSERT into t SET a='b',c = 'X', kc = 2"},
-		"INSERT into t SET a='b',c = 'X', d = 2  /*PUBKEY:ZZZZZZZZ;*/": []string{"INSERT into t SET a='b',c = 'X', d = 2, kc='5'"},
-		"INSERT into t (a,`b`, c) values (2,'oooo\\\"', \"123 \\n\" )": []string{"INSERT into t (kc, a,`b`, c) values ('5', 2,'oooo\\\"', \"123 \\n\" )"}}
+		"INSERT into t SET a='b',c = 'X', d = 2  /*PUBKEY:ZZZZZZZZ;*/": []string{"INSERT into t SET a='b',c = 'X', d = 2, `kc`='5'"},
+		"INSERT into t (a,`b`, c) values (2,'oooo\\\"', \"123 \\n\" )": []string{"INSERT into t (`kc`, a,`b`, c) values ('5', 2,'oooo\\\"', \"123 \\n\" )"}}
 
 	for sql, res := range sqls {
 		err := p.Parse(sql)
@@ -181,3 +186,762 @@ func TestInsert(t *testing.T) {
 
 	}
 }
+
+func TestKindAndTableCorpus(t *testing.T) {
+	p := NewSqlParser()
+	sqls := map[string][]string{
+		"SELECT 1":     []string{"select", ""},
+		"select now()": []string{"select", ""},
+		"SELECT a, b FROM t WHERE x = 'from other'":                       []string{"select", "t"},
+		"select 'from x' from `Tab` where a=1":                            []string{"select", "Tab"},
+		"select (select max(id) from b) from a":                           []string{"select", "a"},
+		"select * from db.t1 join t2 on t1.id=t2.id":                      []string{"select", "t1"},
+		"select * from (select * from t) as x":                            []string{"select", ""},
+		"SELECT COUNT(*) FROM `my db`.`my table`":                         []string{"select", "my table"},
+		"select * from t where a in (1,2,3) order by b limit 10":          []string{"select", "t"},
+		"INSERT INTO t(a) VALUES(1)":                                      []string{"insert", "t"},
+		"insert `t` (`a`) values ('1')":                                   []string{"insert", "t"},
+		"INSERT IGNORE INTO db.t SET a=1":                                 []string{"insert", "t"},
+		"INSERT LOW_PRIORITY INTO t SET a=1":                              []string{"insert", "t"},
+		"insert into T\n(a,\nb)\nvalues\n(1,\n'x')":                       []string{"insert", "T"},
+		"insert into t set a='where', b='values (1)'":                     []string{"insert", "t"},
+		"INSERT INTO t (id, a) VALUES (1, 2) ON DUPLICATE KEY UPDATE a=3": []string{"insert", "t"},
+		"UPDATE `t` SET a=1 WHERE id=2":                                   []string{"update", "t"},
+		"update db.`T` set a=1":                                           []string{"update", "T"},
+		"update t as x set x.a=1 where x.id=1":                            []string{"update", "t"},
+		"UPDATE LOW_PRIORITY IGNORE t SET a=1":                            []string{"update", "t"},
+		"update t set a='x where y=1' where id=1":                         []string{"update", "t"},
+		"DELETE FROM `t` WHERE id=1":                                      []string{"delete", "t"},
+		"delete from db.t where id=1 limit 1":                             []string{"delete", "t"},
+		"DELETE QUICK IGNORE FROM t WHERE id=1":                           []string{"delete", "t"},
+		"delete from t":                                                   []string{"delete", "t"},
+		"CREATE TABLE `t` (id int, PRIMARY KEY (id)) ENGINE=InnoDB":       []string{"create", "t"},
+		"create table if not exists db.t (a varchar(10) default 'x,y')":   []string{"create", "t"},
+		"create table t like t2":                                          []string{"create", "t"},
+		"DROP TABLE `t`":                                                  []string{"drop", "t"},
+		"drop table if exists db.t":                                       []string{"drop", "t"},
+		"drop temporary table t":                                          []string{"drop", "t"},
+		"ALTER TABLE `t` ADD COLUMN c int DEFAULT 0":                      []string{"alter", "t"},
+		"alter online ignore table db.t modify a int, add index (a, b)":   []string{"alter", "t"},
+		"alter table t rename column a to b, rename index i to j":         []string{"alter", "t"},
+		"TRUNCATE TABLE `t`":                                              []string{"truncate", "t"},
+		"truncate db.t":                                                   []string{"truncate", "t"},
+		"begin work":                                                      []string{"begin", ""},
+		"start transaction with consistent snapshot":                      []string{"begin", ""},
+		"Commit Work":                              []string{"commit", ""},
+		"ROLLBACK WORK":                            []string{"rollback", ""},
+		"-- comment\nselect * from t":              []string{"select", "t"},
+		"# comment\ndelete from t where id=1":      []string{"delete", "t"},
+		"/* a */ update /* b */ t set a=1 /* c */": []string{"update", "t"}}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if res[0] != p.GetKind() {
+			t.Fatalf("Kind different: %s vs %s for %s", p.GetKind(), res[0], sql)
+		}
+
+		if res[1] != p.GetTable() {
+			t.Fatalf("Table different: %s vs %s for %s", p.GetTable(), res[1], sql)
+		}
+	}
+}
+
+func TestQuotedTable(t *testing.T) {
+	p := NewSqlParser()
+	// query => table, quoted table, database
+	sqls := map[string][]string{
+		"SELECT COUNT(*) FROM `my db`.`my table`": []string{"my table", "`my db`.`my table`", "my db"},
+		"update db.`T` set a=1":                   []string{"T", "`db`.`T`", "db"},
+		"DROP TABLE `a``b`":                       []string{"a`b", "`a``b`", ""},
+		"delete from t where id=1":                []string{"t", "`t`", ""},
+		"begin":                                   []string{"", "", ""}}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if p.GetTable() != res[0] || p.GetQuotedTable() != res[1] || p.GetSchema() != res[2] {
+			t.Fatalf("Table different: %s %s %s vs %q for %s", p.GetTable(), p.GetQuotedTable(), p.GetSchema(), res, sql)
+		}
+	}
+}
+
+func TestUpdateColumnsCorpus(t *testing.T) {
+	p := NewSqlParser()
+
+	sqls := map[string]map[string]string{
+		"INSERT INTO t(a,b) VALUES(1,'x')":                                       map[string]string{"a": "1", "b": "x"},
+		"insert into t (`a b`, `c``d`) values ('1', '2')":                        map[string]string{"a b": "1", "c`d": "2"},
+		"insert into t (a, b) values ('x, y', 'where z=1')":                      map[string]string{"a": "x, y", "b": "where z=1"},
+		"insert into t (a, b) values ('it''s', \"say \"\"hi\"\"\")":              map[string]string{"a": "it's", "b": "say \"hi\""},
+		"insert into t (a, b) values ('line1\nline2', 'tab\\there')":             map[string]string{"a": "line1\nline2", "b": "tab\there"},
+		"insert into t (a, b, c) values (NULL, -5, 1.5e3)":                       map[string]string{"a": "NULL", "b": "-5", "c": "1.5e3"},
+		"insert into t (a, b) values (now(), concat('a', ',', 'b'))":             map[string]string{"a": "now()", "b": "concat('a', ',', 'b')"},
+		"insert into t (a) values ('a' 'b')":                                     map[string]string{"a": "ab"},
+		"insert into t (a) values (X'4142')":                                     map[string]string{"a": "X'4142'"},
+		"insert into t (a, b) values (1, 2) on duplicate key update b=3":         map[string]string{"a": "1", "b": "2"},
+		"insert into t set a = 'x;y', `b`=2":                                     map[string]string{"a": "x;y", "b": "2"},
+		"insert into t set a = 1 on duplicate key update a = a + 1":              map[string]string{"a": "1"},
+		"insert into t () values ()":                                             map[string]string{},
+		"update t set a = 'it\\'s', b = b + 1 where id=1":                        map[string]string{"a": "it's", "b": "b + 1"},
+		"update t set t.a = 1, `t`.`b` = 'x' where id=1":                         map[string]string{"a": "1", "b": "x"},
+		"update t set a = 'a=b, c=d' where id = 'x where y'":                     map[string]string{"a": "a=b, c=d"},
+		"update t set a = (select max(b) from t2) where id=1":                    map[string]string{"a": "(select max(b) from t2)"},
+		"update t set a = case when b > 1 then 'x' else 'y' end where id=1":      map[string]string{"a": "case when b > 1 then 'x' else 'y' end"},
+		"update t set a = date_add(now(), interval 1 day) where id=1":            map[string]string{"a": "date_add(now(), interval 1 day)"},
+		"update t set a = ? , b = ? where id = ?":                                map[string]string{"a": "?", "b": "?"},
+		"update t set a = \"multi\nline\nvalue\" where id=1":                     map[string]string{"a": "multi\nline\nvalue"},
+		"update t set a = '/* not comment */' where id=1 /* comment */":          map[string]string{"a": "/* not comment */"},
+		"update t set a = 'x' -- comment\n where id=1":                           map[string]string{"a": "x"},
+		"update t set a = cast(b as char) where id=1":                            map[string]string{"a": "cast(b as char)"},
+		"update t set a = default where id=1":                                    map[string]string{"a": "default"},
+		"update t set a = '' where id=1":                                         map[string]string{"a": ""},
+		"update t set a = 'x' collate utf8_bin where id=1":                       map[string]string{"a": "'x' collate utf8_bin"},
+		"update t set a = json_set(a, '$.x', 1) where id=1":                      map[string]string{"a": "json_set(a, '$.x', 1)"},
+		"update t set a = 5 order by id limit 1":                                 map[string]string{"a": "5"},
+		"select a, b from t where a = 1":                                         map[string]string{},
+		"delete from t where a = 1":                                              map[string]string{},
+		"create table t (a int)":                                                 map[string]string{},
+		"insert into t (a, b) values (1, (select count(*) from t2 where x=','))": map[string]string{"a": "1", "b": "(select count(*) from t2 where x=',')"}}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if !reflect.DeepEqual(res, p.GetUpdateColumns()) {
+			t.Fatalf("Fail for: %s : expected: %s , got: %s", sql, res, p.GetUpdateColumns())
+		}
+	}
+}
+
+func TestConditionCorpus(t *testing.T) {
+	p := NewSqlParser()
+	sqls := map[string][]string{
+		"update t set a=1 where id=5":                                 []string{"id", "5"},
+		"update t set a=1 where `id` = 'abc'":                         []string{"id", "abc"},
+		"update t set a=1 where t.id = 'abc'":                         []string{"id", "abc"},
+		"update t set a=1 where (id = 7)":                             []string{"id", "7"},
+		"update t set a=1 where ((id = 7))":                           []string{"id", "7"},
+		"update t set a=1 where 7 = id":                               []string{"id", "7"},
+		"update t set a=1 where id = -7":                              []string{"id", "-7"},
+		"update t set a=1 where id = 'x'' y'":                         []string{"id", "x' y"},
+		"update t set a=1 where id = 'where id=2'":                    []string{"id", "where id=2"},
+		"update t set a='id=3' where id = 4":                          []string{"id", "4"},
+		"update t set a=1 where id = 'a\nb'":                          []string{"id", "a\nb"},
+		"update t set a=1 where id = 5 order by id desc limit 1":      []string{"id", "5"},
+		"delete from t where id = 5":                                  []string{"id", "5"},
+		"delete from db.t where `id`='5' limit 1":                     []string{"id", "5"},
+		"delete from t where id = 5 /*PUBKEY:AAAA;*/":                 []string{"id", "5"},
+		"update t set a=1 where id = 1 or id = 2":                     []string{"", ""},
+		"update t set a=1 where id = 1 and b = 2":                     []string{"", ""},
+		"update t set a=1 where id in (1, 2)":                         []string{"", ""},
+		"update t set a=1 where id > 5":                               []string{"", ""},
+		"update t set a=1 where id = b":                               []string{"", ""},
+		"update t set a=1 where id = null":                            []string{"", ""},
+		"update t set a=1 where not id = 1":                           []string{"", ""},
+		"update t set a=1 where id between 1 and 2":                   []string{"", ""},
+		"update t set a=1 where id like '1%'":                         []string{"", ""},
+		"update t set a=1 where id is null":                           []string{"", ""},
+		"update t set a=1 where id = (select max(id) from t2)":        []string{"", ""},
+		"update t set a=1":                                            []string{"", ""},
+		"delete from t":                                               []string{"", ""},
+		"delete from t where exists (select 1 from t2 where t2.a=1)":  []string{"", ""},
+		"delete from t where id = 1 xor id = 2":                       []string{"", ""},
+		"delete from t where (id = 1) and (b = 2 or c = 3)":           []string{"", ""},
+		"select * from t where id=1":                                  []string{"", ""},
+		"insert into t set id=1":                                      []string{"", ""},
+		"delete from t where id = ?":                                  []string{"", ""},
+		"update t set a=1 where id = 1.5":                             []string{"id", "1.5"},
+		"update t set a=1 where id = \"dq\"":                          []string{"id", "dq"},
+		"update t set a=1 where id = 1 -- trailing comment":           []string{"id", "1"},
+		"update t set a=1 where id = 1 # trailing comment":            []string{"id", "1"},
+		"update t set a=1 where id = '#not comment' and 1=1 and 1=1":  []string{"", ""},
+		"update t set a=1 where id = '-- not comment' /* comment */ ": []string{"id", "-- not comment"}}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		x, y := p.GetOneColumnCondition()
+
+		if res[0] != x || res[1] != y {
+			t.Fatalf("Fail for: %s : expected: %s,%s , got: %s,%s", sql, res[0], res[1], x, y)
+		}
+
+		if p.IsOneColumnCondition() != (res[0] != "") {
+			t.Fatalf("IsOneColumnCondition is wrong for %s", sql)
+		}
+	}
+}
+
+func TestInsertCorpus(t *testing.T) {
+	p := NewSqlParser()
+	sqls := map[string]string{
+		"INSERT INTO t(a) VALUES(1)":                                         "INSERT INTO t(`kc`, a) VALUES('5', 1)",
+		"insert into `t` (`a`, `b`) values ('x', 'y')":                       "insert into `t` (`kc`, `a`, `b`) values ('5', 'x', 'y')",
+		"insert into db.t (a) values ('values (')":                           "insert into db.t (`kc`, a) values ('5', 'values (')",
+		"insert into t () values ()":                                         "insert into t (`kc`) values ('5')",
+		"insert into t\n(a)\nvalues\n('x\ny')":                               "insert into t\n(`kc`, a)\nvalues\n('5', 'x\ny')",
+		"insert into t set a='x, y'":                                         "insert into t set a='x, y', `kc`='5'",
+		"insert into t set a=1 on duplicate key update a=2":                  "insert into t set a=1, `kc`='5' on duplicate key update a=2",
+		"insert into t (a) values (1) on duplicate key update a=2":           "insert into t (`kc`, a) values ('5', 1) on duplicate key update a=2",
+		"insert into t (kc, a) values (7, 1)":                                "insert into t (kc, a) values (7, 1)",
+		"insert into t set kc=7":                                             "insert into t set kc=7",
+		"insert into t (a) values (1) /*PUBKEY:AAAA;*/":                      "insert into t (`kc`, a) values ('5', 1)",
+		"insert into t (a) values (concat('a', 'b')) ;":                      "insert into t (`kc`, a) values ('5', concat('a', 'b'))",
+		"INSERT INTO t (a, b) VALUES ((SELECT 1), 'x') AS new":               "INSERT INTO t (`kc`, a, b) VALUES ('5', (SELECT 1), 'x') AS new",
+		"insert into t set a = 'it\\'s' -- comment":                          "insert into t set a = 'it\\'s', `kc`='5'",
+		"insert ignore into t set a=(select b from c where d=1 limit 1)":     "insert ignore into t set a=(select b from c where d=1 limit 1), `kc`='5'",
+		"insert into t (a) values (1) on duplicate key update a=values(a)+1": "insert into t (`kc`, a) values ('5', 1) on duplicate key update a=values(a)+1"}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		err = p.ExtendInsert("kc", "5", "string")
+
+		if err != nil {
+			t.Fatalf("Error Processing: %s for %s", err.Error(), sql)
+		}
+		if res != p.GetCanonicalQuery() {
+			t.Fatalf("Canonical different: %s vs %s", p.GetCanonicalQuery(), res)
+		}
+
+		if _, ok := p.GetUpdateColumns()["kc"]; !ok {
+			t.Fatalf("Extended column is not in update columns for %s", sql)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	p := NewSqlParser()
+	sqls := []string{
+		"",
+		";",
+		"show tables",
+		"set names utf8",
+		"replace into t set a=1",
+		"create index i on t (a)",
+		"create temporary table t (a int)",
+		"update t set a=1; drop table t",
+		"delete from t where id=1; delete from t2",
+		"select * from t where a='unterminated",
+		"update t set a=`unterminated where id=1",
+		"update t set a=1 /* unterminated comment",
+		"select /*! executable */ 1",
+		"update t set a=1 where (id=1",
+		"update t set a=1 where id=1)",
+		"update t, t2 set t.a=1 where t.id=t2.id",
+		"update t join t2 on t.id=t2.id set t.a=1",
+		"delete t from t join t2 on t.id=t2.id",
+		"delete from t using t join t2",
+		"drop table t1, t2",
+		"alter table t rename to t2",
+		"alter table t add column c int, rename as t2",
+		"alter table t add column (c int",
+		"alter database db character set utf8",
+		"truncate table t1, t2",
+		"truncate",
+		"insert into t values (1, 2)",
+		"insert into t (a) select b from t2",
+		"insert into t (a) values (1), (2, 3)",
+		"insert into t (a, b) values (1)",
+		"insert into t (a) values (1",
+		"update t a=1",
+		"update t set where id=1",
+		"update t set a=1 where",
+		"delete from",
+		"rollback to savepoint x",
+		"commit and chain",
+		"select * from"}
+
+	for _, sql := range sqls {
+		err := p.Parse(sql)
+
+		if err == nil {
+			t.Fatalf("Error expected for %s", sql)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := map[string][]string{
+		"select a,b from t":           []string{"select", "a", ",", "b", "from", "t"},
+		"a<=>b a>=1 a!=2 a<>3":        []string{"a", "<=>", "b", "a", ">=", "1", "a", "!=", "2", "a", "<>", "3"},
+		"'it''s' \"q\\\"\" `a``b`":    []string{"it's", "q\"", "a`b"},
+		"1.5 .5 1e10 0x1F X'AB' 1ab":  []string{"1.5", ".5", "1e10", "0x1F", "X'AB'", "1ab"},
+		"@a @@session.x ?":            []string{"@a", "@@session.x", "?"},
+		"a--1":                        []string{"a", "-", "-", "1"},
+		"a -- c\nb # c\nc /* c */d":   []string{"a", " c", "b", " c", "c", " c ", "d"},
+		"'\\0\\n\\r\\t\\Z\\\\\\%\\x'": []string{"\x00\n\r\t\x1a\\\\%x"}}
+
+	for s, res := range tests {
+		tokens, err := tokenize(s)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), s)
+		}
+
+		values := []string{}
+
+		for _, tk := range tokens {
+			if tk.kind != tokenEOF {
+				values = append(values, tk.value)
+			}
+		}
+
+		if !reflect.DeepEqual(res, values) {
+			t.Fatalf("Fail for: %s : expected: %q , got: %q", s, res, values)
+		}
+	}
+}
+
+func TestSplitInsertRows(t *testing.T) {
+	p := NewSqlParser()
+	sqls := map[string][]string{
+		"insert into t (a, b) values (1, 'x'), (2, 'y')":                  []string{"insert into t (a, b) values (1, 'x')", "insert into t (a, b) values (2, 'y')"},
+		"INSERT INTO t(a) VALUES(1),(2),(3);":                             []string{"INSERT INTO t(a) VALUES(1)", "INSERT INTO t(a) VALUES(2)", "INSERT INTO t(a) VALUES(3)"},
+		"insert into t (a) values ('),('), (')') /*PUBKEY:AAAA;*/":        []string{"insert into t (a) values ('),(')", "insert into t (a) values (')')"},
+		"insert into t (a) values (1), (2) on duplicate key update a=a+1": []string{"insert into t (a) values (1) on duplicate key update a=a+1", "insert into t (a) values (2) on duplicate key update a=a+1"},
+		"insert into t (a)\nvalues\n(1),\n(concat('a', 'b'))":             []string{"insert into t (a)\nvalues\n(1)", "insert into t (a)\nvalues\n(concat('a', 'b'))"},
+		"insert into t (a) values (1)":                                    []string{"insert into t (a) values (1)"},
+		"insert into t set a=1":                                           []string{"insert into t set a=1"},
+		"update t set a=1 where id=2":                                     []string{"update t set a=1 where id=2"}}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		rows, err := p.SplitInsertRows()
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if !reflect.DeepEqual(res, rows) {
+			t.Fatalf("Fail for: %s : expected: %q , got: %q", sql, res, rows)
+		}
+
+		if len(rows) > 1 {
+			if p.ExtendInsert("kc", "5", "string") == nil {
+				t.Fatalf("Multi-row insert must not be extended: %s", sql)
+			}
+			// each row is normal single row insert
+			for _, row := range rows {
+				if err := p.Parse(row); err != nil {
+					t.Fatalf("Error: %s for %s", err.Error(), row)
+				}
+				if err := p.ExtendInsert("kc", "5", "string"); err != nil {
+					t.Fatalf("Error: %s for %s", err.Error(), row)
+				}
+			}
+		}
+	}
+}
+
+func TestExtendInsertRows(t *testing.T) {
+	p := NewSqlParser()
+	// query => query with key column added to each row
+	sqls := map[string]string{
+		"insert into t (a, b) values (1, 'x'), (2, 'y'), (3, 'z')":         "insert into t (`kc`, a, b) values ('5', 1, 'x'), ('6', 2, 'y'), ('7', 3, 'z')",
+		"INSERT INTO t () VALUES (), (), ()":                               "INSERT INTO t (`kc`) VALUES ('5'), ('6'), ('7')",
+		"insert into t (a) values (1),(2),(3) on duplicate key update a=1": "insert into t (`kc`, a) values ('5', 1),('6', 2),('7', 3) on duplicate key update a=1"}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		err = p.ExtendInsertRows("kc", []string{"5", "6", "7"}, "string")
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if p.GetCanonicalQuery() != res {
+			t.Fatalf("Fail for: %s : expected: %s , got: %s", sql, res, p.GetCanonicalQuery())
+		}
+
+		rows, _ := p.SplitInsertRows()
+
+		if len(rows) != 3 {
+			t.Fatalf("Extended query is not parsed again: %s", sql)
+		}
+	}
+
+	p.Parse("insert into t (a) values (1), (2)")
+
+	if p.ExtendInsertRows("kc", []string{"5"}, "string") == nil {
+		t.Fatalf("Expected error for wrong number of values")
+	}
+
+	p.Parse("insert into t set a=1")
+
+	if p.ExtendInsertRows("kc", []string{"5"}, "string") != nil || p.GetCanonicalQuery() != "insert into t set a=1, `kc`='5'" {
+		t.Fatalf("Unexpected query: %s", p.GetCanonicalQuery())
+	}
+}
+
+func TestExtendInsertReservedColumn(t *testing.T) {
+	p := NewSqlParser()
+
+	// key column is named with a reserved word
+	p.Parse("insert into t (a) values (1), (2)")
+
+	if err := p.ExtendInsertRows("key", []string{"5", "6"}, "string"); err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	if p.GetCanonicalQuery() != "insert into t (`key`, a) values ('5', 1), ('6', 2)" {
+		t.Fatalf("Unexpected query: %s", p.GetCanonicalQuery())
+	}
+
+	if _, ok := p.GetUpdateColumns()["key"]; !ok {
+		t.Fatalf("Extended column is not in update columns")
+	}
+
+	p.Parse("insert into t set a=1")
+
+	if err := p.ExtendInsert("order", "5", "string"); err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	if p.GetCanonicalQuery() != "insert into t set a=1, `order`='5'" {
+		t.Fatalf("Unexpected query: %s", p.GetCanonicalQuery())
+	}
+
+	if _, ok := p.GetUpdateColumns()["order"]; !ok {
+		t.Fatalf("Extended column is not in update columns")
+	}
+}
+
+func TestAffectedRows(t *testing.T) {
+	p := NewSqlParser()
+	// query => (select query, row query for id=5)
+	sqls := map[string][]string{
+		"update t set a=1 where status='x'":                            []string{"SELECT * FROM t where status='x'", "update t set a=1 WHERE `id`='5'"},
+		"UPDATE t SET a=a+1, b='c' WHERE x>1 ORDER BY id DESC LIMIT 3": []string{"SELECT * FROM t WHERE x>1 ORDER BY id DESC LIMIT 3", "UPDATE t SET a=a+1, b='c' WHERE `id`='5'"},
+		"update t set a=1": []string{"SELECT * FROM t", "update t set a=1 WHERE `id`='5'"},
+		"update db.t as x set x.a=1 where x.b between 1 and 10": []string{"SELECT * FROM db.t as x where x.b between 1 and 10", "update db.t as x set x.a=1 WHERE `id`='5'"},
+		"delete from t where a in (1, 2, 3)":                    []string{"SELECT * FROM t where a in (1, 2, 3)", "delete from t WHERE `id`='5'"},
+		"DELETE FROM `t` WHERE id>10 LIMIT 2 /*PUBKEY:AAAA;*/":  []string{"SELECT * FROM `t` WHERE id>10 LIMIT 2", "DELETE FROM `t` WHERE `id`='5'"},
+		"delete from t partition (p1) where a=1":                []string{"SELECT * FROM t where a=1", "delete from t partition (p1) WHERE `id`='5'"},
+		"delete from t":                                         []string{"SELECT * FROM t", "delete from t WHERE `id`='5'"}}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		selectSQL, err := p.GetAffectedRowsSelect()
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if selectSQL != res[0] {
+			t.Fatalf("Fail select for: %s : expected: %s , got: %s", sql, res[0], selectSQL)
+		}
+
+		rowSQL, err := p.MakeRowQuery([]string{"id"}, []string{"5"})
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if rowSQL != res[1] {
+			t.Fatalf("Fail row query for: %s : expected: %s , got: %s", sql, res[1], rowSQL)
+		}
+	}
+
+	p.Parse("insert into t (a) values (1)")
+
+	if _, err := p.GetAffectedRowsSelect(); err == nil {
+		t.Fatalf("Expected error for insert")
+	}
+}
+
+func TestKeyCondition(t *testing.T) {
+	p := NewSqlParser()
+	keys := []string{"tenant_id", "id"}
+	// query => values of keys. nil if the condition is not a key condition
+	sqls := map[string][]string{
+		"update t set a=1 where tenant_id=1 and id=2":                 []string{"1", "2"},
+		"update t set a=1 where id='2' AND tenant_id='x'":             []string{"x", "2"},
+		"delete from t where (tenant_id = 1) && (2 = id)":             []string{"1", "2"},
+		"delete from t where t.tenant_id = 1 and (t.id = 'a''b')":     []string{"1", "a'b"},
+		"delete from t where tenant_id = 1":                           nil,
+		"delete from t where tenant_id = 1 or id = 2":                 nil,
+		"delete from t where tenant_id = 1 and id > 2":                nil,
+		"delete from t where tenant_id = 1 and id = 2 and status='x'": nil,
+		"delete from t where tenant_id = 1 and tenant_id = 2":         nil,
+		"delete from t": nil}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		values, ok := p.GetKeyCondition(keys)
+
+		if ok != (res != nil) {
+			t.Fatalf("Key condition detection is wrong for %s", sql)
+		}
+
+		if ok && !reflect.DeepEqual(res, values) {
+			t.Fatalf("Fail for: %s : expected: %q , got: %q", sql, res, values)
+		}
+	}
+
+	p.Parse("update t set a=1 where status='x' limit 10")
+
+	rowSQL, err := p.MakeRowQuery(keys, []string{"1", "b"})
+
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	if rowSQL != "update t set a=1 WHERE `tenant_id`='1' AND `id`='b'" {
+		t.Fatalf("Unexpected row query: %s", rowSQL)
+	}
+
+	// key columns can be keywords or have spaces
+	rowSQL, err = p.MakeRowQuery([]string{"order", "a b", "c`d"}, []string{"1", "2", "3"})
+
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	if rowSQL != "update t set a=1 WHERE `order`='1' AND `a b`='2' AND `c``d`='3'" {
+		t.Fatalf("Unexpected row query: %s", rowSQL)
+	}
+
+	if _, err := p.MakeRowQuery(keys, []string{"1"}); err == nil {
+		t.Fatalf("Expected error for wrong number of values")
+	}
+
+	// many rows
+	rowSQL, err = p.MakeRowsQuery(keys, [][]string{{"1", "b"}, {"2", "c"}})
+
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	if rowSQL != "update t set a=1 WHERE (`tenant_id`='1' AND `id`='b') OR (`tenant_id`='2' AND `id`='c')" {
+		t.Fatalf("Unexpected rows query: %s", rowSQL)
+	}
+
+	if _, err := p.MakeRowsQuery(keys, [][]string{}); err == nil {
+		t.Fatalf("Expected error for empty list of rows")
+	}
+}
+
+func TestReplaceFunctionCalls(t *testing.T) {
+	p := NewSqlParser()
+	// replace calls of NOW, UUID and RAND without arguments. name with arguments is added to a value
+	replace := func(name string, args []string) (string, error) {
+		if name != "NOW" && name != "CURRENT_TIMESTAMP" && name != "UUID" && name != "RAND" {
+			return "", nil
+		}
+		if name == "RAND" && len(args) > 0 {
+			return "", nil
+		}
+		return "'" + name + strings.Join(args, "|") + "'", nil
+	}
+	sqls := map[string]string{
+		"insert into t (a, b) values (now(), UUID())":                   "insert into t (a, b) values ('NOW', 'UUID')",
+		"insert into t set a=CURRENT_TIMESTAMP, b=current_timestamp(3)": "insert into t set a='CURRENT_TIMESTAMP', b='CURRENT_TIMESTAMP3'",
+		"update t set a=date(now( )), b=rand(1) where c<rand()":         "update t set a=date('NOW'), b=rand(1) where c<'RAND'",
+		"update t set a=concat(now(), 'x', (1+2)) where b=1":            "update t set a=concat('NOW', 'x', (1+2)) where b=1",
+		"delete from now where t.now = now(1, (2, 3))":                  "delete from now where t.now = 'NOW1|(2, 3)'",
+		"update t set a=1 where b='now()' and c=`now`":                  "update t set a=1 where b='now()' and c=`now`"}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		replaced, err := p.ReplaceFunctionCalls(replace)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if replaced != (sql != res) || p.GetCanonicalQuery() != res {
+			t.Fatalf("Fail for: %s : expected: %s , got: %s", sql, res, p.GetCanonicalQuery())
+		}
+	}
+
+	p.Parse("insert into t (a, b) values (1, now())")
+	p.ReplaceFunctionCalls(replace)
+
+	if p.GetUpdateColumns()["b"] != "NOW" {
+		t.Fatalf("Query is not parsed again after replace")
+	}
+
+	p.Parse("insert into t (a) values (last_insert_id())")
+
+	_, err := p.ReplaceFunctionCalls(func(name string, args []string) (string, error) {
+		return "", errors.New("Not allowed")
+	})
+
+	if err == nil {
+		t.Fatalf("Expected error from replace callback")
+	}
+}
+
+func TestPermissionsChange(t *testing.T) {
+	p := NewSqlParser()
+	// query => (kind, table, address, permissions)
+	sqls := map[string][]string{
+		"GRANT INSERT ON t TO '1Abc'":                    []string{"grant", "t", "1Abc", "INSERT"},
+		"grant insert, update on table db.t to `1Abc`;":  []string{"grant", "t", "1Abc", "INSERT,UPDATE"},
+		"GRANT ALL PRIVILEGES ON t TO 1Abc":              []string{"grant", "t", "1Abc", "INSERT,UPDATE,DELETE,DDL"},
+		"revoke delete, ddl on T from '1Abc'":            []string{"revoke", "T", "1Abc", "DELETE,DDL"},
+		"/*PUBKEY:AAAA;*/ REVOKE ALL ON t FROM \"1Abc\"": []string{"revoke", "t", "1Abc", "INSERT,UPDATE,DELETE,DDL"}}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		permissions, address := p.GetPermissionsChange()
+
+		if p.GetKind() != res[0] || p.GetTable() != res[1] || address != res[2] || strings.Join(permissions, ",") != res[3] {
+			t.Fatalf("Fail for: %s : got: %s %s %s %s", sql, p.GetKind(), p.GetTable(), address, strings.Join(permissions, ","))
+		}
+	}
+
+	wrong := []string{
+		"GRANT SELECT ON t TO '1Abc'",
+		"GRANT INSERT ON t FROM '1Abc'",
+		"REVOKE INSERT ON t TO '1Abc'",
+		"GRANT INSERT ON t TO ''",
+		"GRANT INSERT t TO '1Abc'",
+		"GRANT INSERT ON t TO '1Abc' WITH GRANT OPTION"}
+
+	for _, sql := range wrong {
+		if p.Parse(sql) == nil {
+			t.Fatalf("Expected error for %s", sql)
+		}
+	}
+
+	p.Parse("insert into t (a) values (1)")
+
+	if permissions, address := p.GetPermissionsChange(); len(permissions) > 0 || address != "" {
+		t.Fatalf("Expected no permissions for insert")
+	}
+}
+
+func TestRowOwnership(t *testing.T) {
+	p := NewSqlParser()
+	// query => (kind, table, enable)
+	sqls := map[string][]string{
+		"ENABLE ROW OWNERSHIP ON t":           []string{"rowownership", "t", "true"},
+		"disable row ownership on table db.T": []string{"rowownership", "T", "false"}}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if p.GetKind() != res[0] || p.GetTable() != res[1] || strconv.FormatBool(p.GetRowOwnershipChange()) != res[2] {
+			t.Fatalf("Fail for: %s : got: %s %s %t", sql, p.GetKind(), p.GetTable(), p.GetRowOwnershipChange())
+		}
+	}
+
+	err := p.Parse("TRANSFER ROW OWNERSHIP ON t TO '1Abc' WHERE tenant_id=1 AND id='2'")
+
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	keyVals, ok := p.GetKeyCondition([]string{"tenant_id", "id"})
+
+	if p.GetKind() != "transfer" || p.GetTable() != "t" || p.GetTransferAddress() != "1Abc" || !ok || strings.Join(keyVals, ",") != "1,2" {
+		t.Fatalf("Fail for transfer: %s %s %s %s", p.GetKind(), p.GetTable(), p.GetTransferAddress(), strings.Join(keyVals, ","))
+	}
+
+	wrong := []string{
+		"ENABLE ROW ON t",
+		"ENABLE ROW OWNERSHIP t",
+		"TRANSFER ROW OWNERSHIP ON t TO '1Abc'",
+		"TRANSFER ROW OWNERSHIP ON t WHERE id=1",
+		"TRANSFER ROW ON t TO '1Abc' WHERE id=1"}
+
+	for _, sql := range wrong {
+		if p.Parse(sql) == nil {
+			t.Fatalf("Expected error for %s", sql)
+		}
+	}
+}
+
+func TestDataLosingAlter(t *testing.T) {
+	p := NewSqlParser()
+	// query => changes or drops existing columns
+	sqls := map[string]bool{
+		"ALTER TABLE t ADD COLUMN c int DEFAULT 0":                      false,
+		"alter table t add index (a, b), drop index i, drop key k":      false,
+		"alter table t drop primary key, drop foreign key f":            false,
+		"alter table t alter column a set default 1":                    false,
+		"alter table t rename index i to j":                             false,
+		"alter table t add column c varchar(10) default 'drop'":         false,
+		"alter table t modify a int":                                    true,
+		"alter table t add column c int, change a b varchar(10)":        true,
+		"alter table t rename column a to b":                            true,
+		"alter table t drop column a":                                   true,
+		"alter table t drop a, add index (b)":                           true,
+		"alter table t convert to character set utf8mb4":                true,
+		"alter table t add column c int, truncate partition p1":         true,
+		"alter online ignore table db.t add column c int, modify a int": true}
+
+	for sql, res := range sqls {
+		err := p.Parse(sql)
+
+		if err != nil {
+			t.Fatalf("Error: %s for %s", err.Error(), sql)
+		}
+
+		if p.IsDataLosingAlter() != res {
+			t.Fatalf("Data losing detection is wrong for %s", sql)
+		}
+	}
+
+	p.Parse("update t set a=1 where id=1")
+
+	if p.IsDataLosingAlter() {
+		t.Fatalf("Only ALTER can lose data")
+	}
+}
@@ -0,0 +1,1341 @@
+package sqlparser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gelembjuk/oursql/lib"
+)
+
+// Recursive descent parser. Builds a statement from tokens of a query
+// It understands only what is needed to find tables, columns, values and conditions.
+// Full syntax check is done by a DB server (EXPLAIN)
+type statementParser struct {
+	query  string
+	tokens []token
+	pos    int
+}
+
+// binary operators precedence. NOT has precedenceNot, unary operators are higher than all
+const (
+	precedenceOr      = 1
+	precedenceXor     = 2
+	precedenceAnd     = 3
+	precedenceNot     = 4
+	precedenceCompare = 5
+)
+
+var binaryOperatorsPrecedence = map[string]int{
+	"||": precedenceOr, "OR": precedenceOr,
+	"XOR": precedenceXor,
+	"&&":  precedenceAnd, "AND": precedenceAnd,
+	"=": precedenceCompare, "<=>": precedenceCompare, ">=": precedenceCompare, ">": precedenceCompare,
+	"<=": precedenceCompare, "<": precedenceCompare, "<>": precedenceCompare, "!=": precedenceCompare,
+	"IS": precedenceCompare, "LIKE": precedenceCompare, "REGEXP": precedenceCompare, "RLIKE": precedenceCompare,
+	"IN": precedenceCompare, "BETWEEN": precedenceCompare, "NOT": precedenceCompare, "SOUNDS": precedenceCompare,
+	"|":  6,
+	"&":  7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10, "DIV": 10, "MOD": 10,
+	"^":  11,
+	"->": 12, "->>": 12,
+}
+
+func newStatementParser(query string, tokens []token) *statementParser {
+	p := &statementParser{query: query}
+
+	// comments are not needed here
+	for _, t := range tokens {
+		if t.kind != tokenComment {
+			p.tokens = append(p.tokens, t)
+		}
+	}
+	return p
+}
+
+// ================== TOKENS NAVIGATION =============================
+func (p *statementParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *statementParser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *statementParser) next() token {
+	t := p.tokens[p.pos]
+
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// end position of last consumed token
+func (p *statementParser) lastEnd() int {
+	if p.pos == 0 {
+		return 0
+	}
+	return p.tokens[p.pos-1].end
+}
+
+func (p *statementParser) acceptKeyword(words ...string) bool {
+	if p.peek().isKeyword(words...) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *statementParser) expectKeyword(word string) error {
+	if !p.acceptKeyword(word) {
+		return p.unexpected(strings.ToUpper(word))
+	}
+	return nil
+}
+
+func (p *statementParser) acceptOperator(op string) bool {
+	if p.peek().isOperator(op) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *statementParser) expectOperator(op string) error {
+	if !p.acceptOperator(op) {
+		return p.unexpected("'" + op + "'")
+	}
+	return nil
+}
+
+func (p *statementParser) unexpected(expected string) error {
+	t := p.peek()
+
+	if t.kind == tokenEOF {
+		return errors.New(fmt.Sprintf("Unexpected end of query, expected %s", expected))
+	}
+	return errors.New(fmt.Sprintf("Unexpected '%s' at position %d, expected %s", t.text, t.pos, expected))
+}
+
+// skip tokens till closing bracket. Current token must be opening bracket
+func (p *statementParser) skipBrackets() error {
+	if err := p.expectOperator("("); err != nil {
+		return err
+	}
+	depth := 1
+
+	for depth > 0 {
+		t := p.next()
+
+		switch {
+		case t.kind == tokenEOF:
+			return errors.New("Unexpected end of query, brackets are not closed")
+		case t.isOperator("("):
+			depth++
+		case t.isOperator(")"):
+			depth--
+		}
+	}
+	return nil
+}
+
+// skip all till the end of a query. brackets must be balanced
+func (p *statementParser) skipToEnd() error {
+	for p.peek().kind != tokenEOF {
+		if p.peek().isOperator("(") {
+			if err := p.skipBrackets(); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.peek().isOperator(")") {
+			return p.unexpected("end of query")
+		}
+		p.next()
+	}
+	return nil
+}
+
+func (p *statementParser) expectEnd() error {
+	if p.peek().kind != tokenEOF {
+		return p.unexpected("end of query")
+	}
+	return nil
+}
+
+// ================== STATEMENTS =============================
+func (p *statementParser) parseStatement() (statement, error) {
+	t := p.peek()
+
+	switch {
+	case t.isKeyword("select"):
+		return p.parseSelect()
+	case t.isKeyword("insert"):
+		return p.parseInsert()
+	case t.isKeyword("update"):
+		return p.parseUpdate()
+	case t.isKeyword("delete"):
+		return p.parseDelete()
+	case t.isKeyword("create") && p.peekAt(1).isKeyword("table"):
+		return p.parseCreateTable()
+	case t.isKeyword("drop") && (p.peekAt(1).isKeyword("table") ||
+		p.peekAt(1).isKeyword("temporary") && p.peekAt(2).isKeyword("table")):
+		return p.parseDropTable()
+	case t.isKeyword("alter") && (p.peekAt(1).isKeyword("table") ||
+		p.peekAt(1).isKeyword("online", "offline", "ignore") && (p.peekAt(2).isKeyword("table") ||
+			p.peekAt(2).isKeyword("ignore") && p.peekAt(3).isKeyword("table"))):
+		return p.parseAlterTable()
+	case t.isKeyword("truncate"):
+		return p.parseTruncateTable()
+	case t.isKeyword("begin", "start", "commit", "rollback"):
+		return p.parseTransactionControl()
+	case t.isKeyword("grant", "revoke"):
+		return p.parsePermissions()
+	case t.isKeyword("enable", "disable") && p.peekAt(1).isKeyword("row"):
+		return p.parseRowOwnership()
+	case t.isKeyword("transfer"):
+		return p.parseTransfer()
+	}
+	return nil, errors.New("Unknown query type")
+}
+
+// table name can be schema.table , both parts can be quoted
+func (p *statementParser) parseTableName() (name tableName, err error) {
+	t := p.peek()
+
+	if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+		err = errors.New("Table name not found")
+		return
+	}
+	p.next()
+	name.name = t.value
+
+	if p.peek().isOperator(".") {
+		p.next()
+		t = p.peek()
+
+		if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+			err = errors.New("Table name not found")
+			return
+		}
+		p.next()
+		name.schema = name.name
+		name.name = t.value
+	}
+	return
+}
+
+// optional [AS] alias after a table name
+func (p *statementParser) skipTableAlias(stopwords ...string) {
+	if p.acceptKeyword("as") {
+		p.next()
+		return
+	}
+	t := p.peek()
+
+	if t.kind == tokenQuotedIdent || t.kind == tokenIdent && !t.isKeyword(stopwords...) {
+		p.next()
+	}
+}
+
+// SELECT. We need only main table. Where is parsed if possible, but errors are ignored, select is not replicated
+func (p *statementParser) parseSelect() (statement, error) {
+	s := &selectStatement{}
+	p.next()
+
+	// find FROM on a top level
+	for !p.peek().isKeyword("from") {
+		if p.peek().kind == tokenEOF {
+			return s, nil
+		}
+		if p.peek().isOperator("(") {
+			if err := p.skipBrackets(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p.next()
+	}
+	p.next()
+
+	if p.peek().isOperator("(") {
+		// subquery in FROM
+		return s, p.skipToEnd()
+	}
+
+	table, err := p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+	s.table = &table
+
+	for p.peek().kind != tokenEOF {
+		if p.peek().isOperator("(") {
+			if err := p.skipBrackets(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.acceptKeyword("where") {
+			start := p.pos
+
+			if where, err := p.parseExpression(); err == nil {
+				s.where = where
+			} else {
+				p.pos = start
+			}
+			break
+		}
+		p.next()
+	}
+	return s, p.skipToEnd()
+}
+
+// INSERT [LOW_PRIORITY | DELAYED | HIGH_PRIORITY] [IGNORE] [INTO] tbl
+//
+//	[(cols)] VALUES (...), (...) | SET a=b, ... | [(cols)] SELECT ...
+//	[AS alias] [ON DUPLICATE KEY UPDATE a=b, ...]
+func (p *statementParser) parseInsert() (statement, error) {
+	s := &insertStatement{columnsPos: -1}
+	p.next()
+
+	p.acceptKeyword("low_priority", "delayed", "high_priority")
+	s.ignore = p.acceptKeyword("ignore")
+	p.acceptKeyword("into")
+
+	var err error
+
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("partition") {
+		if err := p.skipBrackets(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek().isOperator("(") && !p.peekAt(1).isKeyword("select", "with") {
+		s.columnsPos = p.peek().pos
+		s.columns, err = p.parseColumnsList()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case p.acceptKeyword("values", "value"):
+		for {
+			row := valuesRow{pos: p.peek().pos}
+
+			row.values, err = p.parseExpressionsList(true)
+
+			if err != nil {
+				return nil, err
+			}
+			row.end = p.lastEnd()
+			s.rows = append(s.rows, row)
+
+			if !p.acceptOperator(",") {
+				break
+			}
+		}
+
+	case s.columnsPos < 0 && p.acceptKeyword("set"):
+		s.set, err = p.parseAssignments()
+
+		if err != nil {
+			return nil, err
+		}
+		s.setEnd = p.lastEnd()
+
+	case p.peek().isKeyword("select", "with", "table") || p.peek().isOperator("("):
+		s.fromSelect = true
+		// skip the select till ON DUPLICATE
+		for p.peek().kind != tokenEOF && !p.peek().isKeyword("on") {
+			if p.peek().isOperator("(") {
+				if err := p.skipBrackets(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			p.next()
+		}
+
+	default:
+		return nil, p.unexpected("VALUES, SET or SELECT")
+	}
+
+	if p.acceptKeyword("as") {
+		// row alias
+		p.next()
+
+		if p.peek().isOperator("(") {
+			if _, err := p.parseColumnsList(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.acceptKeyword("on") {
+		if err := p.expectKeyword("duplicate"); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("key"); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("update"); err != nil {
+			return nil, err
+		}
+		s.onDuplicate, err = p.parseAssignments()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, p.expectEnd()
+}
+
+// UPDATE [LOW_PRIORITY] [IGNORE] tbl [[AS] alias] SET a=b, ... [WHERE ...] [ORDER BY ...] [LIMIT n]
+func (p *statementParser) parseUpdate() (statement, error) {
+	s := &updateStatement{}
+	p.next()
+
+	p.acceptKeyword("low_priority")
+	p.acceptKeyword("ignore")
+
+	var err error
+
+	s.tablePos = p.peek().pos
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipTableAlias("set")
+	s.tableEnd = p.lastEnd()
+
+	if p.peek().isOperator(",") || p.peek().isKeyword("join", "inner", "left", "right", "cross", "straight_join", "natural") {
+		return nil, errors.New("Multi-table UPDATE is not supported")
+	}
+
+	if err := p.expectKeyword("set"); err != nil {
+		return nil, err
+	}
+
+	s.set, err = p.parseAssignments()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.filterPos = p.peek().pos
+
+	if p.acceptKeyword("where") {
+		s.where, err = p.parseExpression()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.limit, err = p.parseTailClauses()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s, p.expectEnd()
+}
+
+// DELETE [LOW_PRIORITY] [QUICK] [IGNORE] FROM tbl [[AS] alias] [PARTITION (...)] [WHERE ...] [ORDER BY ...] [LIMIT n]
+func (p *statementParser) parseDelete() (statement, error) {
+	s := &deleteStatement{}
+	p.next()
+
+	for p.acceptKeyword("low_priority", "quick", "ignore") {
+	}
+
+	if !p.acceptKeyword("from") {
+		// DELETE t1, t2 FROM ...
+		return nil, errors.New("Multi-table DELETE is not supported")
+	}
+
+	var err error
+
+	s.tablePos = p.peek().pos
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipTableAlias("partition", "where", "order", "limit", "using", "join", "inner", "left", "right", "cross", "straight_join", "natural")
+	s.tableEnd = p.lastEnd()
+
+	if p.peek().isOperator(",") || p.peek().isKeyword("using", "join", "inner", "left", "right", "cross", "straight_join", "natural") {
+		return nil, errors.New("Multi-table DELETE is not supported")
+	}
+
+	if p.acceptKeyword("partition") {
+		if err := p.skipBrackets(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.filterPos = p.peek().pos
+
+	if p.acceptKeyword("where") {
+		s.where, err = p.parseExpression()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.limit, err = p.parseTailClauses()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s, p.expectEnd()
+}
+
+// CREATE TABLE [IF NOT EXISTS] tbl ... . Only table name is needed
+func (p *statementParser) parseCreateTable() (statement, error) {
+	s := &createTableStatement{}
+	p.next()
+	p.next()
+
+	if p.peek().isKeyword("if") {
+		p.next()
+
+		if err := p.expectKeyword("not"); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("exists"); err != nil {
+			return nil, err
+		}
+		s.ifNotExists = true
+	}
+
+	var err error
+
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+	return s, p.skipToEnd()
+}
+
+// DROP [TEMPORARY] TABLE [IF EXISTS] tbl [RESTRICT | CASCADE]
+func (p *statementParser) parseDropTable() (statement, error) {
+	s := &dropTableStatement{}
+	p.next()
+	p.acceptKeyword("temporary")
+	p.next()
+
+	if p.peek().isKeyword("if") {
+		p.next()
+
+		if err := p.expectKeyword("exists"); err != nil {
+			return nil, err
+		}
+		s.ifExists = true
+	}
+
+	var err error
+
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().isOperator(",") {
+		return nil, errors.New("Drop of multiple tables in one query is not supported")
+	}
+
+	p.acceptKeyword("restrict", "cascade")
+
+	return s, p.expectEnd()
+}
+
+// ALTER [ONLINE | OFFLINE] [IGNORE] TABLE tbl [alter_specification [, alter_specification] ...]
+// Specifications are not parsed, we need only table name and if they can lose data. Rename of a table is not allowed,
+// a table must keep its name to be found by references
+func (p *statementParser) parseAlterTable() (statement, error) {
+	s := &alterTableStatement{}
+	p.next()
+	p.acceptKeyword("online", "offline")
+	p.acceptKeyword("ignore")
+	p.next()
+
+	var err error
+
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+
+	specStart := true
+
+	for p.peek().kind != tokenEOF {
+		t := p.peek()
+
+		if specStart && t.isKeyword("rename") && !p.peekAt(1).isKeyword("column", "index", "key") {
+			return nil, errors.New("Rename of a table with ALTER TABLE is not supported")
+		}
+		if specStart && isDataLosingAlterSpec(t, p.peekAt(1)) {
+			s.losesData = true
+		}
+		specStart = false
+
+		if t.isOperator("(") {
+			if err := p.skipBrackets(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if t.isOperator(")") {
+			return nil, p.unexpected("end of query")
+		}
+		if t.isOperator(",") {
+			specStart = true
+		}
+		p.next()
+	}
+	return s, nil
+}
+
+// Specification changes or drops existing columns or rows. A table structure before it is not enough
+// to restore data. Index and constraint changes and new columns don't lose data
+func isDataLosingAlterSpec(t token, next token) bool {
+	switch {
+	case t.isKeyword("change", "modify", "convert", "truncate"):
+		return true
+	case t.isKeyword("rename"):
+		return next.isKeyword("column")
+	case t.isKeyword("drop"):
+		return !next.isKeyword("index", "key", "primary", "foreign", "check", "constraint")
+	}
+	return false
+}
+
+// TRUNCATE [TABLE] tbl
+func (p *statementParser) parseTruncateTable() (statement, error) {
+	s := &truncateTableStatement{}
+	p.next()
+	p.acceptKeyword("table")
+
+	var err error
+
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+	return s, p.expectEnd()
+}
+
+// BEGIN [WORK], START TRANSACTION [...], COMMIT [WORK], ROLLBACK [WORK]
+func (p *statementParser) parseTransactionControl() (statement, error) {
+	t := p.next()
+
+	switch {
+	case t.isKeyword("begin"):
+		p.acceptKeyword("work")
+
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		return &transactionStatement{queryKind: lib.QueryKindBegin}, nil
+
+	case t.isKeyword("start"):
+		if err := p.expectKeyword("transaction"); err != nil {
+			return nil, err
+		}
+		// READ ONLY, WITH CONSISTENT SNAPSHOT etc
+		if err := p.skipToEnd(); err != nil {
+			return nil, err
+		}
+		return &transactionStatement{queryKind: lib.QueryKindBegin}, nil
+
+	case t.isKeyword("commit"):
+		p.acceptKeyword("work")
+
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		return &transactionStatement{queryKind: lib.QueryKindCommit}, nil
+	}
+
+	p.acceptKeyword("work")
+
+	if p.peek().isKeyword("to") {
+		return nil, errors.New("ROLLBACK TO SAVEPOINT is not supported")
+	}
+	if err := p.expectEnd(); err != nil {
+		return nil, err
+	}
+	return &transactionStatement{queryKind: lib.QueryKindRollback}, nil
+}
+
+// GRANT permission [, permission] ... ON [TABLE] tbl TO 'address'
+// REVOKE permission [, permission] ... ON [TABLE] tbl FROM 'address'
+// permission is INSERT, UPDATE, DELETE, DDL or ALL [PRIVILEGES]
+func (p *statementParser) parsePermissions() (statement, error) {
+	s := &permissionsStatement{queryKind: lib.QueryKindGrant}
+	target := "to"
+
+	if p.next().isKeyword("revoke") {
+		s.queryKind = lib.QueryKindRevoke
+		target = "from"
+	}
+
+	for {
+		t := p.peek()
+
+		switch {
+		case t.isKeyword("all"):
+			p.next()
+			p.acceptKeyword("privileges")
+			s.permissions = append(s.permissions, "INSERT", "UPDATE", "DELETE", "DDL")
+		case t.isKeyword("insert", "update", "delete", "ddl"):
+			p.next()
+			s.permissions = append(s.permissions, strings.ToUpper(t.text))
+		default:
+			return nil, p.unexpected("INSERT, UPDATE, DELETE, DDL or ALL")
+		}
+
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+
+	if err := p.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	p.acceptKeyword("table")
+
+	var err error
+
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword(target); err != nil {
+		return nil, err
+	}
+
+	s.address, err = p.parseAddress()
+
+	if err != nil {
+		return nil, err
+	}
+	return s, p.expectEnd()
+}
+
+// ENABLE ROW OWNERSHIP ON [TABLE] tbl
+// DISABLE ROW OWNERSHIP ON [TABLE] tbl
+func (p *statementParser) parseRowOwnership() (statement, error) {
+	s := &rowOwnershipStatement{enable: p.next().isKeyword("enable")}
+	p.next()
+
+	if err := p.expectKeyword("ownership"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	p.acceptKeyword("table")
+
+	var err error
+
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+	return s, p.expectEnd()
+}
+
+// TRANSFER ROW OWNERSHIP ON [TABLE] tbl TO 'address' WHERE where_condition
+func (p *statementParser) parseTransfer() (statement, error) {
+	s := &transferStatement{}
+	p.next()
+
+	if err := p.expectKeyword("row"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("ownership"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	p.acceptKeyword("table")
+
+	var err error
+
+	s.table, err = p.parseTableName()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("to"); err != nil {
+		return nil, err
+	}
+
+	s.address, err = p.parseAddress()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("where"); err != nil {
+		return nil, err
+	}
+
+	s.where, err = p.parseExpression()
+
+	if err != nil {
+		return nil, err
+	}
+	return s, p.expectEnd()
+}
+
+// address of a wallet. string or identifier
+func (p *statementParser) parseAddress() (string, error) {
+	t := p.peek()
+
+	if t.kind != tokenString && t.kind != tokenQuotedIdent && t.kind != tokenIdent || t.value == "" {
+		return "", p.unexpected("address")
+	}
+	p.next()
+
+	return t.value, nil
+}
+
+// (a, `b`, c)
+func (p *statementParser) parseColumnsList() ([]string, error) {
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+	columns := []string{}
+
+	if p.acceptOperator(")") {
+		return columns, nil
+	}
+
+	for {
+		column, err := p.parseColumn()
+
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column.name)
+
+		if p.acceptOperator(")") {
+			return columns, nil
+		}
+		if err := p.expectOperator(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// column name. can be qualified with table and schema
+func (p *statementParser) parseColumn() (*columnExpr, error) {
+	t := p.peek()
+
+	if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+		return nil, p.unexpected("column name")
+	}
+	p.next()
+
+	c := &columnExpr{exprPosition: exprPosition{t.pos, t.end}, name: t.value}
+
+	for p.peek().isOperator(".") {
+		p.next()
+		t = p.peek()
+
+		if t.kind != tokenIdent && t.kind != tokenQuotedIdent && !t.isOperator("*") {
+			return nil, p.unexpected("column name")
+		}
+		p.next()
+		c.table = c.name
+		c.name = t.value
+		c.end = t.end
+	}
+	return c, nil
+}
+
+// a = expr, b = expr ...
+func (p *statementParser) parseAssignments() ([]assignment, error) {
+	list := []assignment{}
+
+	for {
+		column, err := p.parseColumn()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.acceptOperator("=") && !p.acceptOperator(":=") {
+			return nil, p.unexpected("'='")
+		}
+
+		value, err := p.parseExpression()
+
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, assignment{column: column, value: value})
+
+		if !p.acceptOperator(",") {
+			return list, nil
+		}
+	}
+}
+
+// GROUP BY, ORDER BY, LIMIT at the end of UPDATE and DELETE. Returns LIMIT row count
+func (p *statementParser) parseTailClauses() (limit expression, err error) {
+	for _, clause := range []string{"group", "order"} {
+		if !p.acceptKeyword(clause) {
+			continue
+		}
+		if err = p.expectKeyword("by"); err != nil {
+			return
+		}
+		for {
+			if _, err = p.parseExpression(); err != nil {
+				return
+			}
+			p.acceptKeyword("asc", "desc")
+
+			if !p.acceptOperator(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("limit") {
+		if limit, err = p.parseExpression(); err != nil {
+			return
+		}
+		if p.acceptOperator(",") || p.acceptKeyword("offset") {
+			if _, err = p.parseExpression(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// ================== EXPRESSIONS =============================
+
+// (expr, expr, ...). Empty list is allowed if allowempty is true
+func (p *statementParser) parseExpressionsList(allowempty bool) ([]expression, error) {
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+	list := []expression{}
+
+	if allowempty && p.acceptOperator(")") {
+		return list, nil
+	}
+
+	for {
+		e, err := p.parseExpression()
+
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+
+		if p.acceptOperator(")") {
+			return list, nil
+		}
+		if err := p.expectOperator(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *statementParser) parseExpression() (expression, error) {
+	return p.parseBinary(precedenceOr)
+}
+
+// operator of the current token if it is binary operator
+func (p *statementParser) peekBinaryOperator() (string, int, bool) {
+	t := p.peek()
+
+	if t.kind != tokenOperator && t.kind != tokenIdent {
+		return "", 0, false
+	}
+	op := strings.ToUpper(t.text)
+
+	if op == "NOT" && !p.peekAt(1).isKeyword("in", "like", "between", "regexp", "rlike") {
+		return "", 0, false
+	}
+	prec, ok := binaryOperatorsPrecedence[op]
+
+	return op, prec, ok
+}
+
+// precedence climbing
+func (p *statementParser) parseBinary(minprecedence int) (expression, error) {
+	left, err := p.parseUnary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, prec, ok := p.peekBinaryOperator()
+
+		if !ok || prec < minprecedence {
+			return left, nil
+		}
+		p.next()
+
+		pos, _ := left.position()
+
+		not := false
+
+		if op == "NOT" {
+			not = true
+			op = strings.ToUpper(p.next().text)
+		}
+
+		switch op {
+		case "IS":
+			isop := "IS"
+
+			if p.acceptKeyword("not") {
+				isop = "IS NOT"
+			}
+			t := p.next()
+
+			if !t.isKeyword("null", "true", "false", "unknown") {
+				return nil, errors.New(fmt.Sprintf("Unexpected '%s' after IS at position %d", t.text, t.pos))
+			}
+			right := &literalExpr{exprPosition: exprPosition{t.pos, t.end}, literalKind: literalNull, value: strings.ToUpper(t.text)}
+
+			if !t.isKeyword("null") {
+				right.literalKind = literalBool
+			}
+			left = &binaryExpr{exprPosition: exprPosition{pos, t.end}, op: isop, left: left, right: right}
+
+		case "IN":
+			in := &inExpr{not: not, operand: left}
+
+			if p.peek().isOperator("(") && p.peekAt(1).isKeyword("select", "with") {
+				if err := p.skipBrackets(); err != nil {
+					return nil, err
+				}
+			} else {
+				in.list, err = p.parseExpressionsList(false)
+
+				if err != nil {
+					return nil, err
+				}
+			}
+			in.exprPosition = exprPosition{pos, p.lastEnd()}
+			left = in
+
+		case "BETWEEN":
+			b := &betweenExpr{not: not, operand: left}
+
+			b.low, err = p.parseBinary(precedenceCompare + 1)
+
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("and"); err != nil {
+				return nil, err
+			}
+			b.high, err = p.parseBinary(precedenceCompare + 1)
+
+			if err != nil {
+				return nil, err
+			}
+			b.exprPosition = exprPosition{pos, p.lastEnd()}
+			left = b
+
+		default:
+			if op == "SOUNDS" {
+				if err := p.expectKeyword("like"); err != nil {
+					return nil, err
+				}
+				op = "SOUNDS LIKE"
+			}
+			if not {
+				op = "NOT " + op
+			}
+			right, err := p.parseBinary(prec + 1)
+
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasSuffix(op, "LIKE") && p.acceptKeyword("escape") {
+				if right, err = p.parseBinary(prec + 1); err != nil {
+					return nil, err
+				}
+			}
+			left = &binaryExpr{exprPosition: exprPosition{pos, p.lastEnd()}, op: op, left: left, right: right}
+		}
+	}
+}
+
+// NOT, !, -, +, ~, BINARY
+func (p *statementParser) parseUnary() (expression, error) {
+	t := p.peek()
+
+	var op string
+	var operand expression
+	var err error
+
+	switch {
+	case t.isKeyword("not"):
+		p.next()
+		op = "NOT"
+		operand, err = p.parseBinary(precedenceNot + 1)
+
+	case t.isOperator("!") || t.isOperator("-") || t.isOperator("+") || t.isOperator("~"):
+		p.next()
+		op = t.text
+		operand, err = p.parseUnary()
+
+	case t.isKeyword("binary") && !p.peekAt(1).isOperator("("):
+		p.next()
+		op = "BINARY"
+		operand, err = p.parseUnary()
+
+	default:
+		return p.parsePrimary()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, end := operand.position()
+
+	if l, ok := operand.(*literalExpr); ok && op == "-" && l.literalKind == literalNumber {
+		// negative number is a literal
+		return &literalExpr{exprPosition: exprPosition{t.pos, end}, literalKind: literalNumber, value: "-" + l.value}, nil
+	}
+	return &unaryExpr{exprPosition: exprPosition{t.pos, end}, op: op, operand: operand}, nil
+}
+
+func (p *statementParser) parsePrimary() (expression, error) {
+	t := p.peek()
+
+	var e expression
+
+	switch {
+	case t.kind == tokenString:
+		p.next()
+		l := &literalExpr{exprPosition: exprPosition{t.pos, t.end}, literalKind: literalString, value: t.value}
+
+		// 'a' 'b' is same as 'ab'
+		for p.peek().kind == tokenString {
+			s := p.next()
+			l.value = l.value + s.value
+			l.end = s.end
+		}
+		e = l
+
+	case t.kind == tokenNumber:
+		p.next()
+		e = &literalExpr{exprPosition: exprPosition{t.pos, t.end}, literalKind: literalNumber, value: t.text}
+
+	case t.kind == tokenParam:
+		p.next()
+		e = &literalExpr{exprPosition: exprPosition{t.pos, t.end}, literalKind: literalParam, value: t.text}
+
+	case t.kind == tokenVariable:
+		p.next()
+		e = &variableExpr{exprPosition: exprPosition{t.pos, t.end}, name: t.value}
+
+	case t.isKeyword("null"):
+		p.next()
+		e = &literalExpr{exprPosition: exprPosition{t.pos, t.end}, literalKind: literalNull, value: "NULL"}
+
+	case t.isKeyword("true", "false"):
+		p.next()
+		e = &literalExpr{exprPosition: exprPosition{t.pos, t.end}, literalKind: literalBool, value: strings.ToUpper(t.text)}
+
+	case t.isKeyword("case"):
+		if err := p.skipCase(); err != nil {
+			return nil, err
+		}
+		e = &rawExpr{exprPosition{t.pos, p.lastEnd()}}
+
+	case t.isKeyword("interval"):
+		p.next()
+
+		if _, err := p.parseExpression(); err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenIdent {
+			return nil, p.unexpected("INTERVAL unit")
+		}
+		p.next()
+		e = &rawExpr{exprPosition{t.pos, p.lastEnd()}}
+
+	case t.isKeyword("exists") && p.peekAt(1).isOperator("("):
+		p.next()
+
+		if err := p.skipBrackets(); err != nil {
+			return nil, err
+		}
+		e = &rawExpr{exprPosition{t.pos, p.lastEnd()}}
+
+	case (t.kind == tokenIdent || t.kind == tokenQuotedIdent) && p.peekAt(1).isOperator("("):
+		f, err := p.parseFunction()
+
+		if err != nil {
+			return nil, err
+		}
+		e = f
+
+	case t.kind == tokenIdent || t.kind == tokenQuotedIdent:
+		c, err := p.parseColumn()
+
+		if err != nil {
+			return nil, err
+		}
+		e = c
+
+	case t.isOperator("*"):
+		// COUNT(*)
+		p.next()
+		e = &columnExpr{exprPosition: exprPosition{t.pos, t.end}, name: "*"}
+
+	case t.isOperator("("):
+		if p.peekAt(1).isKeyword("select", "with") {
+			if err := p.skipBrackets(); err != nil {
+				return nil, err
+			}
+			e = &rawExpr{exprPosition{t.pos, p.lastEnd()}}
+			break
+		}
+		list, err := p.parseExpressionsList(false)
+
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 1 {
+			e = &parenExpr{exprPosition: exprPosition{t.pos, p.lastEnd()}, inner: list[0]}
+		} else {
+			e = &rowExpr{exprPosition: exprPosition{t.pos, p.lastEnd()}, items: list}
+		}
+
+	default:
+		return nil, p.unexpected("expression")
+	}
+
+	// postfix COLLATE
+	if p.acceptKeyword("collate") {
+		p.next()
+		pos, _ := e.position()
+		e = &rawExpr{exprPosition{pos, p.lastEnd()}}
+	}
+	return e, nil
+}
+
+// function call. Arguments are parsed as expressions if possible.
+// There are functions with special syntax, like CAST(x AS CHAR) or TRIM(LEADING 'a' FROM b), such argument is raw
+func (p *statementParser) parseFunction() (expression, error) {
+	name := p.next()
+	openIndex := p.pos
+
+	if err := p.skipBrackets(); err != nil {
+		return nil, err
+	}
+	closeIndex := p.pos - 1
+
+	f := &funcExpr{exprPosition: exprPosition{name.pos, p.lastEnd()}, name: strings.ToUpper(name.value), args: []expression{}}
+
+	// split arguments by top level commas
+	depth := 0
+	start := openIndex + 1
+
+	for i := start; i <= closeIndex; i++ {
+		t := p.tokens[i]
+
+		if t.isOperator("(") {
+			depth++
+			continue
+		}
+		if t.isOperator(")") && depth > 0 {
+			depth--
+			continue
+		}
+		if depth == 0 && (t.isOperator(",") || i == closeIndex) {
+			if i > start {
+				f.args = append(f.args, p.parseArgument(start, i))
+			}
+			start = i + 1
+		}
+	}
+	return f, nil
+}
+
+// parse tokens [from, to) as an expression. If it is not possible, raw expression is returned
+func (p *statementParser) parseArgument(from, to int) expression {
+	tokens := append([]token{}, p.tokens[from:to]...)
+	tokens = append(tokens, token{kind: tokenEOF, pos: p.tokens[to].pos, end: p.tokens[to].pos})
+
+	sub := &statementParser{query: p.query, tokens: tokens}
+
+	e, err := sub.parseExpression()
+
+	if err == nil && sub.peek().kind == tokenEOF {
+		return e
+	}
+	return &rawExpr{exprPosition{p.tokens[from].pos, p.tokens[to-1].end}}
+}
+
+// CASE ... END. can be nested
+func (p *statementParser) skipCase() error {
+	depth := 0
+
+	for {
+		t := p.next()
+
+		switch {
+		case t.kind == tokenEOF:
+			return errors.New("Unexpected end of query, CASE is not closed")
+		case t.isKeyword("case"):
+			depth++
+		case t.isKeyword("end"):
+			depth--
+
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
@@ -24,10 +24,13 @@ func (um *sqlUpdateManager) Init() error {
 
 // Check if one SQL update can follow other update
 // we allow:
-// insert only after table create
-// update only after insert or update
-// delete only after insert or update
-// nothing about delete or drop
+// insert only after table create, alter or truncate or after delete of same row
+// update only after insert, update or ownership transfer of same row
+// delete only after insert, update or ownership transfer of same row
+// ownership transfer only after insert, update or ownership transfer of same row
+// alter, truncate and drop only after table create, alter or truncate
+// grant, revoke and row ownership change only after table create, alter or truncate or after other permissions change
+// create always
 
 func (um sqlUpdateManager) CheckUpdateCanFollow(sqlUpdPrev *structures.SQLUpdate) (err error) {
 	// parse both queries
@@ -46,16 +49,19 @@ func (um sqlUpdateManager) CheckUpdateCanFollow(sqlUpdPrev *structures.SQLUpdate
 	}
 
 	if um.Parsed.GetKind() != lib.QueryKindCreate &&
+		um.Parsed.GetKind() != lib.QueryKindDrop &&
+		um.Parsed.GetKind() != lib.QueryKindAlter &&
+		um.Parsed.GetKind() != lib.QueryKindTruncate &&
 		um.Parsed.GetKind() != lib.QueryKindInsert &&
 		um.Parsed.GetKind() != lib.QueryKindUpdate &&
-		um.Parsed.GetKind() != lib.QueryKindDelete {
+		um.Parsed.GetKind() != lib.QueryKindDelete &&
+		um.Parsed.GetKind() != lib.QueryKindGrant &&
+		um.Parsed.GetKind() != lib.QueryKindRevoke &&
+		um.Parsed.GetKind() != lib.QueryKindRowOwnership &&
+		um.Parsed.GetKind() != lib.QueryKindTransfer {
 
 		return errors.New("Operation is not an update query")
 	}
-	if sqlparsed1 == nil && um.Parsed.GetKind() != lib.QueryKindCreate {
-		// only create can be based on nothing
-		return errors.New("Operation is not allowed on base of given transaction")
-	}
 
 	if um.Parsed.GetKind() == lib.QueryKindCreate {
 		// we always allow create of a table
@@ -63,33 +69,58 @@ func (um sqlUpdateManager) CheckUpdateCanFollow(sqlUpdPrev *structures.SQLUpdate
 		return nil
 	}
 
+	if sqlparsed1 == nil {
+		// only create can be based on nothing
+		return errors.New("Operation is not allowed on base of given transaction")
+	}
+
 	// for all other operations a table of previous must be same as new operation
 	if sqlparsed1.GetTable() != um.Parsed.GetTable() {
 		return errors.New("Table of this SQL query must be same as a base transaction")
 	}
 
-	if um.Parsed.GetKind() == lib.QueryKindInsert {
-		// only after create and on same table
-		if sqlparsed1.GetKind() == lib.QueryKindCreate {
-			// previous TX is a table create
+	sameRow := bytes.Compare(sqlUpdPrev.ReferenceID, um.SQLUpdate.ReferenceID) == 0 &&
+		len(sqlUpdPrev.ReferenceID) > 0
+
+	// table create, alter or truncate. it is last operation on a table level
+	tableDefined := sqlparsed1.GetKind() == lib.QueryKindCreate ||
+		sqlparsed1.GetKind() == lib.QueryKindAlter ||
+		sqlparsed1.GetKind() == lib.QueryKindTruncate
+
+	switch um.Parsed.GetKind() {
+	case lib.QueryKindInsert:
+		if tableDefined {
+			// previous TX is a table create, alter or truncate
+			return
+		}
+		if sqlparsed1.GetKind() == lib.QueryKindDelete && sameRow {
+			// the row was deleted before and is inserted again
+			return
+		}
+
+	case lib.QueryKindUpdate, lib.QueryKindDelete, lib.QueryKindTransfer:
+		if (sqlparsed1.GetKind() == lib.QueryKindInsert ||
+			sqlparsed1.GetKind() == lib.QueryKindUpdate ||
+			sqlparsed1.GetKind() == lib.QueryKindTransfer) && sameRow {
+			// previous query was insert, update or ownership transfer of same row
 			return
 		}
-	}
 
-	if um.Parsed.GetKind() == lib.QueryKindUpdate ||
-		um.Parsed.GetKind() == lib.QueryKindDelete {
-		// only after create and on same table
-		if sqlparsed1.GetKind() == lib.QueryKindInsert &&
-			sqlparsed1.GetKind() == lib.QueryKindUpdate {
-			// previous query was insert or update
-
-			if bytes.Compare(sqlUpdPrev.ReferenceID, um.SQLUpdate.ReferenceID) == 0 &&
-				len(sqlUpdPrev.ReferenceID) > 0 {
-				// previous RefID must be same as now
-				return
-			}
+	case lib.QueryKindAlter, lib.QueryKindTruncate, lib.QueryKindDrop:
+		if tableDefined {
+			return
 		}
 
+	case lib.QueryKindGrant, lib.QueryKindRevoke, lib.QueryKindRowOwnership:
+		if tableDefined {
+			return
+		}
+		if (sqlparsed1.GetKind() == lib.QueryKindGrant ||
+			sqlparsed1.GetKind() == lib.QueryKindRevoke ||
+			sqlparsed1.GetKind() == lib.QueryKindRowOwnership) && sameRow {
+			// previous change of permissions of same table
+			return
+		}
 	}
 	// in all other case we don't allow
 
@@ -115,7 +146,13 @@ func (um sqlUpdateManager) CheckAllowsMultipleSubtransactions(sqlUpdPrev *struct
 		return
 	}
 
-	if sqlparsed1.GetKind() == lib.QueryKindCreate && um.Parsed.GetKind() == lib.QueryKindInsert {
+	if (sqlparsed1.GetKind() == lib.QueryKindCreate ||
+		sqlparsed1.GetKind() == lib.QueryKindAlter ||
+		sqlparsed1.GetKind() == lib.QueryKindTruncate) &&
+		(um.Parsed.GetKind() == lib.QueryKindInsert ||
+			um.Parsed.GetKind() == lib.QueryKindGrant ||
+			um.Parsed.GetKind() == lib.QueryKindRevoke ||
+			um.Parsed.GetKind() == lib.QueryKindRowOwnership) {
 		allow = true
 		return
 	}
@@ -124,8 +161,12 @@ func (um sqlUpdateManager) CheckAllowsMultipleSubtransactions(sqlUpdPrev *struct
 }
 func (um sqlUpdateManager) GetAlternativeRefID() (RefID []byte, err error) {
 	// if this is insert operation, return a table create RefID
+	// first grant or revoke is based on a table create too
 
-	if um.Parsed.GetKind() == lib.QueryKindInsert {
+	if um.Parsed.GetKind() == lib.QueryKindInsert ||
+		um.Parsed.GetKind() == lib.QueryKindGrant ||
+		um.Parsed.GetKind() == lib.QueryKindRevoke ||
+		um.Parsed.GetKind() == lib.QueryKindRowOwnership {
 		RefID = []byte(um.Parsed.GetTable() + ":*") // this is RefID of a table create  operation
 		return
 	}
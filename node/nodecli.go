@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/gelembjuk/oursql/lib/net"
 	"github.com/gelembjuk/oursql/lib/nodeclient"
@@ -20,7 +23,6 @@ type NodeCLI struct {
 	ConfigDir          string
 	Command            string
 	AlreadyRunningPort int
-	NodeAuthStr        string
 	Node               *nodemanager.Node
 }
 
@@ -49,10 +51,7 @@ func getNodeCLI(input config.AppInput) NodeCLI {
 	nd.ConfigDir = cli.ConfigDir
 	nd.Logger = cli.Logger
 
-	port, authstr := nd.GetRunningProcessInfo()
-
-	cli.AlreadyRunningPort = port
-	cli.NodeAuthStr = authstr
+	cli.AlreadyRunningPort = nd.GetRunningProcessInfo()
 
 	cli.Logger.Trace.Println("Node CLI inited")
 
@@ -81,11 +80,39 @@ func (c *NodeCLI) CreateNode() {
 
 	node.Logger = c.Logger
 	node.MinterAddress = c.Input.MinterAddress
+	node.ChainConfig = c.Input.Chain
+
+	// key of this node. it is used to encrypt connections to other nodes and to auth local requests
+	identity, err := net.LoadNodeIdentity(c.ConfigDir + config.NodeIdentityFileName)
+
+	if err != nil {
+		c.Logger.Error.Printf("Node key load error: %s", err.Error())
+	}
+	node.Identity = identity
+
+	keys, err := net.LoadNodeKeys(c.ConfigDir + config.NodeKeysFileName)
+
+	if err != nil {
+		c.Logger.Error.Printf("Known nodes keys load error: %s", err.Error())
+	}
+	node.NodeKeys = keys
 
 	node.Init()
 	node.InitNodes(c.Input.Nodes, false)
 
-	node.NodeClient.SetAuthStr(c.NodeAuthStr)
+	if c.Input.MinterAddress != "" && c.Input.Chain.Consensus.IsProofOfAuthority() {
+		// blocks are signed by the minter key
+		walletscli, err := c.getWalletsCLI()
+
+		if err == nil {
+			walletobj, err := walletscli.WalletsObj.GetWallet(c.Input.MinterAddress)
+
+			if err == nil {
+				node.MinterPubKey = walletobj.GetPublicKey()
+				node.MinterPrivKey = walletobj.GetPrivateKey()
+			}
+		}
+	}
 
 	if c.Input.ProxyKey != "" {
 		walletscli, err := c.getWalletsCLI()
@@ -125,6 +152,9 @@ func (c NodeCLI) isInteractiveMode() bool {
 		"unapprovedtransactions",
 		"mineblock",
 		"canceltransaction",
+		"signtransaction",
+		"multisigtransactions",
+		"signcheckpoint",
 		"dropblock",
 		"addrhistory",
 		"showunspent",
@@ -179,6 +209,14 @@ func (c NodeCLI) ExecuteCommand() error {
 		return errors.New("Blockchain already exists")
 	}
 
+	if bcexists {
+		err := c.Node.LoadNetworkMagic()
+
+		if err != nil {
+			return err
+		}
+	}
+
 	defer c.Node.DBConn.CloseConnection()
 
 	if c.Command == "initblockchain" {
@@ -229,6 +267,15 @@ func (c NodeCLI) ExecuteCommand() error {
 	} else if c.Command == "canceltransaction" {
 		return c.commandCancelTransaction()
 
+	} else if c.Command == "signtransaction" {
+		return c.commandSignTransaction()
+
+	} else if c.Command == "multisigtransactions" {
+		return c.commandMultiSigTransactions()
+
+	} else if c.Command == "signcheckpoint" {
+		return c.commandSignCheckpoint()
+
 	} else if c.Command == "addrhistory" {
 		return c.commandAddressHistory()
 
@@ -260,6 +307,18 @@ func (c NodeCLI) createDaemonManager() (*server.NodeDaemon, error) {
 		return nil, errors.New("Blockchain is not found. Must be created or inited")
 	}
 
+	err := c.Node.CheckChainConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Node.LoadNetworkMagic()
+
+	if err != nil {
+		return nil, err
+	}
+
 	nd.ConfigDir = c.ConfigDir
 	nd.Logger = c.Logger
 	nd.Port = c.Input.Port
@@ -688,6 +747,125 @@ func (c *NodeCLI) commandCancelTransaction() error {
 	return nil
 }
 
+// Add a signature of FROM address to SQL transaction which waits for signatures of more keys
+func (c *NodeCLI) commandSignTransaction() error {
+	txID, err := hex.DecodeString(c.Input.Args.Transaction)
+
+	if err != nil {
+		return err
+	}
+
+	walletscli, err := c.getWalletsCLI()
+
+	if err != nil {
+		return err
+	}
+
+	walletobj, err := walletscli.WalletsObj.GetWallet(c.Input.Args.From)
+
+	if err != nil {
+		return err
+	}
+
+	complete, err := c.Node.SignMultiSigTransaction(txID, walletobj.GetPublicKey(), walletobj.GetPrivateKey())
+
+	if err != nil {
+		return err
+	}
+
+	if complete {
+		fmt.Printf("Success. Transaction %x has all signatures now\n", txID)
+	} else {
+		fmt.Printf("Signed. Transaction %x waits for more signatures\n", txID)
+	}
+
+	return nil
+}
+
+// Show SQL transactions which wait for signatures of more keys
+func (c *NodeCLI) commandMultiSigTransactions() error {
+	msm, err := c.Node.GetMultiSigManager()
+
+	if err != nil {
+		return err
+	}
+
+	txs, err := msm.GetTransactions()
+
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range txs {
+		fmt.Println(tx.String())
+		fmt.Printf("  Signed by %d keys\n", len(tx.GetSignersPubKeys()))
+	}
+	fmt.Printf("\nTotal transactions: %d\n", len(txs))
+	return nil
+}
+
+// Sign a hash of a block of the primary chain to make a checkpoint. It must be added to a config of all nodes
+func (c *NodeCLI) commandSignCheckpoint() error {
+	if c.Input.Args.Height < 1 {
+		return errors.New("Height of a block is not set")
+	}
+
+	bcm, err := c.Node.GetBCManager()
+
+	if err != nil {
+		return err
+	}
+
+	block, err := bcm.GetBlockAtHeight(c.Input.Args.Height)
+
+	if err != nil {
+		return err
+	}
+
+	walletscli, err := c.getWalletsCLI()
+
+	if err != nil {
+		return err
+	}
+
+	walletobj, err := walletscli.WalletsObj.GetWallet(c.Input.Args.From)
+
+	if err != nil {
+		return err
+	}
+
+	if c.Input.Chain.Finality.CheckpointsKey != hex.EncodeToString(walletobj.GetPublicKey()) {
+		fmt.Println("Warning! The address is not a checkpoints key of the config. Nodes will not accept the checkpoint")
+	}
+
+	cp := config.Checkpoint{}
+	cp.Height = block.Height
+	cp.Hash = hex.EncodeToString(block.Hash)
+
+	signdata, err := cp.GetSignData()
+
+	if err != nil {
+		return err
+	}
+
+	signature, err := utils.SignDataByPubKey(walletobj.GetPublicKey(), walletobj.GetPrivateKey(), signdata)
+
+	if err != nil {
+		return err
+	}
+
+	cp.Signature = hex.EncodeToString(signature)
+
+	cpJSON, err := json.Marshal(cp)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(cpJSON))
+	return nil
+}
+
 // Drops last block from the top of blockchain
 func (c *NodeCLI) commandDropBlock() error {
 
@@ -756,16 +934,31 @@ func (c *NodeCLI) commandShowState(daemon *server.NodeDaemon) error {
 		fmt.Printf("  Loaded %d of %d blocks\n", info.BlocksNumber, info.ExpectingBlocksHeight+1)
 	}
 
+	if info.Sync.Active {
+		fmt.Printf("  Synchronisation: headers %d of %d, added blocks %d, nodes %d, failed requests %d\n",
+			info.Sync.HeadersHeight, info.Sync.TargetHeight, info.Sync.AppliedHeight, info.Sync.Peers, info.Sync.Retries)
+	}
+
 	fmt.Printf("  Number of unapproved transactions - %d\n", info.TransactionsCached)
 
 	fmt.Printf("  Number of unspent transactions outputs - %d\n", info.UnspentOutputs)
 
+	v := info.Violations
+
+	if v.OversizedRequests > 0 || v.ReadTimeouts > 0 || v.RejectedConnections > 0 ||
+		v.RateLimitedRequests > 0 || v.SessionOverloads > 0 {
+		fmt.Printf("  Refused requests: too long %d, read timeouts %d, connections over limit %d, rate limited %d, sessions overloaded %d\n",
+			v.OversizedRequests, v.ReadTimeouts, v.RejectedConnections, v.RateLimitedRequests, v.SessionOverloads)
+	}
+
 	return nil
 }
 
 // Displays list of nodes (connections)
 func (c *NodeCLI) commandShowNodes() error {
 	var nodes []net.NodeAddr
+	var scores map[string]net.NodeScore
+	var peersScores map[string]net.NodeScore
 	var err error
 
 	if c.AlreadyRunningPort > 0 {
@@ -776,13 +969,55 @@ func (c *NodeCLI) commandShowNodes() error {
 		if err != nil {
 			return err
 		}
+
+		info, err := nc.SendGetState()
+
+		if err != nil {
+			return err
+		}
+		scores = info.NodesScores
+		peersScores = info.PeersScores
 	} else {
 		nodes = c.Node.NodeNet.GetNodes()
+		scores = c.Node.NodeNet.GetNodesScores()
+		peersScores = c.Node.NodeNet.GetPeersScores()
 	}
 	fmt.Println("Nodes:")
 
 	for _, n := range nodes {
-		fmt.Println("  ", n.NodeAddrToString())
+		address := n.NodeAddrToString()
+
+		if score, ok := scores[address]; ok && score.Score > 0 {
+			fmt.Printf("   %s, penalty score %d of %d\n", address, score.Score, net.BanScore)
+		} else {
+			fmt.Println("  ", address)
+		}
+	}
+
+	banned := []string{}
+
+	for address, score := range scores {
+		if score.IsBanned() {
+			banned = append(banned, fmt.Sprintf("   %s, banned till %s, bans %d",
+				address, time.Unix(score.BannedTill, 0).Format("2006-01-02 15:04:05"), score.Bans))
+		}
+	}
+
+	for id, score := range peersScores {
+		if score.IsBanned() {
+			banned = append(banned, fmt.Sprintf("   key %s, banned till %s, bans %d",
+				id, time.Unix(score.BannedTill, 0).Format("2006-01-02 15:04:05"), score.Bans))
+		}
+	}
+
+	if len(banned) > 0 {
+		sort.Strings(banned)
+
+		fmt.Println("Banned nodes:")
+
+		for _, line := range banned {
+			fmt.Println(line)
+		}
 	}
 
 	return nil
@@ -2,10 +2,12 @@ package nodemanager
 
 import (
 	"errors"
+	"math/big"
 
 	"github.com/gelembjuk/oursql/lib/remoteclient"
 	"github.com/gelembjuk/oursql/lib/utils"
 	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/config"
 	"github.com/gelembjuk/oursql/node/consensus"
 	"github.com/gelembjuk/oursql/node/structures"
 	"github.com/gelembjuk/oursql/node/transactions"
@@ -14,6 +16,7 @@ import (
 type NodeBlockchain struct {
 	Logger        *utils.LoggerMan
 	MinterAddress string
+	ChainConfig   config.ChainConfig
 	DBConn        *Database
 }
 
@@ -64,6 +67,11 @@ func (n *NodeBlockchain) GetTopBlockHash() ([]byte, error) {
 	return topHash, nil
 }
 
+// Return top hash, height and work of the primary chain
+func (n *NodeBlockchain) GetChainState() ([]byte, int, *big.Int, error) {
+	return n.GetBCManager().GetTopChainWork()
+}
+
 // Returns history of transactions for given address
 func (n *NodeBlockchain) GetAddressHistory(address string) ([]structures.TransactionsHistory, error) {
 	if address == "" {
@@ -111,7 +119,7 @@ func (n *NodeBlockchain) AddBlock(block *structures.Block) (uint, error) {
 		return blockchain.BCBAddState_notAddedNoPrev, nil
 	}
 
-	Minter, err := consensus.NewBlockMakerManager(n.MinterAddress, n.DBConn.DB(), n.Logger)
+	Minter, err := consensus.NewBlockMakerManager(n.MinterAddress, n.ChainConfig, n.DBConn.DB(), n.Logger)
 
 	if err != nil {
 		return 0, err
@@ -121,7 +129,7 @@ func (n *NodeBlockchain) AddBlock(block *structures.Block) (uint, error) {
 	err = Minter.VerifyBlock(block)
 
 	if err != nil {
-		return 0, err
+		return 0, consensus.NewBlockVerifyError(block.Hash, err)
 	}
 
 	return n.GetBCManager().AddBlock(block)
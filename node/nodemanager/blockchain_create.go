@@ -1,6 +1,8 @@
 package nodemanager
 
 import (
+	"bytes"
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
 
@@ -9,7 +11,9 @@ import (
 	"github.com/gelembjuk/oursql/lib/remoteclient"
 	"github.com/gelembjuk/oursql/lib/utils"
 	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/config"
 	"github.com/gelembjuk/oursql/node/consensus"
+	"github.com/gelembjuk/oursql/node/dbquery"
 	"github.com/gelembjuk/oursql/node/structures"
 	"github.com/gelembjuk/oursql/node/transactions"
 )
@@ -17,6 +21,9 @@ import (
 type makeBlockchain struct {
 	Logger        *utils.LoggerMan
 	MinterAddress string
+	MinterPubKey  []byte
+	MinterPrivKey ecdsa.PrivateKey
+	ChainConfig   config.ChainConfig
 	DBConn        *Database
 }
 
@@ -33,7 +40,14 @@ func (n *makeBlockchain) getTransactionsManager() transactions.TransactionsManag
 
 // Init block maker object. It is used to make new blocks
 func (n *makeBlockchain) getBlockMakeManager() (consensus.BlockMakerInterface, error) {
-	return consensus.NewBlockMakerManager(n.MinterAddress, n.DBConn.DB(), n.Logger)
+	Minter, err := consensus.NewBlockMakerManager(n.MinterAddress, n.ChainConfig, n.DBConn.DB(), n.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+	Minter.SetMinterKeys(n.MinterPubKey, n.MinterPrivKey)
+
+	return Minter, nil
 }
 
 // Create new blockchain, add genesis block witha given text
@@ -51,10 +65,17 @@ func (n *makeBlockchain) CreateBlockchain() error {
 	if err != nil {
 		return err
 	}
+	// chain parameters are part of the genesis block hash. other nodes check they use same parameters
+	genesisBlock.ChainConfig = n.ChainConfig.GetHash()
 
-	Minter, _ := n.getBlockMakeManager()
+	// engine of the chain is selected by a config. proof of work or proof of authority
+	Minter, err := n.getBlockMakeManager()
+
+	if err != nil {
+		return err
+	}
 
-	n.Logger.Trace.Printf("Complete genesis block proof of work\n")
+	n.Logger.Trace.Printf("Complete genesis block\n")
 
 	Minter.SetPreparedBlock(genesisBlock)
 
@@ -108,6 +129,10 @@ func (n *makeBlockchain) InitBlockchainFromOther(addr net.NodeAddr, nodeclient *
 		return false, err
 	}
 	n.Logger.Trace.Printf("Importing first block hash %x", block.Hash)
+
+	if len(block.ChainConfig) > 0 && bytes.Compare(block.ChainConfig, n.ChainConfig.GetHash()) != 0 {
+		return false, errors.New("Chain config is not same as the blockchain was created with. Use same genesis config")
+	}
 	// make blockchain with single block
 	err = n.addFirstBlock(block)
 
@@ -168,7 +193,7 @@ func (n *makeBlockchain) prepareGenesisBlock(address, genesisCoinbaseData string
 		return nil, errors.New("Geneisis block text missed")
 	}
 
-	cbtx, errc := structures.NewCoinbaseTransaction(address, genesisCoinbaseData)
+	cbtx, errc := structures.NewCoinbaseTransaction(address, genesisCoinbaseData, n.ChainConfig.GetBlockReward())
 
 	if errc != nil {
 		return nil, errors.New(fmt.Sprintf("Error creating coinbase TX %s", errc.Error()))
@@ -225,6 +250,13 @@ func (n *makeBlockchain) addFirstBlock(genesis *structures.Block) error {
 		return err
 	}
 
+	// tables permissions from a genesis config
+	err = dbquery.InitTablesPermissions(n.DBConn.DB(), n.Logger, n.ChainConfig.ACL)
+
+	if err != nil {
+		return err
+	}
+
 	n.Logger.Trace.Printf("Prepare TX caches\n")
 
 	n.getTransactionsManager().BlockAdded(genesis, true)
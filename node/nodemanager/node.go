@@ -1,6 +1,7 @@
 package nodemanager
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"errors"
 	"math/rand"
@@ -12,6 +13,7 @@ import (
 	"github.com/gelembjuk/oursql/lib/remoteclient"
 	"github.com/gelembjuk/oursql/lib/utils"
 	"github.com/gelembjuk/oursql/node/blockchain"
+	"github.com/gelembjuk/oursql/node/config"
 	"github.com/gelembjuk/oursql/node/consensus"
 	"github.com/gelembjuk/oursql/node/structures"
 	"github.com/gelembjuk/oursql/node/transactions"
@@ -27,13 +29,19 @@ type Node struct {
 
 	ConfigDir       string
 	MinterAddress   string
+	MinterPubKey    []byte // keys of the minter address. used to sign blocks with proof of authority
+	MinterPrivKey   ecdsa.PrivateKey
 	ProxyPubKey     []byte
 	ProxyPrivateKey ecdsa.PrivateKey
+	ChainConfig     config.ChainConfig
 
 	OtherNodes []net.NodeAddr
+	Identity   *net.NodeIdentity // key used in connections to other nodes
+	NodeKeys   *net.NodeKeys     // pinned IDs of other nodes
 
 	SessionID string
 	locks     *NodeLocks
+	syncer    *blocksSync
 }
 type NodeLocks struct {
 	blockAddLock *sync.Mutex
@@ -49,6 +57,7 @@ func (n *Node) Init() {
 	n.NodeBC.Logger = n.Logger
 
 	n.NodeBC.MinterAddress = n.MinterAddress
+	n.NodeBC.ChainConfig = n.ChainConfig
 
 	n.NodeBC.DBConn = n.DBConn
 
@@ -62,6 +71,10 @@ func (n *Node) Init() {
 	n.locks = &NodeLocks{}
 	n.locks.InitLocks()
 
+	if n.syncer == nil {
+		n.syncer = &blocksSync{}
+	}
+
 	rand.Seed(time.Now().UTC().UnixNano())
 }
 
@@ -72,15 +85,24 @@ func (orignode *Node) Clone() *Node {
 	node.ConfigDir = orignode.ConfigDir
 	node.Logger = orignode.Logger
 	node.MinterAddress = orignode.MinterAddress
+	node.MinterPubKey = orignode.MinterPubKey
+	node.MinterPrivKey = orignode.MinterPrivKey
+	node.ChainConfig = orignode.ChainConfig
+	node.Identity = orignode.Identity
+	node.NodeKeys = orignode.NodeKeys
 	// clone DB object
 	ndb := orignode.DBConn.Clone()
 	node.DBConn = &ndb
 
 	node.locks = orignode.locks
+	node.syncer = orignode.syncer
+	node.NodeNet.Scores = orignode.NodeNet.Scores
 
 	node.Init()
 
 	node.NodeClient.SetNodeAddress(orignode.NodeClient.NodeAddress)
+	node.NodeClient.NetworkMagic = orignode.NodeClient.NetworkMagic
+	node.NodeClient.Sessions = orignode.NodeClient.Sessions
 
 	node.InitNodes(orignode.NodeNet.Nodes, true) // set list of nodes and skip loading default if this is empty list
 
@@ -110,7 +132,14 @@ func (n *Node) GetBlockChainIterator() (*blockchain.BlockchainIterator, error) {
 
 // Init block maker object. It is used to make new blocks
 func (n *Node) getBlockMakeManager() (consensus.BlockMakerInterface, error) {
-	return consensus.NewBlockMakerManager(n.MinterAddress, n.DBConn.DB(), n.Logger)
+	Minter, err := consensus.NewBlockMakerManager(n.MinterAddress, n.ChainConfig, n.DBConn.DB(), n.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+	Minter.SetMinterKeys(n.MinterPubKey, n.MinterPrivKey)
+
+	return Minter, nil
 }
 
 // Init SQL transactions manager
@@ -121,12 +150,17 @@ func (n *Node) GetSQLQueryManager() (consensus.SQLTransactionsInterface, error)
 	} else {
 		n.Logger.Trace.Printf("Make query manager without proxy key")
 	}
-	return consensus.NewSQLQueryManager(n.DBConn.DB(), n.Logger, n.ProxyPubKey, n.ProxyPrivateKey)
+	return consensus.NewSQLQueryManager(n.ChainConfig, n.DBConn.DB(), n.Logger, n.ProxyPubKey, n.ProxyPrivateKey)
+}
+
+// Init manager of SQL transactions which wait for signatures of more keys
+func (n *Node) GetMultiSigManager() (consensus.MultiSigTransactionsInterface, error) {
+	return consensus.NewMultiSigManager(n.ChainConfig, n.DBConn.DB(), n.Logger)
 }
 
 // Init block maker object. It is used to make new blocks
 func (n *Node) getCreateManager() *makeBlockchain {
-	return &makeBlockchain{n.Logger, n.MinterAddress, n.DBConn}
+	return &makeBlockchain{n.Logger, n.MinterAddress, n.MinterPubKey, n.MinterPrivKey, n.ChainConfig, n.DBConn}
 }
 
 // Init network client object. It is used to communicate with other nodes
@@ -139,6 +173,9 @@ func (n *Node) InitClient() error {
 
 	client.Logger = n.Logger
 	client.NodeNet = &n.NodeNet
+	client.ChainConfig = n.ChainConfig.GetHash()
+	client.Identity = n.Identity
+	client.Keys = n.NodeKeys
 
 	n.NodeClient = &client
 
@@ -175,6 +212,60 @@ func (n *Node) BlockchainExist() bool {
 	return exists
 }
 
+// Check that a chain config of this node is same as the blockchain was created with.
+// Blockchains created before a config hash was saved in a genesis block are not checked
+func (n *Node) CheckChainConfig() error {
+	if n.DBConn.OpenConnectionIfNeeded("CheckChainConfig", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	bcm, err := n.GetBCManager()
+
+	if err != nil {
+		return err
+	}
+
+	genesisHash, err := bcm.GetGenesisBlockHash()
+
+	if err != nil {
+		return err
+	}
+
+	genesis, err := bcm.GetBlock(genesisHash)
+
+	if err != nil {
+		return err
+	}
+
+	if len(genesis.ChainConfig) > 0 && bytes.Compare(genesis.ChainConfig, n.ChainConfig.GetHash()) != 0 {
+		return errors.New("Chain config is not same as the blockchain was created with. Check Chain of the config file")
+	}
+	return nil
+}
+
+// Load a network magic from a genesis block hash. It is sent with every request to other nodes
+func (n *Node) LoadNetworkMagic() error {
+	if n.DBConn.OpenConnectionIfNeeded("LoadNetworkMagic", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	bcm, err := n.GetBCManager()
+
+	if err != nil {
+		return err
+	}
+
+	genesisHash, err := bcm.GetGenesisBlockHash()
+
+	if err != nil {
+		return err
+	}
+
+	n.NodeClient.NetworkMagic = net.NetworkMagicFromGenesis(genesisHash)
+
+	return nil
+}
+
 // Create new blockchain, add genesis block witha given text
 func (n *Node) CreateBlockchain(minterAddress string) error {
 	bccreator := n.getCreateManager()
@@ -202,12 +293,29 @@ func (n *Node) InitBlockchainFromOther(host string, port int) (bool, error) {
 
 	complete, err := n.getCreateManager().InitBlockchainFromOther(addr, n.NodeClient, &n.NodeBC)
 
+	if err != nil {
+		return false, err
+	}
+	// now we know a network
+	err = n.LoadNetworkMagic()
+
 	if err != nil {
 		return false, err
 	}
 	// add that node to list of known nodes.
 	n.NodeNet.AddNodeToKnown(addr)
 
+	if !complete {
+		// load rest of blocks from that node and other known nodes
+		err = n.SyncBlocks([]net.NodeAddr{addr})
+
+		if err != nil {
+			n.Logger.Trace.Printf("Blocks synchronisation failed: %s", err.Error())
+		} else {
+			complete = true
+		}
+	}
+
 	return complete, nil
 }
 
@@ -238,6 +346,27 @@ func (n *Node) SendTransactionToAll(tx *structures.Transaction) {
 	}
 }
 
+// Send SQL transaction waiting for signatures to all other nodes.
+// Full TX is sent, other nodes can not request it with getdata because it is not in the pool
+func (n *Node) SendMultiSigTransactionToAll(tx *structures.Transaction) {
+	txData, err := structures.SerializeTransaction(tx)
+
+	if err != nil {
+		n.Logger.Error.Printf("Serialize TX %x error: %s", tx.GetID(), err.Error())
+		return
+	}
+
+	n.Logger.Trace.Printf("Send multisig transaction to %d nodes", len(n.NodeNet.Nodes))
+
+	for _, node := range n.NodeNet.Nodes {
+		if node.CompareToAddress(n.NodeClient.NodeAddress) {
+			continue
+		}
+		n.Logger.Trace.Printf("Send multisig TX %x to %s", tx.GetID(), node.NodeAddrToString())
+		n.NodeClient.SendMultiSigTx(node, txData)
+	}
+}
+
 // Add node
 // We need this for case when we want to do some more actions after node added
 func (n *Node) AddNodeToKnown(addr net.NodeAddr, sendversion bool) {
@@ -276,7 +405,7 @@ func (n *Node) SendBlockToAll(newBlock *structures.Block, skipaddr net.NodeAddr)
  */
 func (n *Node) SendVersionToNodes(nodes []net.NodeAddr) {
 	opened := n.DBConn.OpenConnectionIfNeeded("GetHeigh", n.SessionID)
-	bestHeight, err := n.NodeBC.GetBestHeight()
+	topHash, bestHeight, chainWork, err := n.NodeBC.GetChainState()
 
 	if opened {
 		n.DBConn.CloseConnection()
@@ -294,7 +423,7 @@ func (n *Node) SendVersionToNodes(nodes []net.NodeAddr) {
 		if node.CompareToAddress(n.NodeClient.NodeAddress) {
 			continue
 		}
-		n.NodeClient.SendVersion(node, bestHeight)
+		n.NodeClient.SendVersion(node, bestHeight, topHash, chainWork.Bytes())
 	}
 }
 
@@ -352,6 +481,11 @@ func (n *Node) SQLTransaction(PubKey []byte, privKey ecdsa.PrivateKey, sqlcomman
 
 	_, tx, err := qm.NewQueryByNode(sqlcommand, PubKey, privKey)
 
+	if perr, ok := err.(*consensus.MultiSigPendingError); ok {
+		// other keys must sign it. they can do this on any node
+		n.SendMultiSigTransactionToAll(perr.TX)
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -364,6 +498,29 @@ func (n *Node) SQLTransaction(PubKey []byte, privKey ecdsa.PrivateKey, sqlcomman
 	return nil, nil
 }
 
+// Sign SQL transaction waiting for signatures with one more key.
+// Returns true if the TX has all signatures now. Then it is in the pool and the query is executed
+func (n *Node) SignMultiSigTransaction(txID []byte, PubKey []byte, privKey ecdsa.PrivateKey) (bool, error) {
+	msm, err := n.GetMultiSigManager()
+
+	if err != nil {
+		return false, err
+	}
+
+	tx, complete, err := msm.SignTransaction(txID, PubKey, privKey)
+
+	if err != nil {
+		return false, err
+	}
+
+	if complete {
+		n.SendTransactionToAll(tx)
+	} else {
+		n.SendMultiSigTransactionToAll(tx)
+	}
+	return complete, nil
+}
+
 // Try to make a block. If no enough transactions, send new transaction to all other nodes
 func (n *Node) TryToMakeBlock(newTransactionID []byte) ([]byte, error) {
 	n.Logger.Trace.Println("Try to make new block")
@@ -376,7 +533,11 @@ func (n *Node) TryToMakeBlock(newTransactionID []byte) ([]byte, error) {
 
 	n.Logger.Trace.Println("Create block maker")
 	// check how many transactions are ready to be added to a block
-	Minter, _ := n.getBlockMakeManager()
+	Minter, err := n.getBlockMakeManager()
+
+	if err != nil {
+		return nil, err
+	}
 
 	prepres, err := Minter.PrepareNewBlock()
 
@@ -572,7 +733,7 @@ func (n *Node) ReceivedFullBlockFromOtherNode(blockdata []byte) (int, uint, *str
 	block, err := structures.NewBlockFromBytes(blockdata)
 
 	if err != nil {
-		return -1, addstate, nil, err
+		return -1, addstate, nil, consensus.NewBlockVerifyError(nil, err)
 	}
 	// lock this process to prevent conflicts
 	n.locks.blockInLock.Lock()
@@ -602,6 +763,35 @@ func (n *Node) ReceivedFullBlockFromOtherNode(blockdata []byte) (int, uint, *str
 	return blockstate, addstate, block, nil
 }
 
+// Penalize a node which sent a block that was not added. Errors not related to the block itself are skipped.
+// Returns true if the node is banned
+func (n *Node) PenalizeForBlock(addr net.NodeAddr, err error) bool {
+	penalty, ok := GetBlockPenalty(err)
+
+	if !ok {
+		return false
+	}
+	return n.NodeNet.PenalizeNode(addr, penalty, err.Error())
+}
+
+// Penalty for a block that was not added. false if the error is not related to the block itself
+func GetBlockPenalty(err error) (int, bool) {
+	verr, ok := err.(*consensus.BlockVerifyError)
+
+	if !ok {
+		return 0, false
+	}
+
+	switch verr.Err.(type) {
+	case *consensus.ReorgTooDeepError:
+		// the node offers a branch which replaces final blocks. it is banned at once
+		return net.BanScore, true
+	case *structures.SignatureError:
+		return net.PenaltyInvalidSignature, true
+	}
+	return net.PenaltyInvalidBlock, true
+}
+
 // Get node state
 
 func (n *Node) GetNodeState() (nodeclient.ComGetNodeState, error) {
@@ -631,5 +821,10 @@ func (n *Node) GetNodeState() (nodeclient.ComGetNodeState, error) {
 
 	result.UnspentOutputs = unspent
 
+	result.Sync = n.syncer.getState()
+
+	result.NodesScores = n.NodeNet.GetNodesScores()
+	result.PeersScores = n.NodeNet.GetPeersScores()
+
 	return result, nil
 }
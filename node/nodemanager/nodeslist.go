@@ -1,6 +1,8 @@
 package nodemanager
 
 import (
+	"encoding/json"
+
 	"github.com/gelembjuk/oursql/lib/net"
 )
 
@@ -9,6 +11,32 @@ type NodesListStorage struct {
 	SessionID string
 }
 
+// Node record in the nodes table. Old records contain only an address
+// Banned nodes which are known only by ID of a key have PeerID and no address
+type nodeRecord struct {
+	Address string
+	Score   net.NodeScore
+	PeerID  string `json:",omitempty"`
+}
+
+// Key of a record of a node known only by ID
+const peerRecordKeyPrefix = "peer:"
+
+func parseNodeRecord(v []byte) nodeRecord {
+	record := nodeRecord{}
+
+	if len(v) > 0 && v[0] == '{' {
+		err := json.Unmarshal(v, &record)
+
+		if err == nil {
+			return record
+		}
+	}
+	record.Address = string(v)
+
+	return record
+}
+
 func (s NodesListStorage) GetNodes() ([]net.NodeAddr, error) {
 
 	nddb, err := s.DBConn.DB().GetNodesObject()
@@ -20,9 +48,13 @@ func (s NodesListStorage) GetNodes() ([]net.NodeAddr, error) {
 	nodes := []net.NodeAddr{}
 
 	nddb.ForEach(func(k, v []byte) error {
-		addr := string(v)
+		record := parseNodeRecord(v)
+
+		if record.PeerID != "" {
+			return nil
+		}
 		node := net.NodeAddr{}
-		node.LoadFromString(addr)
+		node.LoadFromString(record.Address)
 
 		nodes = append(nodes, node)
 		return nil
@@ -30,7 +62,77 @@ func (s NodesListStorage) GetNodes() ([]net.NodeAddr, error) {
 
 	return nodes, nil
 }
-func (s NodesListStorage) AddNodeToKnown(addr net.NodeAddr) {
+
+// Scores of nodes which were penalized
+func (s NodesListStorage) GetNodesScores() (map[string]net.NodeScore, error) {
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]net.NodeScore)
+
+	nddb.ForEach(func(k, v []byte) error {
+		record := parseNodeRecord(v)
+
+		if record.PeerID == "" && record.Score != (net.NodeScore{}) {
+			scores[record.Address] = record.Score
+		}
+		return nil
+	})
+
+	return scores, nil
+}
+
+// Scores of nodes which were banned by ID of a key
+func (s NodesListStorage) GetPeersScores() (map[string]net.NodeScore, error) {
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]net.NodeScore)
+
+	nddb.ForEach(func(k, v []byte) error {
+		record := parseNodeRecord(v)
+
+		if record.PeerID != "" {
+			scores[record.PeerID] = record.Score
+		}
+		return nil
+	})
+
+	return scores, nil
+}
+
+// Save a score of a node by ID of a key
+func (s NodesListStorage) SavePeerScore(id string, score net.NodeScore) {
+	if !s.DBConn.CheckConnectionIsOpen() {
+		defer s.DBConn.CloseConnection()
+	}
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		s.DBConn.Logger.Trace.Printf("err %s", err.Error())
+		return
+	}
+
+	value, err := json.Marshal(nodeRecord{Score: score, PeerID: id})
+
+	if err != nil {
+		return
+	}
+
+	nddb.PutNode([]byte(peerRecordKeyPrefix+id), value)
+}
+
+// Save a node with its score
+func (s NodesListStorage) SaveNode(addr net.NodeAddr, score net.NodeScore) {
 	if !s.DBConn.CheckConnectionIsOpen() {
 		// if connection is not opened when this function is called, we have to close it
 		// we do this because this structre can be shared between threads.
@@ -47,7 +149,17 @@ func (s NodesListStorage) AddNodeToKnown(addr net.NodeAddr) {
 	address := addr.NodeAddrToString()
 	key := []byte(address)
 
-	nddb.PutNode(key, key)
+	value := key
+
+	if score != (net.NodeScore{}) {
+		value, err = json.Marshal(nodeRecord{Address: address, Score: score})
+
+		if err != nil {
+			return
+		}
+	}
+
+	nddb.PutNode(key, value)
 
 	return
 }
@@ -69,11 +181,11 @@ func (s NodesListStorage) RemoveNodeFromKnown(addr net.NodeAddr) {
 }
 func (s NodesListStorage) GetCountOfKnownNodes() (int, error) {
 
-	nddb, err := s.DBConn.DB().GetNodesObject()
+	nodes, err := s.GetNodes()
 
 	if err != nil {
 		return 0, err
 	}
 
-	return nddb.GetCount()
+	return len(nodes), nil
 }
@@ -0,0 +1,465 @@
+package nodemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Headers first synchronisation. First a chain of headers (BlockShort) is loaded from one node and checked.
+// Then full blocks are loaded by batches from several nodes in parallel. A batch failed on one node
+// is requested from other node. Blocks are added in order of the headers chain
+
+const (
+	syncLocatorSize    = 10    // number of our top blocks sent to find a common block with other node
+	syncBodiesBatch    = 20    // number of blocks requested at once
+	syncMaxPeers       = 4     // nodes to load blocks from in parallel
+	syncMaxRetries     = 3     // a batch is requested from other node this number of times
+	syncMaxHeaders     = 20000 // headers loaded by one synchronisation. next synchronisation continues from them
+	syncRequestTimeout = 30 * time.Second
+)
+
+// State of synchronisation. It is shared by all clones of a node
+type blocksSync struct {
+	lock  sync.Mutex
+	state nodeclient.ComSyncState
+}
+
+// Batch of blocks loaded by one request
+type syncBatch struct {
+	index   int
+	hashes  [][]byte
+	blocks  [][]byte
+	peer    net.NodeAddr // node the blocks are loaded from
+	retries int
+	err     error
+}
+
+// Mark synchronisation started. false if it is already running
+func (s *blocksSync) start(height int, peers int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.state.Active {
+		return false
+	}
+
+	s.state = nodeclient.ComSyncState{}
+	s.state.Active = true
+	s.state.StartHeight = height
+	s.state.HeadersHeight = height
+	s.state.AppliedHeight = height
+	s.state.Peers = peers
+
+	return true
+}
+
+func (s *blocksSync) finish() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.state.Active = false
+}
+
+func (s *blocksSync) update(f func(state *nodeclient.ComSyncState)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f(&s.state)
+}
+
+func (s *blocksSync) getState() nodeclient.ComSyncState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.state
+}
+
+// Check if blocks synchronisation is running now
+func (n *Node) IsSyncing() bool {
+	return n.syncer.getState().Active
+}
+
+// Load blocks we don't have from other nodes. Headers are loaded from a first node of a list,
+// from next nodes if it fails. Full blocks are loaded from all of them and from other known nodes
+func (n *Node) SyncBlocks(peers []net.NodeAddr) error {
+	if n.DBConn.OpenConnectionIfNeeded("SyncBlocks", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	peers = n.getSyncPeers(peers)
+
+	if len(peers) == 0 {
+		return errors.New("No nodes to load blocks from")
+	}
+
+	bestHeight, err := n.NodeBC.GetBestHeight()
+
+	if err != nil {
+		return err
+	}
+
+	if !n.syncer.start(bestHeight, len(peers)) {
+		n.Logger.Trace.Printf("Blocks synchronisation is already running")
+		return nil
+	}
+	defer n.syncer.finish()
+
+	headers, err := n.loadSyncHeaders(peers)
+
+	if err != nil {
+		return err
+	}
+
+	n.Logger.Trace.Printf("Loaded %d headers. Load blocks from %d nodes", len(headers), len(peers))
+
+	return n.loadSyncBodies(peers, headers)
+}
+
+// Nodes to load blocks from. Given nodes go first
+func (n *Node) getSyncPeers(peers []net.NodeAddr) []net.NodeAddr {
+	candidates := append([]net.NodeAddr{}, peers...)
+	candidates = append(candidates, n.NodeNet.GetNodes()...)
+
+	result := []net.NodeAddr{}
+
+	for _, addr := range candidates {
+		if len(result) >= syncMaxPeers {
+			break
+		}
+
+		if addr.CompareToAddress(n.NodeClient.NodeAddress) || n.NodeNet.CheckIsBanned(addr) {
+			continue
+		}
+
+		known := false
+
+		for _, a := range result {
+			if a.CompareToAddress(addr) {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+// Hashes of our top blocks and the genesis block. Other node returns headers after the first of them
+// which is in its primary chain. If all our top blocks are in a side branch there, headers start from the genesis
+// and known blocks are skipped
+func (n *Node) getSyncLocator() ([][]byte, error) {
+	bcm := n.NodeBC.GetBCManager()
+
+	locator := [][]byte{}
+
+	for _, bs := range bcm.GetBlocksShortInfo([]byte{}, syncLocatorSize) {
+		locator = append(locator, bs.Hash)
+	}
+
+	genesisHash, err := bcm.GetGenesisBlockHash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(locator, genesisHash), nil
+}
+
+// Load a chain of headers. Nodes are tried one by one until one of them returns a correct chain
+func (n *Node) loadSyncHeaders(peers []net.NodeAddr) ([]*structures.BlockShort, error) {
+	var err error
+
+	for _, peer := range peers {
+		var headers []*structures.BlockShort
+
+		headers, err = n.loadSyncHeadersFrom(peer)
+
+		if err == nil {
+			return headers, nil
+		}
+
+		n.Logger.Trace.Printf("Failed to load headers from %s: %s", peer.NodeAddrToString(), err.Error())
+
+		n.syncer.update(func(state *nodeclient.ComSyncState) {
+			state.Retries++
+		})
+	}
+	return nil, err
+}
+
+// Load headers from a node batch by batch. Every header must follow previous one and have a correct proof.
+// Loading stops when a chain has work which the node claims or when there are syncMaxHeaders headers
+func (n *Node) loadSyncHeadersFrom(peer net.NodeAddr) ([]*structures.BlockShort, error) {
+	locator, err := n.getSyncLocator()
+
+	if err != nil {
+		return nil, err
+	}
+
+	minter, err := n.getBlockMakeManager()
+
+	if err != nil {
+		return nil, err
+	}
+
+	checker := minter.NewHeadersChecker()
+
+	headers := []*structures.BlockShort{}
+
+	var last *structures.BlockShort
+
+	for {
+		result, err := n.NodeClient.SendGetHeaders(peer, locator, syncRequestTimeout)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i, data := range result.Headers {
+			bs, err := structures.NewBlockShortFromBytes(data)
+
+			if err != nil {
+				return nil, n.penalizeSyncPeer(peer, net.PenaltyProtocolViolation, err)
+			}
+
+			if i == 0 {
+				// first is a block from a locator. we have it already
+				if last == nil {
+					exists, err := n.NodeBC.CheckBlockExists(bs.Hash)
+
+					if err != nil {
+						return nil, err
+					}
+
+					if !exists {
+						return nil, n.penalizeSyncPeer(peer, net.PenaltyProtocolViolation, errors.New(fmt.Sprintf("Headers from %s start from unknown block %x", peer.NodeAddrToString(), bs.Hash)))
+					}
+				} else if !bytes.Equal(bs.Hash, last.Hash) {
+					return nil, n.penalizeSyncPeer(peer, net.PenaltyProtocolViolation, errors.New(fmt.Sprintf("Headers from %s don't start from requested block", peer.NodeAddrToString())))
+				}
+				last = bs
+				continue
+			}
+
+			if !bytes.Equal(bs.PrevBlockHash, last.Hash) || bs.Height != last.Height+1 {
+				return nil, n.penalizeSyncPeer(peer, net.PenaltyProtocolViolation, errors.New(fmt.Sprintf("Headers from %s are broken at height %d", peer.NodeAddrToString(), bs.Height)))
+			}
+
+			err = checker.CheckHeader(bs)
+
+			if err != nil {
+				return nil, n.penalizeSyncPeer(peer, net.PenaltyInvalidBlock, errors.New(fmt.Sprintf("Header from %s at height %d is not valid: %s", peer.NodeAddrToString(), bs.Height, err.Error())))
+			}
+
+			headers = append(headers, bs)
+			last = bs
+
+			if len(headers) >= syncMaxHeaders {
+				break
+			}
+		}
+
+		n.syncer.update(func(state *nodeclient.ComSyncState) {
+			state.TargetHeight = result.Height
+
+			if len(headers) > 0 {
+				state.HeadersHeight = last.Height
+			}
+		})
+
+		if len(result.Headers) < 2 || last.Height >= result.Height || len(headers) >= syncMaxHeaders {
+			break
+		}
+
+		if len(headers) > 0 && len(result.ChainWork) > 0 && checker.GetChainWork().Cmp(new(big.Int).SetBytes(result.ChainWork)) >= 0 {
+			// the chain has work the node claims. more headers are not needed
+			break
+		}
+		// next headers after the last one
+		locator = [][]byte{last.Hash}
+	}
+	return headers, nil
+}
+
+// Load full blocks in parallel from all nodes and add them in order of headers
+func (n *Node) loadSyncBodies(peers []net.NodeAddr, headers []*structures.BlockShort) error {
+	batches := []*syncBatch{}
+
+	var batch *syncBatch
+
+	for _, bs := range headers {
+		exists, err := n.NodeBC.CheckBlockExists(bs.Hash)
+
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			continue
+		}
+
+		if batch == nil || len(batch.hashes) >= syncBodiesBatch {
+			batch = &syncBatch{index: len(batches)}
+			batches = append(batches, batch)
+		}
+		batch.hashes = append(batch.hashes, bs.Hash)
+	}
+
+	if len(batches) == 0 {
+		return nil
+	}
+
+	// a batch is in one of channels or in a worker, so buffers are never full
+	jobs := make(chan *syncBatch, len(batches))
+	results := make(chan *syncBatch, len(batches))
+	quit := make(chan struct{})
+	defer close(quit)
+
+	for _, b := range batches {
+		jobs <- b
+	}
+
+	for _, peer := range peers {
+		go n.syncBodiesWorker(peer, jobs, results, quit)
+	}
+
+	workers := len(peers)
+	loaded := map[int]*syncBatch{}
+	next := 0
+
+	for next < len(batches) {
+		b := <-results
+
+		if b.err != nil {
+			// the worker has exited. the batch goes to other nodes
+			workers--
+			b.retries++
+
+			n.syncer.update(func(state *nodeclient.ComSyncState) {
+				state.Retries++
+				state.Peers = workers
+			})
+
+			if b.retries > syncMaxRetries || workers == 0 {
+				return errors.New(fmt.Sprintf("Failed to load blocks: %s", b.err.Error()))
+			}
+
+			b.err = nil
+			jobs <- b
+			continue
+		}
+
+		loaded[b.index] = b
+
+		// add all batches which are ready in order
+		for ; next < len(batches); next++ {
+			b, ok := loaded[next]
+
+			if !ok {
+				break
+			}
+			delete(loaded, next)
+
+			for _, blockdata := range b.blocks {
+				err := n.addSyncBlock(blockdata)
+
+				if err != nil {
+					n.PenalizeForBlock(b.peer, err)
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Load batches of blocks from one node. It stops when the node fails or synchronisation ends
+func (n *Node) syncBodiesWorker(peer net.NodeAddr, jobs chan *syncBatch, results chan *syncBatch, quit chan struct{}) {
+	for {
+		var b *syncBatch
+
+		select {
+		case b = <-jobs:
+		case <-quit:
+			return
+		}
+
+		b.peer = peer
+		b.blocks, b.err = n.NodeClient.SendGetBodies(peer, b.hashes, syncRequestTimeout)
+
+		if b.err == nil {
+			b.err = checkSyncBodies(b.hashes, b.blocks)
+
+			if b.err != nil {
+				n.penalizeSyncPeer(peer, net.PenaltyProtocolViolation, b.err)
+			}
+		}
+
+		if b.err != nil {
+			b.err = errors.New(fmt.Sprintf("%s: %s", peer.NodeAddrToString(), b.err.Error()))
+		}
+
+		results <- b
+
+		if b.err != nil {
+			return
+		}
+	}
+}
+
+// Penalize a node for wrong data sent during synchronisation. Returns same error
+func (n *Node) penalizeSyncPeer(peer net.NodeAddr, penalty int, err error) error {
+	n.NodeNet.PenalizeNode(peer, penalty, err.Error())
+
+	return err
+}
+
+// Check that blocks are same as requested
+func checkSyncBodies(hashes [][]byte, blocks [][]byte) error {
+	if len(blocks) != len(hashes) {
+		return errors.New(fmt.Sprintf("Received %d blocks instead of %d", len(blocks), len(hashes)))
+	}
+
+	for i, blockdata := range blocks {
+		block, err := structures.NewBlockFromBytes(blockdata)
+
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(block.Hash, hashes[i]) {
+			return errors.New(fmt.Sprintf("Received block %x instead of %x", block.Hash, hashes[i]))
+		}
+	}
+	return nil
+}
+
+// Add a loaded block. Previous block must be added already
+func (n *Node) addSyncBlock(blockdata []byte) error {
+	blockstate, _, block, err := n.ReceivedFullBlockFromOtherNode(blockdata)
+
+	if err != nil {
+		return err
+	}
+
+	if blockstate == 2 {
+		return errors.New(fmt.Sprintf("Previous block of %x is not found", block.Hash))
+	}
+
+	n.syncer.update(func(state *nodeclient.ComSyncState) {
+		state.AppliedHeight = block.Height
+	})
+
+	return nil
+}
@@ -82,7 +82,7 @@ func (n *NodeDaemon) checkPIDFile() error {
 
 		isfine := true
 		// check if process is really running
-		ProcessID, _, _, _, err := n.loadPIDFile()
+		ProcessID, _, _, err := n.loadPIDFile()
 
 		if err == nil && ProcessID > 0 {
 
@@ -174,14 +174,14 @@ func (n *NodeDaemon) StartServer() error {
 		"-logs="+logsstate)
 	cmd.Start()
 	n.Logger.Trace.Println("Daemon process ID is : ", cmd.Process.Pid)
-	n.savePIDFile(cmd.Process.Pid, n.Port, "", "n")
+	n.savePIDFile(cmd.Process.Pid, n.Port, "n")
 
 	i := 0
 
 	for {
 		time.Sleep(1 * time.Second)
 
-		_, _, _, startres, err := n.loadPIDFile()
+		_, _, startres, err := n.loadPIDFile()
 
 		if err != nil {
 			break
@@ -220,14 +220,12 @@ func (n *NodeDaemon) StartServerInteractive() error {
 
 	n.Logger.Trace.Println("Process ID is : ", pid)
 
-	authstr, err := n.savePIDFile(pid, n.Port, "", "y")
+	err = n.savePIDFile(pid, n.Port, "y")
 
 	if err != nil {
 		return err
 	}
 
-	n.Server.NodeAuthStr = authstr
-
 	err = n.DaemonizeServer()
 
 	os.Remove(n.getServerPidFile())
@@ -242,7 +240,7 @@ func (n *NodeDaemon) StartServerInteractive() error {
 // Stops a node daemon. Finds a process and kills it.
 
 func (n *NodeDaemon) StopServer() error {
-	ProcessID, _, _, _, err := n.loadPIDFile()
+	ProcessID, _, _, err := n.loadPIDFile()
 
 	if err == nil && ProcessID > 0 {
 
@@ -281,10 +279,6 @@ func (n *NodeDaemon) StopServer() error {
 func (n *NodeDaemon) DaemonizeServer() error {
 	n.Logger.Trace.Println("Daemon process runs")
 
-	_, _, authstr, _, _ := n.loadPIDFile()
-
-	n.Server.NodeAuthStr = authstr
-
 	// the channel to notify main thread about all work done on kill signal
 	theendchan := make(chan struct{})
 
@@ -362,12 +356,12 @@ func (n *NodeDaemon) waitServerStarted(serverStartResult chan string) {
 	if result == "" {
 		result = "y"
 	}
-	pid, port, authstring, _, err := n.loadPIDFile()
+	pid, port, _, err := n.loadPIDFile()
 
 	if err == nil {
 
 		// save status to know when server started
-		n.savePIDFile(pid, port, authstring, result)
+		n.savePIDFile(pid, port, result)
 	}
 
 	close(serverStartResult)
@@ -375,58 +369,52 @@ func (n *NodeDaemon) waitServerStarted(serverStartResult chan string) {
 
 // Save PID file for a process
 
-func (n *NodeDaemon) savePIDFile(pid int, port int, authstr string, startresult string) (string, error) {
+func (n *NodeDaemon) savePIDFile(pid int, port int, startresult string) error {
 
 	file, err := os.Create(n.getServerPidFile())
 
 	if err != nil {
 		n.Logger.Error.Printf("Unable to create pid file : %v\n", err)
-		return "", err
+		return err
 	}
 
 	defer file.Close()
 
-	// generate some random string. it will be used to auth local network requests
-	if authstr == "" {
-		authstr = utils.RandString(net.CommandLength) // we use same length as for network commands, but this is not related
-	}
-
 	if len(startresult) > 1 {
 		startresult = base64.StdEncoding.EncodeToString([]byte(startresult))
 	}
 
-	_, err = file.WriteString(strconv.Itoa(pid) + " " + strconv.Itoa(port) + " " + authstr + " " + startresult)
+	_, err = file.WriteString(strconv.Itoa(pid) + " " + strconv.Itoa(port) + " " + startresult)
 
 	if err != nil {
 		n.Logger.Error.Printf("Unable to create pid file : %v\n", err)
-		return "", err
+		return err
 	}
 
 	file.Sync() // flush to disk
 
-	return authstr, nil
+	return nil
 }
 
 // Laads PID file.
-func (n *NodeDaemon) loadPIDFile() (int, int, string, string, error) {
+func (n *NodeDaemon) loadPIDFile() (int, int, string, error) {
 
 	if _, err := os.Stat(n.getServerPidFile()); err == nil {
 		// get running port from pid file
 		pidfilecontentsbytes, err := ioutil.ReadFile(n.getServerPidFile())
 
 		if err != nil {
-			return 0, 0, "", "", err
+			return 0, 0, "", err
 		}
 
 		pidfilecontents := string(pidfilecontentsbytes)
 
 		parts := strings.Split(pidfilecontents, " ") // port is after pid and space in this text
 
-		if len(parts) == 4 {
+		if len(parts) == 3 {
 			portstring := parts[1]
 			pidstring := parts[0]
-			authstring := parts[2]
-			startresult := parts[3]
+			startresult := parts[2]
 
 			if len(startresult) > 1 {
 				sDec, _ := base64.StdEncoding.DecodeString(startresult)
@@ -435,28 +423,28 @@ func (n *NodeDaemon) loadPIDFile() (int, int, string, string, error) {
 
 			port, err := strconv.Atoi(portstring)
 			if err != nil {
-				return 0, 0, "", "", err
+				return 0, 0, "", err
 			}
 
 			pid, errp := strconv.Atoi(pidstring)
 
 			if errp != nil {
-				return 0, 0, "", "", errp
+				return 0, 0, "", errp
 			}
 
-			return pid, port, authstring, startresult, nil
+			return pid, port, startresult, nil
 		}
-		return 0, 0, "", "", errors.New("PID file wrong format")
+		return 0, 0, "", errors.New("PID file wrong format")
 	}
 
-	return -1, 0, "", "", nil
+	return -1, 0, "", nil
 }
 
 /*
 * Returns state of a server. Detects if it is running
  */
 func (n *NodeDaemon) GetServerState() (bool, int, int, error) {
-	ProcessID, Port, _, _, err := n.loadPIDFile()
+	ProcessID, Port, _, err := n.loadPIDFile()
 
 	if err == nil && ProcessID > 0 {
 
@@ -488,12 +476,12 @@ func (n *NodeDaemon) checkProcessExists(pid int) bool {
 
 }
 
-// Returns port and auth string of currently running process
-func (n *NodeDaemon) GetRunningProcessInfo() (int, string) {
-	_, port, authstr, _, err := n.loadPIDFile()
+// Returns port of currently running process
+func (n *NodeDaemon) GetRunningProcessInfo() int {
+	_, port, _, err := n.loadPIDFile()
 
 	if err == nil && port > 0 {
-		return port, authstr
+		return port
 	}
-	return 0, ""
+	return 0
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/gelembjuk/oursql/lib/net"
 	"github.com/gelembjuk/oursql/lib/nodeclient"
@@ -17,15 +18,16 @@ import (
 )
 
 type NodeServerRequest struct {
-	Node              *nodemanager.Node
-	S                 *NodeServer
-	Request           []byte
-	RequestIP         string
-	Logger            *utils.LoggerMan
-	HasResponse       bool
-	Response          []byte
-	NodeAuthStrIsGood bool
-	SessID            string
+	Node        *nodemanager.Node
+	S           *NodeServer
+	Request     []byte
+	RequestIP   string
+	Logger      *utils.LoggerMan
+	HasResponse bool
+	Response    []byte
+	PeerID      string // node ID from a TLS key of a client. empty if a client has no key
+	PeerIsLocal bool   // a client has same key as this node
+	SessID      string
 }
 
 func (s *NodeServerRequest) Init() {
@@ -42,12 +44,56 @@ func (s *NodeServerRequest) parseRequestData(payload interface{}) error {
 	err := dec.Decode(payload)
 
 	if err != nil {
+		s.S.penalizePeer(s.PeerID, net.PenaltyProtocolViolation, "Wrong request data")
 		return errors.New("Parse request: " + err.Error())
 	}
 
 	return nil
 }
 
+// Check a node which sent a request. It must have a key and the key must be
+// same as was pinned for the address. If nothing is pinned yet, the key is pinned now, but only
+// if the node connects from the host of the address. Banned nodes are refused too
+func (s *NodeServerRequest) checkPeer(addr net.NodeAddr) error {
+	if s.PeerID == "" {
+		return errors.New(fmt.Sprintf("Node %s has no key", addr.NodeAddrToString()))
+	}
+
+	keys := s.S.Node.NodeClient.Keys
+
+	if keys != nil {
+		err := keys.CheckNodeID(addr, s.PeerID)
+
+		if err != nil {
+			// the node uses an address of other node
+			s.S.penalizePeer(s.PeerID, net.PenaltyProtocolViolation, err.Error())
+			return err
+		}
+
+		if keys.GetNodeID(addr) == "" {
+			// a node can not take an address of other node which we didn't connect yet
+			if !checkHostHasIP(addr.Host, s.RequestIP) {
+				return errors.New(fmt.Sprintf("Node %s connects from other address %s", addr.NodeAddrToString(), s.RequestIP))
+			}
+
+			err = keys.PinNodeID(addr, s.PeerID)
+
+			if err != nil {
+				s.Logger.Error.Printf("Failed to pin key of node %s: %s", addr.NodeAddrToString(), err.Error())
+			}
+		}
+	}
+	return s.checkNodeBanned(addr)
+}
+
+// Refuse data from a node which is banned
+func (s *NodeServerRequest) checkNodeBanned(addr net.NodeAddr) error {
+	if s.S.Node.NodeNet.CheckIsBanned(addr) || s.S.checkPeerIsBanned(s.PeerID) {
+		return errors.New(fmt.Sprintf("Node %s is banned", addr.NodeAddrToString()))
+	}
+	return nil
+}
+
 // Find and return the list of unspent transactions
 func (s *NodeServerRequest) handleGetUnspent() error {
 	s.HasResponse = true
@@ -360,10 +406,19 @@ func (s *NodeServerRequest) handleBlock() error {
 		return err
 	}
 
+	err = s.checkPeer(payload.AddrFrom)
+
+	if err != nil {
+		return err
+	}
+
 	blockstate, addstate, block, err := s.Node.ReceivedFullBlockFromOtherNode(payload.Block)
 	s.Logger.Trace.Printf("adding new block %d, %d", blockstate, addstate)
 	// state of this adding we don't check. not interesting in this place
 	if err != nil {
+		if penalty, ok := nodemanager.GetBlockPenalty(err); ok {
+			s.S.penalizePeer(s.PeerID, penalty, err.Error())
+		}
 		return err
 	}
 
@@ -438,6 +493,12 @@ func (s *NodeServerRequest) handleInv() error {
 		return err
 	}
 
+	err = s.checkPeer(payload.AddrFrom)
+
+	if err != nil {
+		return err
+	}
+
 	s.Logger.Trace.Printf("SessID: %s . Recevied inventory with %d %s\n", s.SessID, len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
@@ -577,6 +638,112 @@ func (s *NodeServerRequest) handleGetBlocksUpper() error {
 /*
 * Response on request to get full body of a block or transaction
  */
+// Return headers of blocks of the primary chain after a common block. It is used for synchronisation
+func (s *NodeServerRequest) handleGetHeaders() error {
+	var payload nodeclient.ComGetHeaders
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.checkPeer(payload.AddrFrom)
+
+	if err != nil {
+		return err
+	}
+
+	s.HasResponse = true
+
+	bcm := s.Node.NodeBC.GetBCManager()
+
+	startHash, err := bcm.ChooseHashUnderTip(payload.Locator, []byte{})
+
+	if err != nil {
+		return err
+	}
+
+	if startHash == nil {
+		return errors.New("No common block found")
+	}
+
+	blocks, err := bcm.GetNextBlocks(startHash)
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ComHeaders{}
+
+	for _, block := range blocks {
+		bdata, err := block.Serialize()
+
+		if err != nil {
+			return err
+		}
+		result.Headers = append(result.Headers, bdata)
+	}
+
+	_, height, work, err := bcm.GetTopChainWork()
+
+	if err != nil {
+		return err
+	}
+	result.Height = height
+	result.ChainWork = work.Bytes()
+
+	s.Logger.Trace.Printf("Return %d headers after %x", len(result.Headers), startHash)
+
+	s.Response, err = net.GobEncode(&result)
+
+	return err
+}
+
+// Return full blocks by hashes. It is used for synchronisation
+func (s *NodeServerRequest) handleGetBodies() error {
+	var payload nodeclient.ComGetBodies
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.checkPeer(payload.AddrFrom)
+
+	if err != nil {
+		return err
+	}
+
+	s.HasResponse = true
+
+	if len(payload.Hashes) > net.MaxBodiesPerRequest {
+		return errors.New(fmt.Sprintf("Too many blocks requested. Max is %d", net.MaxBodiesPerRequest))
+	}
+
+	result := [][]byte{}
+
+	for _, hash := range payload.Hashes {
+		block, err := s.Node.NodeBC.GetBlock(hash)
+
+		if err != nil {
+			return err
+		}
+
+		bdata, err := block.Serialize()
+
+		if err != nil {
+			return err
+		}
+		result = append(result, bdata)
+	}
+
+	s.Response, err = net.GobEncode(&result)
+
+	return err
+}
+
 func (s *NodeServerRequest) handleGetData() error {
 	var payload nodeclient.ComGetData
 
@@ -644,6 +811,7 @@ func (s *NodeServerRequest) handleTx() error {
 	tx, err := structures.DeserializeTransaction(txData)
 
 	if err != nil {
+		s.S.penalizePeer(s.PeerID, net.PenaltyProtocolViolation, err.Error())
 		return err
 	}
 
@@ -653,6 +821,19 @@ func (s *NodeServerRequest) handleTx() error {
 		return nil
 	}
 	s.Logger.Trace.Printf("Received transaction. It does not exists: %x ", tx.GetID())
+
+	msm, err := s.Node.GetMultiSigManager()
+
+	if err != nil {
+		return err
+	}
+	// TX for multisig tables must have all signatures before it goes to the pool
+	err = msm.CheckTransactionSigned(tx)
+
+	if err != nil {
+		return err
+	}
+
 	// this will also verify a transaction
 	err = s.Node.GetTransactionsManager().ReceivedNewTransaction(tx, true)
 
@@ -660,6 +841,10 @@ func (s *NodeServerRequest) handleTx() error {
 		// if error is because some input transaction is not found, then request it and after it this TX again
 		s.Logger.Trace.Println("Error ", err.Error())
 
+		if _, ok := err.(*structures.SignatureError); ok {
+			s.S.penalizePeer(s.PeerID, net.PenaltyInvalidSignature, err.Error())
+		}
+
 		if err, ok := err.(*transactions.TXVerifyError); ok {
 			s.Logger.Trace.Println("Custom errro of kind ", err.GetKind())
 
@@ -694,9 +879,9 @@ func (s *NodeServerRequest) handleTx() error {
 }
 
 /*
-* Process version command. Other node sends own address and index of top block.
-* This node checks if index is bogger then request for a rest of blocks. If index is less
-* then sends own version command and that node will request for blocks
+* Process version command. Other node sends own address, top block and work of its chain.
+* If that chain has more work, this node requests blocks. It can be shorter then our chain.
+* If our chain has more work then sends own version command and that node will request for blocks
  */
 func (s *NodeServerRequest) handleVersion() error {
 	var payload nodeclient.ComVersion
@@ -707,7 +892,25 @@ func (s *NodeServerRequest) handleVersion() error {
 		return err
 	}
 
-	topHash, myBestHeight, err := s.Node.NodeBC.GetBCManager().GetState()
+	err = s.checkPeer(payload.AddrFrom)
+
+	if err != nil {
+		return err
+	}
+
+	// network magic is checked when a request is read. a node from other network can not get here
+
+	if bytes.Compare(payload.ChainConfig, s.Node.NodeClient.ChainConfig) != 0 {
+		// the node has other chain parameters. it is other network or its blocks will not be accepted.
+		// only the address where the node key is pinned is removed, other node can send any address
+		if addr, ok := s.S.getPeerAddr(s.PeerID); ok {
+			s.S.Node.NodeNet.RemoveNodeFromKnown(addr)
+		}
+
+		return errors.New(fmt.Sprintf("Node %s has other chain config. Version is rejected", payload.AddrFrom.NodeAddrToString()))
+	}
+
+	myTopHash, myBestHeight, myWork, err := s.Node.NodeBC.GetChainState()
 
 	if err != nil {
 		return err
@@ -717,24 +920,37 @@ func (s *NodeServerRequest) handleVersion() error {
 		payload.AddrFrom.Host = s.RequestIP
 	}
 
-	s.Logger.Trace.Printf("Received version from %s. Their heigh %d, our heigh %d\n",
-		payload.AddrFrom.NodeAddrToString(), payload.BestHeight, myBestHeight)
+	foreignerWork := new(big.Int).SetBytes(payload.ChainWork)
+
+	s.Logger.Trace.Printf("Received version from %s. Their heigh %d, work %s, our heigh %d, work %s\n",
+		payload.AddrFrom.NodeAddrToString(), payload.BestHeight, foreignerWork.String(), myBestHeight, myWork.String())
 
 	foreignerBestHeight := payload.BestHeight
 
-	if myBestHeight < foreignerBestHeight {
+	var foreignerIsBetter, iAmBetter bool
+
+	if len(payload.TopHash) == 0 {
+		// a node of older version. it doesn't send work
+		foreignerIsBetter = myBestHeight < foreignerBestHeight
+		iAmBetter = myBestHeight > foreignerBestHeight
+	} else if !bytes.Equal(payload.TopHash, myTopHash) {
+		foreignerIsBetter = blockchain.IsHeavierChain(foreignerWork, payload.TopHash, myWork, myTopHash)
+		iAmBetter = !foreignerIsBetter
+	}
+
+	if foreignerIsBetter {
 		s.Logger.Trace.Printf("Request blocks from %s\n", payload.AddrFrom.NodeAddrToString())
 
 		if foreignerBestHeight > s.S.Transit.MaxKnownHeigh {
 			s.S.Transit.MaxKnownHeigh = foreignerBestHeight
 		}
 
-		s.Node.NodeClient.SendGetBlocksUpper(payload.AddrFrom, topHash)
+		s.S.StartBlocksSync(payload.AddrFrom)
 
-	} else if myBestHeight > foreignerBestHeight {
+	} else if iAmBetter {
 		s.Logger.Trace.Printf("Send my version back to %s\n", payload.AddrFrom.NodeAddrToString())
 
-		s.Node.NodeClient.SendVersion(payload.AddrFrom, myBestHeight)
+		s.Node.NodeClient.SendVersion(payload.AddrFrom, myBestHeight, myTopHash, myWork.Bytes())
 	} else {
 		s.Logger.Trace.Printf("Teir blockchain is same as my for %s\n", payload.AddrFrom.NodeAddrToString())
 	}
@@ -765,7 +981,7 @@ func (s *NodeServerRequest) handleGetNodes() error {
 
 // Add new node to list of nodes
 func (s *NodeServerRequest) handleAddNode() error {
-	if !s.NodeAuthStrIsGood {
+	if !s.PeerIsLocal {
 		return errors.New("Local Network Auth is required")
 	}
 
@@ -788,7 +1004,7 @@ func (s *NodeServerRequest) handleAddNode() error {
 
 // Remove node from list of nodes
 func (s *NodeServerRequest) handleRemoveNode() error {
-	if !s.NodeAuthStrIsGood {
+	if !s.PeerIsLocal {
 		return errors.New("Local Network Auth is required")
 	}
 
@@ -804,6 +1020,15 @@ func (s *NodeServerRequest) handleRemoveNode() error {
 
 	s.S.Node.NodeNet.RemoveNodeFromKnown(payload.Node)
 
+	if s.S.Node.NodeClient.Keys != nil {
+		// the node can come back with new key
+		err = s.S.Node.NodeClient.Keys.ForgetNode(payload.Node)
+
+		if err != nil {
+			return err
+		}
+	}
+
 	s.Logger.Trace.Printf("Removed node %s\n", payload.Node.NodeAddrToString())
 	s.Logger.Trace.Println(s.S.Node.NodeNet.Nodes)
 
@@ -814,7 +1039,7 @@ func (s *NodeServerRequest) handleRemoveNode() error {
 
 // Return node state, including pending blocks to load
 func (s *NodeServerRequest) handleGetState() error {
-	if !s.NodeAuthStrIsGood {
+	if !s.PeerIsLocal {
 		return errors.New("Local Network Auth is required")
 	}
 
@@ -828,6 +1053,12 @@ func (s *NodeServerRequest) handleGetState() error {
 
 	info.ExpectingBlocksHeight = s.S.Transit.MaxKnownHeigh
 
+	if info.Sync.TargetHeight > info.ExpectingBlocksHeight {
+		info.ExpectingBlocksHeight = info.Sync.TargetHeight
+	}
+
+	info.Violations = s.S.limits.getViolations()
+
 	s.Response, err = net.GobEncode(&info)
 
 	if err != nil {
@@ -835,3 +1066,51 @@ func (s *NodeServerRequest) handleGetState() error {
 	}
 	return nil
 }
+
+// Received SQL transaction which waits for signatures of more keys. It is merged with a copy known before.
+// If the TX got new signatures it is sent to other nodes. If it has all signatures, it goes to the pool
+func (s *NodeServerRequest) handleTxMultiSig() error {
+	var payload nodeclient.ComTx
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	tx, err := structures.DeserializeTransaction(payload.Transaction)
+
+	if err != nil {
+		return err
+	}
+
+	msm, err := s.Node.GetMultiSigManager()
+
+	if err != nil {
+		return err
+	}
+
+	merged, complete, err := msm.ReceivedPartialTransaction(tx)
+
+	if err != nil {
+		return err
+	}
+
+	if merged == nil {
+		s.Logger.Trace.Printf("Received multisig transaction. Nothing new: %x ", tx.GetID())
+		return nil
+	}
+
+	if complete {
+		s.Logger.Trace.Printf("Multisig transaction is complete: %x ", tx.GetID())
+
+		s.Node.SendTransactionToAll(merged)
+
+		s.S.TryToMakeNewBlock(merged.GetID())
+		return nil
+	}
+
+	s.Node.SendMultiSigTransactionToAll(merged)
+
+	return nil
+}
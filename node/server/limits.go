@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+	"github.com/gelembjuk/oursql/lib/nodeclient"
+)
+
+// Limits of the node server. Requests over limits are refused, it protects a node from
+// a client which sends too much data or too many requests
+const (
+	maxConnections       = 500              // open connections, including sessions
+	requestReadTimeout   = 30               // seconds. a request must be received in this time
+	rateLimitPerSecond   = 50               // requests from one IP address
+	rateLimitBurst       = 200              // requests from one IP address at once
+	sessionMaxWorkers    = 20               // requests executed in parallel in one session
+	sessionMaxBuffered   = 64 * 1024 * 1024 // bytes. data of requests executed now in one session
+	maxExtraDataLength   = 1024             // bytes. extra data is not used now
+	defaultMaxDataLength = 64 * 1024        // bytes. for commands not listed in commandsMaxDataLength
+)
+
+// Max size of request data by command. Data of other commands is small
+var commandsMaxDataLength = map[string]uint32{
+	"block":        32 * 1024 * 1024,
+	"tx":           4 * 1024 * 1024,
+	"txdata":       4 * 1024 * 1024,
+	"txmultisig":   4 * 1024 * 1024,
+	"txsqlrequest": 4 * 1024 * 1024,
+	"addr":         1024 * 1024,
+	"inv":          1024 * 1024,
+	"getheaders":   1024 * 1024,
+	"getbodies":    1024 * 1024,
+}
+
+// Max size of request data for a command
+func getCommandMaxDataLength(command string) uint32 {
+	if max, ok := commandsMaxDataLength[command]; ok {
+		return max
+	}
+	return defaultMaxDataLength
+}
+
+// Max length of a session frame. It is a request of a command with the longest data
+func getSessionMaxFrameLength() uint32 {
+	max := uint32(defaultMaxDataLength)
+
+	for _, length := range commandsMaxDataLength {
+		if length > max {
+			max = length
+		}
+	}
+	return netlib.NetworkMagicLength + netlib.CommandLength + 8 + max + maxExtraDataLength
+}
+
+// Request data is longer than allowed for a command
+type requestTooLongError struct {
+	command string
+	length  uint32
+	max     uint32
+}
+
+func (e *requestTooLongError) Error() string {
+	return fmt.Sprintf("Request %s has %d bytes, max is %d", e.command, e.length, e.max)
+}
+
+// Limits state of a running server
+type serverLimits struct {
+	violations  nodeclient.ComLimitsViolations // changed with atomic. first field to be aligned for it
+	connections chan struct{}                  // a slot is taken by every open connection
+	rateLimiter *netlib.RateLimiter
+}
+
+func newServerLimits() *serverLimits {
+	l := serverLimits{}
+	l.connections = make(chan struct{}, maxConnections)
+	l.rateLimiter = netlib.NewRateLimiter(rateLimitPerSecond, rateLimitBurst)
+
+	return &l
+}
+
+// Take a connection slot. false if there are too many connections
+func (l *serverLimits) openConnection() bool {
+	select {
+	case l.connections <- struct{}{}:
+		return true
+	default:
+		atomic.AddInt64(&l.violations.RejectedConnections, 1)
+		return false
+	}
+}
+
+func (l *serverLimits) closeConnection() {
+	<-l.connections
+}
+
+// Check rate limit of an IP address. Local clients are not limited
+func (l *serverLimits) allowRequest(ip string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.IsLoopback() {
+		return true
+	}
+
+	if l.rateLimiter.Allow(ip) {
+		return true
+	}
+	atomic.AddInt64(&l.violations.RateLimitedRequests, 1)
+
+	return false
+}
+
+func (l *serverLimits) oversizedRequest() {
+	atomic.AddInt64(&l.violations.OversizedRequests, 1)
+}
+
+func (l *serverLimits) readTimeout() {
+	atomic.AddInt64(&l.violations.ReadTimeouts, 1)
+}
+
+func (l *serverLimits) sessionOverloaded() {
+	atomic.AddInt64(&l.violations.SessionOverloads, 1)
+}
+
+// Counters of refused requests
+func (l *serverLimits) getViolations() nodeclient.ComLimitsViolations {
+	v := nodeclient.ComLimitsViolations{}
+	v.OversizedRequests = atomic.LoadInt64(&l.violations.OversizedRequests)
+	v.ReadTimeouts = atomic.LoadInt64(&l.violations.ReadTimeouts)
+	v.RejectedConnections = atomic.LoadInt64(&l.violations.RejectedConnections)
+	v.RateLimitedRequests = atomic.LoadInt64(&l.violations.RateLimitedRequests)
+	v.SessionOverloads = atomic.LoadInt64(&l.violations.SessionOverloads)
+
+	return v
+}
@@ -6,11 +6,16 @@ Errors returned by the proxy must have MySQL codes.
 2 - Query requires public key
 3 - Query requires data to sign
 4 - Error preparing of query parsing
+5 - Not enough funds to pay for the query
+6 - Transaction needs signatures of more keys (multisig table)
 
 */
 import (
+	"sync"
+
 	"github.com/gelembjuk/oursql/lib/dbproxy"
 	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/consensus"
 	"github.com/gelembjuk/oursql/node/nodemanager"
 )
 
@@ -18,7 +23,8 @@ type queryFilter struct {
 	DBProxy        dbproxy.DBProxyInterface
 	Node           *nodemanager.Node
 	Logger         *utils.LoggerMan
-	sessionQueries map[string]string
+	sessionQueries map[string]*consensus.SQLSession // queries collected between BEGIN and COMMIT
+	sessionsLock   sync.Mutex
 }
 
 func InitQueryFilter(proxyAddr, dbAddr string, node *nodemanager.Node, logger *utils.LoggerMan) (q *queryFilter, err error) {
@@ -26,7 +32,7 @@ func InitQueryFilter(proxyAddr, dbAddr string, node *nodemanager.Node, logger *u
 
 	q.Logger = logger
 	q.Node = node
-	q.sessionQueries = make(map[string]string)
+	q.sessionQueries = make(map[string]*consensus.SQLSession)
 
 	q.Logger.Trace.Printf("DB Proxy Start on %s  %s", proxyAddr, dbAddr)
 
@@ -52,19 +58,27 @@ func InitQueryFilter(proxyAddr, dbAddr string, node *nodemanager.Node, logger *u
 
 	return
 }
-func (q *queryFilter) RequestCallback(query string, sessionID string) error {
+// Returns changed query if it must be executed by a server in other form (function calls replaced with values)
+func (q *queryFilter) RequestCallback(query string, sessionID string, conn dbproxy.SessionConnection) (string, error) {
 	qm, err := q.Node.GetSQLQueryManager()
 
 	if err != nil {
-		return err
+		return "", err
 	}
-	tx, errCode, err := qm.NewQueryFromProxy(query)
+	session := q.getSession(sessionID)
+	session.SetConnection(conn)
+
+	tx, serverQuery, errCode, err := qm.NewQueryFromProxySession(query, session)
 
 	if err != nil {
+		if perr, ok := err.(*consensus.MultiSigPendingError); ok {
+			// other signers can get it from any node
+			q.Node.SendMultiSigTransactionToAll(perr.TX)
+		}
 		if errCode > 0 {
-			return dbproxy.NewMySQLError(err.Error(), errCode)
+			return "", dbproxy.NewMySQLError(err.Error(), errCode)
 		}
-		return err
+		return "", err
 	}
 	if tx != nil {
 		q.Logger.Trace.Printf("Query: %s, sessID: %s, TX created %x\n", query, sessionID, tx.GetID())
@@ -72,13 +86,36 @@ func (q *queryFilter) RequestCallback(query string, sessionID string) error {
 		q.Logger.Trace.Printf("Query: %s, sessID: %s, no TX needed\n", query, sessionID)
 	}
 
-	return nil
+	return serverQuery, nil
 }
 func (q *queryFilter) ResponseCallback(sessionID string, err error) {
 
 	if err != nil {
 		q.Logger.Trace.Printf("DB Proxy Response Error: %s\n", err.Error())
 	}
+	q.getSession(sessionID).QueryResponse(err)
+}
+
+// Client connection closed. Not commited queries are dropped
+func (q *queryFilter) SessionClosed(sessionID string) {
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
+
+	delete(q.sessionQueries, sessionID)
+}
+
+// Returns state of SQL transaction for a session
+func (q *queryFilter) getSession(sessionID string) *consensus.SQLSession {
+	q.sessionsLock.Lock()
+	defer q.sessionsLock.Unlock()
+
+	session, ok := q.sessionQueries[sessionID]
+
+	if !ok {
+		session = consensus.NewSQLSession()
+		q.sessionQueries[sessionID] = session
+	}
+	return session
 }
 func (q *queryFilter) Stop() error {
 	q.Logger.Trace.Println("Stop DB proxy")
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -34,7 +35,7 @@ type NodeServer struct {
 	DBAddr      string
 	QueryFlter  *queryFilter
 
-	NodeAuthStr string
+	limits *serverLimits
 }
 
 func (s *NodeServer) GetClient() *nodeclient.NodeClient {
@@ -45,18 +46,91 @@ func (s *NodeServer) GetClient() *nodeclient.NodeClient {
 // handle received data. It can be one way command or a request for some data
 
 func (s *NodeServer) handleConnection(conn net.Conn) {
-	starttime := time.Now().UnixNano()
-	sessid := utils.RandString(5)
+	//s.Logger.Trace.Printf("New command. Start reading")
+	defer s.limits.closeConnection()
+
+	requestIP := ""
+
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		requestIP = addr.IP.String()
+	}
+
+	if !s.limits.allowRequest(requestIP) {
+		s.Logger.Warning.Printf("Too many requests from %s. Connection refused", requestIP)
+		conn.Close()
+		return
+	}
+
+	// a client must send a request in this time. it is not allowed to keep a connection sending nothing
+	deadline := time.Now().Add(requestReadTimeout * time.Second)
+	conn.SetReadDeadline(deadline)
+
+	peerID := ""
+
+	if tlsconn, ok := conn.(*tls.Conn); ok {
+		// know who is on other side before reading a request
+		tlsconn.SetDeadline(time.Now().Add(netlib.HandshakeTimeout * time.Second))
+
+		err := tlsconn.Handshake()
+
+		if err != nil {
+			s.Logger.Trace.Printf("TLS handshake error %s", err.Error())
+			conn.Close()
+			return
+		}
+		tlsconn.SetDeadline(time.Time{})
+		tlsconn.SetReadDeadline(deadline)
 
-	//s.Logger.Trace.Printf("New command. Start reading %s", sessid)
+		peerID = netlib.GetConnNodeID(tlsconn)
 
-	command, request, authstring, err := s.readRequest(conn)
+		if s.checkPeerIsBanned(peerID) {
+			s.Logger.Trace.Printf("Refused connection from banned node %s", peerID)
+			conn.Close()
+			return
+		}
+	}
+
+	command, request, err := s.readRequest(conn)
 
 	if err != nil {
+		if time.Now().After(deadline) {
+			s.limits.readTimeout()
+			s.Logger.Warning.Printf("Request from %s is not received in %d seconds", requestIP, requestReadTimeout)
+		}
+		s.checkRequestTooLong(err, requestIP, peerID)
+
 		s.sendErrorBack(conn, errors.New("Network Data Reading Error: "+err.Error()))
 		conn.Close()
 		return
 	}
+	conn.SetReadDeadline(time.Time{})
+
+	if command == "session" {
+		// the connection stays open for many requests
+		s.serveSession(conn, requestIP, peerID)
+		return
+	}
+
+	response := s.handleRequest(command, request, peerID, requestIP)
+
+	if response != nil {
+		s.Logger.Trace.Printf("Responding %d bytes\n", len(response))
+
+		_, err := conn.Write(response)
+
+		if err != nil {
+			s.Logger.Trace.Printf("Response sending error %s\n", err.Error())
+		}
+	}
+
+	conn.Close()
+}
+
+// Execute a command. Returns a response, first byte is 1 if success and 0 if error.
+// nil is returned if a command has no response. peerID is empty if a client has no node key
+func (s *NodeServer) handleRequest(command string, request []byte, peerID string, requestIP string) []byte {
+	starttime := time.Now().UnixNano()
+	sessid := utils.RandString(5)
 
 	s.Logger.Trace.Printf("Received %s command, %s, old sess %s", command, sessid, s.Node.SessionID)
 
@@ -65,23 +139,21 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	requestobj.Node.SessionID = sessid
 	requestobj.Logger = s.Logger
 	requestobj.Request = request[:]
-	requestobj.NodeAuthStrIsGood = (s.NodeAuthStr == authstring && len(authstring) > 0)
+	requestobj.PeerID = peerID
+	// a client with same key is a local tool working with same config dir
+	requestobj.PeerIsLocal = (peerID != "" && s.Node.Identity != nil && peerID == s.Node.Identity.ID)
 	requestobj.S = s
 	requestobj.S.Node.SessionID = sessid
 	requestobj.SessID = sessid
+	requestobj.RequestIP = requestIP
 
-	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-		requestobj.RequestIP = addr.IP.String()
-	}
 	request = nil
 
 	// open blockchain. and close in the end ofthis function
-	err = requestobj.Node.DBConn.OpenConnection(sessid)
+	err := requestobj.Node.DBConn.OpenConnection(sessid)
 
 	if err != nil {
-		s.sendErrorBack(conn, errors.New("Blockchain open Error: "+err.Error()))
-		conn.Close()
-		return
+		return s.getErrorResponse(errors.New("Blockchain open Error: " + err.Error()))
 	}
 
 	//s.Logger.Trace.Printf("Nodes Network State: %d , %s", len(requestobj.Node.NodeNet.Nodes), requestobj.Node.NodeNet.Nodes)
@@ -107,6 +179,12 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	case "getdata":
 		rerr = requestobj.handleGetData()
 
+	case "getheaders":
+		rerr = requestobj.handleGetHeaders()
+
+	case "getbodies":
+		rerr = requestobj.handleGetBodies()
+
 	case "getunspent":
 		rerr = requestobj.handleGetUnspent()
 
@@ -125,6 +203,9 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	case "txdata":
 		rerr = requestobj.handleTxData()
 
+	case "txmultisig":
+		rerr = requestobj.handleTxMultiSig()
+
 	case "txcurrequest":
 		rerr = requestobj.handleTxCurRequest()
 
@@ -147,10 +228,13 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 		rerr = requestobj.handleVersion()
 	default:
 		rerr = errors.New("Unknown command!")
+		s.penalizePeer(peerID, netlib.PenaltyProtocolViolation, "Unknown command "+command)
 	}
 
 	requestobj.Node.DBConn.CloseConnection()
 
+	var response []byte
+
 	if rerr != nil {
 		s.Logger.Error.Println("Network Command Handle Error: ", rerr.Error())
 		s.Logger.Trace.Println("Network Command Handle Error: ", rerr.Error())
@@ -158,48 +242,134 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 		if requestobj.HasResponse {
 			// return error to the client
 			// first byte is bool false to indicate there was error
-			s.sendErrorBack(conn, rerr)
+			response = s.getErrorResponse(rerr)
 		}
 	}
 
 	if requestobj.HasResponse && requestobj.Response != nil && rerr == nil {
-		// send this response back
 		// first byte is bool true to indicate request was success
-		dataresponse := append([]byte{1}, requestobj.Response...)
-
-		s.Logger.Trace.Printf("Responding %d bytes\n", len(dataresponse))
-
-		_, err := conn.Write(dataresponse)
-
-		if err != nil {
-			s.Logger.Error.Println("Sending response error: ", err.Error())
-		}
+		response = append([]byte{1}, requestobj.Response...)
 	}
+
 	duration := time.Since(time.Unix(0, starttime))
 	ms := duration.Nanoseconds() / int64(time.Millisecond)
 	s.Logger.Trace.Printf("Complete processing %s command. Time: %d ms, sess %s", command, ms, sessid)
 
-	conn.Close()
+	return response
 }
 
 // response error to a client
 func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
+	dataresponse := s.getErrorResponse(err)
+
+	if dataresponse == nil {
+		return
+	}
+
+	s.Logger.Trace.Printf("Responding %d bytes as error message\n", len(dataresponse))
+
+	_, err = conn.Write(dataresponse)
+
+	if err != nil {
+		s.Logger.Error.Println("Sending response error: ", err.Error())
+	}
+}
+
+// Error response. First byte is 0, then encoded error message
+func (s *NodeServer) getErrorResponse(err error) []byte {
 	s.Logger.Error.Println("Sending back error message: ", err.Error())
 	s.Logger.Trace.Println("Sending back error message: ", err.Error())
 
 	payload, err := netlib.GobEncode(err.Error())
 
-	if err == nil {
-		dataresponse := append([]byte{0}, payload...)
+	if err != nil {
+		return nil
+	}
+	return append([]byte{0}, payload...)
+}
 
-		s.Logger.Trace.Printf("Responding %d bytes as error message\n", len(dataresponse))
+// Address of a node with the key. Only nodes this node connected to are known by a key.
+// Other addresses are not proven, so nodes are penalized only by this address. Anyone could get a good node banned otherwise
+func (s *NodeServer) getPeerAddr(peerID string) (netlib.NodeAddr, bool) {
+	if peerID == "" || s.Node.NodeClient.Keys == nil {
+		return netlib.NodeAddr{}, false
+	}
+	return s.Node.NodeClient.Keys.GetNodeAddr(peerID)
+}
 
-		_, err = conn.Write(dataresponse)
+// Check if a host name or IP address is same as IP address of a request
+func checkHostHasIP(host string, ip string) bool {
+	requestIP := net.ParseIP(ip)
 
-		if err != nil {
-			s.Logger.Error.Println("Sending response error: ", err.Error())
+	if requestIP == nil {
+		return false
+	}
+
+	if hostIP := net.ParseIP(host); hostIP != nil {
+		return hostIP.Equal(requestIP)
+	}
+
+	ips, err := net.LookupIP(host)
+
+	if err != nil {
+		return false
+	}
+
+	for _, hostIP := range ips {
+		if hostIP.Equal(requestIP) {
+			return true
 		}
 	}
+	return false
+}
+
+// Count and report a request which is longer than allowed
+func (s *NodeServer) checkRequestTooLong(err error, requestIP string, peerID string) {
+	if _, ok := err.(*requestTooLongError); !ok {
+		return
+	}
+	s.limits.oversizedRequest()
+	s.Logger.Warning.Printf("Too long request from %s: %s", requestIP, err.Error())
+
+	if peerID != "" {
+		s.penalizePeer(peerID, netlib.PenaltyOversizedMessage, err.Error())
+	}
+}
+
+// Add penalty points to a node for bad behaviour. A node is scored by ID of its key, so nodes
+// which only connect to us are penalized too. If the key is pinned for an address, the address is penalized also
+func (s *NodeServer) penalizePeer(peerID string, penalty int, reason string) {
+	if peerID == "" {
+		s.Logger.Warning.Printf("Node without a key can not be penalized for: %s", reason)
+		return
+	}
+
+	s.Node.NodeNet.PenalizePeer(peerID, penalty, reason)
+
+	addr, ok := s.getPeerAddr(peerID)
+
+	if !ok {
+		return
+	}
+
+	if s.Node.NodeNet.PenalizeNode(addr, penalty, reason) {
+		s.Transit.CleanBlocks(addr)
+	}
+}
+
+// Check if a node is banned by ID of its key or by address where the key is pinned
+func (s *NodeServer) checkPeerIsBanned(peerID string) bool {
+	if peerID == "" {
+		return false
+	}
+
+	if s.Node.NodeNet.CheckPeerIsBanned(peerID) {
+		return true
+	}
+
+	addr, ok := s.getPeerAddr(peerID)
+
+	return ok && s.Node.NodeNet.CheckIsBanned(addr)
 }
 
 // Starts a server for node. It listens TPC port and communicates with other nodes and lite clients
@@ -213,7 +383,14 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 		return err
 	}
 
-	ln, err := net.Listen(netlib.Protocol, ":"+strconv.Itoa(s.NodeAddress.Port))
+	var ln net.Listener
+
+	if s.Node.Identity == nil {
+		err = errors.New("Node key is not loaded")
+	} else {
+		// all traffic between nodes is encrypted
+		ln, err = tls.Listen(netlib.Protocol, ":"+strconv.Itoa(s.NodeAddress.Port), s.Node.Identity.ServerTLSConfig())
+	}
 
 	if err != nil {
 		serverStartResult <- err.Error()
@@ -229,6 +406,11 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 	// client will use the address to include it in requests
 	s.Node.NodeClient.SetNodeAddress(s.NodeAddress)
 
+	// requests to other nodes share persistent connections
+	s.Node.NodeClient.Sessions = nodeclient.NewSessionsPool(s.Logger)
+
+	s.limits = newServerLimits()
+
 	s.Node.SendVersionToNodes([]netlib.NodeAddr{})
 
 	s.Logger.Trace.Println("Start block bilding routine")
@@ -272,6 +454,8 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 
 			s.StopDatabaseProxy()
 
+			s.Node.NodeClient.Sessions.Close()
+
 			s.BlockBilderChan <- []byte{} // send signal to block building thread to exit
 			// empty slice means this is exit signal
 
@@ -279,6 +463,12 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 			break
 		}
 
+		if !s.limits.openConnection() {
+			s.Logger.Warning.Printf("Too many connections. Connection from %s refused", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+
 		go s.handleConnection(conn)
 	}
 	return nil
@@ -333,6 +523,28 @@ func (s *NodeServer) BlockBuilder() {
 	}
 }
 
+// Load blocks from other nodes in background. Does nothing if synchronisation is already running
+func (s *NodeServer) StartBlocksSync(addr netlib.NodeAddr) {
+	if s.Node.IsSyncing() {
+		return
+	}
+
+	go func() {
+		// separate node object and DB connection for this thread
+		node := s.Node.Clone()
+		node.SessionID = utils.RandString(5)
+
+		err := node.SyncBlocks([]netlib.NodeAddr{addr})
+
+		if err != nil {
+			s.Logger.Error.Printf("Blocks synchronisation failed: %s", err.Error())
+			return
+		}
+		// some transactions can be unapproved now
+		s.TryToMakeNewBlock([]byte{1})
+	}()
+}
+
 // MySQL proxy server. It is in the middle between a DB server and DB client an reads requests
 func (s *NodeServer) StartDatabaseProxy() (err error) {
 	s.QueryFlter, err = InitQueryFilter(s.DBProxyAddr, s.DBAddr, s.Node.Clone(), s.Logger)
@@ -346,22 +558,36 @@ func (s *NodeServer) StopDatabaseProxy() (err error) {
 }
 
 // Reads and parses request from network data
-func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error) {
+func (s *NodeServer) readRequest(conn io.Reader) (string, []byte, error) {
+	// 0. Read network magic
+	magic, err := s.readFromConnection(conn, netlib.NetworkMagicLength)
+
+	if err != nil {
+		return "", nil, err
+	}
+
 	// 1. Read command
 	commandbuffer, err := s.readFromConnection(conn, netlib.CommandLength)
 
 	if err != nil {
-		return "", nil, "", err
+		return "", nil, err
 	}
 
 	command := netlib.BytesToCommand(commandbuffer)
 
+	// don't read rest of a request from other network
+	err = s.checkNetworkMagic(magic, command)
+
+	if err != nil {
+		return "", nil, err
+	}
+
 	// 2. Get length of command data
 
 	lengthbuffer, err := s.readFromConnection(conn, 4)
 
 	if err != nil {
-		return "", nil, "", err
+		return "", nil, err
 	}
 
 	var datalength uint32
@@ -371,12 +597,21 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 	lengthbuffer, err = s.readFromConnection(conn, 4)
 
 	if err != nil {
-		return "", nil, "", err
+		return "", nil, err
 	}
 
 	var extradatalength uint32
 	binary.Read(bytes.NewReader(lengthbuffer), binary.LittleEndian, &extradatalength)
 
+	// don't allocate memory for data which is longer than expected
+	if max := getCommandMaxDataLength(command); datalength > max {
+		return "", nil, &requestTooLongError{command, datalength, max}
+	}
+
+	if extradatalength > maxExtraDataLength {
+		return "", nil, &requestTooLongError{command, extradatalength, maxExtraDataLength}
+	}
+
 	// 4. read command data by length
 	//s.Logger.Trace.Printf("Before read data %d bytes", datalength)
 
@@ -386,29 +621,25 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 		databuffer, err = s.readFromConnection(conn, int(datalength))
 
 		if err != nil {
-			return "", nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of request: %s", datalength, err.Error()))
+			return "", nil, errors.New(fmt.Sprintf("Error reading %d bytes of request: %s", datalength, err.Error()))
 		}
 	}
 
-	// 5. read extra data by length
-
-	authstr := ""
+	// 5. read extra data by length. it is not used now, peers are known by TLS keys
 
 	if extradatalength > 0 {
-		extradatabuffer, err := s.readFromConnection(conn, int(extradatalength))
+		_, err := s.readFromConnection(conn, int(extradatalength))
 
 		if err != nil {
-			return "", nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of extra data: %s", extradatalength, err.Error()))
+			return "", nil, errors.New(fmt.Sprintf("Error reading %d bytes of extra data: %s", extradatalength, err.Error()))
 		}
-
-		authstr = netlib.BytesToCommand(extradatabuffer)
 	}
 
-	return command, databuffer, authstr, nil
+	return command, databuffer, nil
 }
 
 // Read given amount of bytes from connection
-func (s *NodeServer) readFromConnection(conn net.Conn, countofbytes int) ([]byte, error) {
+func (s *NodeServer) readFromConnection(conn io.Reader, countofbytes int) ([]byte, error) {
 	buff := new(bytes.Buffer)
 
 	pauses := 0
@@ -452,3 +683,27 @@ func (s *NodeServer) readFromConnection(conn net.Conn, countofbytes int) ([]byte
 
 	return buff.Bytes(), nil
 }
+
+// Commands which can be sent by wallets and other clients. They don't know a blockchain, so can send empty magic
+var clientCommands = []string{"viod", "getfblocks", "gethistory", "getbalance", "getunspent", "txdata",
+	"txcurrequest", "txsqlrequest", "getnodes", "addnode", "removenode", "getstate"}
+
+// Check a network magic of a request. Nodes of other blockchains are refused
+func (s *NodeServer) checkNetworkMagic(magic []byte, command string) error {
+	myMagic := s.Node.NodeClient.NetworkMagic
+
+	if len(myMagic) == 0 || bytes.Compare(magic, myMagic) == 0 {
+		return nil
+	}
+
+	if netlib.IsEmptyNetworkMagic(magic) {
+		for _, c := range clientCommands {
+			if c == command {
+				return nil
+			}
+		}
+		return errors.New(fmt.Sprintf("Command %s requires network magic %x", command, myMagic))
+	}
+
+	return errors.New(fmt.Sprintf("Request from other network. Network magic %x, expected %x", magic, myMagic))
+}
@@ -7,3 +7,21 @@ import (
 func TestServerStart(t *testing.T) {
 
 }
+
+func TestCheckHostHasIP(t *testing.T) {
+	if !checkHostHasIP("127.0.0.1", "127.0.0.1") {
+		t.Fatalf("Same IP must match")
+	}
+
+	if !checkHostHasIP("localhost", "127.0.0.1") {
+		t.Fatalf("localhost must match loopback IP")
+	}
+
+	if checkHostHasIP("10.0.0.1", "127.0.0.1") {
+		t.Fatalf("Other IP must not match")
+	}
+
+	if checkHostHasIP("127.0.0.1", "") {
+		t.Fatalf("Empty request IP must not match")
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	netlib "github.com/gelembjuk/oursql/lib/net"
+)
+
+// Serve requests of a session. Requests are executed in parallel, every response has ID of a request.
+// A session is closed if a client sends nothing during SessionReadTimeout, clients ping when idle
+func (s *NodeServer) serveSession(conn net.Conn, requestIP string, peerID string) {
+	defer conn.Close()
+
+	s.Logger.Trace.Printf("Session opened from %s", conn.RemoteAddr().String())
+
+	// confirm the session is opened
+	_, err := conn.Write([]byte{1})
+
+	if err != nil {
+		return
+	}
+
+	writeLock := &sync.Mutex{}
+
+	write := func(kind byte, id uint32, data []byte) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+
+		conn.SetWriteDeadline(time.Now().Add(netlib.SessionReadTimeout * time.Second))
+
+		err := netlib.WriteFrame(conn, kind, id, data)
+
+		if err != nil {
+			s.Logger.Trace.Printf("Session response error %s", err.Error())
+		}
+	}
+
+	// a slot is taken by every request executed now. it limits number of routines of a session
+	workers := make(chan struct{}, sessionMaxWorkers)
+
+	// bytes of requests executed now. changed with atomic
+	buffered := int64(0)
+
+	maxFrameLength := getSessionMaxFrameLength()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(netlib.SessionReadTimeout * time.Second))
+
+		kind, id, data, err := netlib.ReadFrame(conn, maxFrameLength)
+
+		if err != nil {
+			s.Logger.Trace.Printf("Session from %s is closed: %s", conn.RemoteAddr().String(), err.Error())
+
+			if _, ok := err.(*netlib.FrameTooLongError); ok {
+				s.limits.oversizedRequest()
+				s.Logger.Warning.Printf("Too long frame from %s: %s", requestIP, err.Error())
+				s.penalizePeer(peerID, netlib.PenaltyOversizedMessage, err.Error())
+			}
+			return
+		}
+
+		switch kind {
+		case netlib.FramePing:
+			write(netlib.FramePong, id, nil)
+
+		case netlib.FrameRequest:
+			if s.checkPeerIsBanned(peerID) {
+				s.Logger.Trace.Printf("Node %s is banned. Close a session", peerID)
+				return
+			}
+
+			if !s.limits.allowRequest(requestIP) {
+				s.Logger.Warning.Printf("Too many requests from %s in a session", requestIP)
+				write(netlib.FrameResponse, id, s.getErrorResponse(errors.New("Too many requests")))
+				continue
+			}
+
+			if atomic.AddInt64(&buffered, int64(len(data))) > sessionMaxBuffered {
+				atomic.AddInt64(&buffered, -int64(len(data)))
+				s.limits.sessionOverloaded()
+				s.Logger.Warning.Printf("Too much data of requests in a session from %s", requestIP)
+				write(netlib.FrameResponse, id, s.getErrorResponse(errors.New("Too much data of requests in progress")))
+				continue
+			}
+
+			select {
+			case workers <- struct{}{}:
+			default:
+				atomic.AddInt64(&buffered, -int64(len(data)))
+				s.limits.sessionOverloaded()
+				s.Logger.Warning.Printf("Too many requests are executed in a session from %s", requestIP)
+				write(netlib.FrameResponse, id, s.getErrorResponse(errors.New("Too many requests in progress")))
+				continue
+			}
+
+			go func(id uint32, data []byte) {
+				defer func() {
+					atomic.AddInt64(&buffered, -int64(len(data)))
+					<-workers
+				}()
+
+				write(netlib.FrameResponse, id, s.handleSessionRequest(data, requestIP, peerID))
+			}(id, data)
+
+		default:
+			s.Logger.Trace.Printf("Wrong frame kind %d in a session. Close it", kind)
+			s.penalizePeer(peerID, netlib.PenaltyProtocolViolation, fmt.Sprintf("Wrong frame kind %d", kind))
+			return
+		}
+	}
+}
+
+// Execute a request received in a session. A client waits a response for any request,
+// so success is returned for commands without a response
+func (s *NodeServer) handleSessionRequest(data []byte, requestIP string, peerID string) []byte {
+	command, request, err := s.readRequest(bytes.NewReader(data))
+
+	if err != nil {
+		s.checkRequestTooLong(err, requestIP, peerID)
+
+		return s.getErrorResponse(errors.New("Network Data Reading Error: " + err.Error()))
+	}
+
+	response := s.handleRequest(command, request, peerID, requestIP)
+
+	if response == nil {
+		response = []byte{1}
+	}
+	return response
+}
@@ -18,13 +18,25 @@ type Block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int
+	Bits          int    // PoW. difficulty the block was made with. 0 for blocks made before it was recorded
+	Signer        []byte // PoA. public key of an authority which made the block
+	Signature     []byte // PoA. signature of the block hash
+	ChainWork     []byte // cumulative work of a chain ending with the block. big integer, it is set when the block is added to the DB
+	ChainConfig   []byte // genesis block only. hash of a chain config the blockchain is created with
 }
 
-// short info about a block. to exchange over network
+// short info about a block. to exchange over network.
+// Headers used for synchronisation have also a proof of a block, a hash of transactions replaces transactions
 type BlockShort struct {
 	PrevBlockHash []byte
 	Hash          []byte
 	Height        int
+	Timestamp     int64
+	Nonce         int
+	Bits          int
+	TXsHash       []byte
+	Signer        []byte
+	Signature     []byte
 }
 
 // simpler representation of a block. transactions are presented as strings
@@ -100,6 +112,25 @@ func (b *Block) GetShortCopy() *BlockShort {
 	return &bs
 }
 
+// Returns a header of a block. It is a short copy with a proof of the block
+func (b *Block) GetHeaderCopy() (*BlockShort, error) {
+	txshash, err := b.HashTransactions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	bs := b.GetShortCopy()
+	bs.Timestamp = b.Timestamp
+	bs.Nonce = b.Nonce
+	bs.Bits = b.Bits
+	bs.TXsHash = txshash
+	bs.Signer = b.Signer[:]
+	bs.Signature = b.Signature[:]
+
+	return bs, nil
+}
+
 // Returns simpler copy of a block. This is the version for easy print
 // TODO . not sure we really need this
 func (b *Block) GetSimpler() *BlockSimpler {
@@ -136,6 +167,11 @@ func (b *Block) Copy() *Block {
 
 	bc.Nonce = b.Nonce
 	bc.Height = b.Height
+	bc.Bits = b.Bits
+	bc.Signer = utils.CopyBytes(b.Signer)
+	bc.Signature = utils.CopyBytes(b.Signature)
+	bc.ChainWork = utils.CopyBytes(b.ChainWork)
+	bc.ChainConfig = utils.CopyBytes(b.ChainConfig)
 
 	for _, t := range b.Transactions {
 		tc, _ := t.Copy()
@@ -161,6 +197,8 @@ func (b *Block) PrepareNewBlock(transactions []Transaction, prevBlockHash []byte
 
 	b.Hash = []byte{}
 	b.Nonce = 0
+	b.Bits = 0
+	b.ChainWork = []byte{}
 	b.Height = height
 
 	return nil
@@ -0,0 +1,21 @@
+package structures
+
+// Custom errors
+
+import (
+	"fmt"
+)
+
+// Signature of a transaction or a block doesn't match a key
+type SignatureError struct {
+	What string // "TX" or "block"
+	ID   []byte
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("Signature of %s %x is not valid", e.What, e.ID)
+}
+
+func NewSignatureError(what string, id []byte) error {
+	return &SignatureError{what, id}
+}
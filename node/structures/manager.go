@@ -4,8 +4,6 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
-
-	"github.com/gelembjuk/oursql/lib"
 )
 
 // return BlockShort object from bytes
@@ -53,6 +51,24 @@ func NewSQLTransaction(sql SQLUpdate, inputs []TXCurrencyInput, outputs []TXCurr
 	return tx, nil
 }
 
+// New "SQL" transaction with list of updates executed as one atomic operation.
+func NewSQLGroupTransaction(sqls []SQLUpdate, inputs []TXCurrencyInput, outputs []TXCurrrencyOutput) (*Transaction, error) {
+	if len(sqls) == 0 {
+		return nil, errors.New("EMpty SQL trsnaction info")
+	}
+	for _, sql := range sqls {
+		if sql.IsEmpty() {
+			return nil, errors.New("EMpty SQL trsnaction info")
+		}
+	}
+	tx := &Transaction{}
+	tx.Vin = inputs
+	tx.Vout = outputs
+	tx.SetSQLParts(sqls)
+	tx.initNewTX() // init new object
+	return tx, nil
+}
+
 func NewSQLUpdate(sql string, referenceID string, rollbackSQL string) SQLUpdate {
 
 	s := SQLUpdate{}
@@ -89,7 +105,8 @@ func DeserializeTransaction(txData []byte) (*Transaction, error) {
 }
 
 // New "currency" Coin Base transaction. This transaction must be present in each new block
-func NewCoinbaseTransaction(to, data string) (*Transaction, error) {
+// amount is a reward for a block plus payments for SQL queries of the block
+func NewCoinbaseTransaction(to, data string, amount float64) (*Transaction, error) {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -102,7 +119,7 @@ func NewCoinbaseTransaction(to, data string) (*Transaction, error) {
 	}
 	tx := &Transaction{}
 	txin := TXCurrencyInput{[]byte{}, -1}
-	txout := NewTXOutput(lib.CurrencyPaymentForBlockMade, to)
+	txout := NewTXOutput(amount, to)
 	tx.Vin = []TXCurrencyInput{txin}
 	tx.Vout = []TXCurrrencyOutput{*txout}
 	// init this newobject
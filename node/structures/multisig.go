@@ -0,0 +1,147 @@
+package structures
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+// Signature of a TX by one more key. It is used for SQL updates of tables which require M of N signatures
+type TXCoSignature struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// Data which is signed by a creator of a TX and by all co-signers
+func (tx Transaction) GetSignData() ([]byte, error) {
+	txCopy, err := tx.Copy()
+
+	if err != nil {
+		return nil, err
+	}
+	txCopy.Signature = []byte{}
+	txCopy.ID = []byte{}
+	// co-signatures are added after signing
+	txCopy.CoSignatures = nil
+
+	return txCopy.ToBytes()
+}
+
+// Check if a key signed the TX. It can be a creator or a co-signer
+func (tx Transaction) IsSignedBy(pubKey []byte) bool {
+	if bytes.Compare(tx.ByPubKey, pubKey) == 0 {
+		return true
+	}
+
+	for _, cs := range tx.CoSignatures {
+		if bytes.Compare(cs.PubKey, pubKey) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// All keys which signed the TX. A creator is the first
+func (tx Transaction) GetSignersPubKeys() [][]byte {
+	keys := [][]byte{tx.ByPubKey}
+
+	for _, cs := range tx.CoSignatures {
+		keys = append(keys, cs.PubKey)
+	}
+	return keys
+}
+
+// Add a signature of one more key. The signature is done for data returned by GetSignData
+func (tx *Transaction) AddCoSignature(pubKey []byte, signature []byte) error {
+	if tx.IsSignedBy(pubKey) {
+		return errors.New("The TX is already signed by this key")
+	}
+
+	signdata, err := tx.GetSignData()
+
+	if err != nil {
+		return err
+	}
+
+	v, err := utils.VerifySignature(signature, signdata, pubKey)
+
+	if err != nil {
+		return err
+	}
+
+	if !v {
+		return errors.New(fmt.Sprintf("Co-signature does not match for TX %x", tx.GetID()))
+	}
+
+	tx.CoSignatures = append(tx.CoSignatures, TXCoSignature{pubKey, signature})
+	return nil
+}
+
+// Add co-signatures from other copy of same TX. Returns number of added signatures
+func (tx *Transaction) MergeCoSignatures(other *Transaction) (int, error) {
+	if bytes.Compare(tx.GetID(), other.GetID()) != 0 {
+		return 0, errors.New("Can not merge signatures of different transactions")
+	}
+
+	added := 0
+
+	for _, cs := range other.CoSignatures {
+		if tx.IsSignedBy(cs.PubKey) {
+			continue
+		}
+
+		err := tx.AddCoSignature(cs.PubKey, cs.Signature)
+
+		if err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+// Check all co-signatures. signdata is same data which a creator of a TX signed
+func (tx Transaction) verifyCoSignatures(signdata []byte) error {
+	signers := map[string]bool{string(tx.ByPubKey): true}
+
+	for _, cs := range tx.CoSignatures {
+		if signers[string(cs.PubKey)] {
+			return errors.New(fmt.Sprintf("TX %x is signed twice by same key", tx.GetID()))
+		}
+		signers[string(cs.PubKey)] = true
+
+		v, err := utils.VerifySignature(cs.Signature, signdata, cs.PubKey)
+
+		if err != nil {
+			return err
+		}
+
+		if !v {
+			return errors.New(fmt.Sprintf("Co-signature does not match for TX %x", tx.GetID()))
+		}
+	}
+	return nil
+}
+
+// Check a signature of a creator and all co-signatures. Inputs are not checked, it is done by Verify
+// It is used for TXs which are not yet in the pool because they wait for more signatures
+func (tx Transaction) VerifySignatures() error {
+	signdata, err := tx.GetSignData()
+
+	if err != nil {
+		return err
+	}
+
+	v, err := utils.VerifySignature(tx.Signature, signdata, tx.ByPubKey)
+
+	if err != nil {
+		return err
+	}
+
+	if !v {
+		return errors.New(fmt.Sprintf("Signature does not match for TX %x", tx.GetID()))
+	}
+	return tx.verifyCoSignatures(signdata)
+}
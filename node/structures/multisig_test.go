@@ -0,0 +1,154 @@
+package structures
+
+import (
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+)
+
+func TestCoSignatures(t *testing.T) {
+	wallets := []remoteclient.Wallet{}
+
+	for i := 0; i < 3; i++ {
+		w := remoteclient.Wallet{}
+		w.MakeWallet()
+		wallets = append(wallets, w)
+	}
+
+	tx, err := NewSQLTransaction(NewSQLUpdate("UPDATE test SET a=1 WHERE id=1", "test:1", "UPDATE test SET a=0 WHERE id=1"), nil, nil)
+
+	if err != nil {
+		t.Fatalf("TX create error: %s", err.Error())
+	}
+
+	signdata, err := tx.PrepareSignData(wallets[0].GetPublicKey(), map[int]*Transaction{})
+
+	if err != nil {
+		t.Fatalf("Sign data error: %s", err.Error())
+	}
+
+	signature, _ := utils.SignDataByPubKey(wallets[0].GetPublicKey(), wallets[0].GetPrivateKey(), signdata)
+	tx.CompleteTransaction(signature)
+
+	txID := tx.GetID()
+
+	// co-signers sign same data
+	for _, w := range wallets[1:] {
+		data, err := tx.GetSignData()
+
+		if err != nil {
+			t.Fatalf("Sign data error: %s", err.Error())
+		}
+
+		signature, _ := utils.SignDataByPubKey(w.GetPublicKey(), w.GetPrivateKey(), data)
+
+		err = tx.AddCoSignature(w.GetPublicKey(), signature)
+
+		if err != nil {
+			t.Fatalf("Co-signature error: %s", err.Error())
+		}
+	}
+
+	if len(tx.CoSignatures) != 2 {
+		t.Fatalf("Expected 2 co-signatures, got %d", len(tx.CoSignatures))
+	}
+
+	err = tx.AddCoSignature(wallets[1].GetPublicKey(), tx.CoSignatures[0].Signature)
+
+	if err == nil {
+		t.Fatalf("Expected error for second signature of same key")
+	}
+
+	err = tx.Verify(map[int]*Transaction{})
+
+	if err != nil {
+		t.Fatalf("Verify error: %s", err.Error())
+	}
+
+	// ID doesn't depend on co-signatures
+	tx.completeNewTX()
+
+	if string(tx.GetID()) != string(txID) {
+		t.Fatalf("TX ID was changed by co-signatures")
+	}
+
+	// merge to a copy without co-signatures
+	txCopy, _ := tx.Copy()
+	txCopy.CoSignatures = nil
+
+	added, err := txCopy.MergeCoSignatures(tx)
+
+	if err != nil {
+		t.Fatalf("Merge error: %s", err.Error())
+	}
+
+	if added != 2 || len(txCopy.CoSignatures) != 2 {
+		t.Fatalf("Expected 2 merged co-signatures, got %d", added)
+	}
+
+	err = txCopy.VerifySignatures()
+
+	if err != nil {
+		t.Fatalf("Signatures verify error: %s", err.Error())
+	}
+
+	// wrong co-signature
+	tx.CoSignatures[1].Signature = tx.CoSignatures[0].Signature
+
+	err = tx.Verify(map[int]*Transaction{})
+
+	if err == nil {
+		t.Fatalf("Expected verify error for wrong co-signature")
+	}
+
+	err = tx.VerifySignatures()
+
+	if err == nil {
+		t.Fatalf("Expected signatures verify error for wrong co-signature")
+	}
+}
+
+func TestCoSignaturesInBlockHash(t *testing.T) {
+	tx, err := NewSQLTransaction(NewSQLUpdate("UPDATE test SET a=1 WHERE id=1", "test:1", "UPDATE test SET a=0 WHERE id=1"), nil, nil)
+
+	if err != nil {
+		t.Fatalf("TX create error: %s", err.Error())
+	}
+	tx.CoSignatures = []TXCoSignature{TXCoSignature{[]byte("key1"), []byte("sig1")}, TXCoSignature{[]byte("key2"), []byte("sig2")}}
+
+	block := Block{Transactions: []Transaction{*tx}}
+
+	hash, err := block.HashTransactions()
+
+	if err != nil {
+		t.Fatalf("Hash error: %s", err.Error())
+	}
+
+	// order of co-signatures doesn't change a hash
+	block.Transactions[0].CoSignatures = []TXCoSignature{tx.CoSignatures[1], tx.CoSignatures[0]}
+
+	hash2, _ := block.HashTransactions()
+
+	if string(hash) != string(hash2) {
+		t.Fatalf("Hash depends on order of co-signatures")
+	}
+
+	// other co-signature
+	block.Transactions[0].CoSignatures = []TXCoSignature{tx.CoSignatures[0], TXCoSignature{[]byte("key2"), []byte("sig3")}}
+
+	hash2, _ = block.HashTransactions()
+
+	if string(hash) == string(hash2) {
+		t.Fatalf("Hash must be changed when a co-signature is changed")
+	}
+
+	// co-signature removed
+	block.Transactions[0].CoSignatures = tx.CoSignatures[:1]
+
+	hash2, _ = block.HashTransactions()
+
+	if string(hash) == string(hash2) {
+		t.Fatalf("Hash must be changed when a co-signature is removed")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
+	"sort"
 	"strings"
 	"time"
 
@@ -25,7 +26,11 @@ type Transaction struct {
 	Vin        []TXCurrencyInput
 	Vout       []TXCurrrencyOutput
 	SQLCommand SQLUpdate
-	SQLBaseTX  []byte // ID of transaction where same row was affected last time
+	SQLBaseTX  []byte      // ID of transaction where same row was affected last time
+	SQLGroup   []SQLUpdate // next updates of same atomic SQL transaction (BEGIN ... COMMIT). Each has own PrevTransaction
+	// signatures of other keys for updates of multisig tables. They sign same data as ByPubKey
+	// and are not part of the TX ID, so the ID doesn't change while signatures are collected
+	CoSignatures []TXCoSignature
 }
 
 // execute when new tranaction object is created
@@ -61,6 +66,11 @@ func (tx Transaction) IsSQLCommand() bool {
 	return !tx.SQLCommand.IsEmpty()
 }
 
+// Check if the transaction contains more than 1 SQL update
+func (tx Transaction) IsSQLGroup() bool {
+	return len(tx.SQLGroup) > 0
+}
+
 // check if TX is coin base
 func (tx Transaction) IsCoinbaseTransfer() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
@@ -77,6 +87,7 @@ func (tx *Transaction) makeHash() ([]byte, error) {
 
 	txCopy := *tx
 	txCopy.ID = []byte{}
+	txCopy.CoSignatures = nil
 
 	txser, err := txCopy.serialize()
 
@@ -105,6 +116,8 @@ func (tx Transaction) Copy() (*Transaction, error) {
 	txCopy.ByPubKey = tx.ByPubKey
 	txCopy.SQLCommand = tx.SQLCommand
 	txCopy.SQLBaseTX = tx.SQLBaseTX
+	txCopy.SQLGroup = tx.SQLGroup
+	txCopy.CoSignatures = tx.CoSignatures
 
 	return txCopy, nil
 }
@@ -134,7 +147,7 @@ func (tx *Transaction) PrepareSignData(pubKey []byte, prevTXs map[int]*Transacti
 	tx.Signature = []byte{}
 	tx.ID = []byte{}
 
-	return tx.ToBytes()
+	return tx.GetSignData()
 }
 
 // Sets signatures for inputs. Signatures were created separately for data set prepared before
@@ -151,8 +164,9 @@ func (tx *Transaction) CompleteTransaction(signature []byte) error {
 // And total amount of inputs and outputs
 func (tx *Transaction) Verify(prevTXs map[int]*Transaction) error {
 	if tx.IsCoinbaseTransfer() {
-		// coinbase has only 1 output and it must have value equal to constant
-		if tx.Vout[0].Value != lib.CurrencyPaymentForBlockMade {
+		// coinbase has only 1 output and it must have value equal to a block reward plus payments for SQL queries.
+		// the value is checked when a block is verified
+		if tx.Vout[0].Value < 0 {
 			return errors.New("Value of coinbase transaction is wrong")
 		}
 		if len(tx.Vout) > 1 {
@@ -173,15 +187,7 @@ func (tx *Transaction) Verify(prevTXs map[int]*Transaction) error {
 		totalinput += amount
 	}
 	// VERIFY signature
-	// build copy to make sign data
-	txCopy, err := tx.Copy()
-	if err != nil {
-		return err
-	}
-	txCopy.Signature = []byte{}
-	txCopy.ID = []byte{}
-
-	stringtosign, err := txCopy.ToBytes()
+	stringtosign, err := tx.GetSignData()
 
 	if err != nil {
 		return err
@@ -194,7 +200,13 @@ func (tx *Transaction) Verify(prevTXs map[int]*Transaction) error {
 	}
 
 	if !v {
-		return errors.New(fmt.Sprintf("Signatire doe not match for TX %x.", tx.GetID()))
+		return NewSignatureError("TX", tx.GetID())
+	}
+
+	err = tx.verifyCoSignatures(stringtosign)
+
+	if err != nil {
+		return err
 	}
 
 	pubKeyHash, _ := utils.HashPubKey(tx.ByPubKey)
@@ -218,13 +230,37 @@ func (tx *Transaction) Verify(prevTXs map[int]*Transaction) error {
 		totaloutput += vout.Value
 	}
 
-	if math.Abs(totalinput-totaloutput) >= lib.CurrencySmallestUnit {
+	if tx.IsSQLCommand() {
+		// difference is a payment for queries to a minter. it can not be negative
+		if totaloutput-totalinput >= lib.CurrencySmallestUnit {
+			return errors.New(fmt.Sprintf("Output value of a transaction is more than input: %.10f vs %.10f", totaloutput, totalinput))
+		}
+	} else if math.Abs(totalinput-totaloutput) >= lib.CurrencySmallestUnit {
 		return errors.New(fmt.Sprintf("Input and output values of a transaction are not same: %.10f vs %.10f . Diff %.10f", totalinput, totaloutput, totalinput-totaloutput))
 	}
 
 	return nil
 }
 
+// Amount paid to a minter of a block. It is a difference of inputs and outputs of SQL TX
+// prevTXs are same as for Verify
+func (tx Transaction) GetMinterPayment(prevTXs map[int]*Transaction) float64 {
+	if !tx.IsSQLCommand() {
+		return 0
+	}
+
+	payment := float64(0)
+
+	for vind, vin := range tx.Vin {
+		payment += prevTXs[vind].Vout[vin.Vout].Value
+	}
+
+	for _, vout := range tx.Vout {
+		payment -= vout.Value
+	}
+	return payment
+}
+
 // Serialize returns a serialized Transaction
 func (tx Transaction) serialize() ([]byte, error) {
 	// to remove any references to other ponters
@@ -316,6 +352,42 @@ func (tx Transaction) ToBytes() ([]byte, error) {
 		return nil, err
 	}
 
+	for _, sqlUpdate := range tx.SQLGroup {
+		err = binary.Write(buff, binary.BigEndian, sqlUpdate.ToBytes())
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = binary.Write(buff, binary.BigEndian, sqlUpdate.PrevTransaction)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// co-signatures are in a hash of a block. sorted, so any copy of the TX gives same bytes
+	coSignatures := make([]TXCoSignature, len(tx.CoSignatures))
+	copy(coSignatures, tx.CoSignatures)
+
+	sort.Slice(coSignatures, func(i, j int) bool {
+		return bytes.Compare(coSignatures[i].PubKey, coSignatures[j].PubKey) < 0
+	})
+
+	for _, cs := range coSignatures {
+		err = binary.Write(buff, binary.BigEndian, cs.PubKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = binary.Write(buff, binary.BigEndian, cs.Signature)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return buff.Bytes(), nil
 }
 
@@ -344,17 +416,55 @@ func (tx *Transaction) SetSQLPart(sql SQLUpdate) {
 	tx.SQLCommand = sql
 }
 
+// Set list of SQL updates executed as one atomic operation.
+// PrevTransaction of first update goes to SQLBaseTX
+func (tx *Transaction) SetSQLParts(sqls []SQLUpdate) {
+	tx.SQLGroup = nil
+
+	if len(sqls) == 0 {
+		tx.SQLCommand = SQLUpdate{}
+		return
+	}
+	tx.SQLCommand = sqls[0]
+	tx.SQLBaseTX = sqls[0].PrevTransaction
+	tx.SQLCommand.PrevTransaction = nil
+
+	if len(sqls) > 1 {
+		tx.SQLGroup = sqls[1:]
+	}
+}
+
 // set ID of previous TX where SQL item was updated
 func (tx *Transaction) SetSQLPreviousTX(baseTX []byte) {
 	tx.SQLBaseTX = baseTX
 }
 
+// Returns all SQL updates of the TX in order of execution.
+// PrevTransaction is set for each of them
+func (tx Transaction) GetSQLUpdates() []SQLUpdate {
+	if !tx.IsSQLCommand() {
+		return []SQLUpdate{}
+	}
+	first := tx.SQLCommand
+	first.PrevTransaction = tx.SQLBaseTX
+
+	return append([]SQLUpdate{first}, tx.SQLGroup...)
+}
+
 // returns SQL command as string
 func (tx Transaction) GetSQLQuery() string {
-	if len(tx.SQLCommand.Query) > 0 {
+	if len(tx.SQLCommand.Query) == 0 {
+		return ""
+	}
+	if !tx.IsSQLGroup() {
 		return string(tx.SQLCommand.Query)
 	}
-	return ""
+	queries := []string{}
+
+	for _, sqlUpdate := range tx.GetSQLUpdates() {
+		queries = append(queries, string(sqlUpdate.Query))
+	}
+	return strings.Join(queries, "; ")
 }
 
 // String returns a human-readable representation of a transaction
@@ -95,6 +95,63 @@ func TestToBytes(t *testing.T) {
 		t.Fatalf("ToBytes result length is wrong")
 	}
 }
+
+func TestSQLGroup(t *testing.T) {
+	sqls := []SQLUpdate{
+		NewSQLUpdate("INSERT INTO t SET id=1, a='x'", "t:1", "DELETE FROM t WHERE id='1'"),
+		NewSQLUpdate("UPDATE t SET a='y' WHERE id=2", "t:2", "UPDATE t SET  a='z' WHERE id='2'"),
+	}
+	sqls[0].PrevTransaction = []byte{1, 2, 3}
+	sqls[1].PrevTransaction = []byte{4, 5, 6}
+
+	newTX, err := NewSQLGroupTransaction(sqls, nil, nil)
+
+	if err != nil {
+		t.Fatalf("New TX error %s", err.Error())
+	}
+
+	if !newTX.IsSQLGroup() {
+		t.Fatalf("Expected TX to be a group of SQL updates")
+	}
+
+	err = newTX.CompleteTransaction([]byte{1, 2, 3}) // fake signature
+
+	if err != nil {
+		t.Fatalf("Hash error %s", err.Error())
+	}
+
+	txData, err := SerializeTransaction(newTX)
+
+	if err != nil {
+		t.Fatalf("Serialize error %s", err.Error())
+	}
+
+	tx, err := DeserializeTransaction(txData)
+
+	if err != nil {
+		t.Fatalf("DeSerialize error %s", err.Error())
+	}
+
+	updates := tx.GetSQLUpdates()
+
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 SQL updates, got %d", len(updates))
+	}
+
+	for i, u := range updates {
+		if bytes.Compare(u.Query, sqls[i].Query) != 0 {
+			t.Fatalf("Query %d is different: %s vs %s", i, string(u.Query), string(sqls[i].Query))
+		}
+		if bytes.Compare(u.PrevTransaction, sqls[i].PrevTransaction) != 0 {
+			t.Fatalf("Previous TX %d is different: %x vs %x", i, u.PrevTransaction, sqls[i].PrevTransaction)
+		}
+	}
+
+	if bytes.Compare(tx.GetSQLBaseTX(), sqls[0].PrevTransaction) != 0 {
+		t.Fatalf("Base TX is wrong %x", tx.GetSQLBaseTX())
+	}
+}
+
 func TestSignature(t *testing.T) {
 
 }
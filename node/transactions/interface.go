@@ -15,10 +15,12 @@ type TransactionsManagerInterface interface {
 	GetUnapprovedCount() (int, error)
 	GetUnspentCount() (int, error)
 	GetUnapprovedTransactionsForNewBlock(number int) ([]structures.Transaction, error)
+	GetUnapprovedTransactions() ([]*structures.Transaction, error)
 	GetIfExists(txid []byte) (*structures.Transaction, error)
 	GetIfUnapprovedExists(txid []byte) (*structures.Transaction, error)
 
 	VerifyTransaction(tx *structures.Transaction, prevtxs []structures.Transaction, tip []byte) (bool, error)
+	GetMinterPayment(tx *structures.Transaction, prevtxs []structures.Transaction, tip []byte) (float64, error)
 
 	ForEachUnspentOutput(address string, callback UnspentTransactionOutputCallbackInterface) error
 	ForEachUnapprovedTransaction(callback UnApprovedTransactionCallbackInterface) (int, error)
@@ -29,6 +31,7 @@ type TransactionsManagerInterface interface {
 	ReceivedNewCurrencyTransactionData(txBytes []byte, Signature []byte) (*structures.Transaction, error)
 	ReceivedNewTransaction(tx *structures.Transaction, sqltoexecute bool) error
 	PrepareNewSQLTransaction(PubKey []byte, sqlUpdate structures.SQLUpdate, amount float64, to string) ([]byte, []byte, error)
+	PrepareNewSQLGroupTransaction(PubKey []byte, sqlUpdates []structures.SQLUpdate, txTime int64, amount float64, to string) ([]byte, []byte, error)
 
 	// new block was created in blockchain DB. It must not be on top of primary blockchain
 	BlockAdded(block *structures.Block, ontopofchain bool) error
@@ -1,6 +1,7 @@
 package transactions
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
@@ -104,6 +105,16 @@ func (n *txManager) GetUnapprovedCount() (int, error) {
 	return n.getUnapprovedTransactionsManager().GetCount()
 }
 
+// all transactions of the pool, oldest first
+func (n *txManager) GetUnapprovedTransactions() ([]*structures.Transaction, error) {
+	count, err := n.GetUnapprovedCount()
+
+	if err != nil || count == 0 {
+		return []*structures.Transaction{}, err
+	}
+	return n.getUnapprovedTransactionsManager().GetTransactions(count)
+}
+
 // return count of unspent outputs
 func (n *txManager) GetUnspentCount() (int, error) {
 	return n.getUnspentOutputsManager().CountUnspentOutputs()
@@ -201,8 +212,8 @@ func (n *txManager) CancelTransaction(txid []byte) error {
 	}
 	n.Logger.Trace.Printf("Check if is SQL TX")
 	if tx.IsSQLCommand() {
-		n.Logger.Trace.Printf("This is cancel of SQL TX. Rollback it: %s", string(tx.SQLCommand.RollbackQuery))
-		err = n.getQueryParser().ExecuteRollbackQueryFromTX(tx.SQLCommand)
+		n.Logger.Trace.Printf("This is cancel of SQL TX. Rollback it: %s", tx.GetSQLQuery())
+		err = n.rollbackSQLUpdates(tx, tx.GetSQLUpdates())
 
 		if err != nil {
 			return err
@@ -223,21 +234,11 @@ func (n *txManager) CancelTransaction(txid []byte) error {
 // NOTE Transaction can have outputs of other transactions that are not yet approved.
 // This must be considered as correct case
 func (n *txManager) VerifyTransaction(tx *structures.Transaction, prevtxs []structures.Transaction, tip []byte) (bool, error) {
-	inputTXs, notFoundInputs, err := n.getCurrencyInputTransactionsState(tx, tip)
+	inputTXs, err := n.getInputTransactions(tx, prevtxs, tip)
+
 	if err != nil {
-		n.Logger.Trace.Printf("VT error 4: %s", err.Error())
 		return false, err
 	}
-
-	if len(notFoundInputs) > 0 {
-		// some of inputs can be from other transactions in this pool
-		inputTXs, err = n.getUnapprovedTransactionsManager().CheckCurrencyInputsWereBefore(notFoundInputs, prevtxs, inputTXs)
-
-		if err != nil {
-			n.Logger.Trace.Printf("VT error when verify %x: %s", tx.GetID(), err.Error())
-			return false, err
-		}
-	}
 	// do final check against inputs
 
 	err = tx.Verify(inputTXs)
@@ -247,9 +248,52 @@ func (n *txManager) VerifyTransaction(tx *structures.Transaction, prevtxs []stru
 		return false, err
 	}
 
+	// rows of tables in row ownership mode can be updated only by owners
+	err = n.checkRowsOwnership(tx, prevtxs, tip)
+
+	if err != nil {
+		n.Logger.Trace.Printf("VT error 7: %s", err.Error())
+		return false, err
+	}
+
 	return true, nil
 }
 
+// Amount a TX pays to a minter of a block. Inputs are found same way as in VerifyTransaction
+func (n *txManager) GetMinterPayment(tx *structures.Transaction, prevtxs []structures.Transaction, tip []byte) (float64, error) {
+	if !tx.IsSQLCommand() || tx.IsCoinbaseTransfer() {
+		return 0, nil
+	}
+
+	inputTXs, err := n.getInputTransactions(tx, prevtxs, tip)
+
+	if err != nil {
+		return 0, err
+	}
+	return tx.GetMinterPayment(inputTXs), nil
+}
+
+// Find input transactions of a TX in a blockchain starting from tip or in a list of previous TXs of a block
+func (n *txManager) getInputTransactions(tx *structures.Transaction, prevtxs []structures.Transaction, tip []byte) (map[int]*structures.Transaction, error) {
+	inputTXs, notFoundInputs, err := n.getCurrencyInputTransactionsState(tx, tip)
+
+	if err != nil {
+		n.Logger.Trace.Printf("VT error 4: %s", err.Error())
+		return nil, err
+	}
+
+	if len(notFoundInputs) > 0 {
+		// some of inputs can be from other transactions in this pool
+		inputTXs, err = n.getUnapprovedTransactionsManager().CheckCurrencyInputsWereBefore(notFoundInputs, prevtxs, inputTXs)
+
+		if err != nil {
+			n.Logger.Trace.Printf("VT error when verify %x: %s", tx.GetID(), err.Error())
+			return nil, err
+		}
+	}
+	return inputTXs, nil
+}
+
 // Iterate over unapproved transactions, for example to display them . Accepts callback as argument
 func (n *txManager) ForEachUnapprovedTransaction(callback UnApprovedTransactionCallbackInterface) (int, error) {
 	return n.getUnapprovedTransactionsManager().forEachUnapprovedTransaction(callback)
@@ -316,7 +360,9 @@ func (n *txManager) BlockRemovedFromPrimaryChain(block *structures.Block) error
 	// we need to reverse transactions slice. execution of rollback should go
 	// in reversed order
 
-	for _, tx := range block.Transactions {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+
 		if tx.IsCoinbaseTransfer() {
 			continue
 		}
@@ -324,14 +370,15 @@ func (n *txManager) BlockRemovedFromPrimaryChain(block *structures.Block) error
 			continue
 		}
 
-		n.Logger.Trace.Printf("Execute On Block Remove: %s", string(tx.SQLCommand.RollbackQuery))
+		n.Logger.Trace.Printf("Execute On Block Remove: %s", tx.GetSQLQuery())
 
-		_, err := n.getQueryParser().ExecuteQuery(string(tx.SQLCommand.RollbackQuery))
+		// updates of a group are canceled in reversed order
+		// rollback can be not SQL (table snapshot), so it is executed by the query processor
+		err := n.rollbackSQLUpdates(&tx, tx.GetSQLUpdates())
 
 		if err != nil {
 			return err
 		}
-
 	}
 
 	n.getUnspentOutputsManager().UpdateOnBlockCancel(block)
@@ -363,7 +410,7 @@ func (n *txManager) transactionsFromAddedBlock(txList []structures.Transaction)
 
 			n.Logger.Trace.Printf("Execute On Block Add: %s", tx.GetSQLQuery())
 
-			_, err := n.getQueryParser().ExecuteQuery(tx.GetSQLQuery())
+			err := n.executeSQLUpdates(&tx)
 			if err != nil {
 				return err
 			}
@@ -442,11 +489,32 @@ func (n *txManager) ReceivedNewTransaction(tx *structures.Transaction, sqltoexec
 	if !good {
 		return errors.New("Transaction verification failed")
 	}
+	if tx.IsSQLCommand() {
+		// signer must have permissions for all queries
+		err = dbquery.NewPermissionsChecker(n.DB, n.Logger).CheckTransaction(tx)
+
+		if err != nil {
+			return err
+		}
+
+		err = n.checkRowsOwnership(tx, nil, nil)
+
+		if err != nil {
+			return err
+		}
+	}
 	// if this is SQL transaction, execute it now.
 	if tx.IsSQLCommand() && sqltoexecute {
 		n.Logger.Trace.Printf("Execute: %s , refID is %s", tx.GetSQLQuery(), string(tx.SQLCommand.ReferenceID))
 
-		_, err := n.getQueryParser().ExecuteQuery(tx.GetSQLQuery())
+		err := n.executeSQLUpdates(tx)
+		if err != nil {
+			return err
+		}
+	} else if tx.IsSQLCommand() {
+		// queries were executed by a DB server. permissions are kept by a node
+		err = n.getQueryParser().ApplyPermissionsFromTX(tx)
+
 		if err != nil {
 			return err
 		}
@@ -470,11 +538,49 @@ func (n *txManager) PrepareNewCurrencyTransaction(PubKey []byte, to string, amou
 }
 
 // Make new transaction  for SQL command
-// amount to pay for TX can be 0
+// amount to pay for TX can be 0. If to is lib.CurrencyReceiverMinter, a minter of a block gets the amount
 func (n *txManager) PrepareNewSQLTransaction(PubKey []byte, sqlUpdate structures.SQLUpdate,
 	amount float64, to string) (txBytes []byte, datatosign []byte, err error) {
 
-	// find TX where thi refID was last updated and add it to sqlUpdate too
+	return n.PrepareNewSQLGroupTransaction(PubKey, []structures.SQLUpdate{sqlUpdate}, 0, amount, to)
+}
+
+// Make new transaction for list of SQL commands. All of them are executed as one atomic operation
+// amount to pay for TX can be 0
+// txTime is a time used in queries as NOW() etc. 0 means current time
+func (n *txManager) PrepareNewSQLGroupTransaction(PubKey []byte, sqlUpdates []structures.SQLUpdate,
+	txTime int64, amount float64, to string) (txBytes []byte, datatosign []byte, err error) {
+
+	if len(sqlUpdates) == 0 {
+		err = errors.New("No SQL updates for new transaction")
+		return
+	}
+
+	// find TX where each refID was last updated and add it to sqlUpdate too
+	sqlUpdates = append([]structures.SQLUpdate{}, sqlUpdates...)
+
+	for i, sqlUpdate := range sqlUpdates {
+		var inputSQLTX []byte
+		var prevSQLUpdate *structures.SQLUpdate
+
+		inputSQLTX, prevSQLUpdate, err = n.getGroupBaseTransaction(sqlUpdates[:i], sqlUpdate)
+
+		if err != nil {
+			return
+		}
+		if inputSQLTX == nil {
+			inputSQLTX = []byte{}
+		}
+		// we need to verify that current query can follow that previous update
+		err = n.checkSQLUpdateCanFollow(sqlUpdate, prevSQLUpdate)
+
+		if err != nil {
+			return
+		}
+		// thsi si reference to a transaction where same database item was updated last time
+		n.Logger.Trace.Printf("Input transaction %x for %s", inputSQLTX, string(sqlUpdate.Query))
+		sqlUpdates[i].PrevTransaction = inputSQLTX
+	}
 
 	var inputsTX map[int]*structures.Transaction
 	var tx *structures.Transaction
@@ -502,38 +608,32 @@ func (n *txManager) PrepareNewSQLTransaction(PubKey []byte, sqlUpdate structures
 			return
 		}
 
-		tx.SetSQLPart(sqlUpdate)
+		tx.SetSQLParts(sqlUpdates)
 	} else {
 		// currrency part will be empty in new TX
-		tx, err = structures.NewSQLTransaction(sqlUpdate, nil, nil)
+		tx, err = structures.NewSQLGroupTransaction(sqlUpdates, nil, nil)
 
 		if err != nil {
 			return
 		}
 	}
 
-	// set previous TX ID.
-	inputSQLTX, err := n.getBaseTransaction(sqlUpdate)
+	if txTime > 0 {
+		// queries have values of time functions. TX time must be same
+		tx.Time = txTime
+	}
+
+	datatosign, err = tx.PrepareSignData(PubKey, inputsTX)
 
 	if err != nil {
+		err = errors.New(fmt.Sprintf("Error serialyxing prepared TX: %s", err.Error()))
 		return
 	}
-	if inputSQLTX == nil {
-		inputSQLTX = []byte{}
-	} else {
-		// we need to verify that current query can follow that previous update
-		// TODO . Get TXt by inputSQLTX, extract SQLUpdate info from it
-		// and call CheckUpdateCanFollow from dbqueey package
-		// NOTE this can work without that. we check if a row exists before this place and it gives error if no
-	}
-	// thsi si reference to a transaction where same database item was updated last time
-	n.Logger.Trace.Printf("Input transaction %x for %s", inputSQLTX, string(sqlUpdate.Query))
-	tx.SetSQLPreviousTX(inputSQLTX)
 
-	datatosign, err = tx.PrepareSignData(PubKey, inputsTX)
+	// signer is known now. check it owns rows it changes
+	err = n.checkRowsOwnership(tx, nil, nil)
 
 	if err != nil {
-		err = errors.New(fmt.Sprintf("Error serialyxing prepared TX: %s", err.Error()))
 		return
 	}
 
@@ -605,7 +705,11 @@ func (n *txManager) prepareNewCurrencyTransactionComplete(PubKey []byte, to stri
 
 	// Build a list of outputs
 	from, _ := utils.PubKeyToAddres(PubKey)
-	outputs = append(outputs, *structures.NewTXOutput(amount, to))
+
+	if to != lib.CurrencyReceiverMinter {
+		outputs = append(outputs, *structures.NewTXOutput(amount, to))
+	}
+	// payment to a minter has no output. a minter adds it to a coinbase TX of a block
 
 	if totalamount > amount && totalamount-amount > lib.CurrencySmallestUnit {
 		outputs = append(outputs, *structures.NewTXOutput(totalamount-amount, from)) // a change
@@ -838,6 +942,117 @@ func (n *txManager) getCurrencyInputTransactionsState(tx *structures.Transaction
 	return prevTXs, badinputs, nil
 }
 
+// Finds a base transaction for an update that is part of a group.
+// If same row (or a table) was updated by previous update in the group then the base is this new TX.
+// It is marked with empty slice
+// Also returns an update from a base TX which is previous for this update. It is nil if there is no base
+func (n *txManager) getGroupBaseTransaction(prevUpdates []structures.SQLUpdate, sqlUpdate structures.SQLUpdate) (txID []byte, prevUpdate *structures.SQLUpdate, err error) {
+	sqlUpdateMan, err := dbquery.NewSQLUpdateManager(sqlUpdate)
+
+	if err != nil {
+		return
+	}
+
+	altRefID, err := sqlUpdateMan.GetAlternativeRefID()
+
+	if err != nil {
+		return
+	}
+
+	// last update of same row is a previous
+	for i := len(prevUpdates) - 1; i >= 0; i-- {
+		if bytes.Compare(prevUpdates[i].ReferenceID, sqlUpdate.ReferenceID) == 0 ||
+			(altRefID != nil && bytes.Compare(prevUpdates[i].ReferenceID, altRefID) == 0) {
+			return []byte{}, &prevUpdates[i], nil
+		}
+	}
+
+	txID, err = n.getBaseTransaction(sqlUpdate)
+
+	if err != nil || len(txID) == 0 {
+		return
+	}
+
+	prevUpdate, err = n.getBaseSQLUpdate(txID, sqlUpdate.ReferenceID, altRefID)
+
+	return
+}
+
+// Find an update in a base transaction by RefID or alternative RefID
+func (n *txManager) getBaseSQLUpdate(txID []byte, refID []byte, altRefID []byte) (*structures.SQLUpdate, error) {
+	tx, err := n.GetIfExists(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx == nil {
+		return nil, errors.New(fmt.Sprintf("Base transaction %x not found", txID))
+	}
+
+	sqlUpdates := tx.GetSQLUpdates()
+
+	var altUpdate *structures.SQLUpdate
+
+	for i := len(sqlUpdates) - 1; i >= 0; i-- {
+		if bytes.Compare(sqlUpdates[i].ReferenceID, refID) == 0 {
+			return &sqlUpdates[i], nil
+		}
+		if altUpdate == nil && altRefID != nil && bytes.Compare(sqlUpdates[i].ReferenceID, altRefID) == 0 {
+			altUpdate = &sqlUpdates[i]
+		}
+	}
+
+	if altUpdate == nil {
+		return nil, errors.New(fmt.Sprintf("Base transaction %x has no update for %s", txID, string(refID)))
+	}
+	return altUpdate, nil
+}
+
+// Check if SQL update can be based on previous update. prevSQLUpdate is nil if there is no base
+func (n *txManager) checkSQLUpdateCanFollow(sqlUpdate structures.SQLUpdate, prevSQLUpdate *structures.SQLUpdate) error {
+	sqlUpdateMan, err := dbquery.NewSQLUpdateManager(sqlUpdate)
+
+	if err != nil {
+		return err
+	}
+
+	err = sqlUpdateMan.CheckUpdateCanFollow(prevSQLUpdate)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s: %s", string(sqlUpdate.Query), err.Error()))
+	}
+	return nil
+}
+
+// Execute SQL updates of a TX in the order. If some update fails, all executed before are rolled back
+func (n *txManager) executeSQLUpdates(tx *structures.Transaction) error {
+	executed := []structures.SQLUpdate{}
+
+	for _, sqlUpdate := range tx.GetSQLUpdates() {
+		err := n.getQueryParser().ExecuteQueryFromTX(sqlUpdate, tx)
+
+		if err != nil {
+			n.rollbackSQLUpdates(tx, executed)
+			return err
+		}
+		executed = append(executed, sqlUpdate)
+	}
+	return nil
+}
+
+// Rollback SQL updates of a TX. Goes in reversed order
+func (n *txManager) rollbackSQLUpdates(tx *structures.Transaction, sqlUpdates []structures.SQLUpdate) error {
+	for i := len(sqlUpdates) - 1; i >= 0; i-- {
+		err := n.getQueryParser().ExecuteRollbackQueryFromTX(sqlUpdates[i], tx)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Finds a transaction where a refID was last updated or which can be used as a base
 // Firstly looks in a pool of transactions ,if not found, looks in an index
 func (n *txManager) getBaseTransaction(sqlUpdate structures.SQLUpdate) (txID []byte, err error) {
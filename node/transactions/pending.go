@@ -613,23 +613,24 @@ func (u *unApprovedTransactions) FindSQLReferenceTransaction(sqlUpdate structure
 		}
 
 		// RefID in a tarnsaction can not be empty
+		for _, txUpdate := range tx.GetSQLUpdates() {
+			u.Logger.Trace.Printf("Check RefID %s in TX %x", string(txUpdate.ReferenceID), tx.GetID())
 
-		u.Logger.Trace.Printf("Check RefID %s in TX %x", string(tx.SQLCommand.ReferenceID), tx.GetID())
-
-		if bytes.Compare(tx.SQLCommand.ReferenceID, sqlUpdate.ReferenceID) == 0 {
-			// we found this refereence , check if input TX was not yet used as input in other tx
-			if !u.helperCheckTXInList(tx.GetID(), transactionsReused) {
-				txID = utils.CopyBytes(tx.GetID())
+			if bytes.Compare(txUpdate.ReferenceID, sqlUpdate.ReferenceID) == 0 {
+				// we found this refereence , check if input TX was not yet used as input in other tx
+				if !u.helperCheckTXInList(tx.GetID(), transactionsReused) {
+					txID = utils.CopyBytes(tx.GetID())
+				}
 			}
-		}
-		if bytes.Compare(tx.SQLCommand.ReferenceID, altRefID) == 0 {
-			// we found this refereence , check if input TX was not yet used as input in other tx
-			if !u.helperCheckTXInList(tx.GetID(), transactionsReused) {
-				AlttxID = tx.GetID()
+			if bytes.Compare(txUpdate.ReferenceID, altRefID) == 0 {
+				// we found this refereence , check if input TX was not yet used as input in other tx
+				if !u.helperCheckTXInList(tx.GetID(), transactionsReused) {
+					AlttxID = tx.GetID()
+				}
+			}
+			if len(txUpdate.PrevTransaction) > 0 {
+				transactionsReused = append(transactionsReused, txUpdate.PrevTransaction)
 			}
-		}
-		if len(tx.GetSQLBaseTX()) > 0 {
-			transactionsReused = append(transactionsReused, tx.GetSQLBaseTX())
 		}
 
 		return nil
@@ -0,0 +1,248 @@
+package transactions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/oursql/lib"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/dbquery"
+	"github.com/gelembjuk/oursql/node/dbquery/sqlparser"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Row ownership mode of a table. A row can be updated or deleted only by its owner.
+// The owner is a signer of a TX with INSERT of the row, or an address the row was transferred to
+// with TRANSFER ROW OWNERSHIP. The owner is found by following a chain of TXs of the row (PrevTransaction)
+// NOTE rows inserted before the mode was enabled have owners too. Rows inserted before TXs had
+// base transactions have no owner and can be updated by anyone with permissions
+
+// Check that a signer of a TX owns all rows it updates, deletes or transfers.
+// prevtxs are TXs which are before this one in a block, a base TX can be one of them. It is nil if a TX is not in a block,
+// then a base TX can be in a pool. tip is a top block of a branch of a block, empty for the primary chain
+func (n *txManager) checkRowsOwnership(tx *structures.Transaction, prevtxs []structures.Transaction, tip []byte) error {
+	if !tx.IsSQLCommand() {
+		return nil
+	}
+
+	signer, err := utils.PubKeyToAddres(tx.ByPubKey)
+
+	if err != nil {
+		return err
+	}
+
+	permissions := dbquery.NewPermissionsChecker(n.DB, n.Logger)
+
+	sqlUpdates := tx.GetSQLUpdates()
+
+	for i, sqlUpdate := range sqlUpdates {
+		parsed := sqlparser.NewSqlParser()
+
+		err = parsed.Parse(string(sqlUpdate.Query))
+
+		if err != nil {
+			return err
+		}
+
+		if parsed.GetKind() != lib.QueryKindUpdate &&
+			parsed.GetKind() != lib.QueryKindDelete &&
+			parsed.GetKind() != lib.QueryKindTransfer {
+			continue
+		}
+
+		enabled, err := permissions.IsRowOwnershipEnabled(parsed.GetTable())
+
+		if err != nil {
+			return err
+		}
+
+		if !enabled {
+			continue
+		}
+
+		// an owner is found by a chain of TXs, a signer must not choose it
+		err = n.checkRowBaseTransaction(sqlUpdates[:i], sqlUpdate, prevtxs, tip)
+
+		if err != nil {
+			return err
+		}
+
+		owner, err := n.getRowOwner(tx, i, prevtxs)
+
+		if err != nil {
+			return err
+		}
+
+		if owner != "" && owner != signer {
+			return errors.New(fmt.Sprintf("Address %s is not an owner of the row %s", signer, string(sqlUpdate.ReferenceID)))
+		}
+	}
+	return nil
+}
+
+// Find an owner of a row updated by an update with index in a TX. Empty string if a row has no owner
+func (n *txManager) getRowOwner(tx *structures.Transaction, index int, prevtxs []structures.Transaction) (string, error) {
+	sqlUpdates := tx.GetSQLUpdates()
+	refID := sqlUpdates[index].ReferenceID
+
+	for {
+		// previous updates of same row in same TX
+		for i := index - 1; i >= 0; i-- {
+			if bytes.Compare(sqlUpdates[i].ReferenceID, refID) != 0 {
+				continue
+			}
+
+			owner, found, err := getRowOwnerFromUpdate(tx, sqlUpdates[i])
+
+			if err != nil || found {
+				return owner, err
+			}
+			index = i
+		}
+
+		if index >= len(sqlUpdates) {
+			// a base TX must have an update of same row
+			return "", errors.New(fmt.Sprintf("Base transaction %x has no update of the row %s", tx.GetID(), string(refID)))
+		}
+
+		prevTXID := sqlUpdates[index].PrevTransaction
+
+		if len(prevTXID) == 0 {
+			// there is no base TX
+			return "", nil
+		}
+
+		prevTX, err := n.findTransaction(prevTXID, prevtxs)
+
+		if err != nil {
+			return "", err
+		}
+
+		if prevTX == nil {
+			return "", errors.New(fmt.Sprintf("Base transaction %x not found", prevTXID))
+		}
+
+		tx = prevTX
+		sqlUpdates = tx.GetSQLUpdates()
+		index = len(sqlUpdates)
+	}
+}
+
+// Check that a base TX of an update is a TX where a row was changed last time. Only first update of a row
+// in a TX is checked, next updates are based on this TX
+func (n *txManager) checkRowBaseTransaction(prevUpdates []structures.SQLUpdate, sqlUpdate structures.SQLUpdate,
+	prevtxs []structures.Transaction, tip []byte) error {
+
+	for _, prevUpdate := range prevUpdates {
+		if bytes.Compare(prevUpdate.ReferenceID, sqlUpdate.ReferenceID) == 0 {
+			return nil
+		}
+	}
+
+	lastTXID, err := n.getRowLastTransaction(sqlUpdate, prevtxs, tip)
+
+	if err != nil {
+		return err
+	}
+
+	if bytes.Compare(sqlUpdate.PrevTransaction, lastTXID) == 0 {
+		return nil
+	}
+
+	if len(lastTXID) == 0 {
+		return errors.New(fmt.Sprintf("The row %s was not changed before, base transaction must be empty", string(sqlUpdate.ReferenceID)))
+	}
+	return errors.New(fmt.Sprintf("Base transaction of the row %s must be %x", string(sqlUpdate.ReferenceID), lastTXID))
+}
+
+// Find a TX where a row was changed last time. Looks in TXs before in a block, then in a pool if a TX is not in a block,
+// then in the index of rows. nil if a row was never changed
+func (n *txManager) getRowLastTransaction(sqlUpdate structures.SQLUpdate, prevtxs []structures.Transaction, tip []byte) ([]byte, error) {
+	for i := len(prevtxs) - 1; i >= 0; i-- {
+		for _, prevUpdate := range prevtxs[i].GetSQLUpdates() {
+			if bytes.Compare(prevUpdate.ReferenceID, sqlUpdate.ReferenceID) == 0 {
+				return prevtxs[i].GetID(), nil
+			}
+		}
+	}
+
+	if prevtxs == nil {
+		txID, err := n.getUnapprovedTransactionsManager().FindSQLReferenceTransaction(sqlUpdate)
+
+		if err != nil || len(txID) > 0 {
+			return txID, err
+		}
+	}
+
+	txID, err := n.getDataRowsAndTransacionsManager().GetTXForRefID(sqlUpdate.ReferenceID)
+
+	if err != nil || len(txID) == 0 || len(tip) == 0 {
+		return txID, err
+	}
+
+	// the index is for the primary chain. a block of other branch can be checked, a TX must be in the branch.
+	// go back by the chain of the row till a TX which is in both chains
+	for len(txID) > 0 {
+		tx, err := n.getIndexManager().GetTransaction(txID, tip)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if tx != nil {
+			return txID, nil
+		}
+
+		tx, err = n.getIndexManager().GetTransaction(txID, []byte{})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if tx == nil {
+			return nil, errors.New(fmt.Sprintf("Transaction %x of the row %s not found", txID, string(sqlUpdate.ReferenceID)))
+		}
+
+		txID = nil
+
+		for _, prevUpdate := range tx.GetSQLUpdates() {
+			if bytes.Compare(prevUpdate.ReferenceID, sqlUpdate.ReferenceID) == 0 && len(prevUpdate.PrevTransaction) > 0 {
+				txID = prevUpdate.PrevTransaction
+				break
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Owner of a row after an update. found is false if an update doesn't change an owner
+func getRowOwnerFromUpdate(tx *structures.Transaction, sqlUpdate structures.SQLUpdate) (owner string, found bool, err error) {
+	parsed := sqlparser.NewSqlParser()
+
+	err = parsed.Parse(string(sqlUpdate.Query))
+
+	if err != nil {
+		return
+	}
+
+	switch parsed.GetKind() {
+	case lib.QueryKindInsert:
+		owner, err = utils.PubKeyToAddres(tx.ByPubKey)
+		found = true
+	case lib.QueryKindTransfer:
+		owner = parsed.GetTransferAddress()
+		found = true
+	}
+	return
+}
+
+// Find a TX in a list of TXs of a block or in a pool and a blockchain
+func (n *txManager) findTransaction(txID []byte, prevtxs []structures.Transaction) (*structures.Transaction, error) {
+	for i := range prevtxs {
+		if bytes.Compare(prevtxs[i].GetID(), txID) == 0 {
+			return &prevtxs[i], nil
+		}
+	}
+	return n.GetIfExists(txID)
+}
@@ -0,0 +1,182 @@
+package transactions
+
+import (
+	"testing"
+
+	"github.com/gelembjuk/oursql/lib/remoteclient"
+	"github.com/gelembjuk/oursql/lib/utils"
+	"github.com/gelembjuk/oursql/node/database"
+	"github.com/gelembjuk/oursql/node/dbquery"
+	"github.com/gelembjuk/oursql/node/structures"
+)
+
+// Key-value storage in memory. It is used for references, tables permissions and a pool
+type memoryStorage struct {
+	data map[string][]byte
+}
+
+func (s *memoryStorage) InitDB() error     { return nil }
+func (s *memoryStorage) TruncateDB() error { return nil }
+func (s *memoryStorage) GetCount() (int, error) {
+	return len(s.data), nil
+}
+func (s *memoryStorage) ForEach(callback database.ForEachKeyIteratorInterface) error {
+	for key, value := range s.data {
+		if err := callback([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (s *memoryStorage) get(key []byte) ([]byte, error) { return s.data[string(key)], nil }
+func (s *memoryStorage) put(key []byte, value []byte) error {
+	s.data[string(key)] = value
+	return nil
+}
+func (s *memoryStorage) delete(key []byte) error {
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *memoryStorage) SetTXForRefID(RefID []byte, txID []byte) error   { return s.put(RefID, txID) }
+func (s *memoryStorage) GetTXForRefID(RefID []byte) ([]byte, error)      { return s.get(RefID) }
+func (s *memoryStorage) DeleteRefID(RefID []byte) error                  { return s.delete(RefID) }
+func (s *memoryStorage) PutTableACL(table []byte, acl []byte) error      { return s.put(table, acl) }
+func (s *memoryStorage) GetTableACL(table []byte) ([]byte, error)        { return s.get(table) }
+func (s *memoryStorage) DeleteTableACL(table []byte) error               { return s.delete(table) }
+func (s *memoryStorage) GetTransaction(txID []byte) ([]byte, error)      { return s.get(txID) }
+func (s *memoryStorage) PutTransaction(txID []byte, txdata []byte) error { return s.put(txID, txdata) }
+func (s *memoryStorage) DeleteTransaction(txID []byte) error             { return s.delete(txID) }
+
+type memoryDBManager struct {
+	database.DBManager
+	references *memoryStorage
+	acl        *memoryStorage
+	pool       *memoryStorage
+}
+
+func (bdm memoryDBManager) GetDataReferencesObject() (database.DataReferencesaInterface, error) {
+	return bdm.references, nil
+}
+func (bdm memoryDBManager) GetTablesACLObject() (database.TablesACLInterface, error) {
+	return bdm.acl, nil
+}
+func (bdm memoryDBManager) GetUnapprovedTransactionsObject() (database.UnapprovedTransactionsInterface, error) {
+	return bdm.pool, nil
+}
+
+func newMemoryDBManager() memoryDBManager {
+	DBM := database.GetDBManagerMock()
+
+	return memoryDBManager{&DBM, &memoryStorage{map[string][]byte{}}, &memoryStorage{map[string][]byte{}},
+		&memoryStorage{map[string][]byte{}}}
+}
+
+// SQL TX with an ID. Row ownership doesn't check signatures, only a signer key
+func makeRowTX(t *testing.T, id string, w remoteclient.Wallet, query string, refID string, prevTX []byte) *structures.Transaction {
+	sqlUpdate := structures.NewSQLUpdate(query, refID, "")
+	sqlUpdate.PrevTransaction = prevTX
+
+	tx, err := structures.NewSQLGroupTransaction([]structures.SQLUpdate{sqlUpdate}, nil, nil)
+
+	if err != nil {
+		t.Fatalf("TX create error: %s", err.Error())
+	}
+	tx.ID = []byte(id)
+	tx.ByPubKey = w.GetPublicKey()
+
+	return tx
+}
+
+func TestRowOwnerBaseTransaction(t *testing.T) {
+	owner := remoteclient.Wallet{}
+	owner.MakeWallet()
+	other := remoteclient.Wallet{}
+	other.MakeWallet()
+
+	db := newMemoryDBManager()
+	n := &txManager{db, utils.CreateLogger()}
+
+	// table in row ownership mode
+	create := makeRowTX(t, "create", owner, "CREATE TABLE t (id int)", "t:*", nil)
+	create.SetSQLParts([]structures.SQLUpdate{create.SQLCommand, structures.NewSQLUpdate("ENABLE ROW OWNERSHIP ON t", "t:*", "")})
+
+	if err := dbquery.NewQueryProcessor(db, n.Logger).ApplyPermissionsFromTX(create); err != nil {
+		t.Fatalf("Permissions error: %s", err.Error())
+	}
+
+	// the row is inserted in a blockchain by the owner
+	insert := makeRowTX(t, "insert", owner, "INSERT INTO t (id) VALUES (1)", "t:1", []byte("create"))
+	insertBytes, _ := structures.SerializeTransaction(insert)
+
+	db.pool.PutTransaction(insert.GetID(), insertBytes)
+	db.references.SetTXForRefID([]byte("t:1"), insert.GetID())
+
+	unrelated := makeRowTX(t, "unrelated", other, "INSERT INTO t (id) VALUES (2)", "t:2", []byte("create"))
+	unrelatedBytes, _ := structures.SerializeTransaction(unrelated)
+
+	db.pool.PutTransaction(unrelated.GetID(), unrelatedBytes)
+
+	// TXs are checked in a block on the primary chain
+	prevtxs := []structures.Transaction{}
+
+	tx := makeRowTX(t, "update", owner, "UPDATE t SET a=1 WHERE id=1", "t:1", insert.GetID())
+
+	if err := n.checkRowsOwnership(tx, prevtxs, nil); err != nil {
+		t.Fatalf("Owner must update the row: %s", err.Error())
+	}
+
+	tx = makeRowTX(t, "update", other, "UPDATE t SET a=1 WHERE id=1", "t:1", insert.GetID())
+
+	if err := n.checkRowsOwnership(tx, prevtxs, nil); err == nil {
+		t.Fatalf("Other address must not update the row")
+	}
+
+	// the chain of the row can't be skipped
+	tx = makeRowTX(t, "update", other, "DELETE FROM t WHERE id=1", "t:1", nil)
+
+	if err := n.checkRowsOwnership(tx, prevtxs, nil); err == nil {
+		t.Fatalf("Update without base TX must be refused when the row has an owner")
+	}
+
+	tx = makeRowTX(t, "update", other, "DELETE FROM t WHERE id=1", "t:1", unrelated.GetID())
+
+	if err := n.checkRowsOwnership(tx, prevtxs, nil); err == nil {
+		t.Fatalf("Update based on other row must be refused")
+	}
+
+	// TX in a block after other TX of same row
+	prevtxs = []structures.Transaction{*makeRowTX(t, "first", owner, "UPDATE t SET a=2 WHERE id=1", "t:1", insert.GetID())}
+
+	tx = makeRowTX(t, "update", owner, "UPDATE t SET a=1 WHERE id=1", "t:1", insert.GetID())
+
+	if err := n.checkRowsOwnership(tx, prevtxs, nil); err == nil {
+		t.Fatalf("Update must be based on last TX of the row")
+	}
+
+	tx = makeRowTX(t, "update", owner, "UPDATE t SET a=1 WHERE id=1", "t:1", []byte("first"))
+
+	if err := n.checkRowsOwnership(tx, prevtxs, nil); err != nil {
+		t.Fatalf("Owner must update the row after own update: %s", err.Error())
+	}
+}
+
+func TestRowOwnerBaseWithoutRow(t *testing.T) {
+	owner := remoteclient.Wallet{}
+	owner.MakeWallet()
+
+	db := newMemoryDBManager()
+	n := &txManager{db, utils.CreateLogger()}
+
+	unrelated := makeRowTX(t, "unrelated", owner, "INSERT INTO t (id) VALUES (2)", "t:2", nil)
+	unrelatedBytes, _ := structures.SerializeTransaction(unrelated)
+
+	db.pool.PutTransaction(unrelated.GetID(), unrelatedBytes)
+
+	tx := makeRowTX(t, "update", owner, "UPDATE t SET a=1 WHERE id=1", "t:1", unrelated.GetID())
+
+	// a base TX has no update of the row
+	if _, err := n.getRowOwner(tx, 0, nil); err == nil {
+		t.Fatalf("Error is expected for a base TX without the row")
+	}
+}
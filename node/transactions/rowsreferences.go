@@ -46,50 +46,70 @@ func (dr rowsToTransactions) UpdateOnBlockCancel(block *structures.Block) error
 			continue
 		}
 
-		if len(tx.SQLCommand.ReferenceID) == 0 {
-			// no any reference here
-			continue
-		}
-		dr.Logger.Trace.Printf("TX %x , refID %s", tx.GetID(), string(tx.SQLCommand.ReferenceID))
-		curTX, err := drdb.GetTXForRefID(tx.SQLCommand.ReferenceID)
+		// for a group of updates only first update of a row has base TX outside of this TX
+		processed := map[string]bool{}
 
-		if err != nil {
-			// in which cases can it be? it should not happen
-			return err
-		}
+		for _, sqlUpdate := range tx.GetSQLUpdates() {
+			if len(sqlUpdate.ReferenceID) == 0 {
+				// no any reference here
+				continue
+			}
+			if processed[string(sqlUpdate.ReferenceID)] {
+				continue
+			}
+			processed[string(sqlUpdate.ReferenceID)] = true
 
-		if curTX == nil {
-			// there is no reference for this row. skipping it. nothing to do
-			continue
-		}
-		if bytes.Compare(curTX, tx.GetID()) != 0 {
-			// reference is for other TX. this should not happen
-			// TODO if this happens , it is needed to find why and fix something
-			continue
-		}
-		if len(tx.GetSQLBaseTX()) == 0 {
-			drdb.DeleteRefID(tx.SQLCommand.ReferenceID)
-			// delete and this is done
-			continue
-		}
-		// get previous TX to understand what is the type. Maybe that
-		txPrev, err := dr.getIndexManager().GetTransaction(tx.GetSQLBaseTX(), []byte{})
+			err = dr.cancelRefID(drdb, &tx, sqlUpdate)
 
-		if err != nil {
-			return err
+			if err != nil {
+				return err
+			}
 		}
+	}
 
-		if txPrev != nil {
-			if bytes.Compare(tx.SQLCommand.ReferenceID, txPrev.SQLCommand.ReferenceID) == 0 {
+	return nil
+}
+
+// Restore reference of a row to previous TX where it was updated
+func (dr rowsToTransactions) cancelRefID(drdb database.DataReferencesaInterface, tx *structures.Transaction, sqlUpdate structures.SQLUpdate) error {
+	dr.Logger.Trace.Printf("TX %x , refID %s", tx.GetID(), string(sqlUpdate.ReferenceID))
+	curTX, err := drdb.GetTXForRefID(sqlUpdate.ReferenceID)
+
+	if err != nil {
+		// in which cases can it be? it should not happen
+		return err
+	}
+
+	if curTX == nil {
+		// there is no reference for this row. skipping it. nothing to do
+		return nil
+	}
+	if bytes.Compare(curTX, tx.GetID()) != 0 {
+		// reference is for other TX. this should not happen
+		// TODO if this happens , it is needed to find why and fix something
+		return nil
+	}
+	if len(sqlUpdate.PrevTransaction) == 0 {
+		// delete and this is done
+		return drdb.DeleteRefID(sqlUpdate.ReferenceID)
+	}
+	// get previous TX to understand what is the type. Maybe that
+	txPrev, err := dr.getIndexManager().GetTransaction(sqlUpdate.PrevTransaction, []byte{})
+
+	if err != nil {
+		return err
+	}
+
+	if txPrev != nil {
+		for _, prevUpdate := range txPrev.GetSQLUpdates() {
+			if bytes.Compare(sqlUpdate.ReferenceID, prevUpdate.ReferenceID) == 0 {
 				// only if previous TX we worked with same row
-				drdb.SetTXForRefID(tx.SQLCommand.ReferenceID, txPrev.GetID())
+				return drdb.SetTXForRefID(sqlUpdate.ReferenceID, txPrev.GetID())
 			}
 		}
-		// in other cases just delete it
-		drdb.DeleteRefID(tx.SQLCommand.ReferenceID)
 	}
-
-	return nil
+	// in other cases just delete it
+	return drdb.DeleteRefID(sqlUpdate.ReferenceID)
 }
 
 // Block Added To Main branch
@@ -111,17 +131,19 @@ func (dr rowsToTransactions) UpdateOnBlockAdd(block *structures.Block) error {
 			continue
 		}
 
-		if len(tx.SQLCommand.ReferenceID) == 0 {
-			// no any reference here
-			continue
-		}
-		dr.Logger.Trace.Printf("TX %x , refID %s", tx.GetID(), string(tx.SQLCommand.ReferenceID))
+		for _, sqlUpdate := range tx.GetSQLUpdates() {
+			if len(sqlUpdate.ReferenceID) == 0 {
+				// no any reference here
+				continue
+			}
+			dr.Logger.Trace.Printf("TX %x , refID %s", tx.GetID(), string(sqlUpdate.ReferenceID))
 
-		// we set new association
-		err = drdb.SetTXForRefID(tx.SQLCommand.ReferenceID, tx.GetID())
+			// we set new association
+			err = drdb.SetTXForRefID(sqlUpdate.ReferenceID, tx.GetID())
 
-		if err != nil {
-			return err
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -0,0 +1,199 @@
+import _lib
+import _transfers
+import _blocks
+import _complex
+import os
+import startnode
+import managenodes
+import initblockchain
+import re
+import time
+import json
+
+datadirs = []
+
+def aftertest(testfilter):
+    global datadirs
+    
+    for datadir in datadirs:
+        if datadir != "":
+            startnode.StopNode(datadir)
+        
+def test(testfilter):
+    global datadirs
+    _lib.CleanTestFolders()
+    
+    # 2 subnetworks with competing branches. First has 9 blocks, second has 8 blocks
+    # all blocks have same difficulty, so first branch has more work
+    dirs = _complex.Copy6Nodes()
+    
+    nodes = []
+    
+    i = 1
+    for d in dirs:
+        balances = _transfers.GetGroupBalance(d)
+        address = balances.keys()[0]
+        
+        nodes.append({'index':i - 1, 'datadir':d,'address':address,"title":"Server "+str(i)})
+        
+        startnode.StartNodeConfig(d)
+        
+        i = i + 1
+        datadirs.append(d)
+    
+    _lib.StartTestGroup("Check branches before connect")
+    blocks1 = _blocks.GetBlocks(nodes[0]["datadir"])
+    blocks2 = _blocks.GetBlocks(nodes[1]["datadir"])
+    
+    _lib.FatalAssert(len(blocks1) == 9,"First branch should have 9 blocks")
+    _lib.FatalAssert(len(blocks2) == 8,"Second branch should have 8 blocks")
+    _lib.FatalAssert(blocks1[0] != blocks2[0],"Top blocks must be different")
+    
+    _lib.StartTestGroup("Connect subnetworks")
+    managenodes.AddNode(nodes[0]["datadir"],"localhost",'30001')
+    
+    # all nodes must choose the heaviest branch
+    for node in nodes:
+        _lib.StartTestGroup("Check top on "+node["title"]+" "+os.path.basename(node["datadir"]))
+        
+        blocks = _blocks.WaitBlocks(node["datadir"],9)
+        
+        _lib.FatalAssert(len(blocks) == 9,"9 blocks must be on "+node["title"])
+        _lib.FatalAssert(blocks[0] == blocks1[0],"Top block must be from the heaviest branch on "+node["title"])
+    
+    # heavier branch is not replaced with lighter one
+    blocks1_after = _blocks.GetBlocks(nodes[0]["datadir"])
+    
+    _lib.FatalAssert(blocks1_after == blocks1,"Blocks on the first node must not change")
+    
+    for node in nodes:
+        startnode.StopNode(node['datadir'])
+        datadirs[node['index']] = ""
+    
+    _lib.EndTestGroupSuccess()
+    
+    testLongerChainLessWork()
+
+# Blocks of chains from datafortests have same difficulty, so a longer chain always has more work there.
+# Here difficulty is changed every 2 blocks. One node makes blocks fast and its difficulty grows,
+# other node makes blocks slow, its chain is longer but has less work
+def testLongerChainLessWork():
+    global datadirs
+    
+    _lib.StartTestGroup("Longer chain with less work")
+    
+    datadir1 = _lib.CreateTestFolder("_1_")
+    datadir2 = _lib.CreateTestFolder("_2_")
+    
+    datadirs.append(datadir1)
+    datadirs.append(datadir2)
+    
+    # blocks must be made every 4 seconds
+    genesisfile = datadir1 + "/genesis.json"
+    
+    with open(genesisfile, 'w') as fp:
+        json.dump({"Difficulty": {"InitialBits": 12, "RetargetInterval": 2, "BlockTime": 4}}, fp)
+    
+    address1 = createWallet(datadir1)
+    
+    dbconfig = _lib.GetDBCredentials(datadir1)
+    
+    _lib.StartTest("Create blockchain with difficulty config")
+    res = _lib.ExecuteNode(['initblockchain','-configdir',datadir1, 
+                            '-minter', address1, 
+                            '-genesisconfig', genesisfile,
+                            '-mysqlhost', dbconfig['host'], 
+                            '-mysqlport', dbconfig['port'],
+                            '-mysqluser', dbconfig['user'],
+                            '-mysqlpass', dbconfig['password'],
+                            '-mysqldb', dbconfig['database'],
+                            '-logs','trace'])
+    _lib.FatalAssertSubstr(res,"Done!","Blockchain init failed")
+    
+    address1_2 = createWallet(datadir1)
+    
+    # both nodes have blocks 0 and 1, so first retarget is same for both
+    makeBlock(datadir1, address1, address1_2)
+    
+    startnode.StartNode(datadir1, address1, '30000', "Server 1")
+    
+    address2 = initblockchain.ImportBockchain(datadir2, "localhost", '30000')
+    address2_2 = createWallet(datadir2)
+    
+    startnode.StopNode(datadir1, "Server 1")
+    
+    managenodes.RemoveAllNodes(datadir1)
+    managenodes.RemoveAllNodes(datadir2)
+    
+    _lib.StartTestGroup("Make branches")
+    
+    _lib.FatalAssert(len(_blocks.GetBlocks(datadir2)) == 2,"Second node must have 2 blocks after import")
+    
+    # blocks 2 and 3 are made fast, block 4 is made with more bits
+    for i in range(3):
+        makeBlock(datadir2, address2, address2_2)
+    
+    # blocks 2 and 3 are made slow, blocks 4 and 5 are made with less bits
+    makeBlock(datadir1, address1, address1_2)
+    time.sleep(9)
+    
+    for i in range(3):
+        makeBlock(datadir1, address1, address1_2)
+    
+    blocks1 = _blocks.GetBlocks(datadir1)
+    blocks2 = _blocks.GetBlocks(datadir2)
+    
+    _lib.FatalAssert(len(blocks1) == 6,"First branch should have 6 blocks")
+    _lib.FatalAssert(len(blocks2) == 5,"Second branch should have 5 blocks")
+    
+    _lib.StartTestGroup("Connect nodes")
+    
+    startnode.StartNode(datadir1, address1, '30000', "Server 1")
+    startnode.StartNode(datadir2, address2, '30001', "Server 2")
+    
+    # the shorter chain has more work. the node with the longer chain must load it
+    managenodes.AddNode(datadir2, "localhost", '30000')
+    
+    for datadir in [datadir1, datadir2]:
+        _lib.StartTestGroup("Check top on "+os.path.basename(datadir))
+        
+        blocks = waitTop(datadir, blocks2[0])
+        
+        _lib.FatalAssert(blocks[0] == blocks2[0],"Top block must be from the shorter branch with more work")
+        _lib.FatalAssert(len(blocks) == 5,"5 blocks must be in the primary chain")
+    
+    startnode.StopNode(datadir1, "Server 1")
+    startnode.StopNode(datadir2, "Server 2")
+    
+    datadirs[datadirs.index(datadir1)] = ""
+    datadirs[datadirs.index(datadir2)] = ""
+    
+    _lib.EndTestGroupSuccess()
+
+def createWallet(datadir):
+    _lib.StartTest("Create address")
+    res = _lib.ExecuteNode(['createwallet','-configdir',datadir])
+    _lib.FatalAssertSubstr(res,"Your new address","Address creation returned wrong result")
+    
+    match = re.search( r'.+: (.+)', res)
+
+    if not match:
+        _lib.Fatal("Address can not be found in "+res)
+        
+    return match.group(1)
+
+# a block needs a transaction
+def makeBlock(datadir, minter, to):
+    _transfers.Send(datadir, minter, to, 0.1)
+    
+    return _blocks.MintBlock(datadir, minter)
+
+def waitTop(datadir, tophash, maxtime = 20):
+    i = 0
+    while True:
+        blocks = _blocks.GetBlocks(datadir)
+        
+        if blocks[0] == tophash or i >= maxtime:
+            return blocks
+        time.sleep(1)
+        i = i + 1
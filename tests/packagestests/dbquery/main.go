@@ -96,7 +96,7 @@ func createTestTables(dbconn *database.MySQLDBManager, q dbquery.QueryProcessorI
 		log.Fatalln(err.Error())
 	}
 
-	if keyCol != "a" {
+	if len(keyCol) != 1 || keyCol[0] != "a" {
 		log.Fatalln("Wrong key column for table test")
 	}
 
@@ -129,7 +129,7 @@ func createTestTables(dbconn *database.MySQLDBManager, q dbquery.QueryProcessorI
 		log.Fatalln(err.Error())
 	}
 
-	if keyCol != "a" {
+	if len(keyCol) != 1 || keyCol[0] != "a" {
 		log.Fatalln("Wrong key column for table test")
 	}
 